@@ -0,0 +1,419 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// LifecycleAction is an action a lifecycle rule takes on a blob.
+type LifecycleAction string
+
+const (
+	ActionTierToCool    LifecycleAction = "tierToCool"
+	ActionTierToArchive LifecycleAction = "tierToArchive"
+	ActionDelete        LifecycleAction = "delete"
+)
+
+// actionPrecedence orders actions the way the service resolves conflicts:
+// when several actions apply to a blob, the one with the highest precedence
+// wins, so delete beats archive and archive beats cool.
+var actionPrecedence = map[LifecycleAction]int{
+	ActionTierToCool:    1,
+	ActionTierToArchive: 2,
+	ActionDelete:        3,
+}
+
+// LifecycleActions holds the age in days after which each action fires. A nil
+// age disables the action. Ages count from the last modification of base
+// blobs and from the creation of snapshots and versions.
+type LifecycleActions struct {
+	TierToCool    *int
+	TierToArchive *int
+	Delete        *int
+}
+
+func (a LifecycleActions) empty() bool {
+	return a.TierToCool == nil && a.TierToArchive == nil && a.Delete == nil
+}
+
+// validate checks that ages are not negative. The service accepts ages in
+// any order, such as deleting before archiving, and so does validate:
+// Evaluate then takes the action with the highest precedence.
+func (a LifecycleActions) validate(what string) error {
+	for _, age := range []*int{a.TierToCool, a.TierToArchive, a.Delete} {
+		if age != nil && *age < 0 {
+			return fmt.Errorf("%s: days must not be negative", what)
+		}
+	}
+	return nil
+}
+
+// BlobIndexFilter matches blobs whose index tag Name equals Value.
+type BlobIndexFilter struct {
+	Name  string
+	Value string
+}
+
+// LifecycleRule is a typed lifecycle management rule. Create one with
+// NewLifecycleRule and chain the builder methods to add filters and actions.
+type LifecycleRule struct {
+	Name           string
+	Disabled       bool
+	BlobTypes      []string // "blockBlob" and "appendBlob"; defaults to blockBlob
+	PrefixMatch    []string // "container/prefix"
+	BlobIndexMatch []BlobIndexFilter
+	BaseBlob       LifecycleActions
+	Snapshot       LifecycleActions
+	Version        LifecycleActions
+}
+
+// NewLifecycleRule starts a rule for block blobs with no filters or actions.
+func NewLifecycleRule(name string) *LifecycleRule {
+	return &LifecycleRule{Name: name, BlobTypes: []string{"blockBlob"}}
+}
+
+// WithBlobTypes replaces the blob types the rule applies to.
+func (r *LifecycleRule) WithBlobTypes(types ...string) *LifecycleRule {
+	r.BlobTypes = types
+	return r
+}
+
+// WithPrefix limits the rule to blobs whose "container/name" starts with one
+// of the prefixes.
+func (r *LifecycleRule) WithPrefix(prefixes ...string) *LifecycleRule {
+	r.PrefixMatch = append(r.PrefixMatch, prefixes...)
+	return r
+}
+
+// WithBlobIndex limits the rule to blobs with the index tag name=value.
+func (r *LifecycleRule) WithBlobIndex(name, value string) *LifecycleRule {
+	r.BlobIndexMatch = append(r.BlobIndexMatch, BlobIndexFilter{Name: name, Value: value})
+	return r
+}
+
+// Disable keeps the rule in the policy without it taking effect.
+func (r *LifecycleRule) Disable() *LifecycleRule {
+	r.Disabled = true
+	return r
+}
+
+// TierToCoolAfter moves base blobs to the cool tier once they have not been
+// modified for more than days.
+func (r *LifecycleRule) TierToCoolAfter(days int) *LifecycleRule {
+	r.BaseBlob.TierToCool = &days
+	return r
+}
+
+// TierToArchiveAfter moves base blobs to the archive tier once they have not
+// been modified for more than days.
+func (r *LifecycleRule) TierToArchiveAfter(days int) *LifecycleRule {
+	r.BaseBlob.TierToArchive = &days
+	return r
+}
+
+// DeleteAfter deletes base blobs once they have not been modified for more
+// than days.
+func (r *LifecycleRule) DeleteAfter(days int) *LifecycleRule {
+	r.BaseBlob.Delete = &days
+	return r
+}
+
+// TierSnapshotsToCoolAfter moves snapshots older than days to the cool tier.
+func (r *LifecycleRule) TierSnapshotsToCoolAfter(days int) *LifecycleRule {
+	r.Snapshot.TierToCool = &days
+	return r
+}
+
+// TierSnapshotsToArchiveAfter moves snapshots older than days to the archive
+// tier.
+func (r *LifecycleRule) TierSnapshotsToArchiveAfter(days int) *LifecycleRule {
+	r.Snapshot.TierToArchive = &days
+	return r
+}
+
+// DeleteSnapshotsAfter deletes snapshots older than days.
+func (r *LifecycleRule) DeleteSnapshotsAfter(days int) *LifecycleRule {
+	r.Snapshot.Delete = &days
+	return r
+}
+
+// TierVersionsToCoolAfter moves previous versions older than days to the
+// cool tier.
+func (r *LifecycleRule) TierVersionsToCoolAfter(days int) *LifecycleRule {
+	r.Version.TierToCool = &days
+	return r
+}
+
+// TierVersionsToArchiveAfter moves previous versions older than days to the
+// archive tier.
+func (r *LifecycleRule) TierVersionsToArchiveAfter(days int) *LifecycleRule {
+	r.Version.TierToArchive = &days
+	return r
+}
+
+// DeleteVersionsAfter deletes previous versions older than days.
+func (r *LifecycleRule) DeleteVersionsAfter(days int) *LifecycleRule {
+	r.Version.Delete = &days
+	return r
+}
+
+// Validate checks the rule against the constraints the service enforces.
+func (r *LifecycleRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("lifecycle rule requires a name")
+	}
+	if len(r.BlobTypes) == 0 {
+		return fmt.Errorf("rule %s: at least one blob type is required", r.Name)
+	}
+	for _, t := range r.BlobTypes {
+		if t != "blockBlob" && t != "appendBlob" {
+			return fmt.Errorf("rule %s: unsupported blob type %q", r.Name, t)
+		}
+		if t == "appendBlob" && (r.BaseBlob.TierToCool != nil || r.BaseBlob.TierToArchive != nil) {
+			return fmt.Errorf("rule %s: append blobs support only delete actions", r.Name)
+		}
+	}
+	for _, f := range r.BlobIndexMatch {
+		if f.Name == "" {
+			return fmt.Errorf("rule %s: blob index filter requires a tag name", r.Name)
+		}
+	}
+	if r.BaseBlob.empty() && r.Snapshot.empty() && r.Version.empty() {
+		return fmt.Errorf("rule %s: at least one action is required", r.Name)
+	}
+	for what, actions := range map[string]LifecycleActions{
+		"base blob": r.BaseBlob,
+		"snapshot":  r.Snapshot,
+		"version":   r.Version,
+	} {
+		if err := actions.validate(fmt.Sprintf("rule %s %s actions", r.Name, what)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LifecyclePolicy is an ordered set of lifecycle rules for an account.
+type LifecyclePolicy struct {
+	Rules []*LifecycleRule
+}
+
+// NewLifecyclePolicy collects rules into a policy.
+func NewLifecyclePolicy(rules ...*LifecycleRule) *LifecyclePolicy {
+	return &LifecyclePolicy{Rules: rules}
+}
+
+// Validate validates each rule and checks that rule names are unique.
+func (p *LifecyclePolicy) Validate() error {
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("lifecycle policy requires at least one rule")
+	}
+	names := map[string]bool{}
+	for _, r := range p.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate rule name %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// Schema validates the policy and converts it to the management API type.
+func (p *LifecyclePolicy) Schema() (*armstorage.ManagementPolicySchema, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	schema := &armstorage.ManagementPolicySchema{}
+	for _, r := range p.Rules {
+		filters := &armstorage.ManagementPolicyFilter{}
+		for _, t := range r.BlobTypes {
+			filters.BlobTypes = append(filters.BlobTypes, to.Ptr(t))
+		}
+		for _, prefix := range r.PrefixMatch {
+			filters.PrefixMatch = append(filters.PrefixMatch, to.Ptr(prefix))
+		}
+		for _, f := range r.BlobIndexMatch {
+			filters.BlobIndexMatch = append(filters.BlobIndexMatch, &armstorage.TagFilter{
+				Name:  to.Ptr(f.Name),
+				Op:    to.Ptr("=="),
+				Value: to.Ptr(f.Value),
+			})
+		}
+
+		actions := &armstorage.ManagementPolicyAction{}
+		if !r.BaseBlob.empty() {
+			actions.BaseBlob = &armstorage.ManagementPolicyBaseBlob{
+				TierToCool:    afterModification(r.BaseBlob.TierToCool),
+				TierToArchive: afterModification(r.BaseBlob.TierToArchive),
+				Delete:        afterModification(r.BaseBlob.Delete),
+			}
+		}
+		if !r.Snapshot.empty() {
+			actions.Snapshot = &armstorage.ManagementPolicySnapShot{
+				TierToCool:    afterCreation(r.Snapshot.TierToCool),
+				TierToArchive: afterCreation(r.Snapshot.TierToArchive),
+				Delete:        afterCreation(r.Snapshot.Delete),
+			}
+		}
+		if !r.Version.empty() {
+			actions.Version = &armstorage.ManagementPolicyVersion{
+				TierToCool:    afterCreation(r.Version.TierToCool),
+				TierToArchive: afterCreation(r.Version.TierToArchive),
+				Delete:        afterCreation(r.Version.Delete),
+			}
+		}
+
+		schema.Rules = append(schema.Rules, &armstorage.ManagementPolicyRule{
+			Enabled: to.Ptr(!r.Disabled),
+			Name:    to.Ptr(r.Name),
+			Type:    to.Ptr(armstorage.RuleTypeLifecycle),
+			Definition: &armstorage.ManagementPolicyDefinition{
+				Actions: actions,
+				Filters: filters,
+			},
+		})
+	}
+	return schema, nil
+}
+
+func afterModification(days *int) *armstorage.DateAfterModification {
+	if days == nil {
+		return nil
+	}
+	return &armstorage.DateAfterModification{DaysAfterModificationGreaterThan: to.Ptr(float32(*days))}
+}
+
+func afterCreation(days *int) *armstorage.DateAfterCreation {
+	if days == nil {
+		return nil
+	}
+	return &armstorage.DateAfterCreation{DaysAfterCreationGreaterThan: to.Ptr(float32(*days))}
+}
+
+// BlobKind distinguishes base blobs from their snapshots and versions.
+type BlobKind string
+
+const (
+	BlobKindBase     BlobKind = "base"
+	BlobKindSnapshot BlobKind = "snapshot"
+	BlobKindVersion  BlobKind = "version"
+)
+
+// InventoryBlob describes a blob in an inventory to evaluate a policy against.
+type InventoryBlob struct {
+	Name         string   // "container/path/name"
+	Kind         BlobKind // defaults to BlobKindBase
+	BlobType     string   // defaults to "blockBlob"
+	Tier         string   // "Hot", "Cool" or "Archive"; defaults to "Hot"
+	LastModified time.Time
+	CreationTime time.Time // snapshots and versions
+	Tags         map[string]string
+}
+
+// PredictedAction is an action a policy would take on a blob.
+type PredictedAction struct {
+	Blob   string
+	Kind   BlobKind
+	Rule   string
+	Action LifecycleAction
+}
+
+func (a PredictedAction) String() string {
+	return fmt.Sprintf("%s %s %s (rule %s)", a.Action, a.Kind, a.Blob, a.Rule)
+}
+
+// Evaluate predicts which actions the policy would take on the inventory when
+// run on the given date. Like the service, it takes at most one action per
+// blob, preferring delete over archive over cool, and skips tier changes that
+// would not move a blob to a colder tier.
+func (p *LifecyclePolicy) Evaluate(inventory []InventoryBlob, on time.Time) []PredictedAction {
+	var predicted []PredictedAction
+	for _, blob := range inventory {
+		if blob.Kind == "" {
+			blob.Kind = BlobKindBase
+		}
+		var best *PredictedAction
+		for _, r := range p.Rules {
+			if r.Disabled || !r.matches(blob) {
+				continue
+			}
+			for _, action := range r.actionsDue(blob, on) {
+				if best == nil || actionPrecedence[action] > actionPrecedence[best.Action] {
+					best = &PredictedAction{Blob: blob.Name, Kind: blob.Kind, Rule: r.Name, Action: action}
+				}
+			}
+		}
+		if best != nil {
+			predicted = append(predicted, *best)
+		}
+	}
+	sort.SliceStable(predicted, func(i, j int) bool {
+		return predicted[i].Blob < predicted[j].Blob
+	})
+	return predicted
+}
+
+func (r *LifecycleRule) matches(blob InventoryBlob) bool {
+	blobType := blob.BlobType
+	if blobType == "" {
+		blobType = "blockBlob"
+	}
+	typeMatch := false
+	for _, t := range r.BlobTypes {
+		typeMatch = typeMatch || t == blobType
+	}
+	if !typeMatch {
+		return false
+	}
+	if len(r.PrefixMatch) > 0 {
+		prefixMatch := false
+		for _, prefix := range r.PrefixMatch {
+			prefixMatch = prefixMatch || strings.HasPrefix(blob.Name, prefix)
+		}
+		if !prefixMatch {
+			return false
+		}
+	}
+	for _, f := range r.BlobIndexMatch {
+		if value, ok := blob.Tags[f.Name]; !ok || value != f.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// actionsDue returns the rule's actions whose age condition the blob meets.
+func (r *LifecycleRule) actionsDue(blob InventoryBlob, on time.Time) []LifecycleAction {
+	actions, since := r.BaseBlob, blob.LastModified
+	switch blob.Kind {
+	case BlobKindSnapshot:
+		actions, since = r.Snapshot, blob.CreationTime
+	case BlobKindVersion:
+		actions, since = r.Version, blob.CreationTime
+	}
+	age := on.Sub(since).Hours() / 24
+	tier := strings.ToLower(blob.Tier)
+
+	var due []LifecycleAction
+	if actions.TierToCool != nil && age > float64(*actions.TierToCool) && (tier == "" || tier == "hot") {
+		due = append(due, ActionTierToCool)
+	}
+	if actions.TierToArchive != nil && age > float64(*actions.TierToArchive) && tier != "archive" {
+		due = append(due, ActionTierToArchive)
+	}
+	if actions.Delete != nil && age > float64(*actions.Delete) {
+		due = append(due, ActionDelete)
+	}
+	return due
+}
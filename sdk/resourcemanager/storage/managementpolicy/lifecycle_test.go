@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLifecyclePolicyEvaluate(t *testing.T) {
+	policy := NewLifecyclePolicy(
+		NewLifecycleRule("logs").
+			WithPrefix("logs/").
+			TierToCoolAfter(30).
+			TierToArchiveAfter(90).
+			DeleteAfter(365).
+			DeleteSnapshotsAfter(7),
+		NewLifecycleRule("scratch").
+			WithBlobIndex("retention", "short").
+			DeleteAfter(1).
+			DeleteVersionsAfter(1),
+	)
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("policy should be valid: %v", err)
+	}
+
+	on := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return on.AddDate(0, 0, -days) }
+	inventory := []InventoryBlob{
+		{Name: "logs/fresh.log", LastModified: daysAgo(10)},
+		{Name: "logs/month.log", LastModified: daysAgo(31)},
+		{Name: "logs/month-cool.log", Tier: "Cool", LastModified: daysAgo(31)},
+		{Name: "logs/quarter.log", LastModified: daysAgo(100)},
+		{Name: "logs/old.log", Tier: "Archive", LastModified: daysAgo(400)},
+		{Name: "logs/exactly30.log", LastModified: daysAgo(30)},
+		{Name: "logs/snap.log", Kind: BlobKindSnapshot, CreationTime: daysAgo(8), LastModified: daysAgo(8)},
+		{Name: "data/tmp.bin", LastModified: daysAgo(2), Tags: map[string]string{"retention": "short"}},
+		{Name: "data/keep.bin", LastModified: daysAgo(2), Tags: map[string]string{"retention": "long"}},
+		{Name: "data/tmp.bin", Kind: BlobKindVersion, CreationTime: daysAgo(3), Tags: map[string]string{"retention": "short"}},
+		{Name: "logs/append.log", BlobType: "appendBlob", LastModified: daysAgo(400)},
+	}
+
+	got := policy.Evaluate(inventory, on)
+	want := []PredictedAction{
+		{Blob: "data/tmp.bin", Kind: BlobKindBase, Rule: "scratch", Action: ActionDelete},
+		{Blob: "data/tmp.bin", Kind: BlobKindVersion, Rule: "scratch", Action: ActionDelete},
+		{Blob: "logs/month.log", Kind: BlobKindBase, Rule: "logs", Action: ActionTierToCool},
+		{Blob: "logs/old.log", Kind: BlobKindBase, Rule: "logs", Action: ActionDelete},
+		{Blob: "logs/quarter.log", Kind: BlobKindBase, Rule: "logs", Action: ActionTierToArchive},
+		{Blob: "logs/snap.log", Kind: BlobKindSnapshot, Rule: "logs", Action: ActionDelete},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Evaluate() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestLifecycleRuleValidate(t *testing.T) {
+	cases := map[string]*LifecycleRule{
+		"no name":        NewLifecycleRule("").DeleteAfter(1),
+		"no actions":     NewLifecycleRule("empty").WithPrefix("logs/"),
+		"negative":       NewLifecycleRule("negative").DeleteSnapshotsAfter(-1),
+		"append tiering": NewLifecycleRule("append").WithBlobTypes("appendBlob").TierToCoolAfter(30),
+		"unknown type":   NewLifecycleRule("page").WithBlobTypes("pageBlob").DeleteAfter(30),
+	}
+	for name, rule := range cases {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+
+	// the service accepts actions in any order
+	for name, rule := range map[string]*LifecycleRule{
+		"out of order": NewLifecycleRule("order").TierToArchiveAfter(30).TierToCoolAfter(90),
+		"delete first": NewLifecycleRule("order").TierToCoolAfter(30).DeleteAfter(10),
+	} {
+		if err := rule.Validate(); err != nil {
+			t.Errorf("%s: unexpected validation error: %v", name, err)
+		}
+	}
+
+	duplicate := NewLifecyclePolicy(
+		NewLifecycleRule("same").DeleteAfter(1),
+		NewLifecycleRule("same").DeleteAfter(2),
+	)
+	if _, err := duplicate.Schema(); err == nil {
+		t.Errorf("expected duplicate rule names to be rejected")
+	}
+}
+
+func TestLifecyclePolicySchema(t *testing.T) {
+	schema, err := NewLifecyclePolicy(
+		NewLifecycleRule("tagged").
+			WithPrefix("container/a", "container/b").
+			WithBlobIndex("project", "alpha").
+			TierToCoolAfter(30).
+			DeleteVersionsAfter(90).
+			Disable(),
+	).Schema()
+	if err != nil {
+		t.Fatalf("failed to build schema: %v", err)
+	}
+	if len(schema.Rules) != 1 {
+		t.Fatalf("expected one rule, got %d", len(schema.Rules))
+	}
+	rule := schema.Rules[0]
+	if *rule.Enabled {
+		t.Errorf("disabled rule was enabled")
+	}
+	actions := rule.Definition.Actions
+	if actions.BaseBlob == nil || *actions.BaseBlob.TierToCool.DaysAfterModificationGreaterThan != 30 || actions.BaseBlob.Delete != nil {
+		t.Errorf("unexpected base blob actions: %+v", actions.BaseBlob)
+	}
+	if actions.Snapshot != nil {
+		t.Errorf("unexpected snapshot actions: %+v", actions.Snapshot)
+	}
+	if actions.Version == nil || *actions.Version.Delete.DaysAfterCreationGreaterThan != 90 {
+		t.Errorf("unexpected version actions: %+v", actions.Version)
+	}
+	filters := rule.Definition.Filters
+	if len(filters.PrefixMatch) != 2 || len(filters.BlobIndexMatch) != 1 || *filters.BlobIndexMatch[0].Op != "==" {
+		t.Errorf("unexpected filters: %+v", filters)
+	}
+}
@@ -116,6 +116,19 @@ func createManagementPolicy(ctx context.Context, cred azcore.TokenCredential) (*
 		return nil, err
 	}
 
+	policy := NewLifecyclePolicy(
+		NewLifecycleRule("sampletest").
+			WithPrefix("sampletestcontainer").
+			TierToCoolAfter(30).
+			TierToArchiveAfter(90).
+			DeleteAfter(1000).
+			DeleteSnapshotsAfter(30),
+	)
+	schema, err := policy.Schema()
+	if err != nil {
+		return nil, err
+	}
+
 	resp, err := managementPoliciesClient.CreateOrUpdate(
 		ctx,
 		resourceGroupName,
@@ -123,43 +136,7 @@ func createManagementPolicy(ctx context.Context, cred azcore.TokenCredential) (*
 		armstorage.ManagementPolicyNameDefault,
 		armstorage.ManagementPolicy{
 			Properties: &armstorage.ManagementPolicyProperties{
-				Policy: &armstorage.ManagementPolicySchema{
-					Rules: []*armstorage.ManagementPolicyRule{
-						{
-							Enabled: to.Ptr(true),
-							Name:    to.Ptr("sampletest"),
-							Type:    to.Ptr(armstorage.RuleTypeLifecycle),
-							Definition: &armstorage.ManagementPolicyDefinition{
-								Actions: &armstorage.ManagementPolicyAction{
-									BaseBlob: &armstorage.ManagementPolicyBaseBlob{
-										TierToCool: &armstorage.DateAfterModification{
-											DaysAfterModificationGreaterThan: to.Ptr[float32](30),
-										},
-										TierToArchive: &armstorage.DateAfterModification{
-											DaysAfterModificationGreaterThan: to.Ptr[float32](90),
-										},
-										Delete: &armstorage.DateAfterModification{
-											DaysAfterModificationGreaterThan: to.Ptr[float32](1000),
-										},
-									},
-									Snapshot: &armstorage.ManagementPolicySnapShot{
-										Delete: &armstorage.DateAfterCreation{
-											DaysAfterCreationGreaterThan: to.Ptr[float32](30),
-										},
-									},
-								},
-								Filters: &armstorage.ManagementPolicyFilter{
-									BlobTypes: []*string{
-										to.Ptr("blockBlob"),
-									},
-									PrefixMatch: []*string{
-										to.Ptr("sampletestcontainer"),
-									},
-								},
-							},
-						},
-					},
-				},
+				Policy: schema,
 			},
 		},
 		nil,
@@ -0,0 +1,234 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	cloudInitUserName    = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	cloudInitPermissions = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+)
+
+// CloudConfig composes cloud-init user data for Linux VMs. Render it and
+// pass the result to VMSpec.WithCloudInit.
+type CloudConfig struct {
+	Hostname       string
+	PackageUpdate  bool
+	PackageUpgrade bool
+	Packages       []string
+	Users          []CloudInitUser
+	Files          []CloudInitFile
+	RunCmd         []CloudInitCommand
+}
+
+// CloudInitUser is a user cloud-init creates in addition to the image's
+// default user and the VM's admin user.
+type CloudInitUser struct {
+	Name              string
+	Groups            []string
+	Shell             string
+	Sudo              string
+	SSHAuthorizedKeys []string
+}
+
+// CloudInitFile is a file cloud-init writes at boot. Content that is not
+// valid UTF-8 is base64 encoded when rendered.
+type CloudInitFile struct {
+	Path        string
+	Content     string
+	Owner       string
+	Permissions string
+	Append      bool
+}
+
+// CloudInitCommand is a command cloud-init runs once at first boot. A single
+// element is run by the shell, several are run directly as a program and
+// its arguments.
+type CloudInitCommand []string
+
+// NewCloudConfig returns an empty cloud-init config.
+func NewCloudConfig() *CloudConfig {
+	return &CloudConfig{}
+}
+
+// WithHostname sets the host name of the VM.
+func (c *CloudConfig) WithHostname(hostname string) *CloudConfig {
+	c.Hostname = hostname
+	return c
+}
+
+// WithPackages installs packages, updating the package index first.
+func (c *CloudConfig) WithPackages(packages ...string) *CloudConfig {
+	c.PackageUpdate = true
+	c.Packages = append(c.Packages, packages...)
+	return c
+}
+
+// WithPackageUpgrade upgrades all installed packages at first boot.
+func (c *CloudConfig) WithPackageUpgrade() *CloudConfig {
+	c.PackageUpdate = true
+	c.PackageUpgrade = true
+	return c
+}
+
+// WithUser adds a user.
+func (c *CloudConfig) WithUser(user CloudInitUser) *CloudConfig {
+	c.Users = append(c.Users, user)
+	return c
+}
+
+// WithFile writes a file with the given content and octal permissions,
+// such as "0644".
+func (c *CloudConfig) WithFile(filePath, content, permissions string) *CloudConfig {
+	c.Files = append(c.Files, CloudInitFile{Path: filePath, Content: content, Permissions: permissions})
+	return c
+}
+
+// WithShellCommand runs a command line with the shell.
+func (c *CloudConfig) WithShellCommand(commandLine string) *CloudConfig {
+	c.RunCmd = append(c.RunCmd, CloudInitCommand{commandLine})
+	return c
+}
+
+// WithCommand runs a program with arguments, without a shell.
+func (c *CloudConfig) WithCommand(program string, args ...string) *CloudConfig {
+	c.RunCmd = append(c.RunCmd, append(CloudInitCommand{program}, args...))
+	return c
+}
+
+// Validate checks the config for mistakes cloud-init would only report on
+// the VM, if at all.
+func (c *CloudConfig) Validate() error {
+	for _, p := range c.Packages {
+		if p == "" || strings.ContainsAny(p, " \t\n") {
+			return fmt.Errorf("invalid package name %q", p)
+		}
+	}
+	users := map[string]bool{}
+	for _, u := range c.Users {
+		if !cloudInitUserName.MatchString(u.Name) {
+			return fmt.Errorf("invalid user name %q", u.Name)
+		}
+		if users[u.Name] {
+			return fmt.Errorf("user %s is defined twice", u.Name)
+		}
+		users[u.Name] = true
+		if u.Shell != "" && !path.IsAbs(u.Shell) {
+			return fmt.Errorf("user %s: shell %q is not an absolute path", u.Name, u.Shell)
+		}
+	}
+	files := map[string]bool{}
+	for _, f := range c.Files {
+		if !path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path {
+			return fmt.Errorf("file path %q is not a clean absolute path", f.Path)
+		}
+		if files[f.Path] && !f.Append {
+			return fmt.Errorf("file %s is written twice", f.Path)
+		}
+		files[f.Path] = true
+		if f.Permissions != "" && !cloudInitPermissions.MatchString(f.Permissions) {
+			return fmt.Errorf("file %s: permissions %q are not octal", f.Path, f.Permissions)
+		}
+	}
+	for i, cmd := range c.RunCmd {
+		if len(cmd) == 0 || strings.TrimSpace(cmd[0]) == "" {
+			return fmt.Errorf("command %d is empty", i)
+		}
+	}
+	return nil
+}
+
+// cloudConfigDocument is the cloud-config YAML schema of a CloudConfig.
+type cloudConfigDocument struct {
+	Hostname       string   `yaml:"hostname,omitempty"`
+	PackageUpdate  bool     `yaml:"package_update,omitempty"`
+	PackageUpgrade bool     `yaml:"package_upgrade,omitempty"`
+	Packages       []string `yaml:"packages,omitempty"`
+	// Users holds "default", for the image's default user, and
+	// cloudInitUserDocuments.
+	Users      []interface{}           `yaml:"users,omitempty"`
+	WriteFiles []cloudInitFileDocument `yaml:"write_files,omitempty"`
+	// RunCmd holds a string for a shell command and a list for a program
+	// and its arguments.
+	RunCmd []interface{} `yaml:"runcmd,omitempty"`
+}
+
+type cloudInitUserDocument struct {
+	Name              string   `yaml:"name"`
+	Groups            string   `yaml:"groups,omitempty"`
+	Shell             string   `yaml:"shell,omitempty"`
+	Sudo              string   `yaml:"sudo,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+type cloudInitFileDocument struct {
+	Path        string `yaml:"path"`
+	Encoding    string `yaml:"encoding,omitempty"`
+	Content     string `yaml:"content"`
+	Owner       string `yaml:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+	Append      bool   `yaml:"append,omitempty"`
+}
+
+func (c *CloudConfig) document() cloudConfigDocument {
+	doc := cloudConfigDocument{
+		Hostname:       c.Hostname,
+		PackageUpdate:  c.PackageUpdate,
+		PackageUpgrade: c.PackageUpgrade,
+		Packages:       c.Packages,
+	}
+	if len(c.Users) > 0 {
+		// keep the image's default user, which the VM's admin user is
+		// created from
+		doc.Users = append(doc.Users, "default")
+		for _, u := range c.Users {
+			user := cloudInitUserDocument{Name: u.Name, Groups: strings.Join(u.Groups, ", "), Shell: u.Shell, Sudo: u.Sudo}
+			for _, key := range u.SSHAuthorizedKeys {
+				user.SSHAuthorizedKeys = append(user.SSHAuthorizedKeys, strings.TrimSpace(key))
+			}
+			doc.Users = append(doc.Users, user)
+		}
+	}
+	for _, f := range c.Files {
+		file := cloudInitFileDocument{Path: f.Path, Content: f.Content, Owner: f.Owner, Permissions: f.Permissions, Append: f.Append}
+		if !utf8.ValidString(f.Content) {
+			file.Encoding, file.Content = "b64", base64.StdEncoding.EncodeToString([]byte(f.Content))
+		}
+		doc.WriteFiles = append(doc.WriteFiles, file)
+	}
+	for _, cmd := range c.RunCmd {
+		if len(cmd) == 1 {
+			doc.RunCmd = append(doc.RunCmd, cmd[0])
+		} else {
+			doc.RunCmd = append(doc.RunCmd, []string(cmd))
+		}
+	}
+	return doc
+}
+
+// Render validates the config and renders it as a cloud-config YAML
+// document.
+func (c *CloudConfig) Render() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	body, err := yaml.Marshal(c.document())
+	if err != nil {
+		return "", fmt.Errorf("cannot render cloud-config: %v", err)
+	}
+	rendered := "#cloud-config\n" + string(body)
+	if base64.StdEncoding.EncodedLen(len(rendered)) > maxCustomDataLength {
+		return "", fmt.Errorf("cloud-config is longer than %d bytes encoded", maxCustomDataLength)
+	}
+	return rendered, nil
+}
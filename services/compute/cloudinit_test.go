@@ -0,0 +1,133 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestCloudConfigRender(t *testing.T) {
+	rendered, err := NewCloudConfig().
+		WithHostname("web-1").
+		WithPackages("nginx", "jq").
+		WithUser(CloudInitUser{
+			Name:              "deploy",
+			Groups:            []string{"sudo", "www-data"},
+			Shell:             "/bin/bash",
+			Sudo:              "ALL=(ALL) NOPASSWD:ALL",
+			SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA deploy@example\n"},
+		}).
+		WithFile("/etc/motd", "line: one\n# \"quoted\"\n", "0644").
+		WithFile("/opt/blob.bin", "\xff\x00", "600").
+		WithShellCommand("systemctl restart nginx").
+		WithCommand("sh", "-c", "echo 'hi' > /tmp/x").
+		Render()
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+
+	want := `#cloud-config
+hostname: web-1
+package_update: true
+packages:
+- nginx
+- jq
+users:
+- default
+- name: deploy
+  groups: sudo, www-data
+  shell: /bin/bash
+  sudo: ALL=(ALL) NOPASSWD:ALL
+  ssh_authorized_keys:
+  - ssh-ed25519 AAAA deploy@example
+write_files:
+- path: /etc/motd
+  content: |
+    line: one
+    # "quoted"
+  permissions: "0644"
+- path: /opt/blob.bin
+  encoding: b64
+  content: /wA=
+  permissions: "600"
+runcmd:
+- systemctl restart nginx
+- - sh
+  - -c
+  - echo 'hi' > /tmp/x
+`
+	if rendered != want {
+		t.Errorf("rendered\n%s\nwant\n%s", rendered, want)
+	}
+
+	var parsed struct {
+		Users      []interface{}            `yaml:"users"`
+		WriteFiles []map[string]interface{} `yaml:"write_files"`
+		RunCmd     []interface{}            `yaml:"runcmd"`
+	}
+	if err := yaml.Unmarshal([]byte(rendered), &parsed); err != nil {
+		t.Fatalf("cannot parse rendered cloud-config: %v", err)
+	}
+	if len(parsed.Users) != 2 || parsed.Users[0] != "default" {
+		t.Errorf("unexpected users: %v", parsed.Users)
+	}
+	if got := parsed.WriteFiles[0]["content"]; got != "line: one\n# \"quoted\"\n" {
+		t.Errorf("file content did not round-trip: %q", got)
+	}
+	if got, ok := parsed.RunCmd[1].([]interface{}); !ok || len(got) != 3 || got[2] != "echo 'hi' > /tmp/x" {
+		t.Errorf("command did not round-trip: %v", parsed.RunCmd[1])
+	}
+}
+
+func TestCloudConfigRenderQuoting(t *testing.T) {
+	hostile := "a: b\n- c # d"
+	rendered, err := NewCloudConfig().
+		WithHostname(hostile).
+		WithFile("/etc/x", "{not: a map}", "").
+		WithShellCommand("[not, a, list]").
+		Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Hostname   string              `yaml:"hostname"`
+		WriteFiles []map[string]string `yaml:"write_files"`
+		RunCmd     []string            `yaml:"runcmd"`
+	}
+	if err := yaml.UnmarshalStrict([]byte(rendered), &parsed); err != nil {
+		t.Fatalf("cannot parse rendered cloud-config: %v\n%s", err, rendered)
+	}
+	if parsed.Hostname != hostile || parsed.WriteFiles[0]["content"] != "{not: a map}" || parsed.RunCmd[0] != "[not, a, list]" {
+		t.Errorf("values changed the document structure:\n%s", rendered)
+	}
+}
+
+func TestCloudConfigValidate(t *testing.T) {
+	cases := map[string]*CloudConfig{
+		"package with space": NewCloudConfig().WithPackages("nginx; rm -rf /"),
+		"bad user name":      NewCloudConfig().WithUser(CloudInitUser{Name: "Root User"}),
+		"duplicate user":     NewCloudConfig().WithUser(CloudInitUser{Name: "a"}).WithUser(CloudInitUser{Name: "a"}),
+		"relative shell":     NewCloudConfig().WithUser(CloudInitUser{Name: "a", Shell: "bash"}),
+		"relative path":      NewCloudConfig().WithFile("etc/motd", "", ""),
+		"unclean path":       NewCloudConfig().WithFile("/etc/../motd", "", ""),
+		"duplicate file":     NewCloudConfig().WithFile("/etc/motd", "a", "").WithFile("/etc/motd", "b", ""),
+		"bad permissions":    NewCloudConfig().WithFile("/etc/motd", "", "rw-r--r--"),
+		"empty command":      NewCloudConfig().WithShellCommand(" "),
+		"too large":          NewCloudConfig().WithFile("/big", strings.Repeat("x", maxCustomDataLength), ""),
+	}
+	for name, c := range cases {
+		if _, err := c.Render(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	appended := NewCloudConfig().WithFile("/etc/motd", "a", "")
+	appended.Files = append(appended.Files, CloudInitFile{Path: "/etc/motd", Content: "b", Append: true})
+	if err := appended.Validate(); err != nil {
+		t.Errorf("appending to a written file should be allowed: %v", err)
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
-	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2020-09-01/containerservice"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
@@ -26,57 +26,56 @@ func getAKSClient() (containerservice.ManagedClustersClient, error) {
 	return aksClient, nil
 }
 
-// CreateAKS creates a new managed Kubernetes cluster
-func CreateAKS(ctx context.Context, resourceName, location, resourceGroupName, username, sshPublicKeyPath, clientID, clientSecret string, agentPoolCount int32) (c containerservice.ManagedCluster, err error) {
-	var sshKeyData string
-	if _, err = os.Stat(sshPublicKeyPath); err == nil {
+// aksSSHKeyData reads the SSH public key for the cluster's nodes, or
+// returns a placeholder key if there is none.
+func aksSSHKeyData(sshPublicKeyPath string) string {
+	if _, err := os.Stat(sshPublicKeyPath); err == nil {
 		sshBytes, err := ioutil.ReadFile(sshPublicKeyPath)
 		if err != nil {
 			log.Fatalf("failed to read SSH key data: %v", err)
 		}
-		sshKeyData = string(sshBytes)
-	} else {
-		sshKeyData = fakepubkey
-	}
-
-	aksClient, err := getAKSClient()
-	if err != nil {
-		return c, fmt.Errorf("cannot get AKS client: %v", err)
+		return string(sshBytes)
 	}
+	return fakepubkey
+}
 
-	future, err := aksClient.CreateOrUpdate(
-		ctx,
-		resourceGroupName,
-		resourceName,
-		containerservice.ManagedCluster{
-			Name:     &resourceName,
-			Location: &location,
-			ManagedClusterProperties: &containerservice.ManagedClusterProperties{
-				DNSPrefix: &resourceName,
-				LinuxProfile: &containerservice.LinuxProfile{
-					AdminUsername: to.StringPtr(username),
-					SSH: &containerservice.SSHConfiguration{
-						PublicKeys: &[]containerservice.SSHPublicKey{
-							{
-								KeyData: to.StringPtr(sshKeyData),
-							},
+// aksCluster returns a managed cluster with one system node pool.
+func aksCluster(resourceName, location, username, sshKeyData string, agentPoolCount int32) containerservice.ManagedCluster {
+	return containerservice.ManagedCluster{
+		Name:     &resourceName,
+		Location: &location,
+		ManagedClusterProperties: &containerservice.ManagedClusterProperties{
+			DNSPrefix: &resourceName,
+			LinuxProfile: &containerservice.LinuxProfile{
+				AdminUsername: to.StringPtr(username),
+				SSH: &containerservice.SSHConfiguration{
+					PublicKeys: &[]containerservice.SSHPublicKey{
+						{
+							KeyData: to.StringPtr(sshKeyData),
 						},
 					},
 				},
-				AgentPoolProfiles: &[]containerservice.AgentPoolProfile{
-					{
-						Count:  to.Int32Ptr(agentPoolCount),
-						Name:   to.StringPtr("agentpool1"),
-						VMSize: containerservice.StandardD2V2,
-					},
-				},
-				ServicePrincipalProfile: &containerservice.ServicePrincipalProfile{
-					ClientID: to.StringPtr(clientID),
-					Secret:   to.StringPtr(clientSecret),
+			},
+			AgentPoolProfiles: &[]containerservice.ManagedClusterAgentPoolProfile{
+				{
+					Count:  to.Int32Ptr(agentPoolCount),
+					Name:   to.StringPtr("agentpool1"),
+					VMSize: containerservice.VMSizeTypesStandardD2V2,
+					Type:   containerservice.VirtualMachineScaleSets,
+					Mode:   containerservice.System,
 				},
 			},
 		},
-	)
+	}
+}
+
+func createAKS(ctx context.Context, resourceGroupName string, cluster containerservice.ManagedCluster) (c containerservice.ManagedCluster, err error) {
+	aksClient, err := getAKSClient()
+	if err != nil {
+		return c, fmt.Errorf("cannot get AKS client: %v", err)
+	}
+
+	future, err := aksClient.CreateOrUpdate(ctx, resourceGroupName, *cluster.Name, cluster)
 	if err != nil {
 		return c, fmt.Errorf("cannot create AKS cluster: %v", err)
 	}
@@ -89,6 +88,27 @@ func CreateAKS(ctx context.Context, resourceName, location, resourceGroupName, u
 	return future.Result(aksClient)
 }
 
+// CreateAKS creates a new managed Kubernetes cluster
+func CreateAKS(ctx context.Context, resourceName, location, resourceGroupName, username, sshPublicKeyPath, clientID, clientSecret string, agentPoolCount int32) (c containerservice.ManagedCluster, err error) {
+	cluster := aksCluster(resourceName, location, username, aksSSHKeyData(sshPublicKeyPath), agentPoolCount)
+	cluster.ServicePrincipalProfile = &containerservice.ManagedClusterServicePrincipalProfile{
+		ClientID: to.StringPtr(clientID),
+		Secret:   to.StringPtr(clientSecret),
+	}
+	return createAKS(ctx, resourceGroupName, cluster)
+}
+
+// CreateAKSWithManagedIdentity creates a new managed Kubernetes cluster
+// that uses a system-assigned managed identity instead of a service
+// principal to manage Azure resources, so no secret has to be rotated.
+func CreateAKSWithManagedIdentity(ctx context.Context, resourceName, location, resourceGroupName, username, sshPublicKeyPath string, agentPoolCount int32) (c containerservice.ManagedCluster, err error) {
+	cluster := aksCluster(resourceName, location, username, aksSSHKeyData(sshPublicKeyPath), agentPoolCount)
+	cluster.Identity = &containerservice.ManagedClusterIdentity{
+		Type: containerservice.ResourceIdentityTypeSystemAssigned,
+	}
+	return createAKS(ctx, resourceGroupName, cluster)
+}
+
 // GetAKS returns an existing AKS cluster given a resource group name and resource name
 func GetAKS(ctx context.Context, resourceGroupName, resourceName string) (c containerservice.ManagedCluster, err error) {
 	aksClient, err := getAKSClient()
@@ -113,3 +133,42 @@ func DeleteAKS(ctx context.Context, resourceGroupName, resourceName string) (c c
 
 	return aksClient.Delete(ctx, resourceGroupName, resourceName)
 }
+
+// StartAKS starts a stopped AKS cluster.
+func StartAKS(ctx context.Context, resourceGroupName, resourceName string) error {
+	aksClient, err := getAKSClient()
+	if err != nil {
+		return fmt.Errorf("cannot get AKS client: %v", err)
+	}
+
+	future, err := aksClient.Start(ctx, resourceGroupName, resourceName)
+	if err != nil {
+		return fmt.Errorf("cannot start AKS cluster: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, aksClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the AKS cluster start future response: %v", err)
+	}
+	return nil
+}
+
+// StopAKS stops an AKS cluster: its nodes are deallocated and the control
+// plane is shut down until the cluster is started again.
+func StopAKS(ctx context.Context, resourceGroupName, resourceName string) error {
+	aksClient, err := getAKSClient()
+	if err != nil {
+		return fmt.Errorf("cannot get AKS client: %v", err)
+	}
+
+	future, err := aksClient.Stop(ctx, resourceGroupName, resourceName)
+	if err != nil {
+		return fmt.Errorf("cannot stop AKS cluster: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, aksClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the AKS cluster stop future response: %v", err)
+	}
+	return nil
+}
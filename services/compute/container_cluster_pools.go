@@ -0,0 +1,179 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2020-09-01/containerservice"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// maxNodePoolNameLength is the longest name of a Linux node pool; Windows
+// node pools are limited to 6 characters.
+const maxNodePoolNameLength = 12
+
+func getAgentPoolsClient() containerservice.AgentPoolsClient {
+	poolsClient := containerservice.NewAgentPoolsClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	poolsClient.Authorizer = auth
+	_ = poolsClient.AddToUserAgent(config.UserAgent())
+	poolsClient.PollingDuration = time.Hour * 1
+	return poolsClient
+}
+
+// validateNodePoolName checks a node pool name: lowercase letters and
+// digits, starting with a letter.
+func validateNodePoolName(name string, osType containerservice.OSType) error {
+	max := maxNodePoolNameLength
+	if osType == containerservice.Windows {
+		max = 6
+	}
+	if name == "" || len(name) > max {
+		return fmt.Errorf("node pool name %q must have 1 to %d characters", name, max)
+	}
+	for i, r := range name {
+		if (r < 'a' || r > 'z') && (i == 0 || r < '0' || r > '9') {
+			return fmt.Errorf("node pool name %q must be lowercase letters and digits, starting with a letter", name)
+		}
+	}
+	return nil
+}
+
+func putNodePool(ctx context.Context, resourceGroupName, clusterName, poolName string, pool containerservice.AgentPool) (containerservice.AgentPool, error) {
+	poolsClient := getAgentPoolsClient()
+	future, err := poolsClient.CreateOrUpdate(ctx, resourceGroupName, clusterName, poolName, pool)
+	if err != nil {
+		return pool, fmt.Errorf("cannot update node pool: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, poolsClient.Client)
+	if err != nil {
+		return pool, fmt.Errorf("cannot get the node pool create or update future response: %v", err)
+	}
+
+	return future.Result(poolsClient)
+}
+
+// AddNodePool adds a Linux user node pool of count VMs to a cluster.
+func AddNodePool(ctx context.Context, resourceGroupName, clusterName, poolName string, vmSize containerservice.VMSizeTypes, count int32) (containerservice.AgentPool, error) {
+	if err := validateNodePoolName(poolName, containerservice.Linux); err != nil {
+		return containerservice.AgentPool{}, err
+	}
+	return putNodePool(ctx, resourceGroupName, clusterName, poolName, containerservice.AgentPool{
+		ManagedClusterAgentPoolProfileProperties: &containerservice.ManagedClusterAgentPoolProfileProperties{
+			Count:  to.Int32Ptr(count),
+			VMSize: vmSize,
+			OsType: containerservice.Linux,
+			Type:   containerservice.VirtualMachineScaleSets,
+			Mode:   containerservice.User,
+		},
+	})
+}
+
+// GetNodePool returns a node pool of a cluster.
+func GetNodePool(ctx context.Context, resourceGroupName, clusterName, poolName string) (containerservice.AgentPool, error) {
+	poolsClient := getAgentPoolsClient()
+	pool, err := poolsClient.Get(ctx, resourceGroupName, clusterName, poolName)
+	if err != nil {
+		return pool, fmt.Errorf("cannot get node pool %s: %v", poolName, err)
+	}
+	if pool.ManagedClusterAgentPoolProfileProperties == nil {
+		return pool, fmt.Errorf("node pool %s has no properties", poolName)
+	}
+	return pool, nil
+}
+
+// ListNodePools lists the node pools of a cluster.
+func ListNodePools(ctx context.Context, resourceGroupName, clusterName string) ([]containerservice.AgentPool, error) {
+	poolsClient := getAgentPoolsClient()
+	list, err := poolsClient.ListComplete(ctx, resourceGroupName, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list node pools: %v", err)
+	}
+	var pools []containerservice.AgentPool
+	for list.NotDone() {
+		pools = append(pools, list.Value())
+		if err := list.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("cannot list node pools: %v", err)
+		}
+	}
+	return pools, nil
+}
+
+// ScaleNodePool sets the number of nodes of a node pool. Pools with the
+// cluster autoscaler enabled are refused, since it would undo the change.
+func ScaleNodePool(ctx context.Context, resourceGroupName, clusterName, poolName string, count int32) (containerservice.AgentPool, error) {
+	pool, err := GetNodePool(ctx, resourceGroupName, clusterName, poolName)
+	if err != nil {
+		return pool, err
+	}
+	if to.Bool(pool.EnableAutoScaling) {
+		return pool, fmt.Errorf("node pool %s is autoscaled between %d and %d nodes", poolName, to.Int32(pool.MinCount), to.Int32(pool.MaxCount))
+	}
+	if count < 0 || (count == 0 && pool.Mode == containerservice.System) {
+		return pool, fmt.Errorf("cannot scale %s node pool %s to %d nodes", pool.Mode, poolName, count)
+	}
+	pool.Count = to.Int32Ptr(count)
+	return putNodePool(ctx, resourceGroupName, clusterName, poolName, pool)
+}
+
+// GetNodePoolUpgrades returns the Kubernetes version of a node pool and
+// the versions it can be upgraded to, oldest first.
+func GetNodePoolUpgrades(ctx context.Context, resourceGroupName, clusterName, poolName string) (current string, upgrades []AKSUpgrade, err error) {
+	poolsClient := getAgentPoolsClient()
+	profile, err := poolsClient.GetUpgradeProfile(ctx, resourceGroupName, clusterName, poolName)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot get node pool upgrade profile: %v", err)
+	}
+	if profile.AgentPoolUpgradeProfileProperties == nil {
+		return "", nil, nil
+	}
+	if profile.Upgrades != nil {
+		for _, u := range *profile.Upgrades {
+			upgrades = append(upgrades, AKSUpgrade{KubernetesVersion: to.String(u.KubernetesVersion), Preview: to.Bool(u.IsPreview)})
+		}
+	}
+	sortUpgrades(upgrades)
+	return to.String(profile.KubernetesVersion), upgrades, nil
+}
+
+// UpgradeNodePool upgrades a node pool to a Kubernetes version returned
+// by GetNodePoolUpgrades. A node pool cannot be newer than the control
+// plane, so upgrade the cluster with UpgradeAKS first.
+func UpgradeNodePool(ctx context.Context, resourceGroupName, clusterName, poolName, version string) (containerservice.AgentPool, error) {
+	current, upgrades, err := GetNodePoolUpgrades(ctx, resourceGroupName, clusterName, poolName)
+	if err != nil {
+		return containerservice.AgentPool{}, err
+	}
+	if err := checkUpgrade(current, version, upgrades); err != nil {
+		return containerservice.AgentPool{}, err
+	}
+
+	pool, err := GetNodePool(ctx, resourceGroupName, clusterName, poolName)
+	if err != nil {
+		return pool, err
+	}
+	pool.OrchestratorVersion = to.StringPtr(version)
+	return putNodePool(ctx, resourceGroupName, clusterName, poolName, pool)
+}
+
+// DeleteNodePool deletes a node pool and its nodes.
+func DeleteNodePool(ctx context.Context, resourceGroupName, clusterName, poolName string) error {
+	poolsClient := getAgentPoolsClient()
+	future, err := poolsClient.Delete(ctx, resourceGroupName, clusterName, poolName)
+	if err != nil {
+		return fmt.Errorf("cannot delete node pool: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, poolsClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the node pool delete future response: %v", err)
+	}
+	return nil
+}
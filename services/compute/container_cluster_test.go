@@ -5,6 +5,7 @@ package compute
 
 import (
 	"context"
+	"testing"
 	"time"
 
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
@@ -49,3 +50,50 @@ func ExampleCreateAKS() {
 	// retrieved AKS cluster
 	// deleted AKS cluster
 }
+
+func TestAKSUpgrades(t *testing.T) {
+	upgrades := []AKSUpgrade{
+		{KubernetesVersion: "1.19.3", Preview: true},
+		{KubernetesVersion: "1.18.10"},
+		{KubernetesVersion: "1.18.8"},
+	}
+	sortUpgrades(upgrades)
+	if upgrades[0].KubernetesVersion != "1.18.8" || upgrades[2].KubernetesVersion != "1.19.3" {
+		t.Errorf("upgrades not sorted: %+v", upgrades)
+	}
+	if u, ok := LatestAKSUpgrade(upgrades, false); !ok || u.KubernetesVersion != "1.18.10" {
+		t.Errorf("got %+v, want 1.18.10", u)
+	}
+	if u, ok := LatestAKSUpgrade(upgrades, true); !ok || u.KubernetesVersion != "1.19.3" {
+		t.Errorf("got %+v, want the preview 1.19.3", u)
+	}
+	if _, ok := LatestAKSUpgrade(nil, true); ok {
+		t.Errorf("expected no upgrade")
+	}
+
+	if err := checkUpgrade("1.17.13", "1.18.10", upgrades); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkUpgrade("1.17.13", "1.20.0", upgrades); err == nil {
+		t.Errorf("expected an unavailable version to be refused")
+	}
+	if err := checkUpgrade("1.18.10", "1.18.10", upgrades); err == nil {
+		t.Errorf("expected the current version to be refused")
+	}
+}
+
+func TestValidateNodePoolName(t *testing.T) {
+	for _, name := range []string{"user1", "gpu", "abcdefghijkl"} {
+		if err := validateNodePoolName(name, "Linux"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "1pool", "User", "user-1", "abcdefghijklm"} {
+		if err := validateNodePoolName(name, "Linux"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := validateNodePoolName("winpool", "Windows"); err == nil {
+		t.Errorf("expected a long windows pool name to be refused")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2020-09-01/containerservice"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// AKSUpgrade is a Kubernetes version a cluster or node pool can be
+// upgraded to.
+type AKSUpgrade struct {
+	KubernetesVersion string
+	Preview           bool
+}
+
+// compareKubernetesVersions compares two "major.minor.patch" versions
+// numerically, returning -1, 0 or 1.
+func compareKubernetesVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortUpgrades orders upgrades from the oldest to the newest version.
+func sortUpgrades(upgrades []AKSUpgrade) {
+	sort.Slice(upgrades, func(i, j int) bool {
+		return compareKubernetesVersions(upgrades[i].KubernetesVersion, upgrades[j].KubernetesVersion) < 0
+	})
+}
+
+// LatestAKSUpgrade returns the newest of upgrades, skipping preview
+// versions unless allowPreview is set. It returns false if there is none.
+func LatestAKSUpgrade(upgrades []AKSUpgrade, allowPreview bool) (AKSUpgrade, bool) {
+	for i := len(upgrades) - 1; i >= 0; i-- {
+		if allowPreview || !upgrades[i].Preview {
+			return upgrades[i], true
+		}
+	}
+	return AKSUpgrade{}, false
+}
+
+// checkUpgrade verifies that version is one of the available upgrades.
+func checkUpgrade(current, version string, upgrades []AKSUpgrade) error {
+	if compareKubernetesVersions(version, current) == 0 {
+		return fmt.Errorf("already on Kubernetes %s", current)
+	}
+	for _, u := range upgrades {
+		if u.KubernetesVersion == version {
+			return nil
+		}
+	}
+	var available []string
+	for _, u := range upgrades {
+		available = append(available, u.KubernetesVersion)
+	}
+	return fmt.Errorf("cannot upgrade from Kubernetes %s to %s; available upgrades: %s", current, version, strings.Join(available, ", "))
+}
+
+func clusterUpgrades(profile containerservice.ManagedClusterUpgradeProfile) (current string, upgrades []AKSUpgrade) {
+	if profile.ManagedClusterUpgradeProfileProperties == nil || profile.ControlPlaneProfile == nil {
+		return "", nil
+	}
+	cp := profile.ControlPlaneProfile
+	if cp.Upgrades != nil {
+		for _, u := range *cp.Upgrades {
+			upgrades = append(upgrades, AKSUpgrade{KubernetesVersion: to.String(u.KubernetesVersion), Preview: to.Bool(u.IsPreview)})
+		}
+	}
+	sortUpgrades(upgrades)
+	return to.String(cp.KubernetesVersion), upgrades
+}
+
+// GetAKSUpgrades returns the current Kubernetes version of a cluster's
+// control plane and the versions it can be upgraded to, oldest first.
+func GetAKSUpgrades(ctx context.Context, resourceGroupName, resourceName string) (current string, upgrades []AKSUpgrade, err error) {
+	aksClient, err := getAKSClient()
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot get AKS client: %v", err)
+	}
+
+	profile, err := aksClient.GetUpgradeProfile(ctx, resourceGroupName, resourceName)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot get AKS upgrade profile: %v", err)
+	}
+	current, upgrades = clusterUpgrades(profile)
+	return current, upgrades, nil
+}
+
+// UpgradeAKS upgrades the control plane of a cluster to a Kubernetes
+// version returned by GetAKSUpgrades. With upgradeNodePools, the cluster's
+// node pools are upgraded to the same version; otherwise they are left for
+// UpgradeNodePool.
+func UpgradeAKS(ctx context.Context, resourceGroupName, resourceName, version string, upgradeNodePools bool) (c containerservice.ManagedCluster, err error) {
+	current, upgrades, err := GetAKSUpgrades(ctx, resourceGroupName, resourceName)
+	if err != nil {
+		return c, err
+	}
+	if err := checkUpgrade(current, version, upgrades); err != nil {
+		return c, err
+	}
+
+	c, err = GetAKS(ctx, resourceGroupName, resourceName)
+	if err != nil {
+		return c, err
+	}
+	if c.ManagedClusterProperties == nil {
+		return c, fmt.Errorf("AKS cluster %s has no properties", resourceName)
+	}
+	c.KubernetesVersion = to.StringPtr(version)
+	if upgradeNodePools && c.AgentPoolProfiles != nil {
+		for i := range *c.AgentPoolProfiles {
+			(*c.AgentPoolProfiles)[i].OrchestratorVersion = to.StringPtr(version)
+		}
+	}
+	return createAKS(ctx, resourceGroupName, c)
+}
@@ -0,0 +1,179 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/containerinstance/mgmt/2018-10-01/containerinstance"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// containerStateTerminated is the state of a container that exited.
+const containerStateTerminated = "Terminated"
+
+// States of a container group in which its containers no longer run.
+const (
+	containerGroupStateFailed  = "Failed"
+	containerGroupStateStopped = "Stopped"
+)
+
+func getContainerClient() containerinstance.ContainerClient {
+	containerClient := containerinstance.NewContainerClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	containerClient.Authorizer = auth
+	_ = containerClient.AddToUserAgent(config.UserAgent())
+	return containerClient
+}
+
+// GetContainerLogs returns the last tail lines of a container's output,
+// or all of it if tail is 0.
+func GetContainerLogs(ctx context.Context, resourceGroupName, containerGroupName, containerName string, tail int32) (string, error) {
+	var tailLines *int32
+	if tail > 0 {
+		tailLines = to.Int32Ptr(tail)
+	}
+	logs, err := getContainerClient().ListLogs(ctx, resourceGroupName, containerGroupName, containerName, tailLines)
+	if err != nil {
+		return "", fmt.Errorf("cannot get container logs: %v", err)
+	}
+	return to.String(logs.Content), nil
+}
+
+// ContainerExecSession is an exec session in a running container. Connect
+// to WebSocketURI and send Password as the first message; the socket then
+// carries the terminal's input and output.
+type ContainerExecSession struct {
+	WebSocketURI string
+	Password     string
+}
+
+// ExecInContainer starts command, such as "/bin/sh", in a running
+// container with a terminal of rows by cols characters.
+func ExecInContainer(ctx context.Context, resourceGroupName, containerGroupName, containerName, command string, rows, cols int32) (ContainerExecSession, error) {
+	response, err := getContainerClient().ExecuteCommand(ctx, resourceGroupName, containerGroupName, containerName, containerinstance.ContainerExecRequest{
+		Command: to.StringPtr(command),
+		TerminalSize: &containerinstance.ContainerExecRequestTerminalSize{
+			Rows: to.Int32Ptr(rows),
+			Cols: to.Int32Ptr(cols),
+		},
+	})
+	if err != nil {
+		return ContainerExecSession{}, fmt.Errorf("cannot exec in container: %v", err)
+	}
+	return ContainerExecSession{WebSocketURI: to.String(response.WebSocketURI), Password: to.String(response.Password)}, nil
+}
+
+// ContainerExit is the state of a container of a group, with its exit code
+// once it terminated.
+type ContainerExit struct {
+	Name         string
+	State        string
+	ExitCode     int32
+	DetailStatus string
+	RestartCount int32
+}
+
+// ContainerGroupResult is the state of a container group and its
+// containers.
+type ContainerGroupResult struct {
+	State      string
+	Containers []ContainerExit
+	// Terminal is set once no container runs or will be restarted, or the
+	// group failed or was stopped.
+	Terminal bool
+}
+
+// Err returns an error naming the containers that exited with a non-zero
+// code, or reporting that the group failed, or nil.
+func (r ContainerGroupResult) Err() error {
+	var failed []string
+	for _, c := range r.Containers {
+		if c.State == containerStateTerminated && c.ExitCode != 0 {
+			failed = append(failed, fmt.Sprintf("%s exited with %d", c.Name, c.ExitCode))
+		}
+	}
+	if len(failed) == 0 && r.State == containerGroupStateFailed {
+		return fmt.Errorf("container group failed")
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("containers failed: %s", strings.Join(failed, ", "))
+}
+
+// containerGroupResult reads the state of a group from its instance
+// views. A group is terminal when it failed or was stopped, or when all
+// containers terminated and its restart policy does not restart them.
+func containerGroupResult(cg containerinstance.ContainerGroup) ContainerGroupResult {
+	var result ContainerGroupResult
+	if cg.ContainerGroupProperties == nil {
+		return result
+	}
+	if cg.InstanceView != nil {
+		result.State = to.String(cg.InstanceView.State)
+	}
+	stopped := result.State == containerGroupStateFailed || result.State == containerGroupStateStopped
+	if cg.Containers == nil || len(*cg.Containers) == 0 {
+		result.Terminal = stopped
+		return result
+	}
+
+	terminal := true
+	for _, c := range *cg.Containers {
+		exit := ContainerExit{Name: to.String(c.Name)}
+		if c.ContainerProperties != nil && c.InstanceView != nil {
+			exit.RestartCount = to.Int32(c.InstanceView.RestartCount)
+			if s := c.InstanceView.CurrentState; s != nil {
+				exit.State = to.String(s.State)
+				exit.ExitCode = to.Int32(s.ExitCode)
+				exit.DetailStatus = to.String(s.DetailStatus)
+			}
+		}
+		switch {
+		case exit.State != containerStateTerminated:
+			terminal = false
+		case cg.RestartPolicy == containerinstance.Always, cg.RestartPolicy == "":
+			terminal = false
+		case cg.RestartPolicy == containerinstance.OnFailure && exit.ExitCode != 0:
+			terminal = false
+		}
+		result.Containers = append(result.Containers, exit)
+	}
+	result.Terminal = terminal || stopped
+	return result
+}
+
+// GetContainerGroupResult returns the state of a container group and its
+// containers.
+func GetContainerGroupResult(ctx context.Context, resourceGroupName, containerGroupName string) (ContainerGroupResult, error) {
+	cg, err := GetContainerGroup(ctx, resourceGroupName, containerGroupName)
+	if err != nil {
+		return ContainerGroupResult{}, err
+	}
+	return containerGroupResult(cg), nil
+}
+
+// WaitForContainerGroup polls a container group, with DefaultBackoff, until
+// all its containers terminated or the group failed or was stopped, and
+// returns their exit codes. Groups that always restart their containers
+// never terminate and are refused.
+func WaitForContainerGroup(ctx context.Context, resourceGroupName, containerGroupName string) (result ContainerGroupResult, err error) {
+	cg, err := GetContainerGroup(ctx, resourceGroupName, containerGroupName)
+	if err != nil {
+		return result, err
+	}
+	if cg.ContainerGroupProperties == nil || cg.RestartPolicy == containerinstance.Always || cg.RestartPolicy == "" {
+		return result, fmt.Errorf("container group %s restarts its containers and never terminates", containerGroupName)
+	}
+	err = pollUntil(ctx, DefaultBackoff, "container group "+containerGroupName+" to terminate", func() (bool, error) {
+		result, err = GetContainerGroupResult(ctx, resourceGroupName, containerGroupName)
+		return err == nil && result.Terminal, err
+	})
+	return result, err
+}
@@ -0,0 +1,434 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-sdk-for-go/services/containerinstance/mgmt/2018-10-01/containerinstance"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// ContainerSpec describes one container of a container group. Start from
+// NewContainerSpec and change it with the With methods.
+type ContainerSpec struct {
+	Name     string
+	Image    string
+	Command  []string
+	CPU      float64
+	MemoryGB float64
+	Ports    []int32
+	// Env are environment variables; SecureEnv are environment variables
+	// whose values are not returned when the group is read back.
+	Env       map[string]string
+	SecureEnv map[string]string
+	Mounts    []ContainerMountSpec
+}
+
+// ContainerMountSpec mounts a volume of the group into a container.
+type ContainerMountSpec struct {
+	Volume    string
+	MountPath string
+	ReadOnly  bool
+}
+
+// NewContainerSpec returns a spec for a container with 1 CPU and 1.5 GB of
+// memory.
+func NewContainerSpec(name, image string) *ContainerSpec {
+	return &ContainerSpec{Name: name, Image: image, CPU: 1, MemoryGB: 1.5}
+}
+
+// WithCommand overrides the image's entrypoint.
+func (c *ContainerSpec) WithCommand(command ...string) *ContainerSpec {
+	c.Command = command
+	return c
+}
+
+// WithResources sets the CPU cores and memory the container requests.
+func (c *ContainerSpec) WithResources(cpu, memoryGB float64) *ContainerSpec {
+	c.CPU = cpu
+	c.MemoryGB = memoryGB
+	return c
+}
+
+// WithPort opens a TCP port of the container to the other containers of
+// the group. Expose it outside the group with
+// ContainerGroupSpec.WithPublicIP or WithVNet.
+func (c *ContainerSpec) WithPort(port int32) *ContainerSpec {
+	c.Ports = append(c.Ports, port)
+	return c
+}
+
+// WithEnv sets an environment variable.
+func (c *ContainerSpec) WithEnv(name, value string) *ContainerSpec {
+	if c.Env == nil {
+		c.Env = map[string]string{}
+	}
+	c.Env[name] = value
+	return c
+}
+
+// WithSecureEnv sets an environment variable whose value is hidden from
+// reads of the group, for secrets.
+func (c *ContainerSpec) WithSecureEnv(name, value string) *ContainerSpec {
+	if c.SecureEnv == nil {
+		c.SecureEnv = map[string]string{}
+	}
+	c.SecureEnv[name] = value
+	return c
+}
+
+// WithMount mounts a volume of the group at mountPath.
+func (c *ContainerSpec) WithMount(volume, mountPath string, readOnly bool) *ContainerSpec {
+	c.Mounts = append(c.Mounts, ContainerMountSpec{Volume: volume, MountPath: mountPath, ReadOnly: readOnly})
+	return c
+}
+
+// ContainerVolumeSpec is a volume of a container group: an Azure Files
+// share, or a secret volume whose files hold the values of Secret.
+type ContainerVolumeSpec struct {
+	Name string
+
+	StorageAccountName string
+	StorageAccountKey  string
+	ShareName          string
+	ReadOnly           bool
+
+	// Secret maps file names to their contents.
+	Secret map[string]string
+}
+
+// ContainerGroupSpec describes a container group to create with
+// CreateContainerGroupFromSpec. Start from NewContainerGroupSpec and change
+// it with the With methods.
+type ContainerGroupSpec struct {
+	Name          string
+	Location      string
+	OSType        containerinstance.OperatingSystemTypes
+	RestartPolicy containerinstance.ContainerGroupRestartPolicy
+	Containers    []*ContainerSpec
+	Volumes       []ContainerVolumeSpec
+
+	// PublicPorts are exposed on a public IP address, with an optional
+	// DNS name label.
+	PublicPorts  []int32
+	DNSNameLabel string
+
+	// NetworkProfileID injects the group into a virtual network subnet
+	// through a network profile; the group then gets a private IP address.
+	NetworkProfileID string
+	PrivatePorts     []int32
+
+	Tags map[string]string
+}
+
+// NewContainerGroupSpec returns a spec for a Linux container group in the
+// default location whose containers are always restarted.
+func NewContainerGroupSpec(name string) *ContainerGroupSpec {
+	return &ContainerGroupSpec{
+		Name:          name,
+		Location:      config.Location(),
+		OSType:        containerinstance.Linux,
+		RestartPolicy: containerinstance.Always,
+	}
+}
+
+// WithLocation sets the region of the group.
+func (s *ContainerGroupSpec) WithLocation(location string) *ContainerGroupSpec {
+	s.Location = location
+	return s
+}
+
+// WithWindows runs the group's containers on Windows.
+func (s *ContainerGroupSpec) WithWindows() *ContainerGroupSpec {
+	s.OSType = containerinstance.Windows
+	return s
+}
+
+// WithRestartPolicy sets when containers that exit are restarted. Use
+// Never or OnFailure for jobs, to wait for with WaitForContainerGroup.
+func (s *ContainerGroupSpec) WithRestartPolicy(policy containerinstance.ContainerGroupRestartPolicy) *ContainerGroupSpec {
+	s.RestartPolicy = policy
+	return s
+}
+
+// WithContainer adds a container to the group.
+func (s *ContainerGroupSpec) WithContainer(container *ContainerSpec) *ContainerGroupSpec {
+	s.Containers = append(s.Containers, container)
+	return s
+}
+
+// WithAzureFileVolume adds a volume backed by an Azure Files share.
+func (s *ContainerGroupSpec) WithAzureFileVolume(name, accountName, accountKey, shareName string, readOnly bool) *ContainerGroupSpec {
+	s.Volumes = append(s.Volumes, ContainerVolumeSpec{
+		Name:               name,
+		StorageAccountName: accountName,
+		StorageAccountKey:  accountKey,
+		ShareName:          shareName,
+		ReadOnly:           readOnly,
+	})
+	return s
+}
+
+// WithSecretVolume adds a volume with one file per entry of files, kept
+// in memory.
+func (s *ContainerGroupSpec) WithSecretVolume(name string, files map[string]string) *ContainerGroupSpec {
+	s.Volumes = append(s.Volumes, ContainerVolumeSpec{Name: name, Secret: files})
+	return s
+}
+
+// WithPublicIP exposes ports of the group's containers on a public IP
+// address. A non-empty dnsNameLabel gives the address a DNS name in the
+// group's region.
+func (s *ContainerGroupSpec) WithPublicIP(dnsNameLabel string, ports ...int32) *ContainerGroupSpec {
+	s.DNSNameLabel = dnsNameLabel
+	s.PublicPorts = append(s.PublicPorts, ports...)
+	return s
+}
+
+// WithVNet deploys the group into the subnet of a network profile and
+// exposes ports on its private IP address.
+func (s *ContainerGroupSpec) WithVNet(networkProfileID string, ports ...int32) *ContainerGroupSpec {
+	s.NetworkProfileID = networkProfileID
+	s.PrivatePorts = append(s.PrivatePorts, ports...)
+	return s
+}
+
+// WithTag sets a tag on the group.
+func (s *ContainerGroupSpec) WithTag(key, value string) *ContainerGroupSpec {
+	if s.Tags == nil {
+		s.Tags = map[string]string{}
+	}
+	s.Tags[key] = value
+	return s
+}
+
+// Validate checks that the spec describes a group the service can create.
+func (s *ContainerGroupSpec) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("container group name is required")
+	}
+	if len(s.Containers) == 0 {
+		return fmt.Errorf("container group %s has no containers", s.Name)
+	}
+	if s.NetworkProfileID != "" && (len(s.PublicPorts) > 0 || s.DNSNameLabel != "") {
+		return fmt.Errorf("container group %s cannot have a public IP address in a virtual network", s.Name)
+	}
+	if s.OSType == containerinstance.Windows && s.NetworkProfileID != "" {
+		return fmt.Errorf("windows container groups cannot be deployed into a virtual network")
+	}
+
+	volumes := map[string]bool{}
+	for _, v := range s.Volumes {
+		if v.Name == "" || volumes[v.Name] {
+			return fmt.Errorf("volume names must be unique and non-empty, got %q", v.Name)
+		}
+		volumes[v.Name] = true
+		if v.Secret == nil && (v.StorageAccountName == "" || v.StorageAccountKey == "" || v.ShareName == "") {
+			return fmt.Errorf("azure file volume %s needs a storage account, key and share", v.Name)
+		}
+	}
+
+	names := map[string]bool{}
+	ports := map[int32]string{}
+	for _, c := range s.Containers {
+		if c.Name == "" || names[c.Name] {
+			return fmt.Errorf("container names must be unique and non-empty, got %q", c.Name)
+		}
+		names[c.Name] = true
+		if c.Image == "" {
+			return fmt.Errorf("container %s has no image", c.Name)
+		}
+		if c.CPU <= 0 || c.MemoryGB <= 0 {
+			return fmt.Errorf("container %s needs CPU and memory", c.Name)
+		}
+		for _, p := range c.Ports {
+			// containers of a group share a network namespace
+			if other, ok := ports[p]; ok {
+				return fmt.Errorf("port %d is used by containers %s and %s", p, other, c.Name)
+			}
+			ports[p] = c.Name
+		}
+		for name := range c.SecureEnv {
+			if _, ok := c.Env[name]; ok {
+				return fmt.Errorf("container %s sets %s as both a plain and a secure variable", c.Name, name)
+			}
+		}
+		for _, m := range c.Mounts {
+			if !volumes[m.Volume] {
+				return fmt.Errorf("container %s mounts unknown volume %s", c.Name, m.Volume)
+			}
+			if m.MountPath == "" {
+				return fmt.Errorf("container %s mounts volume %s without a path", c.Name, m.Volume)
+			}
+		}
+	}
+	for _, p := range append(append([]int32{}, s.PublicPorts...), s.PrivatePorts...) {
+		if _, ok := ports[p]; !ok {
+			return fmt.Errorf("port %d is exposed but no container opens it", p)
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in order, so that specs produce the
+// same resource every time.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *ContainerSpec) container() containerinstance.Container {
+	var env []containerinstance.EnvironmentVariable
+	for _, name := range sortedKeys(c.Env) {
+		env = append(env, containerinstance.EnvironmentVariable{Name: to.StringPtr(name), Value: to.StringPtr(c.Env[name])})
+	}
+	for _, name := range sortedKeys(c.SecureEnv) {
+		env = append(env, containerinstance.EnvironmentVariable{Name: to.StringPtr(name), SecureValue: to.StringPtr(c.SecureEnv[name])})
+	}
+	var ports []containerinstance.ContainerPort
+	for _, p := range c.Ports {
+		ports = append(ports, containerinstance.ContainerPort{Port: to.Int32Ptr(p), Protocol: containerinstance.ContainerNetworkProtocolTCP})
+	}
+	var mounts []containerinstance.VolumeMount
+	for _, m := range c.Mounts {
+		mounts = append(mounts, containerinstance.VolumeMount{
+			Name:      to.StringPtr(m.Volume),
+			MountPath: to.StringPtr(m.MountPath),
+			ReadOnly:  to.BoolPtr(m.ReadOnly),
+		})
+	}
+
+	properties := &containerinstance.ContainerProperties{
+		Image: to.StringPtr(c.Image),
+		Resources: &containerinstance.ResourceRequirements{
+			Requests: &containerinstance.ResourceRequests{
+				CPU:        to.Float64Ptr(c.CPU),
+				MemoryInGB: to.Float64Ptr(c.MemoryGB),
+			},
+		},
+	}
+	if len(c.Command) > 0 {
+		command := c.Command
+		properties.Command = &command
+	}
+	if len(env) > 0 {
+		properties.EnvironmentVariables = &env
+	}
+	if len(ports) > 0 {
+		properties.Ports = &ports
+	}
+	if len(mounts) > 0 {
+		properties.VolumeMounts = &mounts
+	}
+	return containerinstance.Container{Name: to.StringPtr(c.Name), ContainerProperties: properties}
+}
+
+func (v ContainerVolumeSpec) volume() containerinstance.Volume {
+	volume := containerinstance.Volume{Name: to.StringPtr(v.Name)}
+	if v.Secret != nil {
+		// secret volume files are passed base64 encoded
+		volume.Secret = map[string]*string{}
+		for name, content := range v.Secret {
+			volume.Secret[name] = to.StringPtr(base64.StdEncoding.EncodeToString([]byte(content)))
+		}
+		return volume
+	}
+	volume.AzureFile = &containerinstance.AzureFileVolume{
+		ShareName:          to.StringPtr(v.ShareName),
+		StorageAccountName: to.StringPtr(v.StorageAccountName),
+		StorageAccountKey:  to.StringPtr(v.StorageAccountKey),
+		ReadOnly:           to.BoolPtr(v.ReadOnly),
+	}
+	return volume
+}
+
+func groupPorts(ports []int32) *[]containerinstance.Port {
+	var groupPorts []containerinstance.Port
+	for _, p := range ports {
+		groupPorts = append(groupPorts, containerinstance.Port{Port: to.Int32Ptr(p), Protocol: containerinstance.TCP})
+	}
+	return &groupPorts
+}
+
+// ContainerGroup validates the spec and returns the container group
+// resource it describes.
+func (s *ContainerGroupSpec) ContainerGroup() (cg containerinstance.ContainerGroup, err error) {
+	if err := s.Validate(); err != nil {
+		return cg, err
+	}
+
+	var containers []containerinstance.Container
+	for _, c := range s.Containers {
+		containers = append(containers, c.container())
+	}
+	properties := &containerinstance.ContainerGroupProperties{
+		Containers:    &containers,
+		OsType:        s.OSType,
+		RestartPolicy: s.RestartPolicy,
+	}
+	if len(s.Volumes) > 0 {
+		var volumes []containerinstance.Volume
+		for _, v := range s.Volumes {
+			volumes = append(volumes, v.volume())
+		}
+		properties.Volumes = &volumes
+	}
+	switch {
+	case s.NetworkProfileID != "":
+		properties.NetworkProfile = &containerinstance.ContainerGroupNetworkProfile{ID: to.StringPtr(s.NetworkProfileID)}
+		if len(s.PrivatePorts) > 0 {
+			properties.IPAddress = &containerinstance.IPAddress{Type: containerinstance.Private, Ports: groupPorts(s.PrivatePorts)}
+		}
+	case len(s.PublicPorts) > 0:
+		properties.IPAddress = &containerinstance.IPAddress{Type: containerinstance.Public, Ports: groupPorts(s.PublicPorts)}
+		if s.DNSNameLabel != "" {
+			properties.IPAddress.DNSNameLabel = to.StringPtr(s.DNSNameLabel)
+		}
+	}
+
+	cg = containerinstance.ContainerGroup{
+		Name:                     to.StringPtr(s.Name),
+		Location:                 to.StringPtr(s.Location),
+		ContainerGroupProperties: properties,
+	}
+	if len(s.Tags) > 0 {
+		cg.Tags = *to.StringMapPtr(s.Tags)
+	}
+	return cg, nil
+}
+
+// CreateContainerGroupFromSpec creates the container group described by
+// spec in a resource group.
+func CreateContainerGroupFromSpec(ctx context.Context, resourceGroupName string, spec *ContainerGroupSpec) (c containerinstance.ContainerGroup, err error) {
+	cg, err := spec.ContainerGroup()
+	if err != nil {
+		return c, err
+	}
+
+	containerGroupsClient, err := getContainerGroupsClient()
+	if err != nil {
+		return c, fmt.Errorf("cannot get container group client: %v", err)
+	}
+
+	future, err := containerGroupsClient.CreateOrUpdate(ctx, resourceGroupName, spec.Name, cg)
+	if err != nil {
+		return c, fmt.Errorf("cannot create container group: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, containerGroupsClient.Client)
+	if err != nil {
+		return c, fmt.Errorf("cannot get the container group create or update future response: %v", err)
+	}
+
+	return future.Result(containerGroupsClient)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerinstance/mgmt/2018-10-01/containerinstance"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestContainerGroupSpec(t *testing.T) {
+	spec := NewContainerGroupSpec("web").
+		WithRestartPolicy(containerinstance.OnFailure).
+		WithSecretVolume("tls", map[string]string{"cert.pem": "cert"}).
+		WithAzureFileVolume("data", "account", "key", "share", false).
+		WithContainer(NewContainerSpec("nginx", "nginx:latest").
+			WithPort(80).
+			WithEnv("MODE", "prod").
+			WithSecureEnv("TOKEN", "secret").
+			WithMount("tls", "/etc/tls", true)).
+		WithContainer(NewContainerSpec("sidecar", "busybox").
+			WithResources(0.5, 0.5).
+			WithCommand("sh", "-c", "sleep 3600").
+			WithMount("data", "/data", false)).
+		WithPublicIP("gosdk-web", 80).
+		WithTag("app", "web")
+
+	cg, err := spec.ContainerGroup()
+	if err != nil {
+		t.Fatalf("cannot build container group: %v", err)
+	}
+	if cg.RestartPolicy != containerinstance.OnFailure || cg.IPAddress.Type != containerinstance.Public ||
+		*cg.IPAddress.DNSNameLabel != "gosdk-web" || *cg.Tags["app"] != "web" {
+		t.Errorf("unexpected group: %+v", cg.ContainerGroupProperties)
+	}
+	nginx := (*cg.Containers)[0]
+	env := *nginx.EnvironmentVariables
+	if len(env) != 2 || *env[0].Name != "MODE" || *env[0].Value != "prod" || env[1].Value != nil || *env[1].SecureValue != "secret" {
+		t.Errorf("unexpected environment: %+v", env)
+	}
+	if (*nginx.VolumeMounts)[0].ReadOnly == nil || !*(*nginx.VolumeMounts)[0].ReadOnly {
+		t.Errorf("tls volume should be mounted read-only")
+	}
+	volumes := *cg.Volumes
+	if *volumes[0].Secret["cert.pem"] != "Y2VydA==" || volumes[1].AzureFile == nil || *volumes[1].AzureFile.ShareName != "share" {
+		t.Errorf("unexpected volumes: %+v", volumes)
+	}
+	if sidecar := (*cg.Containers)[1]; len(*sidecar.Command) != 3 || *sidecar.Resources.Requests.CPU != 0.5 {
+		t.Errorf("unexpected sidecar: %+v", sidecar.ContainerProperties)
+	}
+
+	vnet, err := NewContainerGroupSpec("private").
+		WithContainer(NewContainerSpec("app", "app").WithPort(8080)).
+		WithVNet("/networkProfiles/aci", 8080).
+		ContainerGroup()
+	if err != nil {
+		t.Fatalf("cannot build container group: %v", err)
+	}
+	if vnet.IPAddress.Type != containerinstance.Private || *vnet.NetworkProfile.ID != "/networkProfiles/aci" {
+		t.Errorf("unexpected vnet group: %+v", vnet.ContainerGroupProperties)
+	}
+}
+
+func TestContainerGroupSpecValidate(t *testing.T) {
+	app := func() *ContainerSpec { return NewContainerSpec("app", "app").WithPort(80) }
+	invalid := map[string]*ContainerGroupSpec{
+		"no containers":   NewContainerGroupSpec("g"),
+		"duplicate name":  NewContainerGroupSpec("g").WithContainer(app()).WithContainer(NewContainerSpec("app", "other")),
+		"shared port":     NewContainerGroupSpec("g").WithContainer(app()).WithContainer(NewContainerSpec("b", "b").WithPort(80)),
+		"unknown volume":  NewContainerGroupSpec("g").WithContainer(app().WithMount("missing", "/m", false)),
+		"unopened port":   NewContainerGroupSpec("g").WithContainer(app()).WithPublicIP("", 443),
+		"public in vnet":  NewContainerGroupSpec("g").WithContainer(app()).WithVNet("/np", 80).WithPublicIP("label"),
+		"no image":        NewContainerGroupSpec("g").WithContainer(NewContainerSpec("a", "")),
+		"env and secure":  NewContainerGroupSpec("g").WithContainer(app().WithEnv("A", "1").WithSecureEnv("A", "2")),
+		"incomplete file": NewContainerGroupSpec("g").WithContainer(app()).WithAzureFileVolume("v", "account", "", "share", false),
+	}
+	for name, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestContainerGroupResult(t *testing.T) {
+	container := func(name, state string, exitCode int32) containerinstance.Container {
+		return containerinstance.Container{
+			Name: to.StringPtr(name),
+			ContainerProperties: &containerinstance.ContainerProperties{
+				InstanceView: &containerinstance.ContainerPropertiesInstanceView{
+					CurrentState: &containerinstance.ContainerState{State: to.StringPtr(state), ExitCode: to.Int32Ptr(exitCode)},
+				},
+			},
+		}
+	}
+	group := func(policy containerinstance.ContainerGroupRestartPolicy, containers ...containerinstance.Container) containerinstance.ContainerGroup {
+		return containerinstance.ContainerGroup{ContainerGroupProperties: &containerinstance.ContainerGroupProperties{
+			RestartPolicy: policy,
+			Containers:    &containers,
+		}}
+	}
+
+	result := containerGroupResult(group(containerinstance.Never, container("a", "Terminated", 0), container("b", "Terminated", 2)))
+	if !result.Terminal || result.Err() == nil || result.Containers[1].ExitCode != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result := containerGroupResult(group(containerinstance.Never, container("a", "Terminated", 0), container("b", "Running", 0))); result.Terminal {
+		t.Errorf("group with a running container reported terminal")
+	}
+	if result := containerGroupResult(group(containerinstance.OnFailure, container("a", "Terminated", 1))); result.Terminal {
+		t.Errorf("failed container that will be restarted reported terminal")
+	}
+	if result := containerGroupResult(group(containerinstance.OnFailure, container("a", "Terminated", 0))); !result.Terminal || result.Err() != nil {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result := containerGroupResult(containerinstance.ContainerGroup{}); result.Terminal {
+		t.Errorf("group without properties reported terminal")
+	}
+
+	// a failed or stopped group is terminal, though its containers never
+	// reported terminating
+	for _, state := range []string{"Failed", "Stopped"} {
+		cg := group(containerinstance.OnFailure, container("a", "Waiting", 0))
+		cg.InstanceView = &containerinstance.ContainerGroupPropertiesInstanceView{State: to.StringPtr(state)}
+		result := containerGroupResult(cg)
+		if !result.Terminal || (result.Err() != nil) != (state == "Failed") {
+			t.Errorf("%s group: unexpected result %+v, %v", state, result, result.Err())
+		}
+		cg.Containers = nil
+		if result := containerGroupResult(cg); !result.Terminal {
+			t.Errorf("%s group without containers reported running", state)
+		}
+	}
+}
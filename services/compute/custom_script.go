@@ -0,0 +1,223 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/storage"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// CustomScript runs a command on a VM with the CustomScript extension,
+// after downloading the files at FileURIs to the working directory.
+type CustomScript struct {
+	OSType           compute.OperatingSystemTypes
+	FileURIs         []string
+	CommandToExecute string
+	// RunID makes the extension run again when the rest of the script is
+	// unchanged; use a new value, such as a timestamp, for every run.
+	RunID string
+}
+
+// ScriptStatus is the state of a CustomScript run.
+type ScriptStatus string
+
+const (
+	// ScriptTransitioning means the script is still running.
+	ScriptTransitioning ScriptStatus = "transitioning"
+	// ScriptSucceeded means the command exited with status 0.
+	ScriptSucceeded ScriptStatus = "succeeded"
+	// ScriptFailed means the files could not be downloaded or the command
+	// exited with an error.
+	ScriptFailed ScriptStatus = "failed"
+)
+
+// ScriptResult is the result of a CustomScript run, read from the
+// extension's instance view.
+type ScriptResult struct {
+	Status  ScriptStatus
+	Message string
+	StdOut  string
+	StdErr  string
+}
+
+// Err returns an error describing a failed run, or nil.
+func (r ScriptResult) Err() error {
+	if r.Status != ScriptFailed {
+		return nil
+	}
+	if r.StdErr != "" {
+		return fmt.Errorf("custom script failed: %s: %s", r.Message, r.StdErr)
+	}
+	return fmt.Errorf("custom script failed: %s", r.Message)
+}
+
+// UploadScripts uploads local script files as blobs and returns read-only
+// SAS URLs for them that expire at expiry, to use as a CustomScript's
+// FileURIs. The container must exist; keep it private, since anyone with
+// the URLs can read the scripts.
+func UploadScripts(ctx context.Context, accountName, accountGroupName, containerName string, scriptPaths []string, expiry time.Time) ([]string, error) {
+	var uris []string
+	for _, p := range scriptPaths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read script: %v", err)
+		}
+		blobName := filepath.Base(p)
+		_, err = storage.UploadBlockBlob(ctx, accountName, accountGroupName, containerName, blobName, data, "text/plain")
+		if err != nil {
+			return nil, fmt.Errorf("cannot upload script %s: %v", blobName, err)
+		}
+		u, err := storage.GetBlobSASURL(ctx, accountName, accountGroupName, containerName, blobName, "r", expiry)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create SAS for script %s: %v", blobName, err)
+		}
+		uris = append(uris, u.String())
+	}
+	return uris, nil
+}
+
+// customScriptExtension returns the extension resource that runs script.
+// File URIs and the command go in the protected settings, which are
+// encrypted and never returned, since the URIs carry SAS tokens.
+func customScriptExtension(location string, script CustomScript) (compute.VirtualMachineExtension, error) {
+	if strings.TrimSpace(script.CommandToExecute) == "" {
+		return compute.VirtualMachineExtension{}, fmt.Errorf("custom script needs a command to execute")
+	}
+	var publisher, extensionType, version string
+	switch script.OSType {
+	case compute.Linux, "":
+		publisher, extensionType, version = "Microsoft.Azure.Extensions", "CustomScript", "2.1"
+	case compute.Windows:
+		publisher, extensionType, version = "Microsoft.Compute", "CustomScriptExtension", "1.10"
+	default:
+		return compute.VirtualMachineExtension{}, fmt.Errorf("unknown OS type %q", script.OSType)
+	}
+
+	protected := map[string]interface{}{
+		"commandToExecute": script.CommandToExecute,
+	}
+	if len(script.FileURIs) > 0 {
+		protected["fileUris"] = script.FileURIs
+	}
+	ext := compute.VirtualMachineExtension{
+		Location: to.StringPtr(location),
+		VirtualMachineExtensionProperties: &compute.VirtualMachineExtensionProperties{
+			Publisher:               to.StringPtr(publisher),
+			Type:                    to.StringPtr(extensionType),
+			TypeHandlerVersion:      to.StringPtr(version),
+			AutoUpgradeMinorVersion: to.BoolPtr(true),
+			Settings:                map[string]interface{}{},
+			ProtectedSettings:       protected,
+		},
+	}
+	if script.RunID != "" {
+		ext.ForceUpdateTag = to.StringPtr(script.RunID)
+	}
+	return ext, nil
+}
+
+// RunCustomScript runs script on a VM with the CustomScript extension
+// named extensionName, and waits for its result. A VM has at most one
+// CustomScript extension, so reuse the name to run further scripts.
+func RunCustomScript(ctx context.Context, vmName, extensionName string, script CustomScript) (ScriptResult, error) {
+	ext, err := customScriptExtension(config.Location(), script)
+	if err != nil {
+		return ScriptResult{}, err
+	}
+
+	extensionsClient := getVMExtensionsClient()
+	future, err := extensionsClient.CreateOrUpdate(ctx, config.GroupName(), vmName, extensionName, ext)
+	if err != nil {
+		return ScriptResult{}, fmt.Errorf("cannot create vm extension: %v", err)
+	}
+	// a failing script fails the deployment too; the instance view then
+	// says why
+	waitErr := future.WaitForCompletionRef(ctx, extensionsClient.Client)
+
+	result, err := WaitForScriptResult(ctx, vmName, extensionName, 10*time.Second)
+	if err != nil {
+		if waitErr != nil {
+			return result, fmt.Errorf("cannot get the extension create or update future response: %v", waitErr)
+		}
+		return result, err
+	}
+	return result, result.Err()
+}
+
+// GetScriptResult reads the latest result of a CustomScript extension from
+// its instance view.
+func GetScriptResult(ctx context.Context, vmName, extensionName string) (ScriptResult, error) {
+	extensionsClient := getVMExtensionsClient()
+	ext, err := extensionsClient.Get(ctx, config.GroupName(), vmName, extensionName, "instanceView")
+	if err != nil {
+		return ScriptResult{}, fmt.Errorf("cannot get vm extension: %v", err)
+	}
+	if ext.VirtualMachineExtensionProperties == nil {
+		return ScriptResult{Status: ScriptTransitioning}, nil
+	}
+	return parseScriptResult(ext.InstanceView), nil
+}
+
+// WaitForScriptResult polls a CustomScript extension's instance view every
+// interval until the script has finished.
+func WaitForScriptResult(ctx context.Context, vmName, extensionName string, interval time.Duration) (ScriptResult, error) {
+	for {
+		result, err := GetScriptResult(ctx, vmName, extensionName)
+		if err != nil || result.Status != ScriptTransitioning {
+			return result, err
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// parseScriptResult reads a result from an extension instance view. The
+// provisioning state is the first status, with codes such as
+// "ProvisioningState/succeeded"; the command output is reported in
+// substatuses with codes such as "ComponentStatus/StdOut/succeeded".
+func parseScriptResult(view *compute.VirtualMachineExtensionInstanceView) ScriptResult {
+	result := ScriptResult{Status: ScriptTransitioning}
+	if view == nil {
+		return result
+	}
+	if view.Statuses != nil && len(*view.Statuses) > 0 {
+		status := (*view.Statuses)[0]
+		// failures may carry an error code, as in "ProvisioningState/failed/1"
+		code := strings.Split(strings.ToLower(to.String(status.Code)), "/")
+		if len(code) > 1 {
+			switch ScriptStatus(code[1]) {
+			case ScriptSucceeded, ScriptFailed:
+				result.Status = ScriptStatus(code[1])
+			}
+		}
+		result.Message = to.String(status.Message)
+		if result.Message == "" {
+			result.Message = to.String(status.DisplayStatus)
+		}
+	}
+	if view.Substatuses != nil {
+		for _, s := range *view.Substatuses {
+			code := strings.ToLower(to.String(s.Code))
+			switch {
+			case strings.Contains(code, "/stdout/"):
+				result.StdOut = to.String(s.Message)
+			case strings.Contains(code, "/stderr/"):
+				result.StdErr = to.String(s.Message)
+			}
+		}
+	}
+	return result
+}
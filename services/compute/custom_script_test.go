@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestCustomScriptExtension(t *testing.T) {
+	ext, err := customScriptExtension("westus2", CustomScript{
+		OSType:           compute.Windows,
+		FileURIs:         []string{"https://acct.blob.core.windows.net/scripts/setup.ps1?sig=x"},
+		CommandToExecute: "powershell -File setup.ps1",
+		RunID:            "1",
+	})
+	if err != nil {
+		t.Fatalf("failed to build extension: %v", err)
+	}
+	// Type on the extension itself is the read-only resource type
+	if *ext.Publisher != "Microsoft.Compute" || *ext.VirtualMachineExtensionProperties.Type != "CustomScriptExtension" {
+		t.Errorf("unexpected windows extension: %s/%s", *ext.Publisher, *ext.VirtualMachineExtensionProperties.Type)
+	}
+	protected := ext.ProtectedSettings.(map[string]interface{})
+	if protected["commandToExecute"] != "powershell -File setup.ps1" || len(protected["fileUris"].([]string)) != 1 {
+		t.Errorf("unexpected protected settings: %v", protected)
+	}
+	if len(ext.Settings.(map[string]interface{})) != 0 {
+		t.Errorf("SAS URLs must not be in public settings: %v", ext.Settings)
+	}
+	if *ext.ForceUpdateTag != "1" {
+		t.Errorf("run ID not used as force update tag")
+	}
+
+	ext, err = customScriptExtension("westus2", CustomScript{CommandToExecute: "echo hi"})
+	if err != nil || *ext.Publisher != "Microsoft.Azure.Extensions" || ext.ForceUpdateTag != nil {
+		t.Errorf("unexpected linux extension: %+v, %v", ext.VirtualMachineExtensionProperties, err)
+	}
+	if _, err := customScriptExtension("westus2", CustomScript{}); err == nil {
+		t.Errorf("expected a script without a command to be rejected")
+	}
+}
+
+func TestParseScriptResult(t *testing.T) {
+	status := func(code, message string) compute.InstanceViewStatus {
+		return compute.InstanceViewStatus{Code: to.StringPtr(code), Message: to.StringPtr(message)}
+	}
+	cases := []struct {
+		view *compute.VirtualMachineExtensionInstanceView
+		want ScriptResult
+	}{
+		{nil, ScriptResult{Status: ScriptTransitioning}},
+		{
+			&compute.VirtualMachineExtensionInstanceView{
+				Statuses: &[]compute.InstanceViewStatus{status("ProvisioningState/succeeded", "Enable succeeded")},
+				Substatuses: &[]compute.InstanceViewStatus{
+					status("ComponentStatus/StdOut/succeeded", "hello\n"),
+					status("ComponentStatus/StdErr/succeeded", ""),
+				},
+			},
+			ScriptResult{Status: ScriptSucceeded, Message: "Enable succeeded", StdOut: "hello\n"},
+		},
+		{
+			&compute.VirtualMachineExtensionInstanceView{
+				Statuses: &[]compute.InstanceViewStatus{status("ProvisioningState/failed/1", "")},
+				Substatuses: &[]compute.InstanceViewStatus{
+					status("ComponentStatus/StdErr/failed", "boom"),
+				},
+			},
+			ScriptResult{Status: ScriptFailed, StdErr: "boom"},
+		},
+		{
+			&compute.VirtualMachineExtensionInstanceView{
+				Statuses: &[]compute.InstanceViewStatus{{Code: to.StringPtr("ProvisioningState/failed"), DisplayStatus: to.StringPtr("Provisioning failed")}},
+			},
+			ScriptResult{Status: ScriptFailed, Message: "Provisioning failed"},
+		},
+		{
+			&compute.VirtualMachineExtensionInstanceView{
+				Statuses: &[]compute.InstanceViewStatus{status("ProvisioningState/transitioning", "Enable in progress")},
+			},
+			ScriptResult{Status: ScriptTransitioning, Message: "Enable in progress"},
+		},
+	}
+	for i, c := range cases {
+		if got := parseScriptResult(c.view); got != c.want {
+			t.Errorf("case %d: got %+v, want %+v", i, got, c.want)
+		}
+	}
+
+	if (ScriptResult{Status: ScriptFailed, Message: "exit 1", StdErr: "boom"}).Err() == nil {
+		t.Errorf("failed result has no error")
+	}
+	if (ScriptResult{Status: ScriptSucceeded}).Err() != nil {
+		t.Errorf("succeeded result has an error")
+	}
+}
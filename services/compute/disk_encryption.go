@@ -0,0 +1,515 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/keyvault"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/gofrs/uuid"
+)
+
+// EncryptionMode is how the data of a managed disk is encrypted.
+type EncryptionMode string
+
+// Encryption modes of a disk.
+const (
+	// EncryptionModePlatform is server-side encryption with platform
+	// managed keys, which every managed disk has.
+	EncryptionModePlatform EncryptionMode = "platform"
+	// EncryptionModeADE is Azure Disk Encryption: the volumes are encrypted
+	// inside the VM, with BitLocker or dm-crypt, by an extension that keeps
+	// the disk keys in Key Vault wrapped by a key encryption key.
+	EncryptionModeADE EncryptionMode = "ade"
+	// EncryptionModeDES is server-side encryption with a customer managed
+	// key, referenced by a disk encryption set.
+	EncryptionModeDES EncryptionMode = "des"
+)
+
+// Volume types Azure Disk Encryption encrypts.
+const (
+	ADEVolumeTypeAll  = "All"
+	ADEVolumeTypeOS   = "OS"
+	ADEVolumeTypeData = "Data"
+)
+
+func getDiskEncryptionSetsClient() compute.DiskEncryptionSetsClient {
+	desClient := compute.NewDiskEncryptionSetsClient(config.SubscriptionID())
+	a, _ := iam.GetResourceManagementAuthorizer()
+	desClient.Authorizer = a
+	_ = desClient.AddToUserAgent(config.UserAgent())
+	return desClient
+}
+
+// diskEncryptionSetRef is the resource group and name of a disk encryption
+// set, which need not be in the default resource group.
+type diskEncryptionSetRef struct {
+	groupName string
+	name      string
+}
+
+func parseDiskEncryptionSetID(id string) (diskEncryptionSetRef, error) {
+	resource, err := azure.ParseResourceID(id)
+	if err != nil {
+		return diskEncryptionSetRef{}, fmt.Errorf("invalid disk encryption set ID %q: %v", id, err)
+	}
+	return diskEncryptionSetRef{groupName: resource.ResourceGroup, name: resource.ResourceName}, nil
+}
+
+// getDiskEncryptionSetByID gets the disk encryption set with a resource ID.
+func getDiskEncryptionSetByID(ctx context.Context, desClient compute.DiskEncryptionSetsClient, id string) (compute.DiskEncryptionSet, error) {
+	ref, err := parseDiskEncryptionSetID(id)
+	if err != nil {
+		return compute.DiskEncryptionSet{}, err
+	}
+	return desClient.Get(ctx, ref.groupName, ref.name)
+}
+
+// adeExtensionName returns the name and type of the Azure Disk Encryption
+// extension for an OS, and the version that works without an AAD
+// application.
+func adeExtensionName(osType compute.OperatingSystemTypes) (name, version string) {
+	if osType == compute.Windows {
+		return "AzureDiskEncryption", "2.2"
+	}
+	return "AzureDiskEncryptionForLinux", "1.1"
+}
+
+// adeExtension returns the Azure Disk Encryption extension that encrypts
+// volumeType volumes of a VM with disk keys stored in the vault and wrapped
+// by keyURL. Running it again with another sequenceVersion re-wraps the
+// disk keys, which is how the key encryption key is rotated.
+func adeExtension(location string, osType compute.OperatingSystemTypes, vaultID, vaultURL, keyURL, volumeType, sequenceVersion string) compute.VirtualMachineExtension {
+	name, version := adeExtensionName(osType)
+	if volumeType == "" {
+		volumeType = ADEVolumeTypeAll
+	}
+	return compute.VirtualMachineExtension{
+		Location: to.StringPtr(location),
+		VirtualMachineExtensionProperties: &compute.VirtualMachineExtensionProperties{
+			Publisher:               to.StringPtr("Microsoft.Azure.Security"),
+			Type:                    to.StringPtr(name),
+			TypeHandlerVersion:      to.StringPtr(version),
+			AutoUpgradeMinorVersion: to.BoolPtr(true),
+			Settings: map[string]interface{}{
+				"EncryptionOperation":    "EnableEncryption",
+				"KeyVaultURL":            vaultURL,
+				"KeyVaultResourceId":     vaultID,
+				"KeyEncryptionKeyURL":    keyURL,
+				"KekVaultResourceId":     vaultID,
+				"KeyEncryptionAlgorithm": "RSA-OAEP",
+				"VolumeType":             volumeType,
+				"SequenceVersion":        sequenceVersion,
+			},
+		},
+	}
+}
+
+// adeVolumeType returns the volume type an installed Azure Disk Encryption
+// extension encrypts.
+func adeVolumeType(ext compute.VirtualMachineExtension) string {
+	if ext.VirtualMachineExtensionProperties != nil {
+		if settings, ok := ext.Settings.(map[string]interface{}); ok {
+			if v, ok := settings["VolumeType"].(string); ok && v != "" {
+				return v
+			}
+		}
+	}
+	return ADEVolumeTypeAll
+}
+
+// EnableADE encrypts the volumes of a VM with Azure Disk Encryption, using
+// disk keys kept in vaultName and wrapped by the key at keyURL. The vault
+// must be prepared with keyvault.PrepareVaultForDiskEncryption. volumeType
+// is one of the ADEVolumeType constants; empty means all volumes.
+func EnableADE(ctx context.Context, vmName, vaultName, keyURL, volumeType string) (ext compute.VirtualMachineExtension, err error) {
+	vm, err := GetVM(ctx, vmName)
+	if err != nil {
+		return ext, fmt.Errorf("cannot get vm: %v", err)
+	}
+	if vm.VirtualMachineProperties == nil || vm.StorageProfile == nil || vm.StorageProfile.OsDisk == nil {
+		return ext, fmt.Errorf("vm %s has no os disk", vmName)
+	}
+	vault, err := keyvault.GetVault(ctx, vaultName)
+	if err != nil {
+		return ext, fmt.Errorf("cannot get vault: %v", err)
+	}
+	if vault.Properties == nil || !to.Bool(vault.Properties.EnabledForDiskEncryption) {
+		return ext, fmt.Errorf("vault %s is not enabled for disk encryption", vaultName)
+	}
+	sequenceVersion, err := uuid.NewV4()
+	if err != nil {
+		return ext, fmt.Errorf("cannot create sequenceVersion: %v", err)
+	}
+
+	osType := vm.StorageProfile.OsDisk.OsType
+	name, _ := adeExtensionName(osType)
+	extensionsClient := getVMExtensionsClient()
+	future, err := extensionsClient.CreateOrUpdate(
+		ctx,
+		config.GroupName(),
+		vmName,
+		name,
+		adeExtension(to.String(vm.Location), osType, to.String(vault.ID), to.String(vault.Properties.VaultURI), keyURL, volumeType, sequenceVersion.String()))
+	if err != nil {
+		return ext, fmt.Errorf("cannot create vm extension: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, extensionsClient.Client)
+	if err != nil {
+		return ext, fmt.Errorf("cannot get the extension create or update future response: %v", err)
+	}
+
+	return future.Result(extensionsClient)
+}
+
+// CreateDiskEncryptionSet creates a disk encryption set that encrypts disks
+// with the key at keyURL in vaultName, and grants the set's identity access
+// to the vault's keys.
+func CreateDiskEncryptionSet(ctx context.Context, desName, vaultName, keyURL string) (des compute.DiskEncryptionSet, err error) {
+	vault, err := keyvault.GetVault(ctx, vaultName)
+	if err != nil {
+		return des, fmt.Errorf("cannot get vault: %v", err)
+	}
+
+	desClient := getDiskEncryptionSetsClient()
+	future, err := desClient.CreateOrUpdate(
+		ctx,
+		config.GroupName(),
+		desName,
+		compute.DiskEncryptionSet{
+			Location: to.StringPtr(config.Location()),
+			Identity: &compute.EncryptionSetIdentity{Type: compute.SystemAssigned},
+			EncryptionSetProperties: &compute.EncryptionSetProperties{
+				ActiveKey: &compute.KeyVaultAndKeyReference{
+					SourceVault: &compute.SourceVault{ID: vault.ID},
+					KeyURL:      to.StringPtr(keyURL),
+				},
+			},
+		})
+	if err != nil {
+		return des, fmt.Errorf("cannot create disk encryption set: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, desClient.Client)
+	if err != nil {
+		return des, fmt.Errorf("cannot get the disk encryption set create or update future response: %v", err)
+	}
+
+	des, err = future.Result(desClient)
+	if err != nil {
+		return des, err
+	}
+	if des.Identity == nil || des.Identity.PrincipalID == nil {
+		return des, fmt.Errorf("disk encryption set %s has no identity", desName)
+	}
+	if _, err := keyvault.PrepareVaultForDiskEncryption(ctx, vaultName, *des.Identity.PrincipalID); err != nil {
+		return des, err
+	}
+	return des, nil
+}
+
+// EncryptVMDisksWithDES encrypts all disks of a VM with the customer
+// managed key of a disk encryption set in the default resource group. The
+// VM is deallocated for the change and started again if it was running.
+func EncryptVMDisksWithDES(ctx context.Context, vmName, desID string) (err error) {
+	vm, err := GetVM(ctx, vmName)
+	if err != nil {
+		return fmt.Errorf("cannot get vm: %v", err)
+	}
+	disks, err := vmDisks(vm)
+	if err != nil {
+		return err
+	}
+
+	state, err := GetVMState(ctx, vmName)
+	if err != nil {
+		return err
+	}
+	if _, err := TransitionVM(ctx, vmName, TransitionDeallocate); err != nil {
+		return err
+	}
+	if state.PowerState == PowerStateRunning || state.PowerState == PowerStateStarting {
+		defer func() {
+			if _, startErr := TransitionVM(ctx, vmName, TransitionStart); startErr != nil && err == nil {
+				err = startErr
+			}
+		}()
+	}
+
+	disksClient := getDisksClient()
+	for _, d := range disks {
+		future, err := disksClient.Update(ctx, config.GroupName(), d.Name, compute.DiskUpdate{
+			DiskUpdateProperties: &compute.DiskUpdateProperties{
+				Encryption: &compute.Encryption{
+					DiskEncryptionSetID: to.StringPtr(desID),
+					Type:                compute.EncryptionAtRestWithCustomerKey,
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("cannot update disk %s: %v", d.Name, err)
+		}
+		err = future.WaitForCompletionRef(ctx, disksClient.Client)
+		if err != nil {
+			return fmt.Errorf("cannot get the disk update future response: %v", err)
+		}
+	}
+	return nil
+}
+
+// DiskEncryptionStatus is how one disk of a VM is encrypted.
+type DiskEncryptionStatus struct {
+	DiskName string
+	OSDisk   bool
+	Lun      int32
+	Mode     EncryptionMode
+	// KeyURL is the key encryption key of an ADE disk, or the active key
+	// of the disk encryption set of a DES disk.
+	KeyURL              string
+	DiskEncryptionSetID string
+}
+
+// diskEncryptionStatus reads the encryption of a disk of a VM from the
+// disk resource. A DES disk's KeyURL is left to the caller, which has to
+// look up the disk encryption set.
+func diskEncryptionStatus(d vmDisk, disk compute.Disk) DiskEncryptionStatus {
+	status := DiskEncryptionStatus{DiskName: d.Name, OSDisk: d.OSDisk, Lun: d.Lun, Mode: EncryptionModePlatform}
+	if disk.DiskProperties == nil {
+		return status
+	}
+	if c := disk.EncryptionSettingsCollection; c != nil && to.Bool(c.Enabled) {
+		status.Mode = EncryptionModeADE
+		if c.EncryptionSettings != nil {
+			for _, s := range *c.EncryptionSettings {
+				if s.KeyEncryptionKey != nil && s.KeyEncryptionKey.KeyURL != nil {
+					status.KeyURL = *s.KeyEncryptionKey.KeyURL
+					break
+				}
+			}
+		}
+		return status
+	}
+	if e := disk.Encryption; e != nil && e.Type == compute.EncryptionAtRestWithCustomerKey {
+		status.Mode = EncryptionModeDES
+		status.DiskEncryptionSetID = to.String(e.DiskEncryptionSetID)
+	}
+	return status
+}
+
+// GetVMEncryptionStatus reports how each disk of a VM is encrypted.
+func GetVMEncryptionStatus(ctx context.Context, vmName string) ([]DiskEncryptionStatus, error) {
+	vm, err := GetVM(ctx, vmName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get vm: %v", err)
+	}
+	disks, err := vmDisks(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	desClient := getDiskEncryptionSetsClient()
+	activeKeys := map[string]string{}
+	var statuses []DiskEncryptionStatus
+	for _, d := range disks {
+		disk, err := getDisk(ctx, d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get disk %s: %v", d.Name, err)
+		}
+		status := diskEncryptionStatus(d, disk)
+		if status.Mode == EncryptionModeDES {
+			key, ok := activeKeys[status.DiskEncryptionSetID]
+			if !ok {
+				des, err := getDiskEncryptionSetByID(ctx, desClient, status.DiskEncryptionSetID)
+				if err != nil {
+					return nil, fmt.Errorf("cannot get disk encryption set: %v", err)
+				}
+				key = desActiveKey(des)
+				activeKeys[status.DiskEncryptionSetID] = key
+			}
+			status.KeyURL = key
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func desActiveKey(des compute.DiskEncryptionSet) string {
+	if des.EncryptionSetProperties == nil || des.ActiveKey == nil {
+		return ""
+	}
+	return to.String(des.ActiveKey.KeyURL)
+}
+
+// encryptionMode returns the mode the encrypted disks of a VM share. Disks
+// with platform keys only are not encrypted by the VM's owner.
+func encryptionMode(statuses []DiskEncryptionStatus) (EncryptionMode, error) {
+	mode := EncryptionModePlatform
+	for _, s := range statuses {
+		if s.Mode == EncryptionModePlatform {
+			continue
+		}
+		if mode != EncryptionModePlatform && mode != s.Mode {
+			return mode, fmt.Errorf("disks are encrypted with both %s and %s", mode, s.Mode)
+		}
+		mode = s.Mode
+	}
+	return mode, nil
+}
+
+// keyRotated reports whether every encrypted disk uses keyURL.
+func keyRotated(statuses []DiskEncryptionStatus, keyURL string) bool {
+	for _, s := range statuses {
+		if s.Mode != EncryptionModePlatform && !strings.EqualFold(s.KeyURL, keyURL) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDESVault returns an error unless the active key of a disk
+// encryption set is in the vault with vaultID. Rotating to a key in
+// another vault would need the set's identity granted access to that
+// vault first.
+func checkDESVault(des compute.DiskEncryptionSet, vaultID string) error {
+	if des.EncryptionSetProperties == nil || des.ActiveKey == nil || des.ActiveKey.SourceVault == nil {
+		return fmt.Errorf("disk encryption set %s has no active key", to.String(des.Name))
+	}
+	if source := to.String(des.ActiveKey.SourceVault.ID); !strings.EqualFold(source, vaultID) {
+		return fmt.Errorf("disk encryption set %s uses keys from %s, not %s", to.String(des.Name), source, vaultID)
+	}
+	return nil
+}
+
+// desRotated reports whether a disk encryption set finished moving its
+// disks to keyURL: the key is active and no previous keys are left.
+func desRotated(des compute.DiskEncryptionSet, keyURL string) bool {
+	if des.EncryptionSetProperties == nil || !strings.EqualFold(desActiveKey(des), keyURL) {
+		return false
+	}
+	if !strings.EqualFold(to.String(des.ProvisioningState), string(ProvisioningStateSucceeded)) {
+		return false
+	}
+	return des.PreviousKeys == nil || len(*des.PreviousKeys) == 0
+}
+
+// RotateDiskEncryptionKey creates a new version of the key encryption key
+// keyName in vaultName and moves the encrypted disks of a VM to it. ADE
+// disks are re-wrapped by running the extension again; disk encryption
+// sets are pointed at the new version, and must already use keys from
+// vaultName. It then polls, with DefaultBackoff,
+// until every disk reports the new key, and returns the key's URL.
+func RotateDiskEncryptionKey(ctx context.Context, vmName, vaultName, keyName string) (keyURL string, err error) {
+	statuses, err := GetVMEncryptionStatus(ctx, vmName)
+	if err != nil {
+		return "", err
+	}
+	mode, err := encryptionMode(statuses)
+	if err != nil {
+		return "", err
+	}
+	if mode == EncryptionModePlatform {
+		return "", fmt.Errorf("vm %s has no disks encrypted with a customer key", vmName)
+	}
+
+	// disk encryption sets keep their source vault, so check they all use
+	// vaultName before creating the new key version
+	desClient := getDiskEncryptionSetsClient()
+	var desIDs []string
+	if mode == EncryptionModeDES {
+		vault, err := keyvault.GetVault(ctx, vaultName)
+		if err != nil {
+			return "", fmt.Errorf("cannot get vault: %v", err)
+		}
+		checked := map[string]bool{}
+		for _, s := range statuses {
+			if s.Mode != EncryptionModeDES || checked[s.DiskEncryptionSetID] {
+				continue
+			}
+			checked[s.DiskEncryptionSetID] = true
+			des, err := getDiskEncryptionSetByID(ctx, desClient, s.DiskEncryptionSetID)
+			if err != nil {
+				return "", fmt.Errorf("cannot get disk encryption set: %v", err)
+			}
+			if err := checkDESVault(des, to.String(vault.ID)); err != nil {
+				return "", err
+			}
+			desIDs = append(desIDs, s.DiskEncryptionSetID)
+		}
+	}
+
+	key, err := keyvault.CreateKeyEncryptionKey(ctx, vaultName, keyName)
+	if err != nil {
+		return "", fmt.Errorf("cannot create key version: %v", err)
+	}
+	if key.Key == nil || key.Key.Kid == nil {
+		return "", fmt.Errorf("key %s has no key ID", keyName)
+	}
+	keyURL = *key.Key.Kid
+
+	switch mode {
+	case EncryptionModeADE:
+		vm, err := GetVM(ctx, vmName)
+		if err != nil {
+			return keyURL, fmt.Errorf("cannot get vm: %v", err)
+		}
+		name, _ := adeExtensionName(vm.StorageProfile.OsDisk.OsType)
+		ext, err := getVMExtensionsClient().Get(ctx, config.GroupName(), vmName, name, "")
+		if err != nil {
+			return keyURL, fmt.Errorf("cannot get vm extension: %v", err)
+		}
+		if _, err := EnableADE(ctx, vmName, vaultName, keyURL, adeVolumeType(ext)); err != nil {
+			return keyURL, err
+		}
+		return keyURL, pollUntil(ctx, DefaultBackoff, "disks to use the new key", func() (bool, error) {
+			statuses, err := GetVMEncryptionStatus(ctx, vmName)
+			return err == nil && keyRotated(statuses, keyURL), err
+		})
+
+	default:
+		for _, id := range desIDs {
+			ref, err := parseDiskEncryptionSetID(id)
+			if err != nil {
+				return keyURL, err
+			}
+			des, err := desClient.Get(ctx, ref.groupName, ref.name)
+			if err != nil {
+				return keyURL, fmt.Errorf("cannot get disk encryption set: %v", err)
+			}
+			if des.EncryptionSetProperties == nil || des.ActiveKey == nil {
+				return keyURL, fmt.Errorf("disk encryption set %s has no active key", ref.name)
+			}
+			future, err := desClient.Update(ctx, ref.groupName, ref.name, compute.DiskEncryptionSetUpdate{
+				DiskEncryptionSetUpdateProperties: &compute.DiskEncryptionSetUpdateProperties{
+					ActiveKey: &compute.KeyVaultAndKeyReference{
+						SourceVault: des.ActiveKey.SourceVault,
+						KeyURL:      to.StringPtr(keyURL),
+					},
+				},
+			})
+			if err != nil {
+				return keyURL, fmt.Errorf("cannot update disk encryption set: %v", err)
+			}
+			err = future.WaitForCompletionRef(ctx, desClient.Client)
+			if err != nil {
+				return keyURL, fmt.Errorf("cannot get the disk encryption set update future response: %v", err)
+			}
+			err = pollUntil(ctx, DefaultBackoff, "disk encryption set "+ref.name+" to rotate", func() (bool, error) {
+				des, err := desClient.Get(ctx, ref.groupName, ref.name)
+				return err == nil && desRotated(des, keyURL), err
+			})
+			if err != nil {
+				return keyURL, err
+			}
+		}
+		return keyURL, nil
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestADEExtension(t *testing.T) {
+	ext := adeExtension("westus2", compute.Windows, "/vaults/v", "https://v.vault.azure.net/", "https://v.vault.azure.net/keys/kek/1", "", "seq")
+	if *ext.VirtualMachineExtensionProperties.Type != "AzureDiskEncryption" || *ext.TypeHandlerVersion != "2.2" {
+		t.Errorf("unexpected windows extension %s %s", *ext.VirtualMachineExtensionProperties.Type, *ext.TypeHandlerVersion)
+	}
+	settings := ext.Settings.(map[string]interface{})
+	if settings["VolumeType"] != ADEVolumeTypeAll || settings["KeyEncryptionKeyURL"] != "https://v.vault.azure.net/keys/kek/1" || settings["SequenceVersion"] != "seq" {
+		t.Errorf("unexpected settings: %v", settings)
+	}
+	if v := adeVolumeType(ext); v != ADEVolumeTypeAll {
+		t.Errorf("got volume type %s", v)
+	}
+
+	ext = adeExtension("westus2", compute.Linux, "/vaults/v", "https://v.vault.azure.net/", "", ADEVolumeTypeData, "seq")
+	if *ext.VirtualMachineExtensionProperties.Type != "AzureDiskEncryptionForLinux" || *ext.TypeHandlerVersion != "1.1" {
+		t.Errorf("unexpected linux extension %s %s", *ext.VirtualMachineExtensionProperties.Type, *ext.TypeHandlerVersion)
+	}
+	if v := adeVolumeType(ext); v != ADEVolumeTypeData {
+		t.Errorf("got volume type %s, want %s", v, ADEVolumeTypeData)
+	}
+}
+
+func TestDiskEncryptionStatus(t *testing.T) {
+	ade := compute.Disk{DiskProperties: &compute.DiskProperties{
+		EncryptionSettingsCollection: &compute.EncryptionSettingsCollection{
+			Enabled: to.BoolPtr(true),
+			EncryptionSettings: &[]compute.EncryptionSettingsElement{{
+				KeyEncryptionKey: &compute.KeyVaultAndKeyReference{KeyURL: to.StringPtr("https://v/keys/kek/1")},
+			}},
+		},
+	}}
+	des := compute.Disk{DiskProperties: &compute.DiskProperties{
+		Encryption: &compute.Encryption{DiskEncryptionSetID: to.StringPtr("/des/set"), Type: compute.EncryptionAtRestWithCustomerKey},
+	}}
+	platform := compute.Disk{DiskProperties: &compute.DiskProperties{
+		Encryption: &compute.Encryption{Type: compute.EncryptionAtRestWithPlatformKey},
+	}}
+
+	osDisk := vmDisk{Name: "os", OSDisk: true}
+	if s := diskEncryptionStatus(osDisk, ade); s.Mode != EncryptionModeADE || s.KeyURL != "https://v/keys/kek/1" || !s.OSDisk {
+		t.Errorf("unexpected ade status: %+v", s)
+	}
+	if s := diskEncryptionStatus(vmDisk{Name: "data", Lun: 1}, des); s.Mode != EncryptionModeDES || s.DiskEncryptionSetID != "/des/set" || s.Lun != 1 {
+		t.Errorf("unexpected des status: %+v", s)
+	}
+	if s := diskEncryptionStatus(osDisk, platform); s.Mode != EncryptionModePlatform {
+		t.Errorf("unexpected platform status: %+v", s)
+	}
+	if s := diskEncryptionStatus(osDisk, compute.Disk{}); s.Mode != EncryptionModePlatform {
+		t.Errorf("unexpected status without properties: %+v", s)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	statuses := []DiskEncryptionStatus{
+		{DiskName: "os", Mode: EncryptionModeADE, KeyURL: "https://v/keys/kek/2"},
+		{DiskName: "data", Mode: EncryptionModeADE, KeyURL: "https://v/keys/kek/1"},
+		{DiskName: "temp", Mode: EncryptionModePlatform},
+	}
+	if mode, err := encryptionMode(statuses); err != nil || mode != EncryptionModeADE {
+		t.Errorf("got mode %s, %v", mode, err)
+	}
+	if keyRotated(statuses, "https://v/keys/kek/2") {
+		t.Errorf("rotation reported done with a disk on the old key")
+	}
+	statuses[1].KeyURL = "https://V/keys/kek/2"
+	if !keyRotated(statuses, "https://v/keys/kek/2") {
+		t.Errorf("rotation not reported done")
+	}
+	statuses[2].Mode = EncryptionModeDES
+	if _, err := encryptionMode(statuses); err == nil {
+		t.Errorf("expected mixed modes to be refused")
+	}
+
+	des := compute.DiskEncryptionSet{EncryptionSetProperties: &compute.EncryptionSetProperties{
+		ActiveKey:         &compute.KeyVaultAndKeyReference{KeyURL: to.StringPtr("https://v/keys/kek/2")},
+		PreviousKeys:      &[]compute.KeyVaultAndKeyReference{{KeyURL: to.StringPtr("https://v/keys/kek/1")}},
+		ProvisioningState: to.StringPtr("Succeeded"),
+	}}
+	if desRotated(des, "https://v/keys/kek/2") {
+		t.Errorf("rotation reported done with previous keys left")
+	}
+	des.PreviousKeys = nil
+	if !desRotated(des, "https://v/keys/kek/2") {
+		t.Errorf("rotation not reported done")
+	}
+	if desRotated(des, "https://v/keys/kek/3") {
+		t.Errorf("rotation reported done for another key")
+	}
+}
+
+func TestDiskEncryptionSetLookup(t *testing.T) {
+	ref, err := parseDiskEncryptionSetID("/subscriptions/sub/resourceGroups/keys-rg/providers/Microsoft.Compute/diskEncryptionSets/des1")
+	if err != nil || ref.groupName != "keys-rg" || ref.name != "des1" {
+		t.Errorf("got %+v, %v", ref, err)
+	}
+	if _, err := parseDiskEncryptionSetID("des1"); err == nil {
+		t.Errorf("expected a bare name to be refused")
+	}
+
+	vaultID := "/subscriptions/sub/resourceGroups/keys-rg/providers/Microsoft.KeyVault/vaults/vault1"
+	des := compute.DiskEncryptionSet{Name: to.StringPtr("des1"), EncryptionSetProperties: &compute.EncryptionSetProperties{
+		ActiveKey: &compute.KeyVaultAndKeyReference{SourceVault: &compute.SourceVault{ID: to.StringPtr(vaultID)}},
+	}}
+	if err := checkDESVault(des, strings.ToUpper(vaultID)); err != nil {
+		t.Errorf("same vault refused: %v", err)
+	}
+	if err := checkDESVault(des, strings.Replace(vaultID, "vault1", "vault2", 1)); err == nil {
+		t.Errorf("expected a key in another vault to be refused")
+	}
+	if err := checkDESVault(compute.DiskEncryptionSet{}, vaultID); err == nil {
+		t.Errorf("expected a set without an active key to be refused")
+	}
+}
@@ -7,14 +7,12 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
-	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/hybrid"
 	hybridnetwork "github.com/Azure-Samples/azure-sdk-for-go-samples/services/network/hybrid"
 	hybridcompute "github.com/Azure/azure-sdk-for-go/profiles/2017-03-09/compute/mgmt/compute"
-	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
@@ -25,26 +23,30 @@ const (
 	errorPrefix = "Cannot create VM, reason: %v"
 )
 
-func getVMClient(activeDirectoryEndpoint, tokenAudience string) hybridcompute.VirtualMachinesClient {
-	token, err := iam.GetResourceManagementTokenHybrid(activeDirectoryEndpoint, tokenAudience)
+func getVMClient(env hybrid.Environment) (hybridcompute.VirtualMachinesClient, error) {
+	vmClient := hybridcompute.NewVirtualMachinesClientWithBaseURI(env.ResourceManagerEndpoint, env.SubscriptionID)
+	auth, err := hybrid.NewAuthorizer(env.ActiveDirectoryEndpoint, env.TokenAudience)
 	if err != nil {
-		log.Fatalf(fmt.Sprintf(errorPrefix, fmt.Sprintf("Cannot generate token. Error details: %v.", err)))
+		return vmClient, fmt.Errorf("cannot generate token: %v", err)
 	}
-	vmClient := hybridcompute.NewVirtualMachinesClientWithBaseURI(
-		config.Environment().ResourceManagerEndpoint, config.SubscriptionID())
-	vmClient.Authorizer = autorest.NewBearerAuthorizer(token)
+	vmClient.Authorizer = auth
 	_ = vmClient.AddToUserAgent(config.UserAgent())
-	return vmClient
+	return vmClient, nil
 }
 
 // CreateVM creates a new virtual machine with the specified name using the specified network interface and storage account.
 // Username, password, and sshPublicKeyPath determine logon credentials.
 func CreateVM(ctx context.Context, vmName, nicName, username, password, storageAccountName, sshPublicKeyPath string) (vm hybridcompute.VirtualMachine, err error) {
-	nic, _ := hybridnetwork.GetNic(ctx, nicName)
-	environment := config.Environment()
-	vhdURItemplate := "https://%s.blob." + environment.StorageEndpointSuffix + "/vhds/%s.vhd"
+	nic, err := hybridnetwork.GetNic(ctx, nicName)
+	if err != nil {
+		return vm, fmt.Errorf(errorPrefix, err)
+	}
+	vhdURItemplate := "https://%s.blob." + config.Environment().StorageEndpointSuffix + "/vhds/%s.vhd"
 
-	vmClient := getVMClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	vmClient, err := getVMClient(hybrid.ConfiguredEnvironment())
+	if err != nil {
+		return vm, fmt.Errorf(errorPrefix, err)
+	}
 	hardwareProfile := &hybridcompute.HardwareProfile{
 		VMSize: hybridcompute.StandardA1,
 	}
@@ -73,7 +75,7 @@ func CreateVM(ctx context.Context, vmName, nicName, username, password, storageA
 	if err == nil {
 		sshBytes, err := ioutil.ReadFile(sshPublicKeyPath)
 		if err != nil {
-			log.Fatalf(fmt.Sprintf(errorPrefix, fmt.Sprintf("failed to read SSH key data: %v", err)))
+			return vm, fmt.Errorf(errorPrefix, fmt.Sprintf("failed to read SSH key data: %v", err))
 		}
 
 		// if a key is available at the specified path then populate LinuxConfiguration
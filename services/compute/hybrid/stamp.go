@@ -0,0 +1,167 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/hybrid"
+	hybridresources "github.com/Azure/azure-sdk-for-go/profiles/2017-03-09/resources/mgmt/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// Profile is an Azure Stack API profile: a set of API versions a stamp
+// supports, with a package per service under azure-sdk-for-go/profiles.
+type Profile string
+
+// API profiles, newest first.
+const (
+	Profile20190301 Profile = "2019-03-01-hybrid"
+	Profile20180301 Profile = "2018-03-01-hybrid"
+	Profile20170309 Profile = "2017-03-09"
+)
+
+// profileComputeAPIVersions are the compute API versions of the profiles,
+// newest profile first. Managed disks have their own API version, which
+// differs from the VMs'; the 2017-03-09 profile has no managed disks.
+var profileComputeAPIVersions = []struct {
+	profile        Profile
+	apiVersion     string
+	diskAPIVersion string
+}{
+	{Profile20190301, "2017-12-01", "2017-03-30"},
+	{Profile20180301, "2017-03-30", "2017-03-30"},
+	{Profile20170309, "2016-03-30", ""},
+}
+
+// metadataAPIVersion is the version of a stamp's metadata endpoint.
+const metadataAPIVersion = "2015-01-01"
+
+// Stamp is an Azure Stack instance with the endpoints and API profile to
+// use with it.
+type Stamp struct {
+	hybrid.Environment
+	Profile Profile
+	// ManagedDisks is set when the stamp supports managed disks.
+	ManagedDisks bool
+	Authorizer   autorest.Authorizer
+}
+
+// stampMetadata is the response of a stamp's metadata endpoint.
+type stampMetadata struct {
+	GalleryEndpoint string `json:"galleryEndpoint"`
+	GraphEndpoint   string `json:"graphEndpoint"`
+	Authentication  struct {
+		LoginEndpoint string   `json:"loginEndpoint"`
+		Audiences     []string `json:"audiences"`
+	} `json:"authentication"`
+}
+
+// getStampMetadata reads the authentication endpoints of a stamp from its
+// Resource Manager endpoint.
+func getStampMetadata(ctx context.Context, resourceManagerEndpoint string) (metadata stampMetadata, err error) {
+	url := strings.TrimSuffix(resourceManagerEndpoint, "/") + "/metadata/endpoints?api-version=" + metadataAPIVersion
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return metadata, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return metadata, fmt.Errorf("cannot get stamp metadata: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return metadata, fmt.Errorf("cannot get stamp metadata: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return metadata, fmt.Errorf("cannot decode stamp metadata: %v", err)
+	}
+	if metadata.Authentication.LoginEndpoint == "" || len(metadata.Authentication.Audiences) == 0 {
+		return metadata, fmt.Errorf("stamp metadata has no authentication endpoints")
+	}
+	return metadata, nil
+}
+
+// apiVersions maps the resource types of a provider to the API versions a
+// stamp supports for them.
+func apiVersions(provider hybridresources.Provider) map[string][]string {
+	versions := map[string][]string{}
+	if provider.ResourceTypes == nil {
+		return versions
+	}
+	for _, t := range *provider.ResourceTypes {
+		if t.APIVersions != nil {
+			versions[strings.ToLower(to.String(t.ResourceType))] = *t.APIVersions
+		}
+	}
+	return versions
+}
+
+func supports(versions map[string][]string, resourceType, apiVersion string) bool {
+	for _, v := range versions[strings.ToLower(resourceType)] {
+		if v == apiVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// selectProfile picks the newest profile whose compute API version the
+// stamp supports for VMs, and whether it can create managed disks.
+func selectProfile(computeVersions map[string][]string) (profile Profile, managedDisks bool, err error) {
+	for _, p := range profileComputeAPIVersions {
+		if supports(computeVersions, "virtualMachines", p.apiVersion) {
+			managedDisks = p.diskAPIVersion != "" && supports(computeVersions, "disks", p.diskAPIVersion)
+			return p.profile, managedDisks, nil
+		}
+	}
+	return "", false, fmt.Errorf("stamp supports none of the compute API versions of the known profiles")
+}
+
+// discoverStamp reads a stamp's metadata and the compute API versions it
+// supports. newAuthorizer creates the authorizer for the stamp's
+// authentication endpoints.
+func discoverStamp(ctx context.Context, resourceManagerEndpoint, subscriptionID string, newAuthorizer func(loginEndpoint, audience string) (autorest.Authorizer, error)) (*Stamp, error) {
+	metadata, err := getStampMetadata(ctx, resourceManagerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	stamp := &Stamp{Environment: hybrid.Environment{
+		ResourceManagerEndpoint: resourceManagerEndpoint,
+		ActiveDirectoryEndpoint: metadata.Authentication.LoginEndpoint,
+		TokenAudience:           metadata.Authentication.Audiences[0],
+		SubscriptionID:          subscriptionID,
+	}}
+	stamp.Authorizer, err = newAuthorizer(stamp.ActiveDirectoryEndpoint, stamp.TokenAudience)
+	if err != nil {
+		return nil, fmt.Errorf("cannot authenticate to stamp: %v", err)
+	}
+
+	providersClient := hybridresources.NewProvidersClientWithBaseURI(resourceManagerEndpoint, subscriptionID)
+	providersClient.Authorizer = stamp.Authorizer
+	_ = providersClient.AddToUserAgent(config.UserAgent())
+	provider, err := providersClient.Get(ctx, "Microsoft.Compute", "")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get compute provider: %v", err)
+	}
+	stamp.Profile, stamp.ManagedDisks, err = selectProfile(apiVersions(provider))
+	if err != nil {
+		return nil, err
+	}
+	return stamp, nil
+}
+
+// DiscoverStamp connects to the Azure Stack stamp with the given Resource
+// Manager endpoint, such as "https://management.local.azurestack.external",
+// with the configured service principal, and picks the newest API profile
+// it supports.
+func DiscoverStamp(ctx context.Context, resourceManagerEndpoint string) (*Stamp, error) {
+	return discoverStamp(ctx, resourceManagerEndpoint, config.SubscriptionID(), hybrid.NewAuthorizer)
+}
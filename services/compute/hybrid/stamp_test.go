@@ -0,0 +1,164 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/hybrid/hybridtest"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// newFakeStamp starts a fake stamp supporting the VM and, unless nil, disk
+// API versions, with a publisher's images in its marketplace.
+func newFakeStamp(vmVersions, diskVersions []string) *hybridtest.Stamp {
+	s := hybridtest.NewStamp()
+	compute := map[string][]string{"virtualMachines": vmVersions}
+	if diskVersions != nil {
+		compute["disks"] = diskVersions
+	}
+	s.RegisterProvider("Microsoft.Compute", compute)
+
+	names := func(names ...string) []map[string]string {
+		list := []map[string]string{}
+		for _, n := range names {
+			list = append(list, map[string]string{"name": n, "location": "local"})
+		}
+		return list
+	}
+	offers := "/subscriptions/" + hybridtest.SubscriptionID + "/providers/Microsoft.Compute/locations/local/publishers/Canonical/artifacttypes/vmimage/offers"
+	s.Set(offers, names("UbuntuServer"))
+	s.Set(offers+"/UbuntuServer/skus", names("16.04-LTS", "18.04-LTS"))
+	s.Set(offers+"/UbuntuServer/skus/16.04-LTS/versions", names("16.04.201801050"))
+	s.Set(offers+"/UbuntuServer/skus/18.04-LTS/versions", names("18.04.201804262", "18.04.201808140"))
+	return s
+}
+
+// discover discovers the fake stamp without authenticating to it.
+func discover(t *testing.T, s *hybridtest.Stamp) *Stamp {
+	stamp, err := discoverStamp(context.Background(), s.URL, hybridtest.SubscriptionID, func(loginEndpoint, audience string) (autorest.Authorizer, error) {
+		return autorest.NullAuthorizer{}, nil
+	})
+	if err != nil {
+		t.Fatalf("cannot discover stamp: %v", err)
+	}
+	return stamp
+}
+
+func TestDiscoverStamp(t *testing.T) {
+	fake := newFakeStamp([]string{"2015-06-15", "2016-03-30", "2017-03-30", "2017-12-01"}, []string{"2017-03-30"})
+	defer fake.Close()
+	stamp := discover(t, fake)
+	if stamp.ActiveDirectoryEndpoint != hybridtest.LoginEndpoint || stamp.TokenAudience != hybridtest.TokenAudience {
+		t.Errorf("unexpected endpoints: %+v", stamp)
+	}
+	if stamp.Profile != Profile20190301 || !stamp.ManagedDisks {
+		t.Errorf("expected the 2019-03-01 profile with managed disks, got %s %v", stamp.Profile, stamp.ManagedDisks)
+	}
+
+	fake = newFakeStamp([]string{"2017-03-30", "2017-12-01"}, []string{"2016-04-30-preview"})
+	defer fake.Close()
+	stamp = discover(t, fake)
+	if stamp.Profile != Profile20190301 || stamp.ManagedDisks {
+		t.Errorf("expected the 2019-03-01 profile without managed disks, got %s %v", stamp.Profile, stamp.ManagedDisks)
+	}
+
+	fake = newFakeStamp([]string{"2016-03-30", "2017-03-30"}, []string{"2017-03-30"})
+	defer fake.Close()
+	stamp = discover(t, fake)
+	if stamp.Profile != Profile20180301 || !stamp.ManagedDisks {
+		t.Errorf("expected the 2018-03-01 profile with managed disks, got %s %v", stamp.Profile, stamp.ManagedDisks)
+	}
+
+	fake = newFakeStamp([]string{"2015-06-15", "2016-03-30"}, nil)
+	defer fake.Close()
+	stamp = discover(t, fake)
+	if stamp.Profile != Profile20170309 || stamp.ManagedDisks {
+		t.Errorf("expected the 2017-03-09 profile without managed disks, got %s %v", stamp.Profile, stamp.ManagedDisks)
+	}
+	if _, err := CreateManagedVM(context.Background(), stamp, "vm", "/nic", "user", "password", ""); err == nil {
+		t.Errorf("expected managed VM creation to be refused")
+	}
+
+	fake = newFakeStamp([]string{"2015-06-15"}, nil)
+	defer fake.Close()
+	_, err := discoverStamp(context.Background(), fake.URL, hybridtest.SubscriptionID, func(string, string) (autorest.Authorizer, error) {
+		return autorest.NullAuthorizer{}, nil
+	})
+	if err == nil {
+		t.Errorf("expected a stamp without a known profile to be refused")
+	}
+}
+
+func TestStampVMPower(t *testing.T) {
+	config.SetGroupName("rg")
+	fake := newFakeStamp([]string{"2016-03-30"}, nil)
+	defer fake.Close()
+	stamp := discover(t, fake)
+	ctx := context.Background()
+	if err := StartVM(ctx, stamp, "vm1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := StopVM(ctx, stamp, "vm1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteVM(ctx, stamp, "vm1"); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+	want := []string{"POST " + vm + "/start", "POST " + vm + "/powerOff", "DELETE " + vm}
+	requests := fake.Requests()
+	got := requests[len(requests)-len(want):]
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestListMarketplaceImages(t *testing.T) {
+	fake := newFakeStamp([]string{"2016-03-30"}, nil)
+	defer fake.Close()
+	images, err := ListMarketplaceImages(context.Background(), discover(t, fake), "local", "Canonical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 3 {
+		t.Fatalf("expected 3 images, got %+v", images)
+	}
+	last := images[2]
+	if last.Offer != "UbuntuServer" || last.Sku != "18.04-LTS" || last.Version != "18.04.201808140" {
+		t.Errorf("unexpected image: %+v", last)
+	}
+}
+
+func TestCreateManagedVM(t *testing.T) {
+	config.SetGroupName("rg")
+	vmPath := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+	for _, c := range []struct {
+		vmVersions []string
+		apiVersion string
+	}{
+		{[]string{"2017-03-30", "2017-12-01"}, "2017-12-01"},
+		{[]string{"2017-03-30"}, "2017-03-30"},
+	} {
+		fake := newFakeStamp(c.vmVersions, []string{"2017-03-30"})
+		defer fake.Close()
+		stamp := discover(t, fake)
+		vm, err := CreateManagedVM(context.Background(), stamp, "vm1", "/nic", "user", "password", "")
+		if err != nil {
+			t.Fatalf("%s: %v", stamp.Profile, err)
+		}
+		if got := fake.APIVersion(http.MethodPut, vmPath); got != c.apiVersion {
+			t.Errorf("%s: created the vm with API version %q, want %s", stamp.Profile, got, c.apiVersion)
+		}
+		if vm.StorageProfile == nil || vm.StorageProfile.OsDisk.ManagedDisk == nil || *vm.ID != vmPath {
+			t.Errorf("%s: unexpected vm %+v", stamp.Profile, vm.VirtualMachineProperties)
+		}
+	}
+}
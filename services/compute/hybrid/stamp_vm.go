@@ -0,0 +1,250 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	hybridcompute "github.com/Azure/azure-sdk-for-go/profiles/2017-03-09/compute/mgmt/compute"
+	managedcompute "github.com/Azure/azure-sdk-for-go/profiles/2018-03-01/compute/mgmt/compute"
+	managedcompute20190301 "github.com/Azure/azure-sdk-for-go/profiles/2019-03-01/compute/mgmt/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// vmClient returns a VM client for the stamp. Every profile supports the
+// 2017-03-09 compute API, which is enough to manage existing VMs.
+func (s *Stamp) vmClient() hybridcompute.VirtualMachinesClient {
+	vmClient := hybridcompute.NewVirtualMachinesClientWithBaseURI(s.ResourceManagerEndpoint, s.SubscriptionID)
+	vmClient.Authorizer = s.Authorizer
+	_ = vmClient.AddToUserAgent(config.UserAgent())
+	return vmClient
+}
+
+func (s *Stamp) imagesClient() hybridcompute.VirtualMachineImagesClient {
+	imagesClient := hybridcompute.NewVirtualMachineImagesClientWithBaseURI(s.ResourceManagerEndpoint, s.SubscriptionID)
+	imagesClient.Authorizer = s.Authorizer
+	_ = imagesClient.AddToUserAgent(config.UserAgent())
+	return imagesClient
+}
+
+// StartVM starts a VM on the stamp.
+func StartVM(ctx context.Context, stamp *Stamp, vmName string) error {
+	vmClient := stamp.vmClient()
+	future, err := vmClient.Start(ctx, config.GroupName(), vmName)
+	if err != nil {
+		return fmt.Errorf("cannot start vm: %v", err)
+	}
+	if err := future.WaitForCompletionRef(ctx, vmClient.Client); err != nil {
+		return fmt.Errorf("cannot get the vm start future response: %v", err)
+	}
+	return nil
+}
+
+// StopVM powers a VM on the stamp off. It keeps its compute resources.
+func StopVM(ctx context.Context, stamp *Stamp, vmName string) error {
+	vmClient := stamp.vmClient()
+	future, err := vmClient.PowerOff(ctx, config.GroupName(), vmName)
+	if err != nil {
+		return fmt.Errorf("cannot power off vm: %v", err)
+	}
+	if err := future.WaitForCompletionRef(ctx, vmClient.Client); err != nil {
+		return fmt.Errorf("cannot get the vm power off future response: %v", err)
+	}
+	return nil
+}
+
+// DeleteVM deletes a VM on the stamp. Its disks and NICs are kept.
+func DeleteVM(ctx context.Context, stamp *Stamp, vmName string) error {
+	vmClient := stamp.vmClient()
+	future, err := vmClient.Delete(ctx, config.GroupName(), vmName)
+	if err != nil {
+		return fmt.Errorf("cannot delete vm: %v", err)
+	}
+	if err := future.WaitForCompletionRef(ctx, vmClient.Client); err != nil {
+		return fmt.Errorf("cannot get the vm delete future response: %v", err)
+	}
+	return nil
+}
+
+// MarketplaceImage is a VM image syndicated to a stamp's marketplace.
+type MarketplaceImage struct {
+	Publisher string
+	Offer     string
+	Sku       string
+	Version   string
+}
+
+// ListMarketplaceImages lists the versions of every offer and SKU of a
+// publisher, such as "Canonical", that the stamp's operator downloaded to
+// its marketplace in location.
+func ListMarketplaceImages(ctx context.Context, stamp *Stamp, location, publisher string) ([]MarketplaceImage, error) {
+	imagesClient := stamp.imagesClient()
+	offers, err := imagesClient.ListOffers(ctx, location, publisher)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list offers: %v", err)
+	}
+	var images []MarketplaceImage
+	for _, offer := range imageNames(offers) {
+		skus, err := imagesClient.ListSkus(ctx, location, publisher, offer)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list skus of %s: %v", offer, err)
+		}
+		for _, sku := range imageNames(skus) {
+			versions, err := imagesClient.List(ctx, location, publisher, offer, sku, "", nil, "")
+			if err != nil {
+				return nil, fmt.Errorf("cannot list versions of %s %s: %v", offer, sku, err)
+			}
+			for _, version := range imageNames(versions) {
+				images = append(images, MarketplaceImage{Publisher: publisher, Offer: offer, Sku: sku, Version: version})
+			}
+		}
+	}
+	return images, nil
+}
+
+func imageNames(list hybridcompute.ListVirtualMachineImageResource) []string {
+	if list.Value == nil {
+		return nil
+	}
+	names := make([]string, 0, len(*list.Value))
+	for _, r := range *list.Value {
+		names = append(names, to.String(r.Name))
+	}
+	return names
+}
+
+// CreateManagedVM creates a VM with a managed OS disk on a stamp whose
+// profile supports managed disks, so that no storage account is needed,
+// with the compute API of the stamp's profile. Use CreateVM on stamps
+// without managed disks.
+func CreateManagedVM(ctx context.Context, stamp *Stamp, vmName, nicID, username, password, sshPublicKeyPath string) (vm managedcompute.VirtualMachine, err error) {
+	if !stamp.ManagedDisks {
+		return vm, fmt.Errorf("stamp with profile %s does not support managed disks", stamp.Profile)
+	}
+	osProfile := &managedcompute.OSProfile{
+		ComputerName:  to.StringPtr(vmName),
+		AdminUsername: to.StringPtr(username),
+		AdminPassword: to.StringPtr(password),
+	}
+	if _, err := os.Stat(sshPublicKeyPath); err == nil {
+		sshBytes, err := ioutil.ReadFile(sshPublicKeyPath)
+		if err != nil {
+			return vm, fmt.Errorf("cannot read SSH key data: %v", err)
+		}
+		osProfile.LinuxConfiguration = &managedcompute.LinuxConfiguration{
+			SSH: &managedcompute.SSHConfiguration{
+				PublicKeys: &[]managedcompute.SSHPublicKey{
+					{
+						Path:    to.StringPtr(fmt.Sprintf("/home/%s/.ssh/authorized_keys", username)),
+						KeyData: to.StringPtr(string(sshBytes)),
+					},
+				},
+			},
+		}
+	}
+
+	params := managedcompute.VirtualMachine{
+		Location: to.StringPtr(config.Location()),
+		VirtualMachineProperties: &managedcompute.VirtualMachineProperties{
+			HardwareProfile: &managedcompute.HardwareProfile{
+				VMSize: managedcompute.StandardA1,
+			},
+			StorageProfile: &managedcompute.StorageProfile{
+				ImageReference: &managedcompute.ImageReference{
+					Publisher: to.StringPtr(publisher),
+					Offer:     to.StringPtr(offer),
+					Sku:       to.StringPtr(sku),
+					Version:   to.StringPtr("latest"),
+				},
+				OsDisk: &managedcompute.OSDisk{
+					CreateOption: managedcompute.DiskCreateOptionTypesFromImage,
+					ManagedDisk: &managedcompute.ManagedDiskParameters{
+						StorageAccountType: managedcompute.StandardLRS,
+					},
+				},
+			},
+			OsProfile: osProfile,
+			NetworkProfile: &managedcompute.NetworkProfile{
+				NetworkInterfaces: &[]managedcompute.NetworkInterfaceReference{
+					{
+						ID: to.StringPtr(nicID),
+						NetworkInterfaceReferenceProperties: &managedcompute.NetworkInterfaceReferenceProperties{
+							Primary: to.BoolPtr(true),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	switch stamp.Profile {
+	case Profile20180301:
+		return createManagedVM20180301(ctx, stamp, vmName, params)
+	case Profile20190301:
+		return createManagedVM20190301(ctx, stamp, vmName, params)
+	}
+	return vm, fmt.Errorf("cannot create managed vms with profile %s", stamp.Profile)
+}
+
+func createManagedVM20180301(ctx context.Context, stamp *Stamp, vmName string, params managedcompute.VirtualMachine) (vm managedcompute.VirtualMachine, err error) {
+	vmClient := managedcompute.NewVirtualMachinesClientWithBaseURI(stamp.ResourceManagerEndpoint, stamp.SubscriptionID)
+	vmClient.Authorizer = stamp.Authorizer
+	_ = vmClient.AddToUserAgent(config.UserAgent())
+	future, err := vmClient.CreateOrUpdate(ctx, config.GroupName(), vmName, params)
+	if err != nil {
+		return vm, fmt.Errorf(errorPrefix, err)
+	}
+	if err := future.WaitForCompletionRef(ctx, vmClient.Client); err != nil {
+		return vm, fmt.Errorf(errorPrefix, err)
+	}
+	return future.Result(vmClient)
+}
+
+// createManagedVM20190301 creates the VM with the 2019-03-01 profile. The
+// VM model of both profiles has the same JSON form, so params and the
+// result are converted through it; the read-only fields the models do not
+// marshal are copied over.
+func createManagedVM20190301(ctx context.Context, stamp *Stamp, vmName string, params managedcompute.VirtualMachine) (vm managedcompute.VirtualMachine, err error) {
+	var converted managedcompute20190301.VirtualMachine
+	if err := convertModel(params, &converted); err != nil {
+		return vm, fmt.Errorf(errorPrefix, err)
+	}
+	vmClient := managedcompute20190301.NewVirtualMachinesClientWithBaseURI(stamp.ResourceManagerEndpoint, stamp.SubscriptionID)
+	vmClient.Authorizer = stamp.Authorizer
+	_ = vmClient.AddToUserAgent(config.UserAgent())
+	future, err := vmClient.CreateOrUpdate(ctx, config.GroupName(), vmName, converted)
+	if err != nil {
+		return vm, fmt.Errorf(errorPrefix, err)
+	}
+	if err := future.WaitForCompletionRef(ctx, vmClient.Client); err != nil {
+		return vm, fmt.Errorf(errorPrefix, err)
+	}
+	created, err := future.Result(vmClient)
+	if err != nil {
+		return vm, err
+	}
+	if err := convertModel(created, &vm); err != nil {
+		return vm, err
+	}
+	vm.ID, vm.Name, vm.Type = created.ID, created.Name, created.Type
+	if vm.VirtualMachineProperties != nil && created.VirtualMachineProperties != nil {
+		vm.ProvisioningState, vm.VMID = created.ProvisioningState, created.VMID
+	}
+	return vm, nil
+}
+
+// convertModel copies a resource between the models of two API versions
+// through its JSON form.
+func convertModel(from, into interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
@@ -0,0 +1,216 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Kubeconfig is the part of a kubeconfig file needed to connect to a
+// cluster.
+type Kubeconfig struct {
+	CurrentContext string
+	Clusters       []KubeconfigCluster
+	Contexts       []KubeconfigContext
+	Users          []KubeconfigUser
+}
+
+// KubeconfigCluster is the API server of a cluster.
+type KubeconfigCluster struct {
+	Name                     string
+	Server                   string
+	CertificateAuthorityData []byte
+}
+
+// KubeconfigContext pairs a cluster with a user.
+type KubeconfigContext struct {
+	Name      string
+	Cluster   string
+	User      string
+	Namespace string
+}
+
+// KubeconfigUser holds the credentials of a user. Clusters using Azure AD
+// give users an AuthProvider, such as "azure", instead of credentials.
+type KubeconfigUser struct {
+	Name                  string
+	ClientCertificateData []byte
+	ClientKeyData         []byte
+	Token                 string
+	AuthProvider          string
+}
+
+// Current returns the cluster and user of the current context.
+func (k Kubeconfig) Current() (KubeconfigCluster, KubeconfigUser, error) {
+	for _, ctx := range k.Contexts {
+		if ctx.Name != k.CurrentContext {
+			continue
+		}
+		var cluster *KubeconfigCluster
+		for i := range k.Clusters {
+			if k.Clusters[i].Name == ctx.Cluster {
+				cluster = &k.Clusters[i]
+			}
+		}
+		if cluster == nil {
+			return KubeconfigCluster{}, KubeconfigUser{}, fmt.Errorf("context %s refers to unknown cluster %s", ctx.Name, ctx.Cluster)
+		}
+		for _, user := range k.Users {
+			if user.Name == ctx.User {
+				return *cluster, user, nil
+			}
+		}
+		return KubeconfigCluster{}, KubeconfigUser{}, fmt.Errorf("context %s refers to unknown user %s", ctx.Name, ctx.User)
+	}
+	return KubeconfigCluster{}, KubeconfigUser{}, fmt.Errorf("current context %q not found", k.CurrentContext)
+}
+
+// kubeconfigFile is the layout of a kubeconfig file, as clientcmd defines
+// it, limited to the fields Kubeconfig keeps.
+type kubeconfigFile struct {
+	Kind           string `yaml:"kind"`
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Token                 string `yaml:"token"`
+			AuthProvider          struct {
+				Name string `yaml:"name"`
+			} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+func kubeconfigBytes(field, s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %v", field, err)
+	}
+	return b, nil
+}
+
+// ParseKubeconfig parses a kubeconfig file, such as the ones
+// GetAKSKubeconfig returns.
+func ParseKubeconfig(data []byte) (k Kubeconfig, err error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return k, fmt.Errorf("cannot parse kubeconfig: document is empty")
+	}
+	var doc kubeconfigFile
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return k, fmt.Errorf("cannot parse kubeconfig: %v", err)
+	}
+	if doc.Kind != "" && doc.Kind != "Config" {
+		return k, fmt.Errorf("kubeconfig has kind %s, want Config", doc.Kind)
+	}
+
+	k.CurrentContext = doc.CurrentContext
+	for _, c := range doc.Clusters {
+		ca, err := kubeconfigBytes("certificate-authority-data", c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return k, err
+		}
+		k.Clusters = append(k.Clusters, KubeconfigCluster{
+			Name:                     c.Name,
+			Server:                   c.Cluster.Server,
+			CertificateAuthorityData: ca,
+		})
+	}
+	for _, c := range doc.Contexts {
+		k.Contexts = append(k.Contexts, KubeconfigContext{
+			Name:      c.Name,
+			Cluster:   c.Context.Cluster,
+			User:      c.Context.User,
+			Namespace: c.Context.Namespace,
+		})
+	}
+	for _, u := range doc.Users {
+		cert, err := kubeconfigBytes("client-certificate-data", u.User.ClientCertificateData)
+		if err != nil {
+			return k, err
+		}
+		key, err := kubeconfigBytes("client-key-data", u.User.ClientKeyData)
+		if err != nil {
+			return k, err
+		}
+		k.Users = append(k.Users, KubeconfigUser{
+			Name:                  u.Name,
+			ClientCertificateData: cert,
+			ClientKeyData:         key,
+			Token:                 u.User.Token,
+			AuthProvider:          u.User.AuthProvider.Name,
+		})
+	}
+	return k, nil
+}
+
+// GetAKSKubeconfig returns the kubeconfig file of a cluster, for a user or,
+// with admin, for the cluster admin.
+func GetAKSKubeconfig(ctx context.Context, resourceGroupName, resourceName string, admin bool) ([]byte, error) {
+	aksClient, err := getAKSClient()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get AKS client: %v", err)
+	}
+
+	list := aksClient.ListClusterUserCredentials
+	if admin {
+		list = aksClient.ListClusterAdminCredentials
+	}
+	credentials, err := list(ctx, resourceGroupName, resourceName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get AKS credentials: %v", err)
+	}
+	if credentials.Kubeconfigs == nil || len(*credentials.Kubeconfigs) == 0 || (*credentials.Kubeconfigs)[0].Value == nil {
+		return nil, fmt.Errorf("AKS cluster %s returned no kubeconfig", resourceName)
+	}
+	return *(*credentials.Kubeconfigs)[0].Value, nil
+}
+
+// GetAKSKubeconfigParsed returns the parsed kubeconfig of a cluster, as
+// GetAKSKubeconfig.
+func GetAKSKubeconfigParsed(ctx context.Context, resourceGroupName, resourceName string, admin bool) (Kubeconfig, error) {
+	data, err := GetAKSKubeconfig(ctx, resourceGroupName, resourceName, admin)
+	if err != nil {
+		return Kubeconfig{}, err
+	}
+	return ParseKubeconfig(data)
+}
+
+// WriteAKSKubeconfig writes the kubeconfig of a cluster, as
+// GetAKSKubeconfig, to a file only the current user can read.
+func WriteAKSKubeconfig(ctx context.Context, resourceGroupName, resourceName string, admin bool, path string) error {
+	data, err := GetAKSKubeconfig(ctx, resourceGroupName, resourceName, admin)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("cannot write kubeconfig: %v", err)
+	}
+	return nil
+}
@@ -6,16 +6,12 @@ package compute
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
 
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/network"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
 	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/to"
 )
 
 func getVMClient() compute.VirtualMachinesClient {
@@ -38,78 +34,21 @@ func getVMExtensionsClient() compute.VirtualMachineExtensionsClient {
 // Username, password, and sshPublicKeyPath determine logon credentials.
 func CreateVM(ctx context.Context, vmName, nicName, username, password, sshPublicKeyPath string) (vm compute.VirtualMachine, err error) {
 	// see the network samples for how to create and get a NIC resource
-	nic, _ := network.GetNic(ctx, nicName)
-
-	var sshKeyData string
-	if _, err = os.Stat(sshPublicKeyPath); err == nil {
-		sshBytes, err := ioutil.ReadFile(sshPublicKeyPath)
-		if err != nil {
-			log.Fatalf("failed to read SSH key data: %v", err)
-		}
-		sshKeyData = string(sshBytes)
-	} else {
-		sshKeyData = fakepubkey
-	}
-
-	vmClient := getVMClient()
-	future, err := vmClient.CreateOrUpdate(
-		ctx,
-		config.GroupName(),
-		vmName,
-		compute.VirtualMachine{
-			Location: to.StringPtr(config.Location()),
-			VirtualMachineProperties: &compute.VirtualMachineProperties{
-				HardwareProfile: &compute.HardwareProfile{
-					VMSize: compute.VirtualMachineSizeTypesBasicA0,
-				},
-				StorageProfile: &compute.StorageProfile{
-					ImageReference: &compute.ImageReference{
-						Publisher: to.StringPtr(publisher),
-						Offer:     to.StringPtr(offer),
-						Sku:       to.StringPtr(sku),
-						Version:   to.StringPtr("latest"),
-					},
-				},
-				OsProfile: &compute.OSProfile{
-					ComputerName:  to.StringPtr(vmName),
-					AdminUsername: to.StringPtr(username),
-					AdminPassword: to.StringPtr(password),
-					LinuxConfiguration: &compute.LinuxConfiguration{
-						SSH: &compute.SSHConfiguration{
-							PublicKeys: &[]compute.SSHPublicKey{
-								{
-									Path: to.StringPtr(
-										fmt.Sprintf("/home/%s/.ssh/authorized_keys",
-											username)),
-									KeyData: to.StringPtr(sshKeyData),
-								},
-							},
-						},
-					},
-				},
-				NetworkProfile: &compute.NetworkProfile{
-					NetworkInterfaces: &[]compute.NetworkInterfaceReference{
-						{
-							ID: nic.ID,
-							NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
-								Primary: to.BoolPtr(true),
-							},
-						},
-					},
-				},
-			},
-		},
-	)
+	nic, err := network.GetNic(ctx, nicName)
 	if err != nil {
-		return vm, fmt.Errorf("cannot create vm: %v", err)
+		return vm, fmt.Errorf("cannot get nic: %v", err)
 	}
 
-	err = future.WaitForCompletionRef(ctx, vmClient.Client)
+	sshKeyData, err := readSSHPublicKey(sshPublicKeyPath)
 	if err != nil {
-		return vm, fmt.Errorf("cannot get the vm create or update future response: %v", err)
+		return vm, err
 	}
 
-	return future.Result(vmClient)
+	return CreateVMFromSpec(ctx, NewVMSpec(vmName).
+		WithNIC(*nic.ID).
+		WithAdmin(username).
+		WithPassword(password).
+		WithSSHKey(sshKeyData))
 }
 
 // GetVM gets the specified VM info
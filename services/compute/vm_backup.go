@@ -0,0 +1,578 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/storage"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// Tags recording which backup a snapshot belongs to. A snapshot set is all
+// snapshots with the same backupSetTag, and is complete when it has
+// backupDisksTag of them.
+const (
+	backupSetTag     = "backup-set"
+	backupDisksTag   = "backup-disks"
+	backupVMTag      = "backup-vm"
+	backupDiskTag    = "backup-disk"
+	backupLunTag     = "backup-lun"
+	backupCreatedTag = "backup-created"
+	backupExpiresTag = "backup-expires"
+
+	// backupOSDiskLun is the backupLunTag value of OS disk snapshots.
+	backupOSDiskLun = "os"
+)
+
+func getSnapshotsClient() compute.SnapshotsClient {
+	snapshotsClient := compute.NewSnapshotsClient(config.SubscriptionID())
+	a, _ := iam.GetResourceManagementAuthorizer()
+	snapshotsClient.Authorizer = a
+	_ = snapshotsClient.AddToUserAgent(config.UserAgent())
+	return snapshotsClient
+}
+
+// BackupOptions control how BackupVM snapshots a VM.
+type BackupOptions struct {
+	// Retention is how long the snapshots are kept by PruneSnapshotSets;
+	// zero keeps them until they are deleted explicitly.
+	Retention time.Duration
+	// StopVM stops the VM while its disks are snapshotted, so that the
+	// snapshots are consistent with each other, and starts it again
+	// afterwards. Otherwise the snapshots are only crash consistent.
+	StopVM bool
+	// Full takes full snapshots instead of incremental ones.
+	Full bool
+}
+
+// DiskSnapshot is the snapshot of one disk of a VM.
+type DiskSnapshot struct {
+	SnapshotID   string
+	SnapshotName string
+	DiskName     string
+	OSDisk       bool
+	// Lun is the LUN of a data disk.
+	Lun         int32
+	OSType      compute.OperatingSystemTypes
+	Incremental bool
+}
+
+// SnapshotSet is the snapshots of all disks of a VM taken by one backup.
+type SnapshotSet struct {
+	ID       string
+	VMName   string
+	Location string
+	Created  time.Time
+	// Expires is when the set may be pruned; zero if it never expires.
+	Expires time.Time
+	// Disks is the number of disks the VM had when it was backed up.
+	Disks     int
+	Snapshots []DiskSnapshot
+}
+
+// Complete reports whether the set has a snapshot of every disk of the VM.
+// A backup that failed partway leaves an incomplete set if its snapshots
+// could not be deleted.
+func (s SnapshotSet) Complete() bool {
+	return s.Disks > 0 && len(s.Snapshots) == s.Disks
+}
+
+// Expired reports whether the set's retention has passed at now.
+func (s SnapshotSet) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && !now.Before(s.Expires)
+}
+
+// vmDisk is a managed disk of a VM.
+type vmDisk struct {
+	ID                 string
+	Name               string
+	OSDisk             bool
+	Lun                int32
+	OSType             compute.OperatingSystemTypes
+	StorageAccountType compute.StorageAccountTypes
+}
+
+// vmDisks returns the managed disks of a VM, the OS disk first.
+func vmDisks(vm compute.VirtualMachine) ([]vmDisk, error) {
+	if vm.VirtualMachineProperties == nil || vm.StorageProfile == nil || vm.StorageProfile.OsDisk == nil {
+		return nil, fmt.Errorf("vm has no storage profile")
+	}
+	osDisk := vm.StorageProfile.OsDisk
+	if osDisk.ManagedDisk == nil || osDisk.ManagedDisk.ID == nil {
+		return nil, fmt.Errorf("os disk %s is not a managed disk", to.String(osDisk.Name))
+	}
+	disks := []vmDisk{{
+		ID:                 *osDisk.ManagedDisk.ID,
+		Name:               to.String(osDisk.Name),
+		OSDisk:             true,
+		OSType:             osDisk.OsType,
+		StorageAccountType: osDisk.ManagedDisk.StorageAccountType,
+	}}
+	if vm.StorageProfile.DataDisks != nil {
+		for _, d := range *vm.StorageProfile.DataDisks {
+			if d.ManagedDisk == nil || d.ManagedDisk.ID == nil {
+				return nil, fmt.Errorf("data disk %s is not a managed disk", to.String(d.Name))
+			}
+			disks = append(disks, vmDisk{
+				ID:                 *d.ManagedDisk.ID,
+				Name:               to.String(d.Name),
+				Lun:                to.Int32(d.Lun),
+				StorageAccountType: d.ManagedDisk.StorageAccountType,
+			})
+		}
+	}
+	return disks, nil
+}
+
+// backupSnapshot returns the snapshot resource of one disk of a backup.
+// Snapshots are incremental unless opts asks for full ones or the disk is
+// an ultra disk, which cannot be snapshotted incrementally.
+func backupSnapshot(set SnapshotSet, disk vmDisk, opts BackupOptions) (name string, snapshot compute.Snapshot) {
+	lun := backupOSDiskLun
+	name = set.ID + "-os"
+	if !disk.OSDisk {
+		lun = strconv.Itoa(int(disk.Lun))
+		name = fmt.Sprintf("%s-lun%d", set.ID, disk.Lun)
+	}
+	tags := map[string]string{
+		backupSetTag:     set.ID,
+		backupDisksTag:   strconv.Itoa(set.Disks),
+		backupVMTag:      set.VMName,
+		backupDiskTag:    disk.Name,
+		backupLunTag:     lun,
+		backupCreatedTag: set.Created.UTC().Format(time.RFC3339),
+	}
+	if !set.Expires.IsZero() {
+		tags[backupExpiresTag] = set.Expires.UTC().Format(time.RFC3339)
+	}
+	incremental := !opts.Full && disk.StorageAccountType != compute.StorageAccountTypesUltraSSDLRS
+	snapshot = compute.Snapshot{
+		Location: to.StringPtr(set.Location),
+		Tags:     *to.StringMapPtr(tags),
+		Sku:      &compute.SnapshotSku{Name: compute.SnapshotStorageAccountTypesStandardLRS},
+		SnapshotProperties: &compute.SnapshotProperties{
+			OsType: disk.OSType,
+			CreationData: &compute.CreationData{
+				CreateOption:     compute.Copy,
+				SourceResourceID: to.StringPtr(disk.ID),
+			},
+			Incremental: to.BoolPtr(incremental),
+		},
+	}
+	return name, snapshot
+}
+
+func createSnapshot(ctx context.Context, name string, snapshot compute.Snapshot) (compute.Snapshot, error) {
+	snapshotsClient := getSnapshotsClient()
+	future, err := snapshotsClient.CreateOrUpdate(ctx, config.GroupName(), name, snapshot)
+	if err != nil {
+		return snapshot, fmt.Errorf("cannot create snapshot %s: %v", name, err)
+	}
+	err = future.WaitForCompletionRef(ctx, snapshotsClient.Client)
+	if err != nil {
+		return snapshot, fmt.Errorf("cannot get the snapshot create or update future response: %v", err)
+	}
+	return future.Result(snapshotsClient)
+}
+
+// BackupVM snapshots all managed disks of a VM as one snapshot set, tagged
+// with the VM, the disk and LUN each snapshot is of and when the set
+// expires. The snapshots are started together to keep them close in time;
+// set opts.StopVM for snapshots that are fully consistent with each other.
+func BackupVM(ctx context.Context, vmName string, opts BackupOptions) (set SnapshotSet, err error) {
+	vm, err := GetVM(ctx, vmName)
+	if err != nil {
+		return set, fmt.Errorf("cannot get vm: %v", err)
+	}
+	disks, err := vmDisks(vm)
+	if err != nil {
+		return set, fmt.Errorf("cannot back up vm %s: %v", vmName, err)
+	}
+
+	created := time.Now().UTC().Truncate(time.Second)
+	set = SnapshotSet{
+		ID:       fmt.Sprintf("%s-%s", vmName, created.Format("20060102t150405")),
+		VMName:   vmName,
+		Location: to.String(vm.Location),
+		Created:  created,
+		Disks:    len(disks),
+	}
+	if opts.Retention > 0 {
+		set.Expires = created.Add(opts.Retention)
+	}
+
+	backup := func() error {
+		set, err = takeSnapshots(ctx, set, disks, opts, createSnapshot, deleteSnapshot)
+		return err
+	}
+	if opts.StopVM {
+		err = defaultLifecycle().whileStopped(ctx, vmName, TransitionStop, backup)
+	} else {
+		err = backup()
+	}
+	return set, err
+}
+
+// takeSnapshots snapshots the disks of a set at the same time. If any
+// snapshot fails, every snapshot of the set is deleted so that no
+// incomplete set is left behind. That includes the failed ones, as a
+// create accepted by the service may still have made a snapshot; remove
+// must succeed for snapshots that do not exist.
+func takeSnapshots(ctx context.Context, set SnapshotSet, disks []vmDisk, opts BackupOptions,
+	create func(ctx context.Context, name string, snapshot compute.Snapshot) (compute.Snapshot, error),
+	remove func(ctx context.Context, name string) error) (SnapshotSet, error) {
+	snapshots := make([]DiskSnapshot, len(disks))
+	errs := make([]error, len(disks))
+	var wg sync.WaitGroup
+	for i, disk := range disks {
+		wg.Add(1)
+		go func(i int, disk vmDisk) {
+			defer wg.Done()
+			name, snapshot := backupSnapshot(set, disk, opts)
+			snapshot, errs[i] = create(ctx, name, snapshot)
+			snapshots[i] = DiskSnapshot{
+				SnapshotID:   to.String(snapshot.ID),
+				SnapshotName: name,
+				DiskName:     disk.Name,
+				OSDisk:       disk.OSDisk,
+				Lun:          disk.Lun,
+				OSType:       disk.OSType,
+				Incremental:  to.Bool(snapshot.Incremental),
+			}
+		}(i, disk)
+	}
+	wg.Wait()
+
+	var failed error
+	for _, err := range errs {
+		if err != nil {
+			failed = err
+			break
+		}
+	}
+	if failed == nil {
+		set.Snapshots = snapshots
+		return set, nil
+	}
+	for _, snapshot := range snapshots {
+		if err := remove(ctx, snapshot.SnapshotName); err != nil {
+			set.Snapshots = append(set.Snapshots, snapshot)
+			failed = fmt.Errorf("%v; cannot delete snapshot of the incomplete set: %v", failed, err)
+		}
+	}
+	return set, failed
+}
+
+// snapshotSets groups backup snapshots into complete sets, newest first.
+// Snapshots without backup tags and incomplete sets are ignored.
+func snapshotSets(snapshots []compute.Snapshot) []SnapshotSet {
+	byID := map[string]*SnapshotSet{}
+	var sets []*SnapshotSet
+	for _, s := range snapshots {
+		id := to.String(s.Tags[backupSetTag])
+		if id == "" {
+			continue
+		}
+		set, ok := byID[id]
+		if !ok {
+			set = &SnapshotSet{
+				ID:       id,
+				VMName:   to.String(s.Tags[backupVMTag]),
+				Location: to.String(s.Location),
+			}
+			set.Created, _ = time.Parse(time.RFC3339, to.String(s.Tags[backupCreatedTag]))
+			set.Expires, _ = time.Parse(time.RFC3339, to.String(s.Tags[backupExpiresTag]))
+			set.Disks, _ = strconv.Atoi(to.String(s.Tags[backupDisksTag]))
+			byID[id] = set
+			sets = append(sets, set)
+		}
+		snapshot := DiskSnapshot{
+			SnapshotID:   to.String(s.ID),
+			SnapshotName: to.String(s.Name),
+			DiskName:     to.String(s.Tags[backupDiskTag]),
+		}
+		if s.SnapshotProperties != nil {
+			snapshot.OSType = s.OsType
+			snapshot.Incremental = to.Bool(s.Incremental)
+		}
+		if lun := to.String(s.Tags[backupLunTag]); lun == backupOSDiskLun {
+			snapshot.OSDisk = true
+		} else {
+			n, _ := strconv.Atoi(lun)
+			snapshot.Lun = int32(n)
+		}
+		set.Snapshots = append(set.Snapshots, snapshot)
+	}
+
+	result := make([]SnapshotSet, 0, len(sets))
+	for _, set := range sets {
+		if !set.Complete() {
+			continue
+		}
+		sort.Slice(set.Snapshots, func(i, j int) bool {
+			a, b := set.Snapshots[i], set.Snapshots[j]
+			if a.OSDisk != b.OSDisk {
+				return a.OSDisk
+			}
+			return a.Lun < b.Lun
+		})
+		result = append(result, *set)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Created.Equal(result[j].Created) {
+			return result[i].Created.After(result[j].Created)
+		}
+		return result[i].ID > result[j].ID
+	})
+	return result
+}
+
+// ListSnapshotSets lists the snapshot sets of a VM in the default resource
+// group, newest first.
+func ListSnapshotSets(ctx context.Context, vmName string) ([]SnapshotSet, error) {
+	snapshotsClient := getSnapshotsClient()
+	list, err := snapshotsClient.ListByResourceGroupComplete(ctx, config.GroupName())
+	if err != nil {
+		return nil, fmt.Errorf("cannot list snapshots: %v", err)
+	}
+	var snapshots []compute.Snapshot
+	for list.NotDone() {
+		s := list.Value()
+		if to.String(s.Tags[backupVMTag]) == vmName {
+			snapshots = append(snapshots, s)
+		}
+		if err := list.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("cannot list snapshots: %v", err)
+		}
+	}
+	return snapshotSets(snapshots), nil
+}
+
+// setsToPrune returns the expired sets of sets, which is sorted newest
+// first, except for the newest keep sets, which are kept even when expired
+// so a VM always has a backup to restore.
+func setsToPrune(sets []SnapshotSet, now time.Time, keep int) []SnapshotSet {
+	var prune []SnapshotSet
+	for i, set := range sets {
+		if i >= keep && set.Expired(now) {
+			prune = append(prune, set)
+		}
+	}
+	return prune
+}
+
+// PruneSnapshotSets deletes the snapshot sets of a VM whose retention has
+// passed, but always keeps the newest keep sets. It returns the deleted
+// sets.
+func PruneSnapshotSets(ctx context.Context, vmName string, keep int) ([]SnapshotSet, error) {
+	sets, err := ListSnapshotSets(ctx, vmName)
+	if err != nil {
+		return nil, err
+	}
+	prune := setsToPrune(sets, time.Now(), keep)
+	for _, set := range prune {
+		if err := DeleteSnapshotSet(ctx, set); err != nil {
+			return nil, err
+		}
+	}
+	return prune, nil
+}
+
+// isNotFound reports whether err is a 404 response from Resource Manager.
+func isNotFound(err error) bool {
+	detailed, ok := err.(autorest.DetailedError)
+	return ok && detailed.StatusCode == http.StatusNotFound
+}
+
+// deleteSnapshot deletes a snapshot. A snapshot that does not exist is not
+// an error.
+func deleteSnapshot(ctx context.Context, name string) error {
+	snapshotsClient := getSnapshotsClient()
+	future, err := snapshotsClient.Delete(ctx, config.GroupName(), name)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot delete snapshot %s: %v", name, err)
+	}
+	err = future.WaitForCompletionRef(ctx, snapshotsClient.Client)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("cannot get the snapshot delete future response: %v", err)
+	}
+	return nil
+}
+
+// DeleteSnapshotSet deletes all snapshots of a set.
+func DeleteSnapshotSet(ctx context.Context, set SnapshotSet) error {
+	for _, s := range set.Snapshots {
+		if err := deleteSnapshot(ctx, s.SnapshotName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoredDisk returns the managed disk restored from one snapshot of a set
+// for the VM vmName.
+func restoredDisk(set SnapshotSet, snapshot DiskSnapshot, vmName string) (name string, disk compute.Disk) {
+	name = vmName + "-osdisk"
+	if !snapshot.OSDisk {
+		name = fmt.Sprintf("%s-datadisk%d", vmName, snapshot.Lun)
+	}
+	disk = compute.Disk{
+		Location: to.StringPtr(set.Location),
+		Tags: map[string]*string{
+			backupSetTag: to.StringPtr(set.ID),
+		},
+		DiskProperties: &compute.DiskProperties{
+			OsType: snapshot.OSType,
+			CreationData: &compute.CreationData{
+				CreateOption:     compute.Copy,
+				SourceResourceID: to.StringPtr(snapshot.SnapshotID),
+			},
+		},
+	}
+	return name, disk
+}
+
+// RestoreVM creates new managed disks from a snapshot set and creates a VM
+// from them as described by spec, which sets the VM's name, size and NICs.
+// The VM boots from the restored OS disk and has the restored data disks at
+// their original LUNs.
+func RestoreVM(ctx context.Context, set SnapshotSet, spec *VMSpec) (vm compute.VirtualMachine, err error) {
+	if !set.Complete() {
+		return vm, fmt.Errorf("snapshot set %s has %d of %d disk snapshots", set.ID, len(set.Snapshots), set.Disks)
+	}
+	hasOSDisk := false
+	for _, snapshot := range set.Snapshots {
+		hasOSDisk = hasOSDisk || snapshot.OSDisk
+	}
+	if !hasOSDisk {
+		return vm, fmt.Errorf("snapshot set %s has no OS disk snapshot", set.ID)
+	}
+	spec.WithLocation(set.Location)
+
+	disksClient := getDisksClient()
+	for _, snapshot := range set.Snapshots {
+		name, disk := restoredDisk(set, snapshot, spec.Name)
+		future, err := disksClient.CreateOrUpdate(ctx, config.GroupName(), name, disk)
+		if err != nil {
+			return vm, fmt.Errorf("cannot create disk: %v", err)
+		}
+		err = future.WaitForCompletionRef(ctx, disksClient.Client)
+		if err != nil {
+			return vm, fmt.Errorf("cannot get the disk create or update future response: %v", err)
+		}
+		disk, err = future.Result(disksClient)
+		if err != nil {
+			return vm, fmt.Errorf("cannot create disk: %v", err)
+		}
+		if snapshot.OSDisk {
+			spec.WithExistingOSDisk(*disk.ID, snapshot.OSType)
+		} else {
+			spec.WithExistingDataDisk(snapshot.Lun, *disk.ID)
+		}
+	}
+	return CreateVMFromSpec(ctx, spec)
+}
+
+// CopySnapshotSet copies a snapshot set to another region, as a new set of
+// full snapshots in the default resource group. Each snapshot is exported
+// with a temporary SAS, copied as a VHD to a staging container in a storage
+// account in the target region, imported from there and then removed from
+// the staging container.
+func CopySnapshotSet(ctx context.Context, set SnapshotSet, targetLocation, stagingAccountName, stagingAccountGroup, stagingContainer string) (SnapshotSet, error) {
+	account, err := storage.GetStorageAccount(ctx, stagingAccountName, stagingAccountGroup)
+	if err != nil {
+		return SnapshotSet{}, fmt.Errorf("cannot get staging storage account: %v", err)
+	}
+
+	copied := set
+	copied.ID = fmt.Sprintf("%s-%s", set.ID, targetLocation)
+	copied.Location = targetLocation
+	copied.Snapshots = nil
+	for _, s := range set.Snapshots {
+		vhd, err := exportSnapshot(ctx, s.SnapshotName, stagingAccountName, stagingAccountGroup, stagingContainer)
+		if err != nil {
+			return copied, err
+		}
+
+		disk := vmDisk{Name: s.DiskName, OSDisk: s.OSDisk, Lun: s.Lun, OSType: s.OSType}
+		name, snapshot := backupSnapshot(copied, disk, BackupOptions{Full: true})
+		snapshot.CreationData = &compute.CreationData{
+			CreateOption:     compute.Import,
+			SourceURI:        to.StringPtr(vhd),
+			StorageAccountID: account.ID,
+		}
+		snapshot, err = createSnapshot(ctx, name, snapshot)
+		if err != nil {
+			return copied, err
+		}
+		copied.Snapshots = append(copied.Snapshots, DiskSnapshot{
+			SnapshotID:   to.String(snapshot.ID),
+			SnapshotName: name,
+			DiskName:     s.DiskName,
+			OSDisk:       s.OSDisk,
+			Lun:          s.Lun,
+			OSType:       s.OSType,
+		})
+
+		err = storage.DeleteBlob(ctx, stagingAccountName, stagingAccountGroup, stagingContainer, s.SnapshotName+".vhd")
+		if err != nil {
+			return copied, fmt.Errorf("cannot delete staging blob: %v", err)
+		}
+	}
+	return copied, nil
+}
+
+// exportSnapshot copies a snapshot to a page blob in the staging container
+// and returns the blob's URL, without a SAS.
+func exportSnapshot(ctx context.Context, snapshotName, accountName, accountGroupName, containerName string) (string, error) {
+	snapshotsClient := getSnapshotsClient()
+	future, err := snapshotsClient.GrantAccess(ctx, config.GroupName(), snapshotName, compute.GrantAccessData{
+		Access:            compute.Read,
+		DurationInSeconds: to.Int32Ptr(int32((4 * time.Hour).Seconds())),
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot grant access to snapshot %s: %v", snapshotName, err)
+	}
+	err = future.WaitForCompletionRef(ctx, snapshotsClient.Client)
+	if err != nil {
+		return "", fmt.Errorf("cannot get the snapshot grant access future response: %v", err)
+	}
+	access, err := future.Result(snapshotsClient)
+	if err != nil {
+		return "", fmt.Errorf("cannot grant access to snapshot %s: %v", snapshotName, err)
+	}
+	defer func() {
+		revoke, err := snapshotsClient.RevokeAccess(ctx, config.GroupName(), snapshotName)
+		if err == nil {
+			_ = revoke.WaitForCompletionRef(ctx, snapshotsClient.Client)
+		}
+	}()
+
+	source, err := url.Parse(to.String(access.AccessSAS))
+	if err != nil {
+		return "", fmt.Errorf("cannot parse snapshot SAS: %v", err)
+	}
+	blob, err := storage.CopyBlobFromURL(ctx, accountName, accountGroupName, containerName, snapshotName+".vhd", *source, 30*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("cannot copy snapshot %s: %v", snapshotName, err)
+	}
+	u := blob.URL()
+	u.RawQuery = ""
+	return u.String(), nil
+}
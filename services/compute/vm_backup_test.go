@@ -0,0 +1,191 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestVMBackupDisks(t *testing.T) {
+	vm := compute.VirtualMachine{
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			StorageProfile: &compute.StorageProfile{
+				OsDisk: &compute.OSDisk{
+					Name:        to.StringPtr("vm-os"),
+					OsType:      compute.Linux,
+					ManagedDisk: &compute.ManagedDiskParameters{ID: to.StringPtr("/disks/vm-os")},
+				},
+				DataDisks: &[]compute.DataDisk{{
+					Lun:  to.Int32Ptr(3),
+					Name: to.StringPtr("vm-data"),
+					ManagedDisk: &compute.ManagedDiskParameters{
+						ID:                 to.StringPtr("/disks/vm-data"),
+						StorageAccountType: compute.StorageAccountTypesUltraSSDLRS,
+					},
+				}},
+			},
+		},
+	}
+	disks, err := vmDisks(vm)
+	if err != nil {
+		t.Fatalf("failed to list disks: %v", err)
+	}
+	if len(disks) != 2 || !disks[0].OSDisk || disks[1].Lun != 3 || disks[1].ID != "/disks/vm-data" {
+		t.Errorf("unexpected disks: %+v", disks)
+	}
+
+	set := SnapshotSet{
+		ID:       "vm-20200102t030405",
+		VMName:   "vm",
+		Location: "westus2",
+		Created:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Expires:  time.Date(2020, 1, 9, 3, 4, 5, 0, time.UTC),
+	}
+	name, snapshot := backupSnapshot(set, disks[0], BackupOptions{})
+	if name != "vm-20200102t030405-os" || !*snapshot.Incremental || *snapshot.CreationData.SourceResourceID != "/disks/vm-os" {
+		t.Errorf("unexpected OS disk snapshot %s: %+v", name, snapshot.SnapshotProperties)
+	}
+	if *snapshot.Tags[backupLunTag] != backupOSDiskLun || *snapshot.Tags[backupExpiresTag] != "2020-01-09T03:04:05Z" {
+		t.Errorf("unexpected tags: %v", snapshot.Tags)
+	}
+	name, snapshot = backupSnapshot(set, disks[1], BackupOptions{})
+	if name != "vm-20200102t030405-lun3" || *snapshot.Incremental {
+		t.Errorf("ultra disk %s should get a full snapshot", name)
+	}
+	if _, snapshot = backupSnapshot(set, disks[0], BackupOptions{Full: true}); *snapshot.Incremental {
+		t.Errorf("full snapshot requested, got an incremental one")
+	}
+
+	vm.StorageProfile.OsDisk.ManagedDisk = nil
+	if _, err := vmDisks(vm); err == nil {
+		t.Errorf("expected unmanaged disks to be rejected")
+	}
+}
+
+func TestSnapshotSets(t *testing.T) {
+	disks := []vmDisk{
+		{ID: "/disks/data", Name: "data", Lun: 1},
+		{ID: "/disks/os", Name: "os", OSDisk: true, OSType: compute.Linux},
+	}
+	var snapshots []compute.Snapshot
+	for i, created := range []time.Time{
+		time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC),
+	} {
+		set := SnapshotSet{ID: created.Format("vm-20060102"), VMName: "vm", Location: "westus2", Created: created, Disks: len(disks)}
+		if i < 2 {
+			set.Expires = created.Add(24 * time.Hour)
+		}
+		for _, disk := range disks {
+			name, snapshot := backupSnapshot(set, disk, BackupOptions{})
+			snapshot.Name = to.StringPtr(name)
+			snapshot.ID = to.StringPtr("/snapshots/" + name)
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	snapshots = append(snapshots, compute.Snapshot{Name: to.StringPtr("manual")})
+	// a backup that failed after its OS disk snapshot
+	partial := SnapshotSet{ID: "vm-20200104", VMName: "vm", Location: "westus2", Created: time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC), Disks: 2}
+	_, snapshot := backupSnapshot(partial, disks[1], BackupOptions{})
+	snapshots = append(snapshots, snapshot)
+
+	sets := snapshotSets(snapshots)
+	if len(sets) != 3 || sets[0].ID != "vm-20200103" || sets[2].ID != "vm-20200101" {
+		t.Fatalf("unexpected sets: %+v", sets)
+	}
+	first := sets[2]
+	if len(first.Snapshots) != 2 || !first.Snapshots[0].OSDisk || first.Snapshots[1].Lun != 1 {
+		t.Errorf("OS disk should come first: %+v", first.Snapshots)
+	}
+	if first.Snapshots[0].OSType != compute.Linux || first.Snapshots[1].DiskName != "data" || !first.Expires.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected set: %+v", first)
+	}
+
+	now := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	if prune := setsToPrune(sets, now, 0); len(prune) != 2 {
+		t.Errorf("expected both expired sets to be pruned, got %d", len(prune))
+	}
+	// the newest two are kept even though the second is expired
+	if prune := setsToPrune(sets, now, 2); len(prune) != 1 || prune[0].ID != "vm-20200101" {
+		t.Errorf("unexpected sets to prune: %+v", prune)
+	}
+	if prune := setsToPrune(sets, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), 0); len(prune) != 0 {
+		t.Errorf("nothing has expired yet, got %+v", prune)
+	}
+}
+
+func TestRestoredDisk(t *testing.T) {
+	set := SnapshotSet{ID: "vm-1", Location: "eastus"}
+	name, disk := restoredDisk(set, DiskSnapshot{SnapshotID: "/snapshots/s", OSDisk: true, OSType: compute.Windows}, "restored")
+	if name != "restored-osdisk" || *disk.Location != "eastus" || disk.OsType != compute.Windows {
+		t.Errorf("unexpected OS disk %s: %+v", name, disk)
+	}
+	if disk.CreationData.CreateOption != compute.Copy || *disk.CreationData.SourceResourceID != "/snapshots/s" {
+		t.Errorf("unexpected creation data: %+v", disk.CreationData)
+	}
+	if name, _ := restoredDisk(set, DiskSnapshot{Lun: 2}, "restored"); name != "restored-datadisk2" {
+		t.Errorf("unexpected data disk name %s", name)
+	}
+}
+
+func TestTakeSnapshots(t *testing.T) {
+	disks := []vmDisk{
+		{ID: "/disks/os", Name: "os", OSDisk: true},
+		{ID: "/disks/data1", Name: "data1", Lun: 1},
+		{ID: "/disks/data2", Name: "data2", Lun: 2},
+	}
+	set := SnapshotSet{ID: "vm-1", VMName: "vm", Disks: len(disks)}
+	var mu sync.Mutex
+	var deleted []string
+	create := func(fail string) func(context.Context, string, compute.Snapshot) (compute.Snapshot, error) {
+		return func(ctx context.Context, name string, snapshot compute.Snapshot) (compute.Snapshot, error) {
+			if name == fail {
+				return snapshot, fmt.Errorf("quota exceeded")
+			}
+			snapshot.ID = to.StringPtr("/snapshots/" + name)
+			return snapshot, nil
+		}
+	}
+	remove := func(ctx context.Context, name string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		deleted = append(deleted, name)
+		return nil
+	}
+
+	done, err := takeSnapshots(context.Background(), set, disks, BackupOptions{}, create(""), remove)
+	if err != nil || !done.Complete() || done.Snapshots[2].SnapshotID != "/snapshots/vm-1-lun2" {
+		t.Fatalf("unexpected set: %+v, %v", done, err)
+	}
+
+	partial, err := takeSnapshots(context.Background(), set, disks, BackupOptions{}, create("vm-1-lun1"), remove)
+	if err == nil {
+		t.Fatalf("expected the failed snapshot to be returned")
+	}
+	sort.Strings(deleted)
+	if want := []string{"vm-1-lun1", "vm-1-lun2", "vm-1-os"}; !reflect.DeepEqual(deleted, want) || len(partial.Snapshots) != 0 || partial.Complete() {
+		t.Errorf("expected every snapshot of the set, the failed one too, to be deleted, got %v and %+v", deleted, partial)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !isNotFound(autorest.DetailedError{StatusCode: http.StatusNotFound}) {
+		t.Errorf("expected a 404 to be not found")
+	}
+	if isNotFound(autorest.DetailedError{StatusCode: http.StatusConflict}) || isNotFound(fmt.Errorf("snapshot not found")) || isNotFound(nil) {
+		t.Errorf("expected only 404 responses to be not found")
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	compute20200630 "github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-30/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	// maxDataDiskLun is the highest LUN of a data disk. VM sizes limit the
+	// number of data disks, but not which LUNs they use.
+	maxDataDiskLun = 63
+
+	// maxOnlineResizeGB is the largest size a disk can have while it is
+	// resized without deallocating its VM; growing past it needs the VM
+	// deallocated.
+	maxOnlineResizeGB = 4095
+)
+
+// AnyLun makes AttachDisk attach a disk at the next free LUN.
+const AnyLun int32 = -1
+
+// getTieredDisksClient returns a disks client for the API version that
+// added performance tiers.
+func getTieredDisksClient() compute20200630.DisksClient {
+	disksClient := compute20200630.NewDisksClient(config.SubscriptionID())
+	a, _ := iam.GetResourceManagementAuthorizer()
+	disksClient.Authorizer = a
+	_ = disksClient.AddToUserAgent(config.UserAgent())
+	return disksClient
+}
+
+func dataDisks(vm compute.VirtualMachine) []compute.DataDisk {
+	if vm.VirtualMachineProperties == nil || vm.StorageProfile == nil || vm.StorageProfile.DataDisks == nil {
+		return nil
+	}
+	return *vm.StorageProfile.DataDisks
+}
+
+// NextFreeLun returns the lowest LUN not used by a data disk of vm.
+func NextFreeLun(vm compute.VirtualMachine) (int32, error) {
+	used := map[int32]bool{}
+	for _, d := range dataDisks(vm) {
+		used[to.Int32(d.Lun)] = true
+	}
+	for lun := int32(0); lun <= maxDataDiskLun; lun++ {
+		if !used[lun] {
+			return lun, nil
+		}
+	}
+	return 0, fmt.Errorf("vm %s has no free LUN", to.String(vm.Name))
+}
+
+// attachDataDisk adds an existing managed disk to vm's data disks at lun,
+// or at the next free LUN for AnyLun, and returns the LUN used.
+func attachDataDisk(vm *compute.VirtualMachine, diskID, diskName string, lun int32) (int32, error) {
+	if vm.VirtualMachineProperties == nil || vm.StorageProfile == nil {
+		return 0, fmt.Errorf("vm has no storage profile")
+	}
+	disks := dataDisks(*vm)
+	for _, d := range disks {
+		if d.ManagedDisk != nil && strings.EqualFold(to.String(d.ManagedDisk.ID), diskID) {
+			return 0, fmt.Errorf("disk %s is already attached at LUN %d", diskName, to.Int32(d.Lun))
+		}
+	}
+	if lun == AnyLun {
+		var err error
+		if lun, err = NextFreeLun(*vm); err != nil {
+			return 0, err
+		}
+	} else if lun < 0 || lun > maxDataDiskLun {
+		return 0, fmt.Errorf("LUN %d is out of range 0-%d", lun, maxDataDiskLun)
+	}
+	for _, d := range disks {
+		if to.Int32(d.Lun) == lun {
+			return 0, fmt.Errorf("LUN %d is used by disk %s", lun, to.String(d.Name))
+		}
+	}
+	disks = append(disks, compute.DataDisk{
+		Lun:          to.Int32Ptr(lun),
+		Name:         to.StringPtr(diskName),
+		CreateOption: compute.DiskCreateOptionTypesAttach,
+		ManagedDisk:  &compute.ManagedDiskParameters{ID: to.StringPtr(diskID)},
+	})
+	vm.StorageProfile.DataDisks = &disks
+	return lun, nil
+}
+
+// detachDataDisk removes the data disks of vm that match and returns how
+// many it removed.
+func detachDataDisk(vm *compute.VirtualMachine, match func(compute.DataDisk) bool) int {
+	var kept []compute.DataDisk
+	removed := 0
+	for _, d := range dataDisks(*vm) {
+		if match(d) {
+			removed++
+			continue
+		}
+		kept = append(kept, d)
+	}
+	if removed > 0 {
+		if kept == nil {
+			kept = []compute.DataDisk{}
+		}
+		vm.StorageProfile.DataDisks = &kept
+	}
+	return removed
+}
+
+func putVM(ctx context.Context, vmName string, vm compute.VirtualMachine) (compute.VirtualMachine, error) {
+	vmClient := getVMClient()
+	future, err := vmClient.CreateOrUpdate(ctx, config.GroupName(), vmName, vm)
+	if err != nil {
+		return vm, fmt.Errorf("cannot update vm: %v", err)
+	}
+	err = future.WaitForCompletionRef(ctx, vmClient.Client)
+	if err != nil {
+		return vm, fmt.Errorf("cannot get the vm create or update future response: %v", err)
+	}
+	return future.Result(vmClient)
+}
+
+// AttachDisk attaches an existing managed disk in the default resource
+// group to a VM at lun, or at the next free LUN if lun is AnyLun. It
+// returns the updated VM and the LUN used.
+func AttachDisk(ctx context.Context, vmName, diskName string, lun int32) (vm compute.VirtualMachine, usedLun int32, err error) {
+	disk, err := getDisk(ctx, diskName)
+	if err != nil {
+		return vm, 0, fmt.Errorf("cannot get disk: %v", err)
+	}
+	vm, err = GetVM(ctx, vmName)
+	if err != nil {
+		return vm, 0, fmt.Errorf("cannot get vm: %v", err)
+	}
+	usedLun, err = attachDataDisk(&vm, *disk.ID, diskName, lun)
+	if err != nil {
+		return vm, 0, fmt.Errorf("cannot attach disk to vm %s: %v", vmName, err)
+	}
+	vm, err = putVM(ctx, vmName, vm)
+	return vm, usedLun, err
+}
+
+// DetachDiskByName detaches the data disk with the given name from a VM.
+func DetachDiskByName(ctx context.Context, vmName, diskName string) (vm compute.VirtualMachine, err error) {
+	return detachDisk(ctx, vmName, fmt.Sprintf("disk %s", diskName), func(d compute.DataDisk) bool {
+		return strings.EqualFold(to.String(d.Name), diskName)
+	})
+}
+
+// DetachDiskByLun detaches the data disk at a LUN from a VM.
+func DetachDiskByLun(ctx context.Context, vmName string, lun int32) (vm compute.VirtualMachine, err error) {
+	return detachDisk(ctx, vmName, fmt.Sprintf("a disk at LUN %d", lun), func(d compute.DataDisk) bool {
+		return to.Int32(d.Lun) == lun
+	})
+}
+
+func detachDisk(ctx context.Context, vmName, what string, match func(compute.DataDisk) bool) (vm compute.VirtualMachine, err error) {
+	vm, err = GetVM(ctx, vmName)
+	if err != nil {
+		return vm, fmt.Errorf("cannot get vm: %v", err)
+	}
+	if detachDataDisk(&vm, match) == 0 {
+		return vm, fmt.Errorf("vm %s has no %s", vmName, what)
+	}
+	return putVM(ctx, vmName, vm)
+}
+
+// DiskChange is a change to a managed disk. Zero fields are left as they
+// are.
+type DiskChange struct {
+	SizeGB int32
+	Sku    compute.DiskStorageAccountTypes
+	// Tier is the performance tier of a premium SSD, such as "P30". It can
+	// be higher than the tier of the disk's size, for more IOPS and
+	// throughput without growing the disk.
+	Tier string
+}
+
+// DiskChangePlan is what applying a DiskChange to a disk does.
+type DiskChangePlan struct {
+	DiskName   string
+	FromSizeGB int32
+	ToSizeGB   int32
+	FromSku    compute.DiskStorageAccountTypes
+	ToSku      compute.DiskStorageAccountTypes
+	FromTier   string
+	ToTier     string
+	// Deallocate is set when the disk's VM must be deallocated for the
+	// change; DeallocateReasons says why.
+	Deallocate        bool
+	DeallocateReasons []string
+}
+
+// Empty reports whether the plan changes nothing.
+func (p DiskChangePlan) Empty() bool {
+	return p.FromSizeGB == p.ToSizeGB && p.FromSku == p.ToSku && p.FromTier == p.ToTier
+}
+
+// planDiskChange checks a change to a disk and works out whether its VM
+// must be deallocated. It refuses changes that would lose data or that
+// the service does not support, such as shrinking a disk.
+func planDiskChange(disk compute.Disk, tier string, change DiskChange) (DiskChangePlan, error) {
+	plan := DiskChangePlan{
+		DiskName: to.String(disk.Name),
+		FromTier: tier,
+		ToTier:   tier,
+	}
+	if disk.Sku != nil {
+		plan.FromSku = disk.Sku.Name
+	}
+	plan.ToSku = plan.FromSku
+	if disk.DiskProperties != nil {
+		plan.FromSizeGB = to.Int32(disk.DiskSizeGB)
+	}
+	plan.ToSizeGB = plan.FromSizeGB
+
+	if change.SizeGB != 0 {
+		if change.SizeGB < plan.FromSizeGB {
+			return plan, fmt.Errorf("cannot shrink disk %s from %d GB to %d GB: managed disks can only grow", plan.DiskName, plan.FromSizeGB, change.SizeGB)
+		}
+		plan.ToSizeGB = change.SizeGB
+	}
+	if change.Sku != "" && change.Sku != plan.FromSku {
+		if change.Sku == compute.UltraSSDLRS || plan.FromSku == compute.UltraSSDLRS {
+			return plan, fmt.Errorf("cannot change disk %s from %s to %s: ultra disks cannot change SKU", plan.DiskName, plan.FromSku, change.Sku)
+		}
+		plan.ToSku = change.Sku
+	}
+	if change.Tier != "" && change.Tier != plan.FromTier {
+		if plan.ToSku != compute.PremiumLRS {
+			return plan, fmt.Errorf("cannot set performance tier %s on disk %s: only premium SSDs have performance tiers", change.Tier, plan.DiskName)
+		}
+		if !strings.HasPrefix(change.Tier, "P") {
+			return plan, fmt.Errorf("performance tier %s of disk %s is not a premium SSD tier", change.Tier, plan.DiskName)
+		}
+		plan.ToTier = change.Tier
+	}
+
+	attached := disk.ManagedBy != nil || (disk.DiskProperties != nil && disk.DiskState == compute.Attached)
+	if !attached {
+		return plan, nil
+	}
+	needs := func(reason string) {
+		plan.Deallocate = true
+		plan.DeallocateReasons = append(plan.DeallocateReasons, reason)
+	}
+	if plan.ToSizeGB != plan.FromSizeGB {
+		switch {
+		case disk.DiskProperties != nil && disk.OsType != "":
+			needs("OS disks are only resized while deallocated")
+		case plan.FromSku == compute.UltraSSDLRS:
+			needs("ultra disks are only resized while deallocated")
+		case plan.FromSizeGB <= maxOnlineResizeGB && plan.ToSizeGB > maxOnlineResizeGB:
+			needs(fmt.Sprintf("disks only grow past %d GB while deallocated", maxOnlineResizeGB))
+		}
+	}
+	if plan.ToSku != plan.FromSku {
+		needs("the SKU of an attached disk only changes while deallocated")
+	}
+	if plan.ToTier != plan.FromTier {
+		needs("the performance tier of an attached disk only changes while deallocated")
+	}
+	return plan, nil
+}
+
+// PlanDiskChange reads a disk in the default resource group and returns
+// what applying change to it would do, or why it cannot be applied.
+func PlanDiskChange(ctx context.Context, diskName string, change DiskChange) (DiskChangePlan, error) {
+	disk, err := getDisk(ctx, diskName)
+	if err != nil {
+		return DiskChangePlan{}, fmt.Errorf("cannot get disk: %v", err)
+	}
+	tiered, err := getTieredDisksClient().Get(ctx, config.GroupName(), diskName)
+	if err != nil {
+		return DiskChangePlan{}, fmt.Errorf("cannot get disk: %v", err)
+	}
+	var tier string
+	if tiered.DiskProperties != nil {
+		tier = to.String(tiered.Tier)
+	}
+	return planDiskChange(disk, tier, change)
+}
+
+// ApplyDiskChange resizes a disk, changes its SKU or its performance tier.
+// If the disk is attached to vmName and the change needs it, the VM is
+// deallocated first and started again afterwards if it was running.
+func ApplyDiskChange(ctx context.Context, vmName, diskName string, change DiskChange) (plan DiskChangePlan, err error) {
+	plan, err = PlanDiskChange(ctx, diskName, change)
+	if err != nil || plan.Empty() {
+		return plan, err
+	}
+	err = applyDiskPlan(ctx, defaultLifecycle(), vmName, plan, func() error {
+		return updateDisk(ctx, diskName, plan)
+	})
+	return plan, err
+}
+
+// applyDiskPlan runs update, with the VM deallocated if the plan needs it.
+// A failure to start the VM again is returned too.
+func applyDiskPlan(ctx context.Context, l vmLifecycle, vmName string, plan DiskChangePlan, update func() error) error {
+	if plan.Deallocate {
+		return l.whileStopped(ctx, vmName, TransitionDeallocate, update)
+	}
+	return update()
+}
+
+// updateDisk applies the size, SKU and tier of a plan to a disk.
+func updateDisk(ctx context.Context, diskName string, plan DiskChangePlan) error {
+	if plan.ToSizeGB != plan.FromSizeGB || plan.ToSku != plan.FromSku {
+		update := compute.DiskUpdate{DiskUpdateProperties: &compute.DiskUpdateProperties{}}
+		if plan.ToSizeGB != plan.FromSizeGB {
+			update.DiskSizeGB = to.Int32Ptr(plan.ToSizeGB)
+		}
+		if plan.ToSku != plan.FromSku {
+			update.Sku = &compute.DiskSku{Name: plan.ToSku}
+		}
+		disksClient := getDisksClient()
+		future, err := disksClient.Update(ctx, config.GroupName(), diskName, update)
+		if err != nil {
+			return fmt.Errorf("cannot update disk: %v", err)
+		}
+		err = future.WaitForCompletionRef(ctx, disksClient.Client)
+		if err != nil {
+			return fmt.Errorf("cannot get the disk update future response: %v", err)
+		}
+	}
+
+	if plan.ToTier != plan.FromTier {
+		disksClient := getTieredDisksClient()
+		future, err := disksClient.Update(ctx, config.GroupName(), diskName, compute20200630.DiskUpdate{
+			DiskUpdateProperties: &compute20200630.DiskUpdateProperties{
+				Tier: to.StringPtr(plan.ToTier),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("cannot update disk tier: %v", err)
+		}
+		err = future.WaitForCompletionRef(ctx, disksClient.Client)
+		if err != nil {
+			return fmt.Errorf("cannot get the disk update future response: %v", err)
+		}
+	}
+	return nil
+}
+
+// ResizeDataDisk grows the data disk at a LUN of a VM to sizeGB,
+// deallocating the VM only if the disk cannot be resized online.
+func ResizeDataDisk(ctx context.Context, vmName string, lun int32, sizeGB int32) (DiskChangePlan, error) {
+	vm, err := GetVM(ctx, vmName)
+	if err != nil {
+		return DiskChangePlan{}, fmt.Errorf("cannot get vm: %v", err)
+	}
+	for _, d := range dataDisks(vm) {
+		if to.Int32(d.Lun) == lun {
+			return ApplyDiskChange(ctx, vmName, to.String(d.Name), DiskChange{SizeGB: sizeGB})
+		}
+	}
+	return DiskChangePlan{}, fmt.Errorf("vm %s has no disk at LUN %d", vmName, lun)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func vmWithDataDisks(luns ...int32) compute.VirtualMachine {
+	var disks []compute.DataDisk
+	for _, lun := range luns {
+		name := "disk" + string(rune('a'+lun))
+		disks = append(disks, compute.DataDisk{
+			Lun:         to.Int32Ptr(lun),
+			Name:        to.StringPtr(name),
+			ManagedDisk: &compute.ManagedDiskParameters{ID: to.StringPtr("/disks/" + name)},
+		})
+	}
+	return compute.VirtualMachine{
+		Name: to.StringPtr("vm"),
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			StorageProfile: &compute.StorageProfile{DataDisks: &disks},
+		},
+	}
+}
+
+func TestNextFreeLun(t *testing.T) {
+	if lun, err := NextFreeLun(vmWithDataDisks(0, 1, 3)); err != nil || lun != 2 {
+		t.Errorf("got LUN %d, %v, want 2", lun, err)
+	}
+	if lun, err := NextFreeLun(compute.VirtualMachine{}); err != nil || lun != 0 {
+		t.Errorf("got LUN %d, %v for a VM without disks, want 0", lun, err)
+	}
+	var all []int32
+	for lun := int32(0); lun <= maxDataDiskLun; lun++ {
+		all = append(all, lun)
+	}
+	if _, err := NextFreeLun(vmWithDataDisks(all...)); err == nil {
+		t.Errorf("expected an error when all LUNs are used")
+	}
+}
+
+func TestAttachDetachDataDisk(t *testing.T) {
+	vm := vmWithDataDisks(0, 2)
+	lun, err := attachDataDisk(&vm, "/disks/new", "new", AnyLun)
+	if err != nil || lun != 1 {
+		t.Fatalf("got LUN %d, %v, want 1", lun, err)
+	}
+	disks := dataDisks(vm)
+	if len(disks) != 3 || disks[2].CreateOption != compute.DiskCreateOptionTypesAttach || *disks[2].ManagedDisk.ID != "/disks/new" {
+		t.Errorf("unexpected disks: %+v", disks)
+	}
+	if _, err := attachDataDisk(&vm, "/DISKS/NEW", "new", AnyLun); err == nil {
+		t.Errorf("expected attaching the same disk twice to fail")
+	}
+	if _, err := attachDataDisk(&vm, "/disks/other", "other", 2); err == nil {
+		t.Errorf("expected attaching at a used LUN to fail")
+	}
+	if _, err := attachDataDisk(&vm, "/disks/other", "other", 64); err == nil {
+		t.Errorf("expected an out of range LUN to fail")
+	}
+
+	if n := detachDataDisk(&vm, func(d compute.DataDisk) bool { return to.Int32(d.Lun) == 2 }); n != 1 || len(dataDisks(vm)) != 2 {
+		t.Errorf("detached %d disks, %d left", n, len(dataDisks(vm)))
+	}
+	if n := detachDataDisk(&vm, func(d compute.DataDisk) bool { return false }); n != 0 || len(dataDisks(vm)) != 2 {
+		t.Errorf("detached %d disks without a match", n)
+	}
+}
+
+func TestPlanDiskChange(t *testing.T) {
+	disk := func(sku compute.DiskStorageAccountTypes, sizeGB int32, attached bool) compute.Disk {
+		d := compute.Disk{
+			Name:           to.StringPtr("data"),
+			Sku:            &compute.DiskSku{Name: sku},
+			DiskProperties: &compute.DiskProperties{DiskSizeGB: to.Int32Ptr(sizeGB), DiskState: compute.Unattached},
+		}
+		if attached {
+			d.ManagedBy = to.StringPtr("/vms/vm")
+			d.DiskState = compute.Attached
+		}
+		return d
+	}
+
+	cases := []struct {
+		name       string
+		disk       compute.Disk
+		tier       string
+		change     DiskChange
+		deallocate bool
+	}{
+		{"online grow", disk(compute.PremiumLRS, 128, true), "P10", DiskChange{SizeGB: 256}, false},
+		{"grow past 4 TiB", disk(compute.PremiumLRS, 1024, true), "P30", DiskChange{SizeGB: 8192}, true},
+		{"grow beyond 4 TiB", disk(compute.PremiumLRS, 8192, true), "P60", DiskChange{SizeGB: 16384}, false},
+		{"ultra grow", disk(compute.UltraSSDLRS, 128, true), "", DiskChange{SizeGB: 256}, true},
+		{"sku change", disk(compute.StandardLRS, 128, true), "", DiskChange{Sku: compute.PremiumLRS}, true},
+		{"detached sku change", disk(compute.StandardLRS, 128, false), "", DiskChange{Sku: compute.StandardSSDLRS}, false},
+		{"tier change", disk(compute.PremiumLRS, 128, true), "P10", DiskChange{Tier: "P30"}, true},
+		{"same size", disk(compute.PremiumLRS, 128, true), "P10", DiskChange{SizeGB: 128, Tier: "P10"}, false},
+	}
+	for _, c := range cases {
+		plan, err := planDiskChange(c.disk, c.tier, c.change)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if plan.Deallocate != c.deallocate || plan.Deallocate != (len(plan.DeallocateReasons) > 0) {
+			t.Errorf("%s: deallocate %v (%v), want %v", c.name, plan.Deallocate, plan.DeallocateReasons, c.deallocate)
+		}
+	}
+
+	plan, _ := planDiskChange(disk(compute.PremiumLRS, 128, true), "P10", DiskChange{SizeGB: 128})
+	if !plan.Empty() {
+		t.Errorf("unchanged size should give an empty plan: %+v", plan)
+	}
+
+	osDisk := disk(compute.PremiumLRS, 30, true)
+	osDisk.OsType = compute.Linux
+	if plan, err := planDiskChange(osDisk, "P4", DiskChange{SizeGB: 64}); err != nil || !plan.Deallocate {
+		t.Errorf("OS disk resize should deallocate: %+v, %v", plan, err)
+	}
+
+	refused := map[string]DiskChange{
+		"shrink":         {SizeGB: 64},
+		"to ultra":       {Sku: compute.UltraSSDLRS},
+		"tier on hdd":    {Sku: compute.StandardLRS, Tier: "P30"},
+		"non-p tier":     {Tier: "E30"},
+		"shrink and sku": {SizeGB: 32, Sku: compute.StandardSSDLRS},
+	}
+	for name, change := range refused {
+		if _, err := planDiskChange(disk(compute.PremiumLRS, 128, true), "P10", change); err == nil {
+			t.Errorf("%s: expected the change to be refused", name)
+		}
+	}
+}
+
+func TestApplyDiskPlan(t *testing.T) {
+	vms := newFakeVMs(0, map[string]PowerState{"vm1": PowerStateRunning})
+	l := vms.lifecycle()
+	var updatedIn PowerState
+	update := func() error {
+		updatedIn = vms.states["vm1"].PowerState
+		return nil
+	}
+
+	if err := applyDiskPlan(context.Background(), l, "vm1", DiskChangePlan{}, update); err != nil || updatedIn != PowerStateRunning || len(vms.applied) != 0 {
+		t.Errorf("an online change should not touch the vm: %v, %s, %v", err, updatedIn, vms.applied)
+	}
+
+	plan := DiskChangePlan{Deallocate: true}
+	if err := applyDiskPlan(context.Background(), l, "vm1", plan, update); err != nil || updatedIn != PowerStateDeallocated || vms.states["vm1"].PowerState != PowerStateRunning {
+		t.Errorf("expected the update to run deallocated and the vm to run again: %v, %s, %+v", err, updatedIn, vms.states["vm1"])
+	}
+
+	vms.fail["vm1:start"] = true
+	if err := applyDiskPlan(context.Background(), l, "vm1", plan, update); err == nil {
+		t.Errorf("expected the failure to start the vm again to be returned")
+	}
+}
@@ -154,71 +154,21 @@ func CreateDisk(ctx context.Context, diskName string) (disk compute.Disk, err er
 
 // CreateVMWithDisk creates a VM, attaching an already existing data disk
 func CreateVMWithDisk(ctx context.Context, nicName, diskName, vmName, username, password string) (vm compute.VirtualMachine, err error) {
-
-	nic, _ := network.GetNic(ctx, nicName)
-	disk, _ := getDisk(ctx, diskName)
-
-	vmClient := getVMClient()
-	future, err := vmClient.CreateOrUpdate(
-		ctx,
-		config.GroupName(),
-		vmName, compute.VirtualMachine{
-			Location: to.StringPtr(config.Location()),
-			VirtualMachineProperties: &compute.VirtualMachineProperties{
-				HardwareProfile: &compute.HardwareProfile{
-					VMSize: compute.VirtualMachineSizeTypesBasicA0,
-				},
-				NetworkProfile: &compute.NetworkProfile{
-					NetworkInterfaces: &[]compute.NetworkInterfaceReference{
-						{
-							ID: nic.ID,
-							NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
-								Primary: to.BoolPtr(true),
-							},
-						},
-					},
-				},
-				OsProfile: &compute.OSProfile{
-					ComputerName:  to.StringPtr(vmName),
-					AdminUsername: to.StringPtr(username),
-					AdminPassword: to.StringPtr(password),
-					LinuxConfiguration: &compute.LinuxConfiguration{
-						DisablePasswordAuthentication: to.BoolPtr(false),
-					},
-				},
-				StorageProfile: &compute.StorageProfile{
-					ImageReference: &compute.ImageReference{
-						Publisher: to.StringPtr(publisher),
-						Offer:     to.StringPtr(offer),
-						Sku:       to.StringPtr(sku),
-						Version:   to.StringPtr("latest"),
-					},
-					OsDisk: &compute.OSDisk{
-						CreateOption: compute.DiskCreateOptionTypesFromImage,
-						DiskSizeGB:   to.Int32Ptr(64),
-					},
-					DataDisks: &[]compute.DataDisk{
-						{
-							CreateOption: compute.DiskCreateOptionTypesAttach,
-							Lun:          to.Int32Ptr(0),
-							ManagedDisk: &compute.ManagedDiskParameters{
-								ID: disk.ID,
-							},
-						},
-					},
-				},
-			},
-		})
+	nic, err := network.GetNic(ctx, nicName)
 	if err != nil {
-		return vm, fmt.Errorf("cannot create vm: %v", err)
+		return vm, fmt.Errorf("cannot get nic: %v", err)
 	}
-
-	err = future.WaitForCompletionRef(ctx, vmClient.Client)
+	disk, err := getDisk(ctx, diskName)
 	if err != nil {
-		return vm, fmt.Errorf("cannot get the vm create or update future response: %v", err)
+		return vm, fmt.Errorf("cannot get disk: %v", err)
 	}
 
-	return future.Result(vmClient)
+	return CreateVMFromSpec(ctx, NewVMSpec(vmName).
+		WithNIC(*nic.ID).
+		WithAdmin(username).
+		WithPassword(password).
+		WithOSDiskSize(64).
+		WithExistingDataDisk(0, *disk.ID))
 }
 
 // AddDiskEncryptionToVM adds an extension to a VM to enable use of encryption
@@ -0,0 +1,329 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// PowerState is the power state of a VM, from its instance view.
+type PowerState string
+
+// Power states of a VM.
+const (
+	PowerStateStarting     PowerState = "starting"
+	PowerStateRunning      PowerState = "running"
+	PowerStateStopping     PowerState = "stopping"
+	PowerStateStopped      PowerState = "stopped"
+	PowerStateDeallocating PowerState = "deallocating"
+	PowerStateDeallocated  PowerState = "deallocated"
+	// PowerStateUnknown is reported while a VM has no power state, such as
+	// early during creation.
+	PowerStateUnknown PowerState = "unknown"
+)
+
+// ProvisioningState is the state of the last operation on a VM, from its
+// instance view.
+type ProvisioningState string
+
+// Provisioning states of a VM.
+const (
+	ProvisioningStateCreating  ProvisioningState = "creating"
+	ProvisioningStateUpdating  ProvisioningState = "updating"
+	ProvisioningStateDeleting  ProvisioningState = "deleting"
+	ProvisioningStateSucceeded ProvisioningState = "succeeded"
+	ProvisioningStateFailed    ProvisioningState = "failed"
+	ProvisioningStateUnknown   ProvisioningState = "unknown"
+)
+
+// VMState is the power and provisioning state of a VM.
+type VMState struct {
+	PowerState        PowerState
+	ProvisioningState ProvisioningState
+}
+
+// parseVMState reads the state of a VM from the status codes of its
+// instance view, such as "PowerState/running" and
+// "ProvisioningState/succeeded".
+func parseVMState(view compute.VirtualMachineInstanceView) VMState {
+	return parseStatuses(view.Statuses)
+}
+
+// parseStatuses reads a VM state from instance view statuses, of a VM or
+// a scale set instance.
+func parseStatuses(statuses *[]compute.InstanceViewStatus) VMState {
+	state := VMState{PowerState: PowerStateUnknown, ProvisioningState: ProvisioningStateUnknown}
+	if statuses == nil {
+		return state
+	}
+	for _, s := range *statuses {
+		parts := strings.SplitN(strings.ToLower(to.String(s.Code)), "/", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		switch parts[0] {
+		case "powerstate":
+			state.PowerState = PowerState(parts[1])
+		case "provisioningstate":
+			state.ProvisioningState = ProvisioningState(parts[1])
+		}
+	}
+	return state
+}
+
+// GetVMState reads the power and provisioning state of a VM.
+func GetVMState(ctx context.Context, vmName string) (VMState, error) {
+	vmClient := getVMClient()
+	view, err := vmClient.InstanceView(ctx, config.GroupName(), vmName)
+	if err != nil {
+		return VMState{}, fmt.Errorf("cannot get vm instance view: %v", err)
+	}
+	return parseVMState(view), nil
+}
+
+// Transition is a change of a VM's power state.
+type Transition string
+
+// Transitions of a VM's power state.
+const (
+	TransitionStart      Transition = "start"
+	TransitionStop       Transition = "stop"
+	TransitionDeallocate Transition = "deallocate"
+	TransitionRestart    Transition = "restart"
+)
+
+// planTransition returns the operation that applies t to a VM in the given
+// power state, or "" if the VM is already there, and the power state to
+// wait for afterwards. Stopping a deallocated VM leaves it deallocated, and
+// restarting a stopped VM starts it.
+func planTransition(current PowerState, t Transition) (op Transition, target PowerState, err error) {
+	switch t {
+	case TransitionStart:
+		if current == PowerStateRunning || current == PowerStateStarting {
+			return "", PowerStateRunning, nil
+		}
+		return TransitionStart, PowerStateRunning, nil
+	case TransitionStop:
+		switch current {
+		case PowerStateStopped, PowerStateStopping:
+			return "", PowerStateStopped, nil
+		case PowerStateDeallocated, PowerStateDeallocating:
+			return "", PowerStateDeallocated, nil
+		}
+		return TransitionStop, PowerStateStopped, nil
+	case TransitionDeallocate:
+		if current == PowerStateDeallocated || current == PowerStateDeallocating {
+			return "", PowerStateDeallocated, nil
+		}
+		return TransitionDeallocate, PowerStateDeallocated, nil
+	case TransitionRestart:
+		if current == PowerStateRunning {
+			return TransitionRestart, PowerStateRunning, nil
+		}
+		return TransitionStart, PowerStateRunning, nil
+	}
+	return "", "", fmt.Errorf("unknown transition %q", t)
+}
+
+// Backoff is how long WaitForState waits between reads of the instance
+// view: Initial at first, doubling up to Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// DefaultBackoff is the backoff used by WaitForState.
+var DefaultBackoff = Backoff{Initial: 5 * time.Second, Max: time.Minute}
+
+func (b Backoff) next(d time.Duration) time.Duration {
+	if d == 0 {
+		return b.Initial
+	}
+	d *= 2
+	if d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// pollUntil calls done with backoff until it reports true, fails or ctx is
+// done.
+func pollUntil(ctx context.Context, backoff Backoff, what string, done func() (bool, error)) error {
+	var wait time.Duration
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		wait = backoff.next(wait)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("still waiting for %s: %v", what, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// vmLifecycle runs transitions with replaceable operations, so that the
+// state machine can be exercised without Azure.
+type vmLifecycle struct {
+	getState func(ctx context.Context, vmName string) (VMState, error)
+	apply    func(ctx context.Context, vmName string, op Transition) error
+	backoff  Backoff
+}
+
+func defaultLifecycle() vmLifecycle {
+	return vmLifecycle{
+		getState: GetVMState,
+		apply: func(ctx context.Context, vmName string, op Transition) (err error) {
+			switch op {
+			case TransitionStart:
+				_, err = StartVM(ctx, vmName)
+			case TransitionStop:
+				_, err = StopVM(ctx, vmName)
+			case TransitionDeallocate:
+				_, err = DeallocateVM(ctx, vmName)
+			case TransitionRestart:
+				_, err = RestartVM(ctx, vmName)
+			}
+			return err
+		},
+		backoff: DefaultBackoff,
+	}
+}
+
+func (l vmLifecycle) waitForState(ctx context.Context, vmName string, target PowerState) (state VMState, err error) {
+	err = pollUntil(ctx, l.backoff, fmt.Sprintf("vm %s to be %s", vmName, target), func() (bool, error) {
+		if state, err = l.getState(ctx, vmName); err != nil {
+			return false, err
+		}
+		if state.ProvisioningState == ProvisioningStateFailed && state.PowerState != target {
+			return false, fmt.Errorf("vm %s failed while %s, waiting for %s", vmName, state.PowerState, target)
+		}
+		return state.PowerState == target, nil
+	})
+	return state, err
+}
+
+func (l vmLifecycle) transition(ctx context.Context, vmName string, t Transition) (VMState, error) {
+	state, err := l.getState(ctx, vmName)
+	if err != nil {
+		return state, err
+	}
+	op, target, err := planTransition(state.PowerState, t)
+	if err != nil {
+		return state, err
+	}
+	if op != "" {
+		if err := l.apply(ctx, vmName, op); err != nil {
+			return state, fmt.Errorf("cannot %s vm %s: %v", op, vmName, err)
+		}
+	}
+	return l.waitForState(ctx, vmName, target)
+}
+
+// whileStopped applies t, which stops or deallocates a VM, runs fn and
+// then starts the VM again if it was running before. A failure to start it
+// is returned unless fn failed first.
+func (l vmLifecycle) whileStopped(ctx context.Context, vmName string, t Transition, fn func() error) (err error) {
+	state, err := l.getState(ctx, vmName)
+	if err != nil {
+		return err
+	}
+	if _, err = l.transition(ctx, vmName, t); err != nil {
+		return err
+	}
+	if state.PowerState == PowerStateRunning || state.PowerState == PowerStateStarting {
+		defer func() {
+			if _, startErr := l.transition(ctx, vmName, TransitionStart); startErr != nil && err == nil {
+				err = startErr
+			}
+		}()
+	}
+	return fn()
+}
+
+// WaitForState polls a VM's instance view, with DefaultBackoff, until it
+// reaches the target power state. It fails if provisioning of the VM fails
+// or ctx is done first.
+func WaitForState(ctx context.Context, vmName string, target PowerState) (VMState, error) {
+	return defaultLifecycle().waitForState(ctx, vmName, target)
+}
+
+// TransitionVM applies a power state transition to a VM and waits for the
+// resulting state. Transitions are idempotent: starting a running VM or
+// stopping a stopped one only waits for the state.
+func TransitionVM(ctx context.Context, vmName string, t Transition) (VMState, error) {
+	return defaultLifecycle().transition(ctx, vmName, t)
+}
+
+// FleetResult is the outcome of a transition on one VM of a fleet.
+type FleetResult struct {
+	VMName string
+	State  VMState
+	Err    error
+}
+
+func (l vmLifecycle) transitionFleet(ctx context.Context, vmNames []string, t Transition, concurrency int) []FleetResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	results := make([]FleetResult, len(vmNames))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, name := range vmNames {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			state, err := l.transition(ctx, name, t)
+			results[i] = FleetResult{VMName: name, State: state, Err: err}
+		}(i, name)
+	}
+	wg.Wait()
+	return results
+}
+
+// ListVMsByTag lists the names of the VMs in the default resource group
+// with the given tag value, sorted by name.
+func ListVMsByTag(ctx context.Context, tagKey, tagValue string) ([]string, error) {
+	vmClient := getVMClient()
+	list, err := vmClient.ListComplete(ctx, config.GroupName())
+	if err != nil {
+		return nil, fmt.Errorf("cannot list vms: %v", err)
+	}
+	var names []string
+	for list.NotDone() {
+		vm := list.Value()
+		if v, ok := vm.Tags[tagKey]; ok && v != nil && *v == tagValue {
+			names = append(names, *vm.Name)
+		}
+		if err := list.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("cannot list vms: %v", err)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// TransitionVMsByTag applies a transition to every VM in the default
+// resource group with the given tag value, at most concurrency at a time.
+// It returns a result per VM; one VM failing does not stop the others.
+func TransitionVMsByTag(ctx context.Context, tagKey, tagValue string, t Transition, concurrency int) ([]FleetResult, error) {
+	names, err := ListVMsByTag(ctx, tagKey, tagValue)
+	if err != nil {
+		return nil, err
+	}
+	return defaultLifecycle().transitionFleet(ctx, names, t, concurrency), nil
+}
@@ -0,0 +1,236 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestParseVMState(t *testing.T) {
+	view := compute.VirtualMachineInstanceView{
+		Statuses: &[]compute.InstanceViewStatus{
+			{Code: to.StringPtr("ProvisioningState/succeeded")},
+			{Code: to.StringPtr("PowerState/deallocated")},
+		},
+	}
+	want := VMState{PowerState: PowerStateDeallocated, ProvisioningState: ProvisioningStateSucceeded}
+	if got := parseVMState(view); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	view.Statuses = &[]compute.InstanceViewStatus{{Code: to.StringPtr("ProvisioningState/failed/InternalError")}}
+	want = VMState{PowerState: PowerStateUnknown, ProvisioningState: ProvisioningStateFailed}
+	if got := parseVMState(view); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPlanTransition(t *testing.T) {
+	cases := []struct {
+		current PowerState
+		t       Transition
+		op      Transition
+		target  PowerState
+	}{
+		{PowerStateStopped, TransitionStart, TransitionStart, PowerStateRunning},
+		{PowerStateRunning, TransitionStart, "", PowerStateRunning},
+		{PowerStateStarting, TransitionStart, "", PowerStateRunning},
+		{PowerStateRunning, TransitionStop, TransitionStop, PowerStateStopped},
+		{PowerStateStopped, TransitionStop, "", PowerStateStopped},
+		{PowerStateDeallocated, TransitionStop, "", PowerStateDeallocated},
+		{PowerStateStopped, TransitionDeallocate, TransitionDeallocate, PowerStateDeallocated},
+		{PowerStateDeallocating, TransitionDeallocate, "", PowerStateDeallocated},
+		{PowerStateRunning, TransitionRestart, TransitionRestart, PowerStateRunning},
+		{PowerStateDeallocated, TransitionRestart, TransitionStart, PowerStateRunning},
+	}
+	for _, c := range cases {
+		op, target, err := planTransition(c.current, c.t)
+		if err != nil || op != c.op || target != c.target {
+			t.Errorf("%s from %s: got %q to %s (%v), want %q to %s", c.t, c.current, op, target, err, c.op, c.target)
+		}
+	}
+	if _, _, err := planTransition(PowerStateRunning, "hibernate"); err == nil {
+		t.Errorf("expected an unknown transition to be rejected")
+	}
+}
+
+// fakeVMs is an in-memory fleet whose VMs reach the state of the last
+// operation after a number of reads.
+type fakeVMs struct {
+	mu       sync.Mutex
+	states   map[string]VMState
+	pending  map[string]PowerState
+	delay    int
+	applied  []string
+	inFlight int
+	maxSeen  int
+	// fail makes every operation on a VM fail, or only one operation when
+	// keyed by "vm:op".
+	fail map[string]bool
+}
+
+func newFakeVMs(delay int, states map[string]PowerState) *fakeVMs {
+	f := &fakeVMs{
+		states:  map[string]VMState{},
+		pending: map[string]PowerState{},
+		delay:   delay,
+		fail:    map[string]bool{},
+	}
+	for name, s := range states {
+		f.states[name] = VMState{PowerState: s, ProvisioningState: ProvisioningStateSucceeded}
+	}
+	return f
+}
+
+func (f *fakeVMs) lifecycle() vmLifecycle {
+	return vmLifecycle{
+		getState: f.getState,
+		apply:    f.apply,
+		backoff:  Backoff{Initial: time.Millisecond, Max: 4 * time.Millisecond},
+	}
+}
+
+func (f *fakeVMs) getState(ctx context.Context, vmName string) (VMState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, ok := f.states[vmName]
+	if !ok {
+		return VMState{}, fmt.Errorf("vm %s not found", vmName)
+	}
+	if target, ok := f.pending[vmName]; ok {
+		f.delay--
+		if f.delay < 0 {
+			state.PowerState = target
+			f.states[vmName] = state
+			delete(f.pending, vmName)
+		}
+	}
+	return state, nil
+}
+
+func (f *fakeVMs) apply(ctx context.Context, vmName string, op Transition) error {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxSeen {
+		f.maxSeen = f.inFlight
+	}
+	f.applied = append(f.applied, fmt.Sprintf("%s:%s", vmName, op))
+	f.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight--
+	if f.fail[vmName] || f.fail[fmt.Sprintf("%s:%s", vmName, op)] {
+		return fmt.Errorf("operation not allowed")
+	}
+	target := map[Transition]PowerState{
+		TransitionStart:      PowerStateRunning,
+		TransitionRestart:    PowerStateRunning,
+		TransitionStop:       PowerStateStopped,
+		TransitionDeallocate: PowerStateDeallocated,
+	}[op]
+	f.pending[vmName] = target
+	return nil
+}
+
+func TestTransition(t *testing.T) {
+	vms := newFakeVMs(2, map[string]PowerState{"vm1": PowerStateDeallocated})
+	l := vms.lifecycle()
+
+	state, err := l.transition(context.Background(), "vm1", TransitionStart)
+	if err != nil || state.PowerState != PowerStateRunning {
+		t.Fatalf("start: got %+v, %v", state, err)
+	}
+	// already running: no operation, just the state
+	state, err = l.transition(context.Background(), "vm1", TransitionStart)
+	if err != nil || state.PowerState != PowerStateRunning {
+		t.Fatalf("second start: got %+v, %v", state, err)
+	}
+	if len(vms.applied) != 1 || vms.applied[0] != "vm1:start" {
+		t.Errorf("unexpected operations: %v", vms.applied)
+	}
+}
+
+func TestWaitForState(t *testing.T) {
+	vms := newFakeVMs(0, map[string]PowerState{"vm1": PowerStateStopped})
+	l := vms.lifecycle()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.waitForState(ctx, "vm1", PowerStateRunning); err == nil {
+		t.Errorf("expected waiting for a state that never comes to time out")
+	}
+
+	vms.states["vm1"] = VMState{PowerState: PowerStateStarting, ProvisioningState: ProvisioningStateFailed}
+	if _, err := l.waitForState(context.Background(), "vm1", PowerStateRunning); err == nil {
+		t.Errorf("expected a failed provisioning state to stop the wait")
+	}
+}
+
+func TestTransitionFleet(t *testing.T) {
+	names := []string{"vm1", "vm2", "vm3", "vm4", "vm5", "vm6"}
+	states := map[string]PowerState{}
+	for _, name := range names {
+		states[name] = PowerStateRunning
+	}
+	states["vm4"] = PowerStateDeallocated
+	vms := newFakeVMs(0, states)
+	vms.fail["vm2"] = true
+
+	results := vms.lifecycle().transitionFleet(context.Background(), names, TransitionDeallocate, 2)
+	if vms.maxSeen > 2 {
+		t.Errorf("%d operations ran at once, want at most 2", vms.maxSeen)
+	}
+	if len(vms.applied) != 5 {
+		t.Errorf("expected 5 operations, got %v", vms.applied)
+	}
+	for i, r := range results {
+		if r.VMName != names[i] {
+			t.Errorf("result %d is for %s, want %s", i, r.VMName, names[i])
+		}
+		if r.VMName == "vm2" {
+			if r.Err == nil {
+				t.Errorf("expected vm2 to fail")
+			}
+			continue
+		}
+		if r.Err != nil || r.State.PowerState != PowerStateDeallocated {
+			t.Errorf("%s: got %+v, %v", r.VMName, r.State, r.Err)
+		}
+	}
+}
+
+func TestWhileStopped(t *testing.T) {
+	vms := newFakeVMs(0, map[string]PowerState{"vm1": PowerStateRunning, "vm2": PowerStateDeallocated})
+	l := vms.lifecycle()
+	ran := false
+	err := l.whileStopped(context.Background(), "vm1", TransitionDeallocate, func() error {
+		ran = vms.states["vm1"].PowerState == PowerStateDeallocated
+		return nil
+	})
+	if err != nil || !ran || vms.states["vm1"].PowerState != PowerStateRunning {
+		t.Errorf("expected fn to run deallocated and the vm to run again: %v, %+v", err, vms.states["vm1"])
+	}
+	if err := l.whileStopped(context.Background(), "vm2", TransitionDeallocate, func() error { return nil }); err != nil || vms.states["vm2"].PowerState != PowerStateDeallocated {
+		t.Errorf("expected a deallocated vm to stay deallocated: %v, %+v", err, vms.states["vm2"])
+	}
+
+	vms.fail["vm1:start"] = true
+	if err := l.whileStopped(context.Background(), "vm1", TransitionDeallocate, func() error { return nil }); err == nil {
+		t.Errorf("expected the failure to start the vm again to be returned")
+	}
+	fnErr := fmt.Errorf("resize failed")
+	if err := l.whileStopped(context.Background(), "vm1", TransitionDeallocate, func() error { return fnErr }); err != fnErr {
+		t.Errorf("expected the error of fn to win, got %v", err)
+	}
+}
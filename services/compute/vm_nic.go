@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/network"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func vmNICs(vm compute.VirtualMachine) []compute.NetworkInterfaceReference {
+	if vm.VirtualMachineProperties == nil || vm.NetworkProfile == nil || vm.NetworkProfile.NetworkInterfaces == nil {
+		return nil
+	}
+	return *vm.NetworkProfile.NetworkInterfaces
+}
+
+// markPrimaryNIC marks one NIC of a VM with several as primary, the first
+// one unless another already is, as Azure requires.
+func markPrimaryNIC(nics []compute.NetworkInterfaceReference) {
+	if len(nics) < 2 {
+		return
+	}
+	primary := 0
+	for i, n := range nics {
+		if n.NetworkInterfaceReferenceProperties != nil && to.Bool(n.Primary) {
+			primary = i
+			break
+		}
+	}
+	for i := range nics {
+		nics[i].NetworkInterfaceReferenceProperties = &compute.NetworkInterfaceReferenceProperties{Primary: to.BoolPtr(i == primary)}
+	}
+}
+
+// attachNIC adds a network interface to a VM.
+func attachNIC(vm *compute.VirtualMachine, nicID string) error {
+	if vm.VirtualMachineProperties == nil || vm.NetworkProfile == nil {
+		return fmt.Errorf("vm has no network profile")
+	}
+	nics := vmNICs(*vm)
+	for _, n := range nics {
+		if strings.EqualFold(to.String(n.ID), nicID) {
+			return fmt.Errorf("nic %s is already attached", nicID)
+		}
+	}
+	nics = append(nics, compute.NetworkInterfaceReference{ID: to.StringPtr(nicID)})
+	markPrimaryNIC(nics)
+	vm.NetworkProfile.NetworkInterfaces = &nics
+	return nil
+}
+
+// detachNIC removes a network interface from a VM. A VM keeps at least one
+// NIC; when the primary one is detached the first remaining one becomes
+// primary.
+func detachNIC(vm *compute.VirtualMachine, nicID string) error {
+	var kept []compute.NetworkInterfaceReference
+	for _, n := range vmNICs(*vm) {
+		if strings.EqualFold(to.String(n.ID), nicID) {
+			continue
+		}
+		kept = append(kept, n)
+	}
+	switch {
+	case len(kept) == len(vmNICs(*vm)):
+		return fmt.Errorf("nic %s is not attached", nicID)
+	case len(kept) == 0:
+		return fmt.Errorf("cannot detach the only nic of a vm")
+	}
+	markPrimaryNIC(kept)
+	if len(kept) == 1 {
+		kept[0].NetworkInterfaceReferenceProperties = &compute.NetworkInterfaceReferenceProperties{Primary: to.BoolPtr(true)}
+	}
+	vm.NetworkProfile.NetworkInterfaces = &kept
+	return nil
+}
+
+// changeNICs changes the NICs of a deallocated VM, which Azure requires.
+func changeNICs(ctx context.Context, vmName string, change func(*compute.VirtualMachine) error) (vm compute.VirtualMachine, err error) {
+	state, err := GetVMState(ctx, vmName)
+	if err != nil {
+		return vm, err
+	}
+	if state.PowerState != PowerStateDeallocated {
+		return vm, fmt.Errorf("vm %s must be deallocated to change its nics, it is %s", vmName, state.PowerState)
+	}
+	vm, err = GetVM(ctx, vmName)
+	if err != nil {
+		return vm, fmt.Errorf("cannot get vm: %v", err)
+	}
+	if err := change(&vm); err != nil {
+		return vm, fmt.Errorf("cannot change nics of vm %s: %v", vmName, err)
+	}
+	return putVM(ctx, vmName, vm)
+}
+
+// AttachNIC attaches an existing network interface to a deallocated VM as
+// a secondary NIC. The VM size limits how many NICs it can have.
+func AttachNIC(ctx context.Context, vmName, nicName string) (compute.VirtualMachine, error) {
+	nic, err := network.GetNic(ctx, nicName)
+	if err != nil {
+		return compute.VirtualMachine{}, fmt.Errorf("cannot get nic: %v", err)
+	}
+	return changeNICs(ctx, vmName, func(vm *compute.VirtualMachine) error {
+		return attachNIC(vm, to.String(nic.ID))
+	})
+}
+
+// DetachNIC detaches a network interface from a deallocated VM. The NIC
+// is not deleted.
+func DetachNIC(ctx context.Context, vmName, nicName string) (compute.VirtualMachine, error) {
+	nic, err := network.GetNic(ctx, nicName)
+	if err != nil {
+		return compute.VirtualMachine{}, fmt.Errorf("cannot get nic: %v", err)
+	}
+	return changeNICs(ctx, vmName, func(vm *compute.VirtualMachine) error {
+		return detachNIC(vm, to.String(nic.ID))
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func primaryNICs(vm compute.VirtualMachine) []string {
+	var primary []string
+	for _, n := range vmNICs(vm) {
+		if n.NetworkInterfaceReferenceProperties != nil && to.Bool(n.Primary) {
+			primary = append(primary, to.String(n.ID))
+		}
+	}
+	return primary
+}
+
+func TestAttachDetachNIC(t *testing.T) {
+	vm := compute.VirtualMachine{VirtualMachineProperties: &compute.VirtualMachineProperties{
+		NetworkProfile: &compute.NetworkProfile{NetworkInterfaces: &[]compute.NetworkInterfaceReference{{ID: to.StringPtr("/nics/a")}}},
+	}}
+
+	if err := attachNIC(&vm, "/nics/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := attachNIC(&vm, "/nics/c"); err != nil {
+		t.Fatal(err)
+	}
+	if p := primaryNICs(vm); len(vmNICs(vm)) != 3 || len(p) != 1 || p[0] != "/nics/a" {
+		t.Errorf("got primary nics %v of %d, want /nics/a", p, len(vmNICs(vm)))
+	}
+	if err := attachNIC(&vm, "/NICS/B"); err == nil {
+		t.Errorf("expected attaching the same nic twice to fail")
+	}
+
+	if err := detachNIC(&vm, "/nics/a"); err != nil {
+		t.Fatal(err)
+	}
+	if p := primaryNICs(vm); len(p) != 1 || p[0] != "/nics/b" {
+		t.Errorf("got primary nics %v after detaching the primary, want /nics/b", p)
+	}
+	if err := detachNIC(&vm, "/nics/a"); err == nil {
+		t.Errorf("expected detaching a detached nic to fail")
+	}
+	if err := detachNIC(&vm, "/nics/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := detachNIC(&vm, "/nics/c"); err == nil {
+		t.Errorf("expected detaching the only nic to fail")
+	}
+	if p := primaryNICs(vm); len(p) != 1 || p[0] != "/nics/c" {
+		t.Errorf("got primary nics %v, want /nics/c", p)
+	}
+}
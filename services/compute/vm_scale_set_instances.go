@@ -0,0 +1,183 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func GetVMSSVMsClient() compute.VirtualMachineScaleSetVMsClient {
+	vmssVMsClient := compute.NewVirtualMachineScaleSetVMsClient(config.SubscriptionID())
+	a, _ := iam.GetResourceManagementAuthorizer()
+	vmssVMsClient.Authorizer = a
+	_ = vmssVMsClient.AddToUserAgent(config.UserAgent())
+	return vmssVMsClient
+}
+
+// VMSSInstance is a VM of a scale set.
+type VMSSInstance struct {
+	InstanceID string
+	Name       string
+	// LatestModel is set when the instance runs the scale set's current
+	// model.
+	LatestModel bool
+	State       VMState
+}
+
+func vmssInstance(vm compute.VirtualMachineScaleSetVM) VMSSInstance {
+	instance := VMSSInstance{
+		InstanceID: to.String(vm.InstanceID),
+		Name:       to.String(vm.Name),
+		State:      parseStatuses(nil),
+	}
+	if vm.VirtualMachineScaleSetVMProperties != nil {
+		instance.LatestModel = to.Bool(vm.LatestModelApplied)
+		if vm.InstanceView != nil {
+			instance.State = parseStatuses(vm.InstanceView.Statuses)
+		}
+	}
+	return instance
+}
+
+// sortInstances sorts instances by their numeric instance ID.
+func sortInstances(instances []VMSSInstance) {
+	sort.Slice(instances, func(i, j int) bool {
+		a, errA := strconv.Atoi(instances[i].InstanceID)
+		b, errB := strconv.Atoi(instances[j].InstanceID)
+		if errA != nil || errB != nil {
+			return instances[i].InstanceID < instances[j].InstanceID
+		}
+		return a < b
+	})
+}
+
+// ListVMSSInstances lists the instances of a scale set with their state,
+// ordered by instance ID.
+func ListVMSSInstances(ctx context.Context, vmssName string) ([]VMSSInstance, error) {
+	vmssVMsClient := GetVMSSVMsClient()
+	list, err := vmssVMsClient.ListComplete(ctx, config.GroupName(), vmssName, "", "", "instanceView")
+	if err != nil {
+		return nil, fmt.Errorf("cannot list vmss instances: %v", err)
+	}
+	var instances []VMSSInstance
+	for list.NotDone() {
+		instances = append(instances, vmssInstance(list.Value()))
+		if err := list.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("cannot list vmss instances: %v", err)
+		}
+	}
+	sortInstances(instances)
+	return instances, nil
+}
+
+// GetVMSSInstance gets the state of one instance of a scale set.
+func GetVMSSInstance(ctx context.Context, vmssName, instanceID string) (VMSSInstance, error) {
+	vmssVMsClient := GetVMSSVMsClient()
+	vm, err := vmssVMsClient.Get(ctx, config.GroupName(), vmssName, instanceID, compute.InstanceView)
+	if err != nil {
+		return VMSSInstance{}, fmt.Errorf("cannot get vmss instance: %v", err)
+	}
+	return vmssInstance(vm), nil
+}
+
+// ScaleVMSS sets the number of instances of a scale set. Scaling in
+// removes the instances the scale-in policy picks.
+func ScaleVMSS(ctx context.Context, vmssName string, capacity int64) (vmss compute.VirtualMachineScaleSet, err error) {
+	if capacity < 0 {
+		return vmss, fmt.Errorf("capacity %d is negative", capacity)
+	}
+	vmss, err = GetVMSS(ctx, vmssName)
+	if err != nil {
+		return vmss, fmt.Errorf("cannot get vmss: %v", err)
+	}
+	if vmss.Sku == nil {
+		return vmss, fmt.Errorf("vmss %s has no sku", vmssName)
+	}
+	sku := *vmss.Sku
+	sku.Capacity = to.Int64Ptr(capacity)
+
+	vmssClient := GetVMSSClient()
+	future, err := vmssClient.Update(ctx, config.GroupName(), vmssName, compute.VirtualMachineScaleSetUpdate{Sku: &sku})
+	if err != nil {
+		return vmss, fmt.Errorf("cannot scale vmss: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, vmssClient.Client)
+	if err != nil {
+		return vmss, fmt.Errorf("cannot get the vmss update future response: %v", err)
+	}
+
+	return future.Result(vmssClient)
+}
+
+// ReimageVMSSInstances reimages the OS disks of the given instances of a
+// scale set.
+func ReimageVMSSInstances(ctx context.Context, vmssName string, instanceIDs []string) error {
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+	vmssClient := GetVMSSClient()
+	future, err := vmssClient.Reimage(ctx, config.GroupName(), vmssName, &compute.VirtualMachineScaleSetReimageParameters{
+		InstanceIds: &instanceIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot reimage vmss instances: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, vmssClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the vmss reimage future response: %v", err)
+	}
+	return nil
+}
+
+// DeleteVMSSInstances deletes the given instances of a scale set, reducing
+// its capacity.
+func DeleteVMSSInstances(ctx context.Context, vmssName string, instanceIDs []string) error {
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+	vmssClient := GetVMSSClient()
+	future, err := vmssClient.DeleteInstances(ctx, config.GroupName(), vmssName, compute.VirtualMachineScaleSetVMInstanceRequiredIDs{
+		InstanceIds: &instanceIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot delete vmss instances: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, vmssClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the vmss delete instances future response: %v", err)
+	}
+	return nil
+}
+
+// UpdateVMSSInstances brings the given instances of a scale set up to its
+// latest model.
+func UpdateVMSSInstances(ctx context.Context, vmssName string, instanceIDs []string) error {
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+	vmssClient := GetVMSSClient()
+	future, err := vmssClient.UpdateInstances(ctx, config.GroupName(), vmssName, compute.VirtualMachineScaleSetVMInstanceRequiredIDs{
+		InstanceIds: &instanceIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot update vmss instances: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, vmssClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the vmss update instances future response: %v", err)
+	}
+	return nil
+}
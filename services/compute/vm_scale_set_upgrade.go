@@ -0,0 +1,258 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+)
+
+// RollingUpgradeOptions control how RollingUpgradeVMSS rolls a model change
+// out to the instances of a scale set.
+type RollingUpgradeOptions struct {
+	// BatchSize is how many instances are upgraded at a time; at least 1.
+	BatchSize int
+	// MaxUnhealthyPercent is the share of the scale set's instances that
+	// may fail their health check before the upgrade is stopped and rolled
+	// back. With 0, any failure stops it.
+	MaxUnhealthyPercent int
+	// PauseBetweenBatches is how long to wait after a healthy batch before
+	// starting the next one.
+	PauseBetweenBatches time.Duration
+	// HealthCheck checks an instance after it was upgraded, for example
+	// by probing the application on it. By default an instance is healthy
+	// if it is running and provisioned.
+	HealthCheck func(ctx context.Context, vmssName string, instance VMSSInstance) error
+	// Rollback is called when the upgrade is stopped. By default the
+	// previous model is restored and applied to the upgraded instances.
+	Rollback func(ctx context.Context, rollback UpgradeRollback) error
+	// RollbackTimeout bounds the rollback when the upgrade is stopped
+	// because its context was cancelled or timed out, in which case the
+	// rollback runs with a context of its own. Defaults to 30 minutes.
+	RollbackTimeout time.Duration
+}
+
+const defaultRollbackTimeout = 30 * time.Minute
+
+// UpgradeRollback describes a stopped rolling upgrade to roll back.
+type UpgradeRollback struct {
+	VMSSName      string
+	PreviousModel *compute.VirtualMachineScaleSetVMProfile
+	// Upgraded are the IDs of the instances already on the new model.
+	Upgraded []string
+	Cause    error
+}
+
+// RollingUpgradeResult reports the progress of a rolling upgrade.
+type RollingUpgradeResult struct {
+	Batches    int
+	Upgraded   []string
+	Unhealthy  []string
+	RolledBack bool
+}
+
+// vmssUpgrader runs rolling upgrades with replaceable operations, so that
+// the orchestration can be exercised without Azure.
+type vmssUpgrader struct {
+	getModel        func(ctx context.Context, vmssName string) (compute.VirtualMachineScaleSet, error)
+	putModel        func(ctx context.Context, vmssName string, vmss compute.VirtualMachineScaleSet) error
+	listInstances   func(ctx context.Context, vmssName string) ([]VMSSInstance, error)
+	getInstance     func(ctx context.Context, vmssName, instanceID string) (VMSSInstance, error)
+	updateInstances func(ctx context.Context, vmssName string, instanceIDs []string) error
+}
+
+func defaultVMSSUpgrader() vmssUpgrader {
+	return vmssUpgrader{
+		getModel: GetVMSS,
+		putModel: func(ctx context.Context, vmssName string, vmss compute.VirtualMachineScaleSet) error {
+			vmssClient := GetVMSSClient()
+			future, err := vmssClient.CreateOrUpdate(ctx, config.GroupName(), vmssName, vmss)
+			if err != nil {
+				return fmt.Errorf("cannot update vmss: %v", err)
+			}
+			err = future.WaitForCompletionRef(ctx, vmssClient.Client)
+			if err != nil {
+				return fmt.Errorf("cannot get the vmss create or update future response: %v", err)
+			}
+			return nil
+		},
+		listInstances:   ListVMSSInstances,
+		getInstance:     GetVMSSInstance,
+		updateInstances: UpdateVMSSInstances,
+	}
+}
+
+// upgradeBatches splits instance IDs into batches of size.
+func upgradeBatches(instanceIDs []string, size int) [][]string {
+	if size < 1 {
+		size = 1
+	}
+	var batches [][]string
+	for len(instanceIDs) > 0 {
+		n := size
+		if n > len(instanceIDs) {
+			n = len(instanceIDs)
+		}
+		batches = append(batches, instanceIDs[:n])
+		instanceIDs = instanceIDs[n:]
+	}
+	return batches
+}
+
+// copyVMProfile returns a deep copy of a scale set's VM profile, to restore
+// on rollback.
+func copyVMProfile(profile *compute.VirtualMachineScaleSetVMProfile) (*compute.VirtualMachineScaleSetVMProfile, error) {
+	if profile == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+	var copied compute.VirtualMachineScaleSetVMProfile
+	if err := json.Unmarshal(b, &copied); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+func instanceHealthy(ctx context.Context, vmssName string, instance VMSSInstance) error {
+	if instance.State.ProvisioningState == ProvisioningStateFailed {
+		return fmt.Errorf("instance %s failed to provision", instance.InstanceID)
+	}
+	if instance.State.PowerState != PowerStateRunning {
+		return fmt.Errorf("instance %s is %s", instance.InstanceID, instance.State.PowerState)
+	}
+	return nil
+}
+
+func (u vmssUpgrader) restoreModel(ctx context.Context, rollback UpgradeRollback) error {
+	vmss, err := u.getModel(ctx, rollback.VMSSName)
+	if err != nil {
+		return err
+	}
+	if vmss.VirtualMachineScaleSetProperties == nil {
+		return fmt.Errorf("vmss %s has no properties", rollback.VMSSName)
+	}
+	vmss.VirtualMachineProfile = rollback.PreviousModel
+	if err := u.putModel(ctx, rollback.VMSSName, vmss); err != nil {
+		return err
+	}
+	return u.updateInstances(ctx, rollback.VMSSName, rollback.Upgraded)
+}
+
+func (u vmssUpgrader) upgrade(ctx context.Context, vmssName string, change func(*compute.VirtualMachineScaleSet) error, opts RollingUpgradeOptions) (result RollingUpgradeResult, err error) {
+	if opts.HealthCheck == nil {
+		opts.HealthCheck = instanceHealthy
+	}
+	if opts.Rollback == nil {
+		opts.Rollback = u.restoreModel
+	}
+	if opts.RollbackTimeout <= 0 {
+		opts.RollbackTimeout = defaultRollbackTimeout
+	}
+
+	vmss, err := u.getModel(ctx, vmssName)
+	if err != nil {
+		return result, err
+	}
+	if vmss.VirtualMachineScaleSetProperties == nil {
+		return result, fmt.Errorf("vmss %s has no properties", vmssName)
+	}
+	if policy := vmss.UpgradePolicy; policy != nil && policy.Mode != "" && policy.Mode != compute.Manual {
+		return result, fmt.Errorf("vmss %s has upgrade policy %s; rolling upgrades need %s", vmssName, policy.Mode, compute.Manual)
+	}
+	previous, err := copyVMProfile(vmss.VirtualMachineProfile)
+	if err != nil {
+		return result, fmt.Errorf("cannot copy vmss model: %v", err)
+	}
+	if err := change(&vmss); err != nil {
+		return result, err
+	}
+	if err := u.putModel(ctx, vmssName, vmss); err != nil {
+		return result, err
+	}
+
+	instances, err := u.listInstances(ctx, vmssName)
+	if err != nil {
+		return result, err
+	}
+	var stale []string
+	for _, instance := range instances {
+		if !instance.LatestModel {
+			stale = append(stale, instance.InstanceID)
+		}
+	}
+
+	stop := func(cause error) (RollingUpgradeResult, error) {
+		// a cancelled upgrade must still be rolled back
+		rollbackCtx := ctx
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			rollbackCtx, cancel = context.WithTimeout(context.Background(), opts.RollbackTimeout)
+			defer cancel()
+		}
+		rollbackErr := opts.Rollback(rollbackCtx, UpgradeRollback{
+			VMSSName:      vmssName,
+			PreviousModel: previous,
+			Upgraded:      result.Upgraded,
+			Cause:         cause,
+		})
+		if rollbackErr != nil {
+			return result, fmt.Errorf("rolling upgrade of vmss %s stopped: %v; rollback failed: %v", vmssName, cause, rollbackErr)
+		}
+		result.RolledBack = true
+		return result, fmt.Errorf("rolling upgrade of vmss %s rolled back: %v", vmssName, cause)
+	}
+
+	for i, batch := range upgradeBatches(stale, opts.BatchSize) {
+		if err := ctx.Err(); err != nil {
+			return stop(err)
+		}
+		if i > 0 && opts.PauseBetweenBatches > 0 {
+			select {
+			case <-ctx.Done():
+				return stop(ctx.Err())
+			case <-time.After(opts.PauseBetweenBatches):
+			}
+		}
+		result.Batches++
+		if err := u.updateInstances(ctx, vmssName, batch); err != nil {
+			// some of the batch may be upgraded; roll all of it back
+			result.Upgraded = append(result.Upgraded, batch...)
+			return stop(err)
+		}
+		result.Upgraded = append(result.Upgraded, batch...)
+
+		var unhealthy error
+		for _, id := range batch {
+			instance, err := u.getInstance(ctx, vmssName, id)
+			if err == nil {
+				err = opts.HealthCheck(ctx, vmssName, instance)
+			}
+			if err != nil {
+				result.Unhealthy = append(result.Unhealthy, id)
+				unhealthy = err
+			}
+		}
+		if len(result.Unhealthy)*100 > opts.MaxUnhealthyPercent*len(instances) {
+			return stop(fmt.Errorf("%d of %d instances are unhealthy: %v", len(result.Unhealthy), len(instances), unhealthy))
+		}
+	}
+	return result, nil
+}
+
+// RollingUpgradeVMSS changes the model of a scale set with change and rolls
+// the new model out to its instances in batches, checking the health of
+// each batch. When more instances are unhealthy than opts allows, the
+// upgrade stops and opts.Rollback is called. The scale set must use the
+// Manual upgrade policy, so that only the orchestrator upgrades instances.
+func RollingUpgradeVMSS(ctx context.Context, vmssName string, change func(*compute.VirtualMachineScaleSet) error, opts RollingUpgradeOptions) (RollingUpgradeResult, error) {
+	return defaultVMSSUpgrader().upgrade(ctx, vmssName, change, opts)
+}
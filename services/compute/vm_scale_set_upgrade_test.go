@@ -0,0 +1,230 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestUpgradeBatches(t *testing.T) {
+	ids := []string{"0", "1", "2", "3", "4"}
+	want := [][]string{{"0", "1"}, {"2", "3"}, {"4"}}
+	if got := upgradeBatches(ids, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := upgradeBatches(ids, 0); len(got) != 5 {
+		t.Errorf("batch size 0 should upgrade one at a time, got %v", got)
+	}
+}
+
+func TestSortInstances(t *testing.T) {
+	instances := []VMSSInstance{{InstanceID: "10"}, {InstanceID: "2"}, {InstanceID: "1"}}
+	sortInstances(instances)
+	if instances[0].InstanceID != "1" || instances[2].InstanceID != "10" {
+		t.Errorf("instances not sorted numerically: %+v", instances)
+	}
+}
+
+// fakeVMSS is an in-memory scale set whose instances report the image
+// version of the model they were last updated to.
+type fakeVMSS struct {
+	model     compute.VirtualMachineScaleSet
+	instances map[string]string
+	order     []string
+	updates   [][]string
+	badImage  string
+}
+
+func newFakeVMSS(n int) *fakeVMSS {
+	f := &fakeVMSS{
+		model: compute.VirtualMachineScaleSet{
+			VirtualMachineScaleSetProperties: &compute.VirtualMachineScaleSetProperties{
+				UpgradePolicy: &compute.UpgradePolicy{Mode: compute.Manual},
+				VirtualMachineProfile: &compute.VirtualMachineScaleSetVMProfile{
+					StorageProfile: &compute.VirtualMachineScaleSetStorageProfile{
+						ImageReference: &compute.ImageReference{Version: to.StringPtr("1.0")},
+					},
+				},
+			},
+		},
+		instances: map[string]string{},
+	}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprint(i)
+		f.instances[id] = "1.0"
+		f.order = append(f.order, id)
+	}
+	return f
+}
+
+func (f *fakeVMSS) version() string {
+	return *f.model.VirtualMachineProfile.StorageProfile.ImageReference.Version
+}
+
+func (f *fakeVMSS) upgrader() vmssUpgrader {
+	return vmssUpgrader{
+		getModel: func(ctx context.Context, vmssName string) (compute.VirtualMachineScaleSet, error) {
+			profile, _ := copyVMProfile(f.model.VirtualMachineProfile)
+			vmss := f.model
+			properties := *f.model.VirtualMachineScaleSetProperties
+			properties.VirtualMachineProfile = profile
+			vmss.VirtualMachineScaleSetProperties = &properties
+			return vmss, nil
+		},
+		putModel: func(ctx context.Context, vmssName string, vmss compute.VirtualMachineScaleSet) error {
+			f.model = vmss
+			return nil
+		},
+		listInstances: func(ctx context.Context, vmssName string) ([]VMSSInstance, error) {
+			var instances []VMSSInstance
+			for _, id := range f.order {
+				instances = append(instances, VMSSInstance{InstanceID: id, LatestModel: f.instances[id] == f.version()})
+			}
+			return instances, nil
+		},
+		getInstance: func(ctx context.Context, vmssName, instanceID string) (VMSSInstance, error) {
+			state := VMState{PowerState: PowerStateRunning, ProvisioningState: ProvisioningStateSucceeded}
+			if f.instances[instanceID] == f.badImage {
+				state.PowerState = PowerStateStopped
+			}
+			return VMSSInstance{InstanceID: instanceID, State: state}, nil
+		},
+		updateInstances: func(ctx context.Context, vmssName string, instanceIDs []string) error {
+			f.updates = append(f.updates, instanceIDs)
+			for _, id := range instanceIDs {
+				f.instances[id] = f.version()
+			}
+			return nil
+		},
+	}
+}
+
+func setImageVersion(version string) func(*compute.VirtualMachineScaleSet) error {
+	return func(vmss *compute.VirtualMachineScaleSet) error {
+		vmss.VirtualMachineProfile.StorageProfile.ImageReference.Version = to.StringPtr(version)
+		return nil
+	}
+}
+
+func TestRollingUpgrade(t *testing.T) {
+	vmss := newFakeVMSS(5)
+	result, err := vmss.upgrader().upgrade(context.Background(), "vmss", setImageVersion("2.0"), RollingUpgradeOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatalf("upgrade failed: %v", err)
+	}
+	if result.Batches != 3 || len(result.Upgraded) != 5 || result.RolledBack {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	for id, version := range vmss.instances {
+		if version != "2.0" {
+			t.Errorf("instance %s is on %s", id, version)
+		}
+	}
+}
+
+func TestRollingUpgradeRollback(t *testing.T) {
+	vmss := newFakeVMSS(10)
+	vmss.badImage = "2.0"
+
+	// one unhealthy instance of ten is within 10%, the second is not
+	result, err := vmss.upgrader().upgrade(context.Background(), "vmss", setImageVersion("2.0"), RollingUpgradeOptions{
+		BatchSize:           1,
+		MaxUnhealthyPercent: 10,
+	})
+	if err == nil {
+		t.Fatalf("expected the upgrade to be stopped")
+	}
+	if result.Batches != 2 || len(result.Unhealthy) != 2 || !result.RolledBack {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if vmss.version() != "1.0" {
+		t.Errorf("model not rolled back, image version %s", vmss.version())
+	}
+	for id, version := range vmss.instances {
+		if version != "1.0" {
+			t.Errorf("instance %s not rolled back, on %s", id, version)
+		}
+	}
+	if last := vmss.updates[len(vmss.updates)-1]; !reflect.DeepEqual(last, []string{"0", "1"}) {
+		t.Errorf("rollback updated %v, want the upgraded instances", last)
+	}
+}
+
+func TestRollingUpgradeCancelled(t *testing.T) {
+	vmss := newFakeVMSS(4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the fake fails calls on a done context, as the SDK does; the upgrade
+	// is cancelled once the first batch is upgraded
+	u := vmss.upgrader()
+	putModel, updateInstances := u.putModel, u.updateInstances
+	u.putModel = func(ctx context.Context, vmssName string, model compute.VirtualMachineScaleSet) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return putModel(ctx, vmssName, model)
+	}
+	var rollbackDeadline bool
+	u.updateInstances = func(callCtx context.Context, vmssName string, instanceIDs []string) error {
+		if err := callCtx.Err(); err != nil {
+			return err
+		}
+		if callCtx != ctx {
+			_, rollbackDeadline = callCtx.Deadline()
+		}
+		defer cancel()
+		return updateInstances(callCtx, vmssName, instanceIDs)
+	}
+
+	result, err := u.upgrade(ctx, "vmss", setImageVersion("2.0"), RollingUpgradeOptions{BatchSize: 2, RollbackTimeout: time.Minute})
+	if err == nil {
+		t.Fatalf("expected the upgrade to be stopped")
+	}
+	if result.Batches != 1 || !result.RolledBack || !rollbackDeadline {
+		t.Errorf("expected a bounded rollback after the first batch, got %+v, %v", result, err)
+	}
+	if vmss.version() != "1.0" {
+		t.Errorf("model not rolled back, image version %s", vmss.version())
+	}
+	for id, version := range vmss.instances {
+		if version != "1.0" {
+			t.Errorf("instance %s not rolled back, on %s", id, version)
+		}
+	}
+}
+
+func TestRollingUpgradeCustomRollback(t *testing.T) {
+	vmss := newFakeVMSS(3)
+	var rollback UpgradeRollback
+	_, err := vmss.upgrader().upgrade(context.Background(), "vmss", setImageVersion("2.0"), RollingUpgradeOptions{
+		BatchSize: 2,
+		HealthCheck: func(ctx context.Context, vmssName string, instance VMSSInstance) error {
+			return fmt.Errorf("probe failed")
+		},
+		Rollback: func(ctx context.Context, r UpgradeRollback) error {
+			rollback = r
+			return nil
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected the upgrade to be stopped")
+	}
+	if len(rollback.Upgraded) != 2 || rollback.Cause == nil || *rollback.PreviousModel.StorageProfile.ImageReference.Version != "1.0" {
+		t.Errorf("unexpected rollback: %+v", rollback)
+	}
+
+	vmss = newFakeVMSS(1)
+	vmss.model.UpgradePolicy.Mode = compute.Automatic
+	if _, err := vmss.upgrader().upgrade(context.Background(), "vmss", setImageVersion("2.0"), RollingUpgradeOptions{}); err == nil {
+		t.Errorf("expected a scale set with automatic upgrades to be refused")
+	}
+}
@@ -0,0 +1,499 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	// maxCustomDataLength is the most bytes of custom data, such as a
+	// cloud-init config, a VM accepts once base64 encoded.
+	maxCustomDataLength = 87380
+
+	// maxWindowsComputerNameLength is the longest NetBIOS name Windows
+	// accepts as a computer name.
+	maxWindowsComputerNameLength = 15
+)
+
+// VMImage is the image a VM is created from: either a marketplace image or
+// the resource ID of a shared gallery image version or a custom image.
+type VMImage struct {
+	Publisher string
+	Offer     string
+	Sku       string
+	Version   string
+	ID        string
+}
+
+// MarketplaceImage returns a marketplace image. An empty version uses the
+// latest version.
+func MarketplaceImage(publisher, offer, sku, version string) VMImage {
+	if version == "" {
+		version = "latest"
+	}
+	return VMImage{Publisher: publisher, Offer: offer, Sku: sku, Version: version}
+}
+
+// GalleryImage returns the image of a shared image gallery with the given
+// image version ID, or image definition ID for its latest version.
+func GalleryImage(imageID string) VMImage {
+	return VMImage{ID: imageID}
+}
+
+// CustomImage returns a managed custom image with the given resource ID.
+func CustomImage(imageID string) VMImage {
+	return VMImage{ID: imageID}
+}
+
+func (i VMImage) reference() *compute.ImageReference {
+	if i.ID != "" {
+		return &compute.ImageReference{ID: to.StringPtr(i.ID)}
+	}
+	return &compute.ImageReference{
+		Publisher: to.StringPtr(i.Publisher),
+		Offer:     to.StringPtr(i.Offer),
+		Sku:       to.StringPtr(i.Sku),
+		Version:   to.StringPtr(i.Version),
+	}
+}
+
+// DataDiskSpec describes a data disk of a VM: either a new empty disk of
+// SizeGB, or an existing managed disk with the resource ID DiskID.
+type DataDiskSpec struct {
+	Lun                int32
+	SizeGB             int32
+	DiskID             string
+	Caching            compute.CachingTypes
+	StorageAccountType compute.StorageAccountTypes
+}
+
+// VMSpec describes a virtual machine to create with CreateVMFromSpec. Start
+// from NewVMSpec, which fills in the defaults used by the other samples, and
+// change it with the With methods.
+type VMSpec struct {
+	Name         string
+	Location     string
+	Size         compute.VirtualMachineSizeTypes
+	Image        VMImage
+	OSType       compute.OperatingSystemTypes
+	OSDiskSizeGB int32
+	// OSDiskID is an existing managed OS disk to boot from instead of
+	// Image, such as a disk restored from a snapshot. The VM then keeps
+	// the disk's users and settings, so no credentials are needed.
+	OSDiskID string
+
+	AdminUsername string
+	AdminPassword string
+	SSHPublicKeys []string
+
+	DataDisks []DataDiskSpec
+
+	SystemAssignedIdentity  bool
+	UserAssignedIdentityIDs []string
+
+	AvailabilitySetID         string
+	Zone                      string
+	ProximityPlacementGroupID string
+
+	// NICIDs are the network interfaces of the VM; the first is primary.
+	NICIDs []string
+	Tags   map[string]string
+
+	// CustomData is passed to the VM at creation, typically a cloud-init
+	// config for Linux. It is base64 encoded when the VM is created.
+	CustomData string
+}
+
+// NewVMSpec returns a spec for a small Ubuntu VM in the default location.
+// Add at least a NIC and credentials before creating it.
+func NewVMSpec(name string) *VMSpec {
+	return &VMSpec{
+		Name:     name,
+		Location: config.Location(),
+		Size:     compute.VirtualMachineSizeTypesBasicA0,
+		Image:    MarketplaceImage(publisher, offer, sku, "latest"),
+		OSType:   compute.Linux,
+	}
+}
+
+// WithLocation sets the region of the VM.
+func (s *VMSpec) WithLocation(location string) *VMSpec {
+	s.Location = location
+	return s
+}
+
+// WithSize sets the VM size.
+func (s *VMSpec) WithSize(size compute.VirtualMachineSizeTypes) *VMSpec {
+	s.Size = size
+	return s
+}
+
+// WithImage sets the image the VM is created from.
+func (s *VMSpec) WithImage(image VMImage) *VMSpec {
+	s.Image = image
+	return s
+}
+
+// WithWindows marks the image as a Windows image. Windows VMs need a
+// password and cannot use SSH keys.
+func (s *VMSpec) WithWindows() *VMSpec {
+	s.OSType = compute.Windows
+	return s
+}
+
+// WithOSDiskSize sets the OS disk size, instead of the image's default.
+func (s *VMSpec) WithOSDiskSize(sizeGB int32) *VMSpec {
+	s.OSDiskSizeGB = sizeGB
+	return s
+}
+
+// WithExistingOSDisk boots the VM from an existing managed OS disk of the
+// given OS type, instead of an image.
+func (s *VMSpec) WithExistingOSDisk(diskID string, osType compute.OperatingSystemTypes) *VMSpec {
+	s.OSDiskID = diskID
+	s.OSType = osType
+	return s
+}
+
+// WithAdmin sets the administrator user name.
+func (s *VMSpec) WithAdmin(username string) *VMSpec {
+	s.AdminUsername = username
+	return s
+}
+
+// WithPassword sets the administrator password. Linux VMs without a
+// password only allow SSH key authentication.
+func (s *VMSpec) WithPassword(password string) *VMSpec {
+	s.AdminPassword = password
+	return s
+}
+
+// WithSSHKey authorizes an SSH public key for the administrator.
+func (s *VMSpec) WithSSHKey(publicKey string) *VMSpec {
+	s.SSHPublicKeys = append(s.SSHPublicKeys, strings.TrimSpace(publicKey))
+	return s
+}
+
+// WithDataDisk adds a new empty managed data disk.
+func (s *VMSpec) WithDataDisk(lun, sizeGB int32) *VMSpec {
+	s.DataDisks = append(s.DataDisks, DataDiskSpec{Lun: lun, SizeGB: sizeGB})
+	return s
+}
+
+// WithExistingDataDisk attaches an existing managed disk.
+func (s *VMSpec) WithExistingDataDisk(lun int32, diskID string) *VMSpec {
+	s.DataDisks = append(s.DataDisks, DataDiskSpec{Lun: lun, DiskID: diskID})
+	return s
+}
+
+// WithSystemAssignedIdentity gives the VM a system-assigned managed
+// identity.
+func (s *VMSpec) WithSystemAssignedIdentity() *VMSpec {
+	s.SystemAssignedIdentity = true
+	return s
+}
+
+// WithUserAssignedIdentity assigns the user-assigned identity with the given
+// resource ID to the VM.
+func (s *VMSpec) WithUserAssignedIdentity(identityID string) *VMSpec {
+	s.UserAssignedIdentityIDs = append(s.UserAssignedIdentityIDs, identityID)
+	return s
+}
+
+// InAvailabilitySet places the VM in the availability set with the given
+// resource ID.
+func (s *VMSpec) InAvailabilitySet(availabilitySetID string) *VMSpec {
+	s.AvailabilitySetID = availabilitySetID
+	return s
+}
+
+// InZone places the VM in an availability zone, "1", "2" or "3".
+func (s *VMSpec) InZone(zone string) *VMSpec {
+	s.Zone = zone
+	return s
+}
+
+// InProximityPlacementGroup places the VM in the proximity placement group
+// with the given resource ID.
+func (s *VMSpec) InProximityPlacementGroup(groupID string) *VMSpec {
+	s.ProximityPlacementGroupID = groupID
+	return s
+}
+
+// WithNIC adds the network interface with the given resource ID. The first
+// NIC added is the primary one.
+func (s *VMSpec) WithNIC(nicID string) *VMSpec {
+	s.NICIDs = append(s.NICIDs, nicID)
+	return s
+}
+
+// WithTag sets a tag on the VM.
+func (s *VMSpec) WithTag(key, value string) *VMSpec {
+	if s.Tags == nil {
+		s.Tags = map[string]string{}
+	}
+	s.Tags[key] = value
+	return s
+}
+
+// WithCloudInit sets a cloud-init config, or other custom data, to pass to
+// the VM.
+func (s *VMSpec) WithCloudInit(cloudConfig string) *VMSpec {
+	s.CustomData = cloudConfig
+	return s
+}
+
+// Validate checks that the spec describes a VM the service can create.
+func (s *VMSpec) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("vm name is required")
+	}
+	if s.Location == "" {
+		return fmt.Errorf("vm %s: location is required", s.Name)
+	}
+	if len(s.NICIDs) == 0 {
+		return fmt.Errorf("vm %s: at least one NIC is required", s.Name)
+	}
+	if s.OSDiskID != "" {
+		if s.OSType != compute.Linux && s.OSType != compute.Windows {
+			return fmt.Errorf("vm %s: unknown OS type %q", s.Name, s.OSType)
+		}
+		if s.AdminUsername != "" || s.AdminPassword != "" || len(s.SSHPublicKeys) > 0 || s.CustomData != "" {
+			return fmt.Errorf("vm %s: credentials and custom data cannot be set on a VM booted from an existing OS disk", s.Name)
+		}
+	} else if err := s.validateOSProfile(); err != nil {
+		return err
+	}
+	if s.AvailabilitySetID != "" && s.Zone != "" {
+		return fmt.Errorf("vm %s: cannot be in both an availability set and a zone", s.Name)
+	}
+	if s.Zone != "" && s.Zone != "1" && s.Zone != "2" && s.Zone != "3" {
+		return fmt.Errorf("vm %s: unknown zone %q", s.Name, s.Zone)
+	}
+	luns := map[int32]bool{}
+	for _, d := range s.DataDisks {
+		if luns[d.Lun] {
+			return fmt.Errorf("vm %s: LUN %d is used twice", s.Name, d.Lun)
+		}
+		luns[d.Lun] = true
+		if (d.DiskID == "") == (d.SizeGB <= 0) {
+			return fmt.Errorf("vm %s: data disk at LUN %d needs either a size or an existing disk", s.Name, d.Lun)
+		}
+	}
+	if base64.StdEncoding.EncodedLen(len(s.CustomData)) > maxCustomDataLength {
+		return fmt.Errorf("vm %s: custom data is longer than %d bytes encoded", s.Name, maxCustomDataLength)
+	}
+	return nil
+}
+
+// validateOSProfile checks the image and credentials of a VM created from
+// an image.
+func (s *VMSpec) validateOSProfile() error {
+	if s.Image.ID == "" && (s.Image.Publisher == "" || s.Image.Offer == "" || s.Image.Sku == "") {
+		return fmt.Errorf("vm %s: image needs an ID or a publisher, offer and sku", s.Name)
+	}
+	if s.AdminUsername == "" {
+		return fmt.Errorf("vm %s: admin username is required", s.Name)
+	}
+	switch s.OSType {
+	case compute.Linux:
+		if s.AdminPassword == "" && len(s.SSHPublicKeys) == 0 {
+			return fmt.Errorf("vm %s: a password or SSH key is required", s.Name)
+		}
+	case compute.Windows:
+		if s.AdminPassword == "" {
+			return fmt.Errorf("vm %s: windows requires a password", s.Name)
+		}
+		if len(s.SSHPublicKeys) > 0 {
+			return fmt.Errorf("vm %s: windows does not support SSH keys", s.Name)
+		}
+		if len(s.Name) > maxWindowsComputerNameLength {
+			return fmt.Errorf("vm %s: windows computer names are limited to %d characters", s.Name, maxWindowsComputerNameLength)
+		}
+	default:
+		return fmt.Errorf("vm %s: unknown OS type %q", s.Name, s.OSType)
+	}
+	return nil
+}
+
+// VirtualMachine returns the VM resource described by the spec.
+func (s *VMSpec) VirtualMachine() (compute.VirtualMachine, error) {
+	if err := s.Validate(); err != nil {
+		return compute.VirtualMachine{}, err
+	}
+
+	storageProfile, osProfile := &compute.StorageProfile{}, s.osProfile()
+	if s.OSDiskID != "" {
+		storageProfile.OsDisk = &compute.OSDisk{
+			OsType:       s.OSType,
+			CreateOption: compute.DiskCreateOptionTypesAttach,
+			ManagedDisk:  &compute.ManagedDiskParameters{ID: to.StringPtr(s.OSDiskID)},
+		}
+	} else {
+		storageProfile.ImageReference = s.Image.reference()
+		if s.OSDiskSizeGB > 0 {
+			storageProfile.OsDisk = &compute.OSDisk{
+				CreateOption: compute.DiskCreateOptionTypesFromImage,
+				DiskSizeGB:   to.Int32Ptr(s.OSDiskSizeGB),
+			}
+		}
+	}
+	if len(s.DataDisks) > 0 {
+		var disks []compute.DataDisk
+		for _, d := range s.DataDisks {
+			disk := compute.DataDisk{
+				Lun:     to.Int32Ptr(d.Lun),
+				Caching: d.Caching,
+			}
+			if d.DiskID != "" {
+				disk.CreateOption = compute.DiskCreateOptionTypesAttach
+				disk.ManagedDisk = &compute.ManagedDiskParameters{ID: to.StringPtr(d.DiskID)}
+			} else {
+				disk.CreateOption = compute.DiskCreateOptionTypesEmpty
+				disk.DiskSizeGB = to.Int32Ptr(d.SizeGB)
+				if d.StorageAccountType != "" {
+					disk.ManagedDisk = &compute.ManagedDiskParameters{StorageAccountType: d.StorageAccountType}
+				}
+			}
+			disks = append(disks, disk)
+		}
+		storageProfile.DataDisks = &disks
+	}
+
+	var nics []compute.NetworkInterfaceReference
+	for i, id := range s.NICIDs {
+		nics = append(nics, compute.NetworkInterfaceReference{
+			ID: to.StringPtr(id),
+			NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
+				Primary: to.BoolPtr(i == 0),
+			},
+		})
+	}
+
+	vm := compute.VirtualMachine{
+		Location: to.StringPtr(s.Location),
+		Identity: s.identity(),
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			HardwareProfile: &compute.HardwareProfile{VMSize: s.Size},
+			StorageProfile:  storageProfile,
+			OsProfile:       osProfile,
+			NetworkProfile:  &compute.NetworkProfile{NetworkInterfaces: &nics},
+		},
+	}
+	if len(s.Tags) > 0 {
+		vm.Tags = *to.StringMapPtr(s.Tags)
+	}
+	if s.Zone != "" {
+		vm.Zones = &[]string{s.Zone}
+	}
+	if s.AvailabilitySetID != "" {
+		vm.AvailabilitySet = &compute.SubResource{ID: to.StringPtr(s.AvailabilitySetID)}
+	}
+	if s.ProximityPlacementGroupID != "" {
+		vm.ProximityPlacementGroup = &compute.SubResource{ID: to.StringPtr(s.ProximityPlacementGroupID)}
+	}
+	return vm, nil
+}
+
+// osProfile returns the OS profile of a VM created from an image, or nil
+// for a VM booted from an existing OS disk.
+func (s *VMSpec) osProfile() *compute.OSProfile {
+	if s.OSDiskID != "" {
+		return nil
+	}
+	osProfile := &compute.OSProfile{
+		ComputerName:  to.StringPtr(s.Name),
+		AdminUsername: to.StringPtr(s.AdminUsername),
+	}
+	if s.AdminPassword != "" {
+		osProfile.AdminPassword = to.StringPtr(s.AdminPassword)
+	}
+	if s.CustomData != "" {
+		osProfile.CustomData = to.StringPtr(base64.StdEncoding.EncodeToString([]byte(s.CustomData)))
+	}
+	if s.OSType == compute.Linux {
+		linux := &compute.LinuxConfiguration{
+			DisablePasswordAuthentication: to.BoolPtr(s.AdminPassword == ""),
+		}
+		if len(s.SSHPublicKeys) > 0 {
+			var keys []compute.SSHPublicKey
+			for _, key := range s.SSHPublicKeys {
+				keys = append(keys, compute.SSHPublicKey{
+					Path:    to.StringPtr(fmt.Sprintf("/home/%s/.ssh/authorized_keys", s.AdminUsername)),
+					KeyData: to.StringPtr(key),
+				})
+			}
+			linux.SSH = &compute.SSHConfiguration{PublicKeys: &keys}
+		}
+		osProfile.LinuxConfiguration = linux
+	} else {
+		osProfile.WindowsConfiguration = &compute.WindowsConfiguration{
+			ProvisionVMAgent: to.BoolPtr(true),
+		}
+	}
+	return osProfile
+}
+
+func (s *VMSpec) identity() *compute.VirtualMachineIdentity {
+	if !s.SystemAssignedIdentity && len(s.UserAssignedIdentityIDs) == 0 {
+		return nil
+	}
+	identity := &compute.VirtualMachineIdentity{Type: compute.ResourceIdentityTypeSystemAssigned}
+	if len(s.UserAssignedIdentityIDs) > 0 {
+		identity.Type = compute.ResourceIdentityTypeUserAssigned
+		if s.SystemAssignedIdentity {
+			identity.Type = compute.ResourceIdentityTypeSystemAssignedUserAssigned
+		}
+		identity.UserAssignedIdentities = map[string]*compute.VirtualMachineIdentityUserAssignedIdentitiesValue{}
+		for _, id := range s.UserAssignedIdentityIDs {
+			identity.UserAssignedIdentities[id] = &compute.VirtualMachineIdentityUserAssignedIdentitiesValue{}
+		}
+	}
+	return identity
+}
+
+// CreateVMFromSpec creates a virtual machine in the default resource group
+// as described by spec.
+func CreateVMFromSpec(ctx context.Context, spec *VMSpec) (vm compute.VirtualMachine, err error) {
+	vm, err = spec.VirtualMachine()
+	if err != nil {
+		return vm, err
+	}
+
+	vmClient := getVMClient()
+	future, err := vmClient.CreateOrUpdate(ctx, config.GroupName(), spec.Name, vm)
+	if err != nil {
+		return vm, fmt.Errorf("cannot create vm: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, vmClient.Client)
+	if err != nil {
+		return vm, fmt.Errorf("cannot get the vm create or update future response: %v", err)
+	}
+
+	return future.Result(vmClient)
+}
+
+// readSSHPublicKey reads an SSH public key from a file, falling back to a
+// placeholder key if the file does not exist.
+func readSSHPublicKey(sshPublicKeyPath string) (string, error) {
+	if _, err := os.Stat(sshPublicKeyPath); err != nil {
+		return fakepubkey, nil
+	}
+	sshBytes, err := ioutil.ReadFile(sshPublicKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read SSH key data: %v", err)
+	}
+	return string(sshBytes), nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package compute
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+)
+
+func TestVMSpecVirtualMachine(t *testing.T) {
+	spec := NewVMSpec("spec-vm").
+		WithLocation("westus2").
+		WithSize(compute.VirtualMachineSizeTypesStandardD2sV3).
+		WithImage(GalleryImage("/subscriptions/s/resourceGroups/g/providers/Microsoft.Compute/galleries/gal/images/img/versions/1.0.0")).
+		WithAdmin("azureuser").
+		WithSSHKey(fakepubkey+"\n").
+		WithDataDisk(0, 32).
+		WithExistingDataDisk(1, "/subscriptions/s/resourceGroups/g/providers/Microsoft.Compute/disks/d").
+		WithSystemAssignedIdentity().
+		WithUserAssignedIdentity("/identities/id1").
+		InZone("2").
+		InProximityPlacementGroup("/ppg").
+		WithNIC("/nics/primary").
+		WithNIC("/nics/secondary").
+		WithTag("env", "test").
+		WithCloudInit("#cloud-config\npackages: [nginx]\n")
+
+	vm, err := spec.VirtualMachine()
+	if err != nil {
+		t.Fatalf("failed to build vm: %v", err)
+	}
+
+	if *vm.Location != "westus2" || vm.HardwareProfile.VMSize != compute.VirtualMachineSizeTypesStandardD2sV3 {
+		t.Errorf("unexpected location or size: %s, %s", *vm.Location, vm.HardwareProfile.VMSize)
+	}
+	if image := vm.StorageProfile.ImageReference; image.ID == nil || image.Publisher != nil {
+		t.Errorf("gallery image not referenced by ID: %+v", image)
+	}
+	linux := vm.OsProfile.LinuxConfiguration
+	if vm.OsProfile.AdminPassword != nil || !*linux.DisablePasswordAuthentication {
+		t.Errorf("password authentication enabled without a password")
+	}
+	keys := *linux.SSH.PublicKeys
+	if len(keys) != 1 || *keys[0].KeyData != fakepubkey || *keys[0].Path != "/home/azureuser/.ssh/authorized_keys" {
+		t.Errorf("unexpected SSH keys: %+v", keys)
+	}
+	customData, _ := base64.StdEncoding.DecodeString(*vm.OsProfile.CustomData)
+	if !strings.HasPrefix(string(customData), "#cloud-config") {
+		t.Errorf("custom data not encoded: %q", *vm.OsProfile.CustomData)
+	}
+
+	disks := *vm.StorageProfile.DataDisks
+	if disks[0].CreateOption != compute.DiskCreateOptionTypesEmpty || *disks[0].DiskSizeGB != 32 {
+		t.Errorf("unexpected empty disk: %+v", disks[0])
+	}
+	if disks[1].CreateOption != compute.DiskCreateOptionTypesAttach || disks[1].ManagedDisk.ID == nil {
+		t.Errorf("unexpected attached disk: %+v", disks[1])
+	}
+	if vm.StorageProfile.OsDisk != nil {
+		t.Errorf("OS disk set without a size: %+v", vm.StorageProfile.OsDisk)
+	}
+
+	if vm.Identity.Type != compute.ResourceIdentityTypeSystemAssignedUserAssigned || len(vm.Identity.UserAssignedIdentities) != 1 {
+		t.Errorf("unexpected identity: %+v", vm.Identity)
+	}
+	if len(*vm.Zones) != 1 || (*vm.Zones)[0] != "2" || vm.AvailabilitySet != nil || *vm.ProximityPlacementGroup.ID != "/ppg" {
+		t.Errorf("unexpected placement: zones %v, set %v, ppg %v", vm.Zones, vm.AvailabilitySet, vm.ProximityPlacementGroup)
+	}
+	nics := *vm.NetworkProfile.NetworkInterfaces
+	if len(nics) != 2 || !*nics[0].Primary || *nics[1].Primary {
+		t.Errorf("first NIC should be the only primary: %+v", nics)
+	}
+	if *vm.Tags["env"] != "test" {
+		t.Errorf("unexpected tags: %v", vm.Tags)
+	}
+}
+
+func TestVMSpecWindows(t *testing.T) {
+	vm, err := NewVMSpec("win-vm").
+		WithLocation("westus2").
+		WithWindows().
+		WithImage(MarketplaceImage("MicrosoftWindowsServer", "WindowsServer", "2019-Datacenter", "")).
+		WithAdmin("azureuser").
+		WithPassword("password!1delete").
+		WithNIC("/nics/primary").
+		VirtualMachine()
+	if err != nil {
+		t.Fatalf("failed to build vm: %v", err)
+	}
+	if vm.OsProfile.WindowsConfiguration == nil || vm.OsProfile.LinuxConfiguration != nil {
+		t.Errorf("unexpected OS configuration: %+v", vm.OsProfile)
+	}
+	if *vm.StorageProfile.ImageReference.Version != "latest" || vm.Identity != nil {
+		t.Errorf("unexpected image or identity: %+v, %+v", vm.StorageProfile.ImageReference, vm.Identity)
+	}
+}
+
+func TestVMSpecExistingOSDisk(t *testing.T) {
+	vm, err := NewVMSpec("restored-vm").
+		WithLocation("westus2").
+		WithExistingOSDisk("/disks/os", compute.Windows).
+		WithNIC("/nics/primary").
+		VirtualMachine()
+	if err != nil {
+		t.Fatalf("failed to build vm: %v", err)
+	}
+	osDisk := vm.StorageProfile.OsDisk
+	if osDisk.CreateOption != compute.DiskCreateOptionTypesAttach || *osDisk.ManagedDisk.ID != "/disks/os" || osDisk.OsType != compute.Windows {
+		t.Errorf("unexpected OS disk: %+v", osDisk)
+	}
+	if vm.OsProfile != nil || vm.StorageProfile.ImageReference != nil {
+		t.Errorf("VM booted from a disk has an OS profile or image: %+v, %+v", vm.OsProfile, vm.StorageProfile.ImageReference)
+	}
+
+	spec := NewVMSpec("restored-vm").WithLocation("westus2").WithExistingOSDisk("/disks/os", compute.Linux).WithNIC("/nics/n")
+	if err := spec.WithAdmin("azureuser").Validate(); err == nil {
+		t.Errorf("expected credentials on a VM booted from a disk to be rejected")
+	}
+}
+
+func TestVMSpecValidate(t *testing.T) {
+	valid := func() *VMSpec {
+		return NewVMSpec("vm").WithLocation("westus2").WithAdmin("azureuser").WithPassword("pass!1word").WithNIC("/nics/n")
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid spec rejected: %v", err)
+	}
+
+	cases := map[string]*VMSpec{
+		"no name":          named(valid(), ""),
+		"no nic":           NewVMSpec("vm").WithLocation("westus2").WithAdmin("azureuser").WithPassword("pass!1word"),
+		"no admin":         valid().WithAdmin(""),
+		"no credentials":   valid().WithPassword(""),
+		"no image":         valid().WithImage(VMImage{Publisher: "p"}),
+		"windows ssh":      valid().WithWindows().WithSSHKey(fakepubkey),
+		"windows name":     named(valid().WithWindows(), "a-very-long-windows-name"),
+		"zone and set":     valid().InZone("1").InAvailabilitySet("/sets/s"),
+		"unknown zone":     valid().InZone("4"),
+		"duplicate lun":    valid().WithDataDisk(0, 8).WithDataDisk(0, 16),
+		"disk without src": valid().WithDataDisk(2, 0),
+		"huge custom data": valid().WithCloudInit(strings.Repeat("x", maxCustomDataLength)),
+	}
+	for name, spec := range cases {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func named(s *VMSpec, name string) *VMSpec {
+	s.Name = name
+	return s
+}
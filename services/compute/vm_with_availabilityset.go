@@ -66,53 +66,10 @@ func CreateVMWithLoadBalancer(ctx context.Context, vmName, lbName, vnetName, sub
 		return
 	}
 
-	vmClient := getVMClient()
-	future, err := vmClient.CreateOrUpdate(
-		ctx,
-		config.GroupName(),
-		vmName,
-		compute.VirtualMachine{
-			Location: to.StringPtr(config.Location()),
-			VirtualMachineProperties: &compute.VirtualMachineProperties{
-				HardwareProfile: &compute.HardwareProfile{
-					VMSize: compute.VirtualMachineSizeTypesStandardA0,
-				},
-				StorageProfile: &compute.StorageProfile{
-					ImageReference: &compute.ImageReference{
-						Publisher: to.StringPtr(publisher),
-						Offer:     to.StringPtr(offer),
-						Sku:       to.StringPtr(sku),
-						Version:   to.StringPtr("latest"),
-					},
-				},
-				OsProfile: &compute.OSProfile{
-					ComputerName:  to.StringPtr(vmName),
-					AdminUsername: to.StringPtr("azureuser"),
-					AdminPassword: to.StringPtr("password!1delete"),
-				},
-				NetworkProfile: &compute.NetworkProfile{
-					NetworkInterfaces: &[]compute.NetworkInterfaceReference{
-						{
-							ID: nic.ID,
-							NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
-								Primary: to.BoolPtr(true),
-							},
-						},
-					},
-				},
-				AvailabilitySet: &compute.SubResource{
-					ID: as.ID,
-				},
-			},
-		})
-	if err != nil {
-		return vm, fmt.Errorf("cannot create vm: %v", err)
-	}
-
-	err = future.WaitForCompletionRef(ctx, vmClient.Client)
-	if err != nil {
-		return vm, fmt.Errorf("cannot get the vm create or update future response: %v", err)
-	}
-
-	return future.Result(vmClient)
+	return CreateVMFromSpec(ctx, NewVMSpec(vmName).
+		WithSize(compute.VirtualMachineSizeTypesStandardA0).
+		WithNIC(*nic.ID).
+		WithAdmin("azureuser").
+		WithPassword("password!1delete").
+		InAvailabilitySet(*as.ID))
 }
@@ -17,58 +17,16 @@ import (
 
 // CreateVMWithMSI creates a virtual machine with a system-assigned managed identity.
 func CreateVMWithMSI(ctx context.Context, vmName, nicName, username, password string) (vm compute.VirtualMachine, err error) {
-	nic, _ := network.GetNic(ctx, nicName)
-
-	vmClient := getVMClient()
-	future, err := vmClient.CreateOrUpdate(
-		ctx,
-		config.GroupName(),
-		vmName,
-		compute.VirtualMachine{
-			Location: to.StringPtr(config.Location()),
-			Identity: &compute.VirtualMachineIdentity{
-				Type: compute.ResourceIdentityTypeSystemAssigned,
-			},
-			VirtualMachineProperties: &compute.VirtualMachineProperties{
-				HardwareProfile: &compute.HardwareProfile{
-					VMSize: compute.VirtualMachineSizeTypesBasicA0,
-				},
-				StorageProfile: &compute.StorageProfile{
-					ImageReference: &compute.ImageReference{
-						Publisher: to.StringPtr(publisher),
-						Offer:     to.StringPtr(offer),
-						Sku:       to.StringPtr(sku),
-						Version:   to.StringPtr("latest"),
-					},
-				},
-				OsProfile: &compute.OSProfile{
-					ComputerName:  to.StringPtr(vmName),
-					AdminUsername: to.StringPtr(username),
-					AdminPassword: to.StringPtr(password),
-				},
-				NetworkProfile: &compute.NetworkProfile{
-					NetworkInterfaces: &[]compute.NetworkInterfaceReference{
-						{
-							ID: nic.ID,
-							NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
-								Primary: to.BoolPtr(true),
-							},
-						},
-					},
-				},
-			},
-		},
-	)
+	nic, err := network.GetNic(ctx, nicName)
 	if err != nil {
-		return vm, fmt.Errorf("cannot create vm: %v", err)
+		return vm, fmt.Errorf("cannot get nic: %v", err)
 	}
 
-	err = future.WaitForCompletionRef(ctx, vmClient.Client)
-	if err != nil {
-		return vm, fmt.Errorf("cannot get the vm create or update future response: %v", err)
-	}
-
-	return future.Result(vmClient)
+	return CreateVMFromSpec(ctx, NewVMSpec(vmName).
+		WithNIC(*nic.ID).
+		WithAdmin(username).
+		WithPassword(password).
+		WithSystemAssignedIdentity())
 }
 
 // AddIdentityToVM adds a managed identity to an existing VM by activating the
@@ -107,58 +65,19 @@ func AddIdentityToVM(ctx context.Context, vmName string) (ext compute.VirtualMac
 
 // CreateVMWithUserAssignedID creates a virtual machine with a user-assigned identity.
 func CreateVMWithUserAssignedID(ctx context.Context, vmName, nicName, username, password string, id msi.Identity) (vm compute.VirtualMachine, err error) {
-	nic, _ := network.GetNic(ctx, nicName)
-	vmClient := getVMClient()
-	future, err := vmClient.CreateOrUpdate(
-		ctx,
-		config.GroupName(),
-		vmName,
-		compute.VirtualMachine{
-			Location: to.StringPtr(config.Location()),
-			Identity: &compute.VirtualMachineIdentity{
-				Type: compute.ResourceIdentityTypeUserAssigned,
-				UserAssignedIdentities: map[string]*compute.VirtualMachineIdentityUserAssignedIdentitiesValue{
-					*id.ID: {},
-				},
-			},
-			VirtualMachineProperties: &compute.VirtualMachineProperties{
-				HardwareProfile: &compute.HardwareProfile{
-					VMSize: compute.VirtualMachineSizeTypesBasicA0,
-				},
-				StorageProfile: &compute.StorageProfile{
-					ImageReference: &compute.ImageReference{
-						Publisher: to.StringPtr(publisher),
-						Offer:     to.StringPtr(offer),
-						Sku:       to.StringPtr(sku),
-						Version:   to.StringPtr("latest"),
-					},
-				},
-				OsProfile: &compute.OSProfile{
-					ComputerName:  to.StringPtr(vmName),
-					AdminUsername: to.StringPtr(username),
-					AdminPassword: to.StringPtr(password),
-				},
-				NetworkProfile: &compute.NetworkProfile{
-					NetworkInterfaces: &[]compute.NetworkInterfaceReference{
-						{
-							ID: nic.ID,
-							NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
-								Primary: to.BoolPtr(true),
-							},
-						},
-					},
-				},
-			},
-		},
-	)
+	nic, err := network.GetNic(ctx, nicName)
 	if err != nil {
-		return vm, errors.Wrap(err, "failed to create VM")
+		return vm, errors.Wrap(err, "failed to get NIC")
 	}
-	err = future.WaitForCompletionRef(ctx, vmClient.Client)
+	vm, err = CreateVMFromSpec(ctx, NewVMSpec(vmName).
+		WithNIC(*nic.ID).
+		WithAdmin(username).
+		WithPassword(password).
+		WithUserAssignedIdentity(*id.ID))
 	if err != nil {
-		return vm, errors.Wrap(err, "failed waiting for async operation to complete")
+		return vm, errors.Wrap(err, "failed to create VM")
 	}
-	return future.Result(vmClient)
+	return vm, nil
 }
 
 // AddUserAssignedIDToVM adds the specified user-assigned identity to the specified pre-existing VM.
@@ -7,6 +7,7 @@ require (
 	github.com/Azure-Samples/azure-sdk-for-go-samples v0.0.0-20220208083254-57c00fc3af1d
 	github.com/Azure/azure-amqp-common-go v1.1.4
 	github.com/Azure/azure-event-hubs-go v1.3.0
+	github.com/Azure/azure-pipeline-go v0.1.9
 	github.com/Azure/azure-sdk-for-go v48.0.0+incompatible
 	github.com/Azure/azure-storage-blob-go v0.0.0-20181023070848-cf01652132cc
 	github.com/Azure/go-autorest/autorest v0.11.10
@@ -20,4 +21,5 @@ require (
 	github.com/marstr/randname v0.0.0-20181206212954-d5b0f288ab8c
 	github.com/pkg/errors v0.8.1
 	github.com/satori/go.uuid v1.2.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+// Package hybrid holds what the Azure Stack samples share: the stamp and
+// subscription they talk to, and how they authenticate to it.
+package hybrid
+
+import (
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Environment is an Azure Stack stamp's endpoints and the subscription to
+// use on it. It is comparable, so it can key per-stamp caches.
+type Environment struct {
+	ResourceManagerEndpoint string
+	ActiveDirectoryEndpoint string
+	TokenAudience           string
+	SubscriptionID          string
+}
+
+// ConfiguredEnvironment returns the environment of the configured cloud
+// and subscription.
+func ConfiguredEnvironment() Environment {
+	env := config.Environment()
+	return Environment{
+		ResourceManagerEndpoint: env.ResourceManagerEndpoint,
+		ActiveDirectoryEndpoint: env.ActiveDirectoryEndpoint,
+		TokenAudience:           env.TokenAudience,
+		SubscriptionID:          config.SubscriptionID(),
+	}
+}
+
+// NewAuthorizer authenticates the configured service principal against a
+// stamp's Active Directory endpoint.
+func NewAuthorizer(activeDirectoryEndpoint, tokenAudience string) (autorest.Authorizer, error) {
+	token, err := iam.GetResourceManagementTokenHybrid(activeDirectoryEndpoint, tokenAudience)
+	if err != nil {
+		return nil, err
+	}
+	return autorest.NewBearerAuthorizer(token), nil
+}
@@ -0,0 +1,197 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+// Package hybridtest provides a fake Azure Stack stamp for the hybrid
+// samples' tests.
+package hybridtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/hybrid"
+)
+
+// The gallery and authentication endpoints and subscription of every fake
+// stamp.
+const (
+	GalleryEndpoint = "https://gallery.local:30015/"
+	LoginEndpoint   = "https://login.local/adfs"
+	TokenAudience   = "https://management.adfs.local/"
+	SubscriptionID  = "sub"
+)
+
+// Stamp is a local Resource Manager endpoint serving a stamp's metadata
+// and registered providers, and keeping the resources put to it in memory,
+// keyed by lowercase ID as Resource Manager IDs are case-insensitive. Puts
+// complete synchronously; posts, such as VM power actions, are accepted
+// and only recorded.
+type Stamp struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+	// apiVersions is the api-version of the last request of each method
+	// and path.
+	apiVersions map[string]string
+	providers   map[string]map[string][]string
+	resources   map[string]interface{}
+}
+
+// NewStamp starts a fake stamp, which the caller closes.
+func NewStamp() *Stamp {
+	s := &Stamp{
+		apiVersions: map[string]string{},
+		providers:   map[string]map[string][]string{},
+		resources:   map[string]interface{}{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Environment returns the environment clients use to talk to the stamp.
+func (s *Stamp) Environment() hybrid.Environment {
+	return hybrid.Environment{
+		ResourceManagerEndpoint: s.URL,
+		ActiveDirectoryEndpoint: LoginEndpoint,
+		TokenAudience:           TokenAudience,
+		SubscriptionID:          SubscriptionID,
+	}
+}
+
+// RegisterProvider registers a resource provider namespace, such as
+// "Microsoft.Compute", with the API versions the stamp supports for each
+// of its resource types.
+func (s *Stamp) RegisterProvider(namespace string, apiVersions map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.providers[strings.ToLower(namespace)] = apiVersions
+}
+
+// Set stores a response the stamp returns as is for GETs of path, such as
+// a list that is not a resource of its own.
+func (s *Stamp) Set(path string, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[strings.ToLower(path)] = v
+}
+
+// Requests returns the method and path of every request so far.
+func (s *Stamp) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// APIVersion returns the api-version of the last request with the method
+// and path.
+func (s *Stamp) APIVersion(method, path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apiVersions[method+" "+path]
+}
+
+// IDs returns the lowercase paths of the stored resources, sorted.
+func (s *Stamp) IDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.resources))
+	for id := range s.resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *Stamp) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.URL.Path
+	s.requests = append(s.requests, r.Method+" "+id)
+	s.apiVersions[r.Method+" "+id] = r.URL.Query().Get("api-version")
+
+	reply := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	notFound := func() {
+		reply(http.StatusNotFound, map[string]interface{}{"error": map[string]string{"code": "ResourceNotFound"}})
+	}
+	providers := "/subscriptions/" + SubscriptionID + "/providers/"
+	switch {
+	case r.Method == http.MethodGet && id == "/metadata/endpoints":
+		reply(http.StatusOK, map[string]interface{}{
+			"galleryEndpoint": GalleryEndpoint,
+			"authentication": map[string]interface{}{
+				"loginEndpoint": LoginEndpoint,
+				"audiences":     []string{TokenAudience},
+			},
+		})
+	case r.Method == http.MethodGet && strings.HasPrefix(id, providers) && !strings.Contains(strings.TrimPrefix(id, providers), "/"):
+		namespace := strings.TrimPrefix(id, providers)
+		apiVersions, ok := s.providers[strings.ToLower(namespace)]
+		if !ok {
+			notFound()
+			return
+		}
+		types := []map[string]interface{}{}
+		for t, versions := range apiVersions {
+			types = append(types, map[string]interface{}{"resourceType": t, "apiVersions": versions})
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i]["resourceType"].(string) < types[j]["resourceType"].(string) })
+		reply(http.StatusOK, map[string]interface{}{"namespace": namespace, "resourceTypes": types})
+	case r.Method == http.MethodPut:
+		var resource map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			reply(http.StatusBadRequest, map[string]interface{}{"error": map[string]string{"code": "InvalidRequestContent"}})
+			return
+		}
+		s.put(id, resource)
+		reply(http.StatusOK, resource)
+	case r.Method == http.MethodGet:
+		resource, ok := s.resources[strings.ToLower(id)]
+		if !ok {
+			notFound()
+			return
+		}
+		reply(http.StatusOK, resource)
+	case r.Method == http.MethodDelete:
+		for stored := range s.resources {
+			if key := strings.ToLower(id); stored == key || strings.HasPrefix(stored, key+"/") {
+				delete(s.resources, stored)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// put stores a resource as provisioned, and the subnets of a virtual
+// network as resources of their own.
+func (s *Stamp) put(id string, resource map[string]interface{}) {
+	resource["id"] = id
+	resource["name"] = path.Base(id)
+	props, _ := resource["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		resource["properties"] = props
+	}
+	props["provisioningState"] = "Succeeded"
+	if subnets, ok := props["subnets"].([]interface{}); ok && strings.Contains(id, "/virtualNetworks/") {
+		for _, subnet := range subnets {
+			if subnet, ok := subnet.(map[string]interface{}); ok {
+				s.put(id+"/subnets/"+subnet["name"].(string), subnet)
+			}
+		}
+	}
+	s.resources[strings.ToLower(id)] = resource
+}
@@ -20,6 +20,7 @@ var (
 	batchAuthorizer    autorest.Authorizer
 	graphAuthorizer    autorest.Authorizer
 	keyvaultAuthorizer autorest.Authorizer
+	storageAuthorizer  autorest.Authorizer
 )
 
 // OAuthGrantType specifies which grant type to use.
@@ -158,6 +159,31 @@ func GetKeyvaultAuthorizer() (autorest.Authorizer, error) {
 	return keyvaultAuthorizer, err
 }
 
+// GetStorageAuthorizer gets an OAuthTokenAuthorizer for Azure Storage
+// data-plane requests made with an AAD identity, such as requesting a user
+// delegation key. Storage *accounts* are managed by Azure Resource Manager.
+func GetStorageAuthorizer() (autorest.Authorizer, error) {
+	if storageAuthorizer != nil {
+		return storageAuthorizer, nil
+	}
+
+	var a autorest.Authorizer
+	var err error
+
+	a, err = getAuthorizerForResource(
+		grantType(), config.Environment().ResourceIdentifiers.Storage)
+
+	if err == nil {
+		// cache
+		storageAuthorizer = a
+	} else {
+		// clear cache
+		storageAuthorizer = nil
+	}
+
+	return storageAuthorizer, err
+}
+
 func getAuthorizerForResource(grantType OAuthGrantType, resource string) (autorest.Authorizer, error) {
 	var a autorest.Authorizer
 	var err error
@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package keyvault
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/mgmt/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	uuid "github.com/satori/go.uuid"
+)
+
+// diskEncryptionAccessPolicy grants a principal the key permissions disk
+// encryption needs: reading the key encryption key and wrapping and
+// unwrapping disk keys with it.
+func diskEncryptionAccessPolicy(tenantID uuid.UUID, objectID string) keyvault.AccessPolicyEntry {
+	return keyvault.AccessPolicyEntry{
+		TenantID: &tenantID,
+		ObjectID: to.StringPtr(objectID),
+		Permissions: &keyvault.Permissions{
+			Keys: &[]keyvault.KeyPermissions{
+				keyvault.KeyPermissionsGet,
+				keyvault.KeyPermissionsWrapKey,
+				keyvault.KeyPermissionsUnwrapKey,
+			},
+		},
+	}
+}
+
+// PrepareVaultForDiskEncryption enables a vault for disk encryption. It
+// lets the compute platform store Azure Disk Encryption secrets in the
+// vault, turns on soft delete and purge protection, which disk encryption
+// sets require, and grants the principals with objectIDs, such as the
+// identity of a disk encryption set, access to the vault's keys. Purge
+// protection cannot be turned off again.
+func PrepareVaultForDiskEncryption(ctx context.Context, vaultName string, objectIDs ...string) (keyvault.Vault, error) {
+	vaultsClient := getVaultsClient()
+	tenantID, err := uuid.FromString(config.TenantID())
+	if err != nil {
+		return keyvault.Vault{}, err
+	}
+
+	_, err = vaultsClient.Update(ctx, config.GroupName(), vaultName, keyvault.VaultPatchParameters{
+		Properties: &keyvault.VaultPatchProperties{
+			EnabledForDiskEncryption: to.BoolPtr(true),
+			EnableSoftDelete:         to.BoolPtr(true),
+			EnablePurgeProtection:    to.BoolPtr(true),
+		},
+	})
+	if err != nil {
+		return keyvault.Vault{}, fmt.Errorf("cannot enable vault for disk encryption: %v", err)
+	}
+
+	if len(objectIDs) > 0 {
+		var policies []keyvault.AccessPolicyEntry
+		for _, id := range objectIDs {
+			policies = append(policies, diskEncryptionAccessPolicy(tenantID, id))
+		}
+		_, err = vaultsClient.UpdateAccessPolicy(ctx, config.GroupName(), vaultName, keyvault.Add, keyvault.VaultAccessPolicyParameters{
+			Properties: &keyvault.VaultAccessPolicyProperties{AccessPolicies: &policies},
+		})
+		if err != nil {
+			return keyvault.Vault{}, fmt.Errorf("cannot add vault access policies: %v", err)
+		}
+	}
+
+	return vaultsClient.Get(ctx, config.GroupName(), vaultName)
+}
+
+// CreateKeyEncryptionKey creates an RSA key that wraps disk encryption
+// keys. If the key exists, a new version of it is created, which is how
+// the key is rotated.
+func CreateKeyEncryptionKey(ctx context.Context, vaultName, keyName string) (key kv.KeyBundle, err error) {
+	vault, err := GetVault(ctx, vaultName)
+	if err != nil {
+		return key, err
+	}
+	if vault.Properties == nil || vault.Properties.VaultURI == nil {
+		return key, fmt.Errorf("vault %s has no URI", vaultName)
+	}
+
+	keyClient := getKeysClient()
+	return keyClient.CreateKey(
+		ctx,
+		*vault.Properties.VaultURI,
+		keyName,
+		kv.KeyCreateParameters{
+			KeyAttributes: &kv.KeyAttributes{
+				Enabled: to.BoolPtr(true),
+			},
+			KeySize: to.Int32Ptr(2048),
+			KeyOps: &[]kv.JSONWebKeyOperation{
+				kv.WrapKey,
+				kv.UnwrapKey,
+			},
+			Kty: kv.RSA,
+		})
+}
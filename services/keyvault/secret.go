@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package keyvault
+
+import (
+	"context"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// SetSecret creates a secret in the specified keyvault, or adds a new version
+// of the secret if it already exists.
+func SetSecret(ctx context.Context, vaultName, secretName, value, contentType string) (secret keyvault.SecretBundle, err error) {
+	vaultsClient := getVaultsClient()
+	vault, err := vaultsClient.Get(ctx, config.GroupName(), vaultName)
+	if err != nil {
+		return
+	}
+	vaultURL := *vault.Properties.VaultURI
+
+	secretClient := getKeysClient()
+	return secretClient.SetSecret(
+		ctx,
+		vaultURL,
+		secretName,
+		keyvault.SecretSetParameters{
+			Value:       to.StringPtr(value),
+			ContentType: to.StringPtr(contentType),
+		})
+}
@@ -0,0 +1,193 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// RouteSource is where a route of a subnet comes from.
+type RouteSource string
+
+const (
+	// RouteSourceDefault are the system routes of every subnet.
+	RouteSourceDefault RouteSource = "Default"
+	// RouteSourceGateway are routes learnt from a virtual network gateway.
+	RouteSourceGateway RouteSource = "VirtualNetworkGateway"
+	// RouteSourceUser are the routes of the subnet's route table.
+	RouteSourceUser RouteSource = "User"
+)
+
+// NextHopVNetPeering is the next hop of the system routes to peered
+// networks. Route tables cannot use it.
+const NextHopVNetPeering network.RouteNextHopType = "VNetPeering"
+
+// sourcePreference orders the sources of routes with the same prefix.
+var sourcePreference = map[RouteSource]int{
+	RouteSourceUser:    0,
+	RouteSourceGateway: 1,
+	RouteSourceDefault: 2,
+}
+
+// reservedPrefixes are the address ranges Azure drops traffic to unless a
+// virtual network or a peering uses them.
+var reservedPrefixes = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10"}
+
+// RouteEntry is a route of a subnet.
+type RouteEntry struct {
+	Source           RouteSource
+	Name             string
+	AddressPrefix    string
+	NextHopType      network.RouteNextHopType
+	NextHopIPAddress string
+}
+
+func (r RouteEntry) String() string {
+	hop := string(r.NextHopType)
+	if r.NextHopIPAddress != "" {
+		hop += " " + r.NextHopIPAddress
+	}
+	return fmt.Sprintf("%s %s via %s", r.Source, r.AddressPrefix, hop)
+}
+
+// SystemRoutes returns the system routes of a subnet in a virtual network
+// with an address space and peered networks, keyed by peering name.
+func SystemRoutes(addressSpace []string, peers map[string][]string) []RouteEntry {
+	var routes []RouteEntry
+	local := map[string]bool{}
+	for _, prefix := range addressSpace {
+		routes = append(routes, RouteEntry{Source: RouteSourceDefault, Name: "vnet", AddressPrefix: prefix, NextHopType: network.RouteNextHopTypeVnetLocal})
+		local[prefix] = true
+	}
+	names := make([]string, 0, len(peers))
+	for name := range peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, prefix := range peers[name] {
+			routes = append(routes, RouteEntry{Source: RouteSourceDefault, Name: name, AddressPrefix: prefix, NextHopType: NextHopVNetPeering})
+			local[prefix] = true
+		}
+	}
+	routes = append(routes, RouteEntry{Source: RouteSourceDefault, Name: "internet", AddressPrefix: "0.0.0.0/0", NextHopType: network.RouteNextHopTypeInternet})
+	for _, prefix := range reservedPrefixes {
+		if !local[prefix] {
+			routes = append(routes, RouteEntry{Source: RouteSourceDefault, Name: "reserved", AddressPrefix: prefix, NextHopType: network.RouteNextHopTypeNone})
+		}
+	}
+	return routes
+}
+
+// TableRoutes returns the user-defined routes of a route table.
+func TableRoutes(table network.RouteTable) []RouteEntry {
+	var routes []RouteEntry
+	if table.RouteTablePropertiesFormat == nil || table.Routes == nil {
+		return routes
+	}
+	for _, r := range *table.Routes {
+		if r.RoutePropertiesFormat == nil {
+			continue
+		}
+		routes = append(routes, RouteEntry{
+			Source:           RouteSourceUser,
+			Name:             to.String(r.Name),
+			AddressPrefix:    to.String(r.AddressPrefix),
+			NextHopType:      r.NextHopType,
+			NextHopIPAddress: to.String(r.NextHopIPAddress),
+		})
+	}
+	return routes
+}
+
+// NextHop returns the route Azure picks for traffic to an IPv4 address:
+// the one with the longest matching prefix, and on a tie the user route
+// over a gateway route over a system route.
+func NextHop(routes []RouteEntry, destination string) (RouteEntry, error) {
+	ip := net.ParseIP(destination).To4()
+	if ip == nil {
+		return RouteEntry{}, fmt.Errorf("invalid IPv4 destination %q", destination)
+	}
+	addr := binary.BigEndian.Uint32(ip)
+
+	var best RouteEntry
+	bestLength := -1
+	for _, r := range routes {
+		block, err := parseIPv4Block(r.AddressPrefix)
+		if err != nil || addr < block.first || addr > block.last {
+			continue
+		}
+		_, cidr, _ := net.ParseCIDR(r.AddressPrefix)
+		length, _ := cidr.Mask.Size()
+		if length > bestLength || length == bestLength && sourcePreference[r.Source] < sourcePreference[best.Source] {
+			best, bestLength = r, length
+		}
+	}
+	if bestLength < 0 {
+		return best, fmt.Errorf("no route to %s", destination)
+	}
+	return best, nil
+}
+
+// connectedPeerAddressSpaces returns the address spaces of the peered
+// networks of a virtual network, keyed by peering name. Only connected
+// peerings carry traffic: one whose remote side was never created or was
+// deleted is Initiated or Disconnected and adds no routes.
+func connectedPeerAddressSpaces(vnet network.VirtualNetwork) map[string][]string {
+	spaces := peerAddressSpaces(vnet)
+	if vnet.VirtualNetworkPropertiesFormat == nil || vnet.VirtualNetworkPeerings == nil {
+		return spaces
+	}
+	for _, peering := range *vnet.VirtualNetworkPeerings {
+		if peering.VirtualNetworkPeeringPropertiesFormat == nil || peering.PeeringState != network.VirtualNetworkPeeringStateConnected {
+			delete(spaces, to.String(peering.Name))
+		}
+	}
+	return spaces
+}
+
+// getRouteTableByID returns the route table with a resource ID, which may
+// be in another resource group or subscription than the subnet using it.
+func getRouteTableByID(ctx context.Context, id string) (network.RouteTable, error) {
+	resource, err := azure.ParseResourceID(id)
+	if err != nil {
+		return network.RouteTable{}, err
+	}
+	routeTablesClient := getRouteTablesClient()
+	routeTablesClient.SubscriptionID = resource.SubscriptionID
+	return routeTablesClient.Get(ctx, resource.ResourceGroup, resource.ResourceName, "")
+}
+
+// PredictNextHop returns the route traffic from a subnet to an IPv4
+// address takes, from the system routes of the virtual network and the
+// routes of the subnet's route table. Routes learnt from gateways are not
+// known locally and so not considered.
+func PredictNextHop(ctx context.Context, vnetName, subnetName, destination string) (RouteEntry, error) {
+	vnet, err := GetVirtualNetwork(ctx, vnetName)
+	if err != nil {
+		return RouteEntry{}, err
+	}
+	routes := SystemRoutes(vnetAddressSpace(vnet), connectedPeerAddressSpaces(vnet))
+
+	subnet, err := GetVirtualNetworkSubnet(ctx, vnetName, subnetName)
+	if err != nil {
+		return RouteEntry{}, fmt.Errorf("cannot get subnet: %v", err)
+	}
+	if subnet.SubnetPropertiesFormat != nil && subnet.RouteTable != nil && subnet.RouteTable.ID != nil {
+		table, err := getRouteTableByID(ctx, to.String(subnet.RouteTable.ID))
+		if err != nil {
+			return RouteEntry{}, fmt.Errorf("cannot get route table: %v", err)
+		}
+		routes = append(routes, TableRoutes(table)...)
+	}
+	return NextHop(routes, destination)
+}
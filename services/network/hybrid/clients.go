@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/hybrid"
+	"github.com/Azure/azure-sdk-for-go/profiles/2017-03-09/network/mgmt/network"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// clientFactory creates network clients for stamps. It keeps one
+// authorizer per stamp, so the service principal token is acquired once
+// and refreshed by its provider rather than on every call.
+type clientFactory struct {
+	newAuthorizer func(activeDirectoryEndpoint, tokenAudience string) (autorest.Authorizer, error)
+
+	mu          sync.Mutex
+	authorizers map[hybrid.Environment]autorest.Authorizer
+}
+
+func newClientFactory(newAuthorizer func(activeDirectoryEndpoint, tokenAudience string) (autorest.Authorizer, error)) *clientFactory {
+	return &clientFactory{newAuthorizer: newAuthorizer, authorizers: map[hybrid.Environment]autorest.Authorizer{}}
+}
+
+// authorizer returns the cached authorizer of a stamp, creating it on
+// first use. Failures are not cached so that a later call can retry.
+func (f *clientFactory) authorizer(stamp hybrid.Environment) (autorest.Authorizer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if auth, ok := f.authorizers[stamp]; ok {
+		return auth, nil
+	}
+	auth, err := f.newAuthorizer(stamp.ActiveDirectoryEndpoint, stamp.TokenAudience)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate token for %s: %v", stamp.ResourceManagerEndpoint, err)
+	}
+	f.authorizers[stamp] = auth
+	return auth, nil
+}
+
+// baseClient returns an authorized client for a stamp, which the typed
+// network clients embed.
+func (f *clientFactory) baseClient(stamp hybrid.Environment) (network.BaseClient, error) {
+	auth, err := f.authorizer(stamp)
+	if err != nil {
+		return network.BaseClient{}, err
+	}
+	client := network.NewWithBaseURI(stamp.ResourceManagerEndpoint, stamp.SubscriptionID)
+	client.Authorizer = auth
+	_ = client.AddToUserAgent(config.UserAgent())
+	return client, nil
+}
+
+// clients and currentStamp are what the package functions use; tests point
+// them at a fake stamp.
+var (
+	clients      = newClientFactory(hybrid.NewAuthorizer)
+	currentStamp = hybrid.ConfiguredEnvironment
+)
+
+func getBaseClient() (network.BaseClient, error) {
+	return clients.baseClient(currentStamp())
+}
+
+func getVnetClient() (network.VirtualNetworksClient, error) {
+	client, err := getBaseClient()
+	return network.VirtualNetworksClient{BaseClient: client}, err
+}
+
+func getSubnetClient() (network.SubnetsClient, error) {
+	client, err := getBaseClient()
+	return network.SubnetsClient{BaseClient: client}, err
+}
+
+func getNsgClient() (network.SecurityGroupsClient, error) {
+	client, err := getBaseClient()
+	return network.SecurityGroupsClient{BaseClient: client}, err
+}
+
+func getIPClient() (network.PublicIPAddressesClient, error) {
+	client, err := getBaseClient()
+	return network.PublicIPAddressesClient{BaseClient: client}, err
+}
+
+func getNicClient() (network.InterfacesClient, error) {
+	client, err := getBaseClient()
+	return network.InterfacesClient{BaseClient: client}, err
+}
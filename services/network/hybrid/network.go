@@ -6,13 +6,9 @@ package network
 import (
 	"context"
 	"fmt"
-	"log"
 
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
-	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
 	"github.com/Azure/azure-sdk-for-go/profiles/2017-03-09/network/mgmt/network"
-	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
@@ -20,66 +16,13 @@ const (
 	errorPrefix = "Cannot create %v, reason: %v"
 )
 
-func getVnetClient(activeDirectoryEndpoint, tokenAudience string) network.VirtualNetworksClient {
-	token, err := iam.GetResourceManagementTokenHybrid(activeDirectoryEndpoint, tokenAudience)
-	if err != nil {
-		log.Fatalf(fmt.Sprintf(errorPrefix, "virtual network", fmt.Sprintf("Cannot generate token. Error details: %v.", err)))
-	}
-	vnetClient := network.NewVirtualNetworksClientWithBaseURI(config.Environment().ResourceManagerEndpoint, config.SubscriptionID())
-	vnetClient.Authorizer = autorest.NewBearerAuthorizer(token)
-	_ = vnetClient.AddToUserAgent(config.UserAgent())
-	return vnetClient
-}
-
-func getNsgClient(activeDirectoryEndpoint, tokenAudience string) network.SecurityGroupsClient {
-	token, err := iam.GetResourceManagementTokenHybrid(activeDirectoryEndpoint, tokenAudience)
-	if err != nil {
-		log.Fatalf(fmt.Sprintf(errorPrefix, "security group", fmt.Sprintf("Cannot generate token. Error details: %v.", err)))
-	}
-	nsgClient := network.NewSecurityGroupsClientWithBaseURI(config.Environment().ResourceManagerEndpoint, config.SubscriptionID())
-	nsgClient.Authorizer = autorest.NewBearerAuthorizer(token)
-	_ = nsgClient.AddToUserAgent(config.UserAgent())
-	return nsgClient
-}
-
-func getIPClient(activeDirectoryEndpoint, tokenAudience string) network.PublicIPAddressesClient {
-	token, err := iam.GetResourceManagementTokenHybrid(activeDirectoryEndpoint, tokenAudience)
-	if err != nil {
-		log.Fatalf(fmt.Sprintf(errorPrefix, "public IP address", fmt.Sprintf("Cannot generate token. Error details: %v.", err)))
-	}
-	ipClient := network.NewPublicIPAddressesClientWithBaseURI(config.Environment().ResourceManagerEndpoint, config.SubscriptionID())
-	ipClient.Authorizer = autorest.NewBearerAuthorizer(token)
-	_ = ipClient.AddToUserAgent(config.UserAgent())
-	return ipClient
-}
-
-func getNicClient(activeDirectoryEndpoint, tokenAudience string) network.InterfacesClient {
-	token, err := iam.GetResourceManagementTokenHybrid(activeDirectoryEndpoint, tokenAudience)
-	if err != nil {
-		log.Fatalf(fmt.Sprintf(errorPrefix, "network interface", fmt.Sprintf("Cannot generate token. Error details: %v.", err)))
-	}
-	nicClient := network.NewInterfacesClientWithBaseURI(config.Environment().ResourceManagerEndpoint, config.SubscriptionID())
-	nicClient.Authorizer = autorest.NewBearerAuthorizer(token)
-	_ = nicClient.AddToUserAgent(config.UserAgent())
-	return nicClient
-}
-
-func getSubnetClient(activeDirectoryEndpoint, tokenAudience string) network.SubnetsClient {
-	token, err := iam.GetResourceManagementTokenHybrid(activeDirectoryEndpoint, tokenAudience)
-	if err != nil {
-		log.Fatalf(fmt.Sprintf(errorPrefix, "subnet", fmt.Sprintf("Cannot generate token. Error details: %v.", err)))
-	}
-	subnetsClient := network.NewSubnetsClientWithBaseURI(config.Environment().ResourceManagerEndpoint, config.SubscriptionID())
-	subnetsClient.Authorizer = autorest.NewBearerAuthorizer(token)
-	_ = subnetsClient.AddToUserAgent(config.UserAgent())
-	return subnetsClient
-}
-
 // CreateVirtualNetworkAndSubnets creates a virtual network with one subnet
 func CreateVirtualNetworkAndSubnets(ctx context.Context, vnetName, subnetName string) (vnet network.VirtualNetwork, err error) {
 	resourceName := "virtual network and subnet"
-	environment := config.Environment()
-	vnetClient := getVnetClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	vnetClient, err := getVnetClient()
+	if err != nil {
+		return vnet, fmt.Errorf(fmt.Sprintf(errorPrefix, resourceName, err))
+	}
 	future, err := vnetClient.CreateOrUpdate(
 		ctx,
 		config.GroupName(),
@@ -116,8 +59,10 @@ func CreateVirtualNetworkAndSubnets(ctx context.Context, vnetName, subnetName st
 // CreateNetworkSecurityGroup creates a new network security group
 func CreateNetworkSecurityGroup(ctx context.Context, nsgName string) (nsg network.SecurityGroup, err error) {
 	resourceName := "security group"
-	environment := config.Environment()
-	nsgClient := getNsgClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	nsgClient, err := getNsgClient()
+	if err != nil {
+		return nsg, fmt.Errorf(fmt.Sprintf(errorPrefix, resourceName, err))
+	}
 	future, err := nsgClient.CreateOrUpdate(
 		ctx,
 		config.GroupName(),
@@ -172,8 +117,10 @@ func CreateNetworkSecurityGroup(ctx context.Context, nsgName string) (nsg networ
 // CreatePublicIP creates a new public IP
 func CreatePublicIP(ctx context.Context, ipName string) (ip network.PublicIPAddress, err error) {
 	resourceName := "public IP"
-	environment, _ := azure.EnvironmentFromURL(config.Environment().ResourceManagerEndpoint)
-	ipClient := getIPClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	ipClient, err := getIPClient()
+	if err != nil {
+		return ip, fmt.Errorf(fmt.Sprintf(errorPrefix, resourceName, err))
+	}
 	future, err := ipClient.CreateOrUpdate(
 		ctx,
 		config.GroupName(),
@@ -213,8 +160,10 @@ func CreateNetworkInterface(ctx context.Context, netInterfaceName, nsgName, vnet
 	if err != nil {
 		return nic, fmt.Errorf(fmt.Sprintf(errorPrefix, resourceName, fmt.Sprintf("failed to get ip address: %v", err)))
 	}
-	environment := config.Environment()
-	nicClient := getNicClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	nicClient, err := getNicClient()
+	if err != nil {
+		return nic, fmt.Errorf(fmt.Sprintf(errorPrefix, resourceName, err))
+	}
 	future, err := nicClient.CreateOrUpdate(
 		ctx,
 		config.GroupName(),
@@ -249,28 +198,122 @@ func CreateNetworkInterface(ctx context.Context, netInterfaceName, nsgName, vnet
 
 // GetNetworkSecurityGroup retrieves a netwrok resource group by its name
 func GetNetworkSecurityGroup(ctx context.Context, nsgName string) (network.SecurityGroup, error) {
-	environment := config.Environment()
-	nsgClient := getNsgClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	nsgClient, err := getNsgClient()
+	if err != nil {
+		return network.SecurityGroup{}, err
+	}
 	return nsgClient.Get(ctx, config.GroupName(), nsgName, "")
 }
 
 // GetVirtualNetworkSubnet retrieves a virtual netwrok subnet by its name
 func GetVirtualNetworkSubnet(ctx context.Context, vnetName string, subnetName string) (network.Subnet, error) {
-	environment := config.Environment()
-	subnetsClient := getSubnetClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	subnetsClient, err := getSubnetClient()
+	if err != nil {
+		return network.Subnet{}, err
+	}
 	return subnetsClient.Get(ctx, config.GroupName(), vnetName, subnetName, "")
 }
 
 // GetPublicIP retrieves a public IP by its name
 func GetPublicIP(ctx context.Context, ipName string) (network.PublicIPAddress, error) {
-	environment := config.Environment()
-	ipClient := getIPClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	ipClient, err := getIPClient()
+	if err != nil {
+		return network.PublicIPAddress{}, err
+	}
 	return ipClient.Get(ctx, config.GroupName(), ipName, "")
 }
 
 // GetNic retrieves a network interface by its name
 func GetNic(ctx context.Context, nicName string) (network.Interface, error) {
-	environment := config.Environment()
-	nicClient := getNicClient(environment.ActiveDirectoryEndpoint, environment.TokenAudience)
+	nicClient, err := getNicClient()
+	if err != nil {
+		return network.Interface{}, err
+	}
 	return nicClient.Get(ctx, config.GroupName(), nicName, "")
 }
+
+// DeleteVirtualNetwork deletes a virtual network and its subnets
+func DeleteVirtualNetwork(ctx context.Context, vnetName string) error {
+	vnetClient, err := getVnetClient()
+	if err != nil {
+		return err
+	}
+	future, err := vnetClient.Delete(ctx, config.GroupName(), vnetName)
+	if err != nil {
+		return fmt.Errorf("cannot delete virtual network: %v", err)
+	}
+	err = future.WaitForCompletionRef(ctx, vnetClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the vnet delete future response: %v", err)
+	}
+	return nil
+}
+
+// DeleteVirtualNetworkSubnet deletes a subnet of a virtual network
+func DeleteVirtualNetworkSubnet(ctx context.Context, vnetName, subnetName string) error {
+	subnetsClient, err := getSubnetClient()
+	if err != nil {
+		return err
+	}
+	future, err := subnetsClient.Delete(ctx, config.GroupName(), vnetName, subnetName)
+	if err != nil {
+		return fmt.Errorf("cannot delete subnet: %v", err)
+	}
+	err = future.WaitForCompletionRef(ctx, subnetsClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the subnet delete future response: %v", err)
+	}
+	return nil
+}
+
+// DeleteNetworkSecurityGroup deletes a network security group
+func DeleteNetworkSecurityGroup(ctx context.Context, nsgName string) error {
+	nsgClient, err := getNsgClient()
+	if err != nil {
+		return err
+	}
+	future, err := nsgClient.Delete(ctx, config.GroupName(), nsgName)
+	if err != nil {
+		return fmt.Errorf("cannot delete nsg: %v", err)
+	}
+	err = future.WaitForCompletionRef(ctx, nsgClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the nsg delete future response: %v", err)
+	}
+	return nil
+}
+
+// DeletePublicIP deletes a public IP
+func DeletePublicIP(ctx context.Context, ipName string) error {
+	ipClient, err := getIPClient()
+	if err != nil {
+		return err
+	}
+	future, err := ipClient.Delete(ctx, config.GroupName(), ipName)
+	if err != nil {
+		return fmt.Errorf("cannot delete public ip address: %v", err)
+	}
+	err = future.WaitForCompletionRef(ctx, ipClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the public ip address delete future response: %v", err)
+	}
+	return nil
+}
+
+// DeleteNetworkInterface deletes a network interface. Delete the VM using
+// it first.
+func DeleteNetworkInterface(ctx context.Context, nicName string) error {
+	nicClient, err := getNicClient()
+	if err != nil {
+		return err
+	}
+	future, err := nicClient.Delete(ctx, config.GroupName(), nicName)
+	if err != nil {
+		return fmt.Errorf("cannot delete nic: %v", err)
+	}
+	err = future.WaitForCompletionRef(ctx, nicClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the nic delete future response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/hybrid"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/hybrid/hybridtest"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// use points the package at a fake stamp until the returned function is
+// called.
+func use(fake *hybridtest.Stamp, newAuthorizer func(string, string) (autorest.Authorizer, error)) func() {
+	oldClients, oldStamp := clients, currentStamp
+	clients, currentStamp = newClientFactory(newAuthorizer), fake.Environment
+	return func() { clients, currentStamp = oldClients, oldStamp }
+}
+
+func nullAuthorizer(string, string) (autorest.Authorizer, error) {
+	return autorest.NullAuthorizer{}, nil
+}
+
+func TestFakeStampNetwork(t *testing.T) {
+	config.SetGroupName("rg")
+	fake := hybridtest.NewStamp()
+	defer fake.Close()
+	defer use(fake, nullAuthorizer)()
+	ctx := context.Background()
+
+	if _, err := CreateVirtualNetworkAndSubnets(ctx, "vnet1", "subnet1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateNetworkSecurityGroup(ctx, "nsg1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreatePublicIP(ctx, "ip1"); err != nil {
+		t.Fatal(err)
+	}
+	nic, err := CreateNetworkInterface(ctx, "nic1", "nsg1", "vnet1", "subnet1", "ip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network"
+	ipConfig := (*nic.IPConfigurations)[0]
+	if to.String(ipConfig.Subnet.ID) != group+"/virtualNetworks/vnet1/subnets/subnet1" || to.String(ipConfig.PublicIPAddress.ID) != group+"/publicIPAddresses/ip1" {
+		t.Errorf("unexpected nic IP configuration: %+v", ipConfig.InterfaceIPConfigurationPropertiesFormat)
+	}
+
+	if err := DeleteNetworkInterface(ctx, "nic1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeletePublicIP(ctx, "ip1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteNetworkSecurityGroup(ctx, "nsg1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteVirtualNetworkSubnet(ctx, "vnet1", "subnet1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetVirtualNetworkSubnet(ctx, "vnet1", "subnet1"); err == nil {
+		t.Errorf("expected the deleted subnet to be gone")
+	}
+	if err := DeleteVirtualNetwork(ctx, "vnet1"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := fake.IDs(); len(ids) != 0 {
+		t.Errorf("expected no resources left, got %v", ids)
+	}
+
+	want := []string{
+		"DELETE " + group + "/networkInterfaces/nic1",
+		"DELETE " + group + "/publicIPAddresses/ip1",
+		"DELETE " + group + "/networkSecurityGroups/nsg1",
+		"DELETE " + group + "/virtualNetworks/vnet1/subnets/subnet1",
+		"GET " + group + "/virtualNetworks/vnet1/subnets/subnet1",
+		"DELETE " + group + "/virtualNetworks/vnet1",
+	}
+	requests := fake.Requests()
+	got := requests[len(requests)-len(want):]
+	for i := range want {
+		if !strings.EqualFold(got[i], want[i]) {
+			t.Errorf("request %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestClientFactoryCachesTokens(t *testing.T) {
+	calls := 0
+	fail := true
+	factory := newClientFactory(func(string, string) (autorest.Authorizer, error) {
+		calls++
+		if fail {
+			return nil, errors.New("adfs unavailable")
+		}
+		return autorest.NullAuthorizer{}, nil
+	})
+	stamp := hybrid.Environment{ResourceManagerEndpoint: "https://management.local/", SubscriptionID: "sub"}
+
+	if _, err := factory.baseClient(stamp); err == nil {
+		t.Fatalf("expected the token failure to be returned")
+	}
+	fail = false
+	for i := 0; i < 3; i++ {
+		client, err := factory.baseClient(stamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.BaseURI != stamp.ResourceManagerEndpoint || client.SubscriptionID != "sub" {
+			t.Errorf("unexpected client: %s %s", client.BaseURI, client.SubscriptionID)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected a failed and a cached token, got %d token requests", calls)
+	}
+
+	other := stamp
+	other.ResourceManagerEndpoint = "https://management.other.local/"
+	if _, err := factory.baseClient(other); err != nil || calls != 3 {
+		t.Errorf("expected a token for another stamp, got %d token requests, %v", calls, err)
+	}
+}
+
+func TestFakeStampTokenFailure(t *testing.T) {
+	fake := hybridtest.NewStamp()
+	defer fake.Close()
+	defer use(fake, func(string, string) (autorest.Authorizer, error) {
+		return nil, errors.New("adfs unavailable")
+	})()
+
+	if _, err := CreatePublicIP(context.Background(), "ip1"); err == nil {
+		t.Errorf("expected the token failure to be returned")
+	}
+	if err := DeletePublicIP(context.Background(), "ip1"); err == nil {
+		t.Errorf("expected the token failure to be returned")
+	}
+	if requests := fake.Requests(); len(requests) != 0 {
+		t.Errorf("expected no requests without a token, got %v", requests)
+	}
+}
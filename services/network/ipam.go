@@ -0,0 +1,315 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// azureReservedAddresses is the number of addresses Azure keeps in every
+// subnet: the network address, the default gateway, two for Azure DNS and
+// the broadcast address.
+const azureReservedAddresses = 5
+
+// Subnet prefix lengths Azure accepts for IPv4.
+const (
+	minSubnetPrefixLength = 8
+	maxSubnetPrefixLength = 29
+)
+
+// ipv4Block is an IPv4 CIDR block as the range [first, last].
+type ipv4Block struct {
+	first, last uint32
+}
+
+func parseIPv4Block(prefix string) (ipv4Block, error) {
+	_, cidr, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return ipv4Block{}, fmt.Errorf("invalid address prefix %q", prefix)
+	}
+	ip := cidr.IP.To4()
+	if ip == nil {
+		return ipv4Block{}, fmt.Errorf("address prefix %s is not IPv4", prefix)
+	}
+	ones, _ := cidr.Mask.Size()
+	first := binary.BigEndian.Uint32(ip)
+	return ipv4Block{first: first, last: first + uint32(1<<uint(32-ones)) - 1}, nil
+}
+
+func (b ipv4Block) overlaps(o ipv4Block) bool {
+	return b.first <= o.last && o.first <= b.last
+}
+
+func (b ipv4Block) String() string {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, b.first)
+	size := uint64(b.last-b.first) + 1
+	ones := 32
+	for size > 1 {
+		size >>= 1
+		ones--
+	}
+	return ip.String() + "/" + strconv.Itoa(ones)
+}
+
+// UsableAddresses returns the number of addresses of a subnet that can be
+// assigned to resources, after Azure's five reserved addresses.
+func UsableAddresses(prefix string) (int, error) {
+	block, err := parseIPv4Block(prefix)
+	if err != nil {
+		return 0, err
+	}
+	usable := int(block.last-block.first) + 1 - azureReservedAddresses
+	if usable < 0 {
+		usable = 0
+	}
+	return usable, nil
+}
+
+// PrefixLengthForHosts returns the longest prefix length, and so the
+// smallest subnet, with room for hosts addresses besides Azure's reserved
+// ones.
+func PrefixLengthForHosts(hosts int) (int, error) {
+	for length := maxSubnetPrefixLength; length >= minSubnetPrefixLength; length-- {
+		if 1<<uint(32-length)-azureReservedAddresses >= hosts {
+			return length, nil
+		}
+	}
+	return 0, fmt.Errorf("no subnet has room for %d hosts", hosts)
+}
+
+// AddressPlan allocates subnet prefixes from the address space of a
+// virtual network. Only IPv4 is supported.
+type AddressPlan struct {
+	spaces []ipv4Block
+	used   []ipv4Block
+}
+
+// NewAddressPlan returns a plan for an address space with the given
+// prefixes, such as existing subnets, already in use.
+func NewAddressPlan(addressSpace []string, used ...string) (*AddressPlan, error) {
+	p := &AddressPlan{}
+	for _, prefix := range addressSpace {
+		block, err := parseIPv4Block(prefix)
+		if err != nil {
+			return nil, err
+		}
+		p.spaces = append(p.spaces, block)
+	}
+	for _, prefix := range used {
+		if err := p.Reserve(prefix); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Reserve marks a prefix as in use.
+func (p *AddressPlan) Reserve(prefix string) error {
+	block, err := parseIPv4Block(prefix)
+	if err != nil {
+		return err
+	}
+	p.used = append(p.used, block)
+	sort.Slice(p.used, func(i, j int) bool { return p.used[i].first < p.used[j].first })
+	return nil
+}
+
+// Allocate returns the lowest free prefix with the given length, such as
+// 24 for a /24, and reserves it.
+func (p *AddressPlan) Allocate(prefixLength int) (string, error) {
+	if prefixLength < minSubnetPrefixLength || prefixLength > maxSubnetPrefixLength {
+		return "", fmt.Errorf("subnet prefix length must be between %d and %d, got %d", minSubnetPrefixLength, maxSubnetPrefixLength, prefixLength)
+	}
+	size := uint64(1) << uint(32-prefixLength)
+	for _, space := range p.spaces {
+		// candidates are aligned to their size; skip past any block in use
+		candidate := (uint64(space.first) + size - 1) / size * size
+		for candidate+size-1 <= uint64(space.last) {
+			block := ipv4Block{first: uint32(candidate), last: uint32(candidate + size - 1)}
+			conflict := false
+			for _, used := range p.used {
+				if used.overlaps(block) {
+					candidate = (uint64(used.last) + size) / size * size
+					conflict = true
+					break
+				}
+			}
+			if !conflict {
+				p.used = append(p.used, block)
+				sort.Slice(p.used, func(i, j int) bool { return p.used[i].first < p.used[j].first })
+				return block.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no free /%d left in the address space", prefixLength)
+}
+
+// AddressOverlap is a prefix of a virtual network that overlaps the address
+// space of a peered network.
+type AddressOverlap struct {
+	Prefix       string
+	Peering      string
+	RemotePrefix string
+}
+
+func (o AddressOverlap) String() string {
+	return fmt.Sprintf("%s overlaps %s of peering %s", o.Prefix, o.RemotePrefix, o.Peering)
+}
+
+func vnetAddressSpace(vnet network.VirtualNetwork) []string {
+	if vnet.VirtualNetworkPropertiesFormat == nil || vnet.AddressSpace == nil || vnet.AddressSpace.AddressPrefixes == nil {
+		return nil
+	}
+	return *vnet.AddressSpace.AddressPrefixes
+}
+
+func subnetPrefixes(subnet network.Subnet) []string {
+	if subnet.SubnetPropertiesFormat == nil {
+		return nil
+	}
+	if subnet.AddressPrefixes != nil && len(*subnet.AddressPrefixes) > 0 {
+		return *subnet.AddressPrefixes
+	}
+	if subnet.AddressPrefix != nil {
+		return []string{*subnet.AddressPrefix}
+	}
+	return nil
+}
+
+// peerAddressSpaces returns the address space of each peered network of a
+// virtual network by peering name.
+func peerAddressSpaces(vnet network.VirtualNetwork) map[string][]string {
+	spaces := map[string][]string{}
+	if vnet.VirtualNetworkPropertiesFormat == nil || vnet.VirtualNetworkPeerings == nil {
+		return spaces
+	}
+	for _, peering := range *vnet.VirtualNetworkPeerings {
+		if peering.VirtualNetworkPeeringPropertiesFormat == nil || peering.RemoteAddressSpace == nil || peering.RemoteAddressSpace.AddressPrefixes == nil {
+			continue
+		}
+		spaces[to.String(peering.Name)] = *peering.RemoteAddressSpace.AddressPrefixes
+	}
+	return spaces
+}
+
+// peeringOverlaps returns the prefixes of the address space of a virtual
+// network that overlap those of its peered networks. IPv6 prefixes are
+// skipped.
+func peeringOverlaps(vnet network.VirtualNetwork) []AddressOverlap {
+	var overlaps []AddressOverlap
+	peers := peerAddressSpaces(vnet)
+	names := make([]string, 0, len(peers))
+	for name := range peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, prefix := range vnetAddressSpace(vnet) {
+		local, err := parseIPv4Block(prefix)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			for _, remotePrefix := range peers[name] {
+				remote, err := parseIPv4Block(remotePrefix)
+				if err == nil && local.overlaps(remote) {
+					overlaps = append(overlaps, AddressOverlap{Prefix: prefix, Peering: name, RemotePrefix: remotePrefix})
+				}
+			}
+		}
+	}
+	return overlaps
+}
+
+// addressPlanForVNet returns a plan for the IPv4 address space of a
+// virtual network with its subnets and the address spaces of its peered
+// networks in use.
+func addressPlanForVNet(vnet network.VirtualNetwork) (*AddressPlan, error) {
+	var space []string
+	for _, prefix := range vnetAddressSpace(vnet) {
+		if !strings.Contains(prefix, ":") {
+			space = append(space, prefix)
+		}
+	}
+	plan, err := NewAddressPlan(space)
+	if err != nil {
+		return nil, err
+	}
+	var used []string
+	if vnet.VirtualNetworkPropertiesFormat != nil && vnet.Subnets != nil {
+		for _, subnet := range *vnet.Subnets {
+			used = append(used, subnetPrefixes(subnet)...)
+		}
+	}
+	for _, prefixes := range peerAddressSpaces(vnet) {
+		used = append(used, prefixes...)
+	}
+	for _, prefix := range used {
+		if strings.Contains(prefix, ":") {
+			continue
+		}
+		if err := plan.Reserve(prefix); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// GetVirtualNetworkPeeringOverlaps returns the prefixes of a virtual
+// network's address space that overlap the address spaces of its peered
+// networks.
+func GetVirtualNetworkPeeringOverlaps(ctx context.Context, vnetName string) ([]AddressOverlap, error) {
+	vnet, err := GetVirtualNetwork(ctx, vnetName)
+	if err != nil {
+		return nil, err
+	}
+	return peeringOverlaps(vnet), nil
+}
+
+// AllocateSubnetPrefix returns the lowest prefix of the given length that
+// is free in a virtual network, considering its subnets and peered
+// networks. The prefix is not reserved: concurrent callers may get the
+// same one.
+func AllocateSubnetPrefix(ctx context.Context, vnetName string, prefixLength int) (string, error) {
+	vnet, err := GetVirtualNetwork(ctx, vnetName)
+	if err != nil {
+		return "", err
+	}
+	plan, err := addressPlanForVNet(vnet)
+	if err != nil {
+		return "", err
+	}
+	return plan.Allocate(prefixLength)
+}
+
+// resolveSubnetPrefix returns addressPrefix, or if it is a size such as
+// "/24", the next free prefix of that size in the virtual network.
+func resolveSubnetPrefix(ctx context.Context, vnetName, addressPrefix string) (string, error) {
+	if !strings.HasPrefix(addressPrefix, "/") {
+		return addressPrefix, nil
+	}
+	length, err := parseSubnetSize(addressPrefix)
+	if err != nil {
+		return "", err
+	}
+	return AllocateSubnetPrefix(ctx, vnetName, length)
+}
+
+// parseSubnetSize returns the prefix length of a subnet size such as "/24".
+func parseSubnetSize(size string) (int, error) {
+	length, err := strconv.Atoi(strings.TrimPrefix(size, "/"))
+	if err != nil || !strings.HasPrefix(size, "/") {
+		return 0, fmt.Errorf("invalid subnet size %q", size)
+	}
+	return length, nil
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestAddressPlanAllocate(t *testing.T) {
+	plan, err := NewAddressPlan([]string{"10.0.0.0/16", "10.1.0.0/24"}, "10.0.0.0/24", "10.0.2.0/23")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocations := []struct {
+		length int
+		want   string
+	}{
+		{24, "10.0.1.0/24"},
+		{24, "10.0.4.0/24"},
+		{26, "10.0.5.0/26"},
+		{22, "10.0.8.0/22"},
+		{25, "10.0.5.128/25"},
+		{16, ""},
+	}
+	for _, a := range allocations {
+		got, err := plan.Allocate(a.length)
+		if a.want == "" {
+			if err == nil {
+				t.Errorf("/%d: expected an error, got %s", a.length, got)
+			}
+			continue
+		}
+		if err != nil || got != a.want {
+			t.Errorf("/%d: got %s, %v, want %s", a.length, got, err, a.want)
+		}
+	}
+
+	small, _ := NewAddressPlan([]string{"10.1.0.0/24"}, "10.1.0.0/25")
+	if got, _ := small.Allocate(25); got != "10.1.0.128/25" {
+		t.Errorf("got %s, want 10.1.0.128/25", got)
+	}
+	if _, err := small.Allocate(26); err == nil {
+		t.Errorf("expected a full address space to be refused")
+	}
+	if _, err := small.Allocate(30); err == nil {
+		t.Errorf("expected a /30 to be refused")
+	}
+	if _, err := NewAddressPlan([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("expected an invalid prefix to be refused")
+	}
+}
+
+func TestSubnetCapacity(t *testing.T) {
+	for prefix, want := range map[string]int{"10.0.0.0/24": 251, "10.0.0.0/29": 3, "10.0.0.0/16": 65531} {
+		if got, err := UsableAddresses(prefix); err != nil || got != want {
+			t.Errorf("%s: got %d, %v, want %d", prefix, got, err, want)
+		}
+	}
+	for hosts, want := range map[int]int{1: 29, 3: 29, 4: 28, 251: 24, 252: 23} {
+		if got, err := PrefixLengthForHosts(hosts); err != nil || got != want {
+			t.Errorf("%d hosts: got /%d, %v, want /%d", hosts, got, err, want)
+		}
+	}
+}
+
+func TestVirtualNetworkAddressPlan(t *testing.T) {
+	peering := func(name string, prefixes ...string) network.VirtualNetworkPeering {
+		return network.VirtualNetworkPeering{
+			Name: to.StringPtr(name),
+			VirtualNetworkPeeringPropertiesFormat: &network.VirtualNetworkPeeringPropertiesFormat{
+				RemoteAddressSpace: &network.AddressSpace{AddressPrefixes: &prefixes},
+			},
+		}
+	}
+	vnet := network.VirtualNetwork{
+		VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+			AddressSpace: &network.AddressSpace{AddressPrefixes: &[]string{"10.0.0.0/16", "fd00::/48"}},
+			Subnets: &[]network.Subnet{
+				{SubnetPropertiesFormat: &network.SubnetPropertiesFormat{AddressPrefix: to.StringPtr("10.0.0.0/24")}},
+				{SubnetPropertiesFormat: &network.SubnetPropertiesFormat{AddressPrefixes: &[]string{"10.0.1.0/24", "fd00::/64"}}},
+			},
+			VirtualNetworkPeerings: &[]network.VirtualNetworkPeering{
+				peering("hub", "10.0.2.0/24", "172.16.0.0/16"),
+				peering("spoke", "192.168.0.0/16"),
+			},
+		},
+	}
+	plan, err := addressPlanForVNet(vnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := plan.Allocate(24); got != "10.0.3.0/24" {
+		t.Errorf("got %s, want 10.0.3.0/24", got)
+	}
+	want := []AddressOverlap{{Prefix: "10.0.0.0/16", Peering: "hub", RemotePrefix: "10.0.2.0/24"}}
+	if got := peeringOverlaps(vnet); !reflect.DeepEqual(got, want) {
+		t.Errorf("got overlaps %v, want %v", got, want)
+	}
+}
+
+func TestPlanSubnets(t *testing.T) {
+	subnets, err := planSubnets([]string{defaultAddressSpace}, defaultSubnetSize, "subnet1", "subnet2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range subnets {
+		got = append(got, to.String(s.Name)+" "+to.String(s.AddressPrefix))
+	}
+	if want := []string{"subnet1 10.0.0.0/24", "subnet2 10.0.1.0/24"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := planSubnets([]string{"10.0.0.0/24"}, "/25", "a", "b", "c"); err == nil {
+		t.Errorf("expected an error when the address space is full")
+	}
+	if _, err := planSubnets([]string{defaultAddressSpace}, "24", "a"); err == nil {
+		t.Errorf("expected an error for a size without a slash")
+	}
+}
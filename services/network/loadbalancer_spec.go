@@ -0,0 +1,582 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// LBFrontendSpec is a frontend IP configuration: a public IP, or a subnet
+// with an optional static private IP for internal load balancers.
+type LBFrontendSpec struct {
+	Name       string
+	PublicIPID string
+	SubnetID   string
+	PrivateIP  string
+	Zones      []string
+}
+
+// LBProbeSpec is a health probe. Path is used by HTTP and HTTPS probes.
+type LBProbeSpec struct {
+	Name            string
+	Protocol        network.ProbeProtocol
+	Port            int32
+	Path            string
+	IntervalSeconds int32
+	Count           int32
+}
+
+// LBRuleSpec is a load balancing rule. Frontend, BackendPool and Probe are
+// names of the load balancer's frontends, pools and probes; Probe is
+// optional. Protocol All with ports 0 is an HA ports rule.
+type LBRuleSpec struct {
+	Name               string
+	Frontend           string
+	BackendPool        string
+	Probe              string
+	Protocol           network.TransportProtocol
+	FrontendPort       int32
+	BackendPort        int32
+	FloatingIP         bool
+	IdleTimeoutMinutes int32
+	// DisableOutboundSNAT stops the rule from giving the pool outbound
+	// connectivity, when an outbound rule provides it instead.
+	DisableOutboundSNAT bool
+}
+
+// LBNATPoolSpec is an inbound NAT pool: a scale set referencing it gets one
+// frontend port of the range per instance, forwarded to BackendPort.
+type LBNATPoolSpec struct {
+	Name              string
+	Frontend          string
+	Protocol          network.TransportProtocol
+	FrontendPortStart int32
+	FrontendPortEnd   int32
+	BackendPort       int32
+}
+
+// LBOutboundRuleSpec is an outbound rule giving a pool SNAT through the
+// frontends' public IPs.
+type LBOutboundRuleSpec struct {
+	Name        string
+	Frontends   []string
+	BackendPool string
+	Protocol    network.LoadBalancerOutboundRuleProtocol
+	// AllocatedPorts is the number of SNAT ports per instance; 0 lets
+	// Azure choose.
+	AllocatedPorts     int32
+	IdleTimeoutMinutes int32
+}
+
+// LoadBalancerSpec describes a load balancer whose parts refer to each
+// other by name. Start from NewLoadBalancerSpec and change it with the With
+// methods.
+type LoadBalancerSpec struct {
+	Name          string
+	ResourceGroup string
+	Location      string
+	SKU           network.LoadBalancerSkuName
+	Frontends     []LBFrontendSpec
+	BackendPools  []string
+	Probes        []LBProbeSpec
+	Rules         []LBRuleSpec
+	NATPools      []LBNATPoolSpec
+	OutboundRules []LBOutboundRuleSpec
+	Tags          map[string]string
+}
+
+// NewLoadBalancerSpec returns a spec for a Basic load balancer in the
+// configured resource group and location.
+func NewLoadBalancerSpec(name string) *LoadBalancerSpec {
+	return &LoadBalancerSpec{Name: name, ResourceGroup: config.GroupName(), Location: config.Location(), SKU: network.LoadBalancerSkuNameBasic}
+}
+
+// WithResourceGroup sets the resource group of the load balancer.
+func (s *LoadBalancerSpec) WithResourceGroup(resourceGroup string) *LoadBalancerSpec {
+	s.ResourceGroup = resourceGroup
+	return s
+}
+
+// WithLocation sets the region of the load balancer.
+func (s *LoadBalancerSpec) WithLocation(location string) *LoadBalancerSpec {
+	s.Location = location
+	return s
+}
+
+// WithStandardSKU makes it a Standard load balancer, which HA ports,
+// HTTPS probes and outbound rules require. Its public IPs must be Standard
+// too.
+func (s *LoadBalancerSpec) WithStandardSKU() *LoadBalancerSpec {
+	s.SKU = network.LoadBalancerSkuNameStandard
+	return s
+}
+
+// WithPublicFrontend adds a frontend for an existing public IP.
+func (s *LoadBalancerSpec) WithPublicFrontend(name, publicIPID string) *LoadBalancerSpec {
+	s.Frontends = append(s.Frontends, LBFrontendSpec{Name: name, PublicIPID: publicIPID})
+	return s
+}
+
+// WithPrivateFrontend adds an internal frontend in a subnet, with a static
+// private IP or, if privateIP is empty, a dynamic one.
+func (s *LoadBalancerSpec) WithPrivateFrontend(name, subnetID, privateIP string, zones ...string) *LoadBalancerSpec {
+	s.Frontends = append(s.Frontends, LBFrontendSpec{Name: name, SubnetID: subnetID, PrivateIP: privateIP, Zones: zones})
+	return s
+}
+
+// WithBackendPool adds a backend address pool.
+func (s *LoadBalancerSpec) WithBackendPool(name string) *LoadBalancerSpec {
+	s.BackendPools = append(s.BackendPools, name)
+	return s
+}
+
+// WithTCPProbe adds a probe that opens a TCP connection every 15 seconds
+// and marks an instance down after 2 failures.
+func (s *LoadBalancerSpec) WithTCPProbe(name string, port int32) *LoadBalancerSpec {
+	s.Probes = append(s.Probes, LBProbeSpec{Name: name, Protocol: network.ProbeProtocolTCP, Port: port, IntervalSeconds: 15, Count: 2})
+	return s
+}
+
+// WithHTTPProbe adds a probe that expects 200 from path, with the
+// intervals of WithTCPProbe.
+func (s *LoadBalancerSpec) WithHTTPProbe(name string, port int32, path string) *LoadBalancerSpec {
+	s.Probes = append(s.Probes, LBProbeSpec{Name: name, Protocol: network.ProbeProtocolHTTP, Port: port, Path: path, IntervalSeconds: 15, Count: 2})
+	return s
+}
+
+// WithHTTPSProbe is WithHTTPProbe over TLS. It requires the Standard SKU.
+func (s *LoadBalancerSpec) WithHTTPSProbe(name string, port int32, path string) *LoadBalancerSpec {
+	s.Probes = append(s.Probes, LBProbeSpec{Name: name, Protocol: network.ProbeProtocolHTTPS, Port: port, Path: path, IntervalSeconds: 15, Count: 2})
+	return s
+}
+
+// WithRule adds a load balancing rule.
+func (s *LoadBalancerSpec) WithRule(rule LBRuleSpec) *LoadBalancerSpec {
+	s.Rules = append(s.Rules, rule)
+	return s
+}
+
+// WithHAPortsRule adds a rule balancing all ports and protocols of an
+// internal frontend, as network virtual appliances need. It requires the
+// Standard SKU.
+func (s *LoadBalancerSpec) WithHAPortsRule(name, frontend, backendPool, probe string) *LoadBalancerSpec {
+	return s.WithRule(LBRuleSpec{Name: name, Frontend: frontend, BackendPool: backendPool, Probe: probe, Protocol: network.TransportProtocolAll})
+}
+
+// WithNATPool adds an inbound NAT pool for a scale set, forwarding ports
+// frontendPortStart to frontendPortEnd to backendPort.
+func (s *LoadBalancerSpec) WithNATPool(name, frontend string, protocol network.TransportProtocol, frontendPortStart, frontendPortEnd, backendPort int32) *LoadBalancerSpec {
+	s.NATPools = append(s.NATPools, LBNATPoolSpec{
+		Name: name, Frontend: frontend, Protocol: protocol,
+		FrontendPortStart: frontendPortStart, FrontendPortEnd: frontendPortEnd, BackendPort: backendPort,
+	})
+	return s
+}
+
+// WithOutboundRule adds an outbound rule. It requires the Standard SKU.
+func (s *LoadBalancerSpec) WithOutboundRule(rule LBOutboundRuleSpec) *LoadBalancerSpec {
+	s.OutboundRules = append(s.OutboundRules, rule)
+	return s
+}
+
+// WithTag adds a tag.
+func (s *LoadBalancerSpec) WithTag(key, value string) *LoadBalancerSpec {
+	if s.Tags == nil {
+		s.Tags = map[string]string{}
+	}
+	s.Tags[key] = value
+	return s
+}
+
+// lbNames indexes the names of one kind of part of a load balancer.
+type lbNames map[string]bool
+
+func (n lbNames) add(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s has no name", kind)
+	}
+	if n[strings.ToLower(name)] {
+		return fmt.Errorf("%s %s is defined twice", kind, name)
+	}
+	n[strings.ToLower(name)] = true
+	return nil
+}
+
+func (n lbNames) check(owner, kind, name string) error {
+	if !n[strings.ToLower(name)] {
+		return fmt.Errorf("%s refers to unknown %s %q", owner, kind, name)
+	}
+	return nil
+}
+
+// frontendPorts is a range of ports of a frontend that a rule or NAT pool
+// listens on.
+type frontendPorts struct {
+	owner       string
+	frontend    string
+	protocol    network.TransportProtocol
+	first, last int32
+}
+
+func (p frontendPorts) overlaps(o frontendPorts) bool {
+	return strings.EqualFold(p.frontend, o.frontend) &&
+		(p.protocol == o.protocol || p.protocol == network.TransportProtocolAll || o.protocol == network.TransportProtocolAll) &&
+		p.first <= o.last && o.first <= p.last
+}
+
+// Validate checks that names are unique, that the frontends are either all
+// public or all internal, that rules, NAT pools and outbound rules refer to
+// defined parts, that no two of them listen on the same frontend port, that
+// rules sharing a frontend with an outbound rule disable outbound SNAT, and
+// that the SKU supports the features used.
+func (s *LoadBalancerSpec) Validate() error {
+	standard := s.SKU == network.LoadBalancerSkuNameStandard
+	if s.Name == "" {
+		return fmt.Errorf("load balancer has no name")
+	}
+	if len(s.Frontends) == 0 {
+		return fmt.Errorf("load balancer %s has no frontend", s.Name)
+	}
+
+	frontends, internal := lbNames{}, map[string]bool{}
+	for _, f := range s.Frontends {
+		if err := frontends.add("frontend", f.Name); err != nil {
+			return err
+		}
+		if (f.PublicIPID == "") == (f.SubnetID == "") {
+			return fmt.Errorf("frontend %s needs either a public IP or a subnet", f.Name)
+		}
+		if f.PublicIPID != "" && f.PrivateIP != "" {
+			return fmt.Errorf("public frontend %s cannot have a private IP", f.Name)
+		}
+		internal[strings.ToLower(f.Name)] = f.SubnetID != ""
+		if first := s.Frontends[0]; internal[strings.ToLower(f.Name)] != (first.SubnetID != "") {
+			return fmt.Errorf("load balancer %s mixes public and internal frontends %s and %s", s.Name, first.Name, f.Name)
+		}
+	}
+	pools := lbNames{}
+	for _, p := range s.BackendPools {
+		if err := pools.add("backend pool", p); err != nil {
+			return err
+		}
+	}
+	probes := lbNames{}
+	for _, p := range s.Probes {
+		if err := probes.add("probe", p.Name); err != nil {
+			return err
+		}
+		if p.Port < 1 || p.Port > 65535 {
+			return fmt.Errorf("probe %s has invalid port %d", p.Name, p.Port)
+		}
+		switch p.Protocol {
+		case network.ProbeProtocolTCP:
+			if p.Path != "" {
+				return fmt.Errorf("tcp probe %s cannot have a path", p.Name)
+			}
+		case network.ProbeProtocolHTTP, network.ProbeProtocolHTTPS:
+			if !strings.HasPrefix(p.Path, "/") {
+				return fmt.Errorf("probe %s needs a path starting with /", p.Name)
+			}
+			if p.Protocol == network.ProbeProtocolHTTPS && !standard {
+				return fmt.Errorf("https probe %s requires the Standard SKU", p.Name)
+			}
+		default:
+			return fmt.Errorf("probe %s has unknown protocol %s", p.Name, p.Protocol)
+		}
+	}
+
+	var listening []frontendPorts
+	listen := func(owner, frontend string, protocol network.TransportProtocol, first, last int32) error {
+		ports := frontendPorts{owner: owner, frontend: frontend, protocol: protocol, first: first, last: last}
+		for _, other := range listening {
+			if other.overlaps(ports) {
+				return fmt.Errorf("%s and %s both use ports of frontend %s", other.owner, owner, frontend)
+			}
+		}
+		listening = append(listening, ports)
+		return nil
+	}
+
+	rules := lbNames{}
+	for _, r := range s.Rules {
+		owner := "rule " + r.Name
+		if err := rules.add("rule", r.Name); err != nil {
+			return err
+		}
+		if err := frontends.check(owner, "frontend", r.Frontend); err != nil {
+			return err
+		}
+		if err := pools.check(owner, "backend pool", r.BackendPool); err != nil {
+			return err
+		}
+		if r.Probe != "" {
+			if err := probes.check(owner, "probe", r.Probe); err != nil {
+				return err
+			}
+		}
+		if r.Protocol == network.TransportProtocolAll {
+			if !standard || !internal[strings.ToLower(r.Frontend)] {
+				return fmt.Errorf("HA ports rule %s requires the Standard SKU and an internal frontend", r.Name)
+			}
+			if r.FrontendPort != 0 || r.BackendPort != 0 {
+				return fmt.Errorf("HA ports rule %s must use port 0", r.Name)
+			}
+			// an HA ports rule takes every port of its frontend
+			if err := listen(owner, r.Frontend, r.Protocol, 0, 65535); err != nil {
+				return err
+			}
+			continue
+		}
+		if r.Protocol != network.TransportProtocolTCP && r.Protocol != network.TransportProtocolUDP {
+			return fmt.Errorf("rule %s has unknown protocol %s", r.Name, r.Protocol)
+		}
+		if r.FrontendPort < 1 || r.FrontendPort > 65534 || r.BackendPort < 1 || r.BackendPort > 65535 {
+			return fmt.Errorf("rule %s has invalid ports %d -> %d", r.Name, r.FrontendPort, r.BackendPort)
+		}
+		if err := listen(owner, r.Frontend, r.Protocol, r.FrontendPort, r.FrontendPort); err != nil {
+			return err
+		}
+	}
+
+	natPools := lbNames{}
+	for _, p := range s.NATPools {
+		owner := "NAT pool " + p.Name
+		if err := natPools.add("NAT pool", p.Name); err != nil {
+			return err
+		}
+		if err := frontends.check(owner, "frontend", p.Frontend); err != nil {
+			return err
+		}
+		if p.Protocol != network.TransportProtocolTCP && p.Protocol != network.TransportProtocolUDP {
+			return fmt.Errorf("NAT pool %s must use Tcp or Udp", p.Name)
+		}
+		if p.FrontendPortStart < 1 || p.FrontendPortEnd > 65534 || p.FrontendPortStart > p.FrontendPortEnd || p.BackendPort < 1 || p.BackendPort > 65535 {
+			return fmt.Errorf("NAT pool %s has invalid ports %d-%d -> %d", p.Name, p.FrontendPortStart, p.FrontendPortEnd, p.BackendPort)
+		}
+		if err := listen(owner, p.Frontend, p.Protocol, p.FrontendPortStart, p.FrontendPortEnd); err != nil {
+			return err
+		}
+	}
+
+	outboundRules, outboundFrontends := lbNames{}, map[string]string{}
+	for _, o := range s.OutboundRules {
+		owner := "outbound rule " + o.Name
+		if err := outboundRules.add("outbound rule", o.Name); err != nil {
+			return err
+		}
+		if !standard {
+			return fmt.Errorf("outbound rule %s requires the Standard SKU", o.Name)
+		}
+		if len(o.Frontends) == 0 {
+			return fmt.Errorf("outbound rule %s has no frontend", o.Name)
+		}
+		for _, f := range o.Frontends {
+			if err := frontends.check(owner, "frontend", f); err != nil {
+				return err
+			}
+			if internal[strings.ToLower(f)] {
+				return fmt.Errorf("outbound rule %s needs public frontends, %s is internal", o.Name, f)
+			}
+			outboundFrontends[strings.ToLower(f)] = o.Name
+		}
+		if err := pools.check(owner, "backend pool", o.BackendPool); err != nil {
+			return err
+		}
+		if o.AllocatedPorts < 0 || o.AllocatedPorts%8 != 0 {
+			return fmt.Errorf("outbound rule %s must allocate a multiple of 8 ports", o.Name)
+		}
+	}
+	// Azure refuses rules giving SNAT through a frontend an outbound rule
+	// uses
+	for _, r := range s.Rules {
+		if o, ok := outboundFrontends[strings.ToLower(r.Frontend)]; ok && !r.DisableOutboundSNAT {
+			return fmt.Errorf("rule %s shares frontend %s with outbound rule %s and must disable outbound SNAT", r.Name, r.Frontend, o)
+		}
+	}
+	return nil
+}
+
+// loadBalancerID returns the resource ID of a load balancer.
+func loadBalancerID(subscriptionID, resourceGroupName, name string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/loadBalancers/%s", subscriptionID, resourceGroupName, name)
+}
+
+// child returns a reference to a part of the load balancer with ID lbID.
+func child(lbID, kind, name string) *network.SubResource {
+	return &network.SubResource{ID: to.StringPtr(lbID + "/" + kind + "/" + name)}
+}
+
+func optionalInt32(v int32) *int32 {
+	if v == 0 {
+		return nil
+	}
+	return to.Int32Ptr(v)
+}
+
+// LoadBalancer validates the spec and returns the API representation of
+// the load balancer with ID lbID, with references resolved to IDs.
+func (s *LoadBalancerSpec) LoadBalancer(lbID string) (lb network.LoadBalancer, err error) {
+	if err := s.Validate(); err != nil {
+		return lb, err
+	}
+	// Validate accepts names in any case; Azure needs the defined one
+	names := map[string]string{}
+	for _, f := range s.Frontends {
+		names["frontend/"+strings.ToLower(f.Name)] = f.Name
+	}
+	for _, p := range s.BackendPools {
+		names["pool/"+strings.ToLower(p)] = p
+	}
+	for _, p := range s.Probes {
+		names["probe/"+strings.ToLower(p.Name)] = p.Name
+	}
+	frontend := func(name string) *network.SubResource {
+		return child(lbID, "frontendIPConfigurations", names["frontend/"+strings.ToLower(name)])
+	}
+	pool := func(name string) *network.SubResource {
+		return child(lbID, "backendAddressPools", names["pool/"+strings.ToLower(name)])
+	}
+
+	frontends := make([]network.FrontendIPConfiguration, 0, len(s.Frontends))
+	for _, f := range s.Frontends {
+		props := &network.FrontendIPConfigurationPropertiesFormat{PrivateIPAllocationMethod: network.Dynamic}
+		if f.PublicIPID != "" {
+			props.PublicIPAddress = &network.PublicIPAddress{ID: to.StringPtr(f.PublicIPID)}
+		} else {
+			props.Subnet = &network.Subnet{ID: to.StringPtr(f.SubnetID)}
+			if f.PrivateIP != "" {
+				props.PrivateIPAllocationMethod = network.Static
+				props.PrivateIPAddress = to.StringPtr(f.PrivateIP)
+			}
+		}
+		ipConfig := network.FrontendIPConfiguration{Name: to.StringPtr(f.Name), FrontendIPConfigurationPropertiesFormat: props}
+		if len(f.Zones) > 0 {
+			zones := f.Zones
+			ipConfig.Zones = &zones
+		}
+		frontends = append(frontends, ipConfig)
+	}
+	pools := make([]network.BackendAddressPool, 0, len(s.BackendPools))
+	for _, p := range s.BackendPools {
+		pools = append(pools, network.BackendAddressPool{Name: to.StringPtr(p)})
+	}
+	probes := make([]network.Probe, 0, len(s.Probes))
+	for _, p := range s.Probes {
+		props := &network.ProbePropertiesFormat{
+			Protocol:          p.Protocol,
+			Port:              to.Int32Ptr(p.Port),
+			IntervalInSeconds: optionalInt32(p.IntervalSeconds),
+			NumberOfProbes:    optionalInt32(p.Count),
+		}
+		if p.Path != "" {
+			props.RequestPath = to.StringPtr(p.Path)
+		}
+		probes = append(probes, network.Probe{Name: to.StringPtr(p.Name), ProbePropertiesFormat: props})
+	}
+	rules := make([]network.LoadBalancingRule, 0, len(s.Rules))
+	for _, r := range s.Rules {
+		props := &network.LoadBalancingRulePropertiesFormat{
+			FrontendIPConfiguration: frontend(r.Frontend),
+			BackendAddressPool:      pool(r.BackendPool),
+			Protocol:                r.Protocol,
+			LoadDistribution:        network.LoadDistributionDefault,
+			FrontendPort:            to.Int32Ptr(r.FrontendPort),
+			BackendPort:             to.Int32Ptr(r.BackendPort),
+			IdleTimeoutInMinutes:    optionalInt32(r.IdleTimeoutMinutes),
+			EnableFloatingIP:        to.BoolPtr(r.FloatingIP),
+		}
+		if r.Probe != "" {
+			props.Probe = child(lbID, "probes", names["probe/"+strings.ToLower(r.Probe)])
+		}
+		if r.DisableOutboundSNAT {
+			props.DisableOutboundSnat = to.BoolPtr(true)
+		}
+		rules = append(rules, network.LoadBalancingRule{Name: to.StringPtr(r.Name), LoadBalancingRulePropertiesFormat: props})
+	}
+	natPools := make([]network.InboundNatPool, 0, len(s.NATPools))
+	for _, p := range s.NATPools {
+		natPools = append(natPools, network.InboundNatPool{
+			Name: to.StringPtr(p.Name),
+			InboundNatPoolPropertiesFormat: &network.InboundNatPoolPropertiesFormat{
+				FrontendIPConfiguration: frontend(p.Frontend),
+				Protocol:                p.Protocol,
+				FrontendPortRangeStart:  to.Int32Ptr(p.FrontendPortStart),
+				FrontendPortRangeEnd:    to.Int32Ptr(p.FrontendPortEnd),
+				BackendPort:             to.Int32Ptr(p.BackendPort),
+			},
+		})
+	}
+	outboundRules := make([]network.OutboundRule, 0, len(s.OutboundRules))
+	for _, o := range s.OutboundRules {
+		var refs []network.SubResource
+		for _, f := range o.Frontends {
+			refs = append(refs, *frontend(f))
+		}
+		protocol := o.Protocol
+		if protocol == "" {
+			protocol = network.LoadBalancerOutboundRuleProtocolAll
+		}
+		outboundRules = append(outboundRules, network.OutboundRule{
+			Name: to.StringPtr(o.Name),
+			OutboundRulePropertiesFormat: &network.OutboundRulePropertiesFormat{
+				FrontendIPConfigurations: &refs,
+				BackendAddressPool:       pool(o.BackendPool),
+				Protocol:                 protocol,
+				AllocatedOutboundPorts:   optionalInt32(o.AllocatedPorts),
+				IdleTimeoutInMinutes:     optionalInt32(o.IdleTimeoutMinutes),
+			},
+		})
+	}
+
+	tags := map[string]*string{}
+	for k, v := range s.Tags {
+		tags[k] = to.StringPtr(v)
+	}
+	return network.LoadBalancer{
+		Location: to.StringPtr(s.Location),
+		Sku:      &network.LoadBalancerSku{Name: s.SKU},
+		Tags:     tags,
+		LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+			FrontendIPConfigurations: &frontends,
+			BackendAddressPools:      &pools,
+			Probes:                   &probes,
+			LoadBalancingRules:       &rules,
+			InboundNatPools:          &natPools,
+			OutboundRules:            &outboundRules,
+		},
+	}, nil
+}
+
+// CreateLoadBalancerFromSpec creates or updates the load balancer a spec
+// describes, in the spec's resource group or, if it has none, the
+// configured one.
+func CreateLoadBalancerFromSpec(ctx context.Context, spec *LoadBalancerSpec) (lb network.LoadBalancer, err error) {
+	resourceGroup := spec.ResourceGroup
+	if resourceGroup == "" {
+		resourceGroup = config.GroupName()
+	}
+	loadBalancer, err := spec.LoadBalancer(loadBalancerID(config.SubscriptionID(), resourceGroup, spec.Name))
+	if err != nil {
+		return lb, err
+	}
+
+	lbClient := getLBClient()
+	future, err := lbClient.CreateOrUpdate(ctx, resourceGroup, spec.Name, loadBalancer)
+	if err != nil {
+		return lb, fmt.Errorf("cannot create load balancer: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, lbClient.Client)
+	if err != nil {
+		return lb, fmt.Errorf("cannot get load balancer create or update future response: %v", err)
+	}
+
+	return future.Result(lbClient)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+)
+
+func TestLoadBalancerSpec(t *testing.T) {
+	const lbID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/loadBalancers/lb"
+	spec := NewLoadBalancerSpec("lb").
+		WithResourceGroup("rg").
+		WithLocation("westus2").
+		WithStandardSKU().
+		WithPublicFrontend("public", "/publicIPAddresses/pip").
+		WithBackendPool("web").
+		WithHTTPSProbe("https", 443, "/healthz").
+		WithRule(LBRuleSpec{Name: "https", Frontend: "public", BackendPool: "web", Probe: "HTTPS",
+			Protocol: network.TransportProtocolTCP, FrontendPort: 443, BackendPort: 8443, DisableOutboundSNAT: true}).
+		WithNATPool("ssh", "public", network.TransportProtocolTCP, 50000, 50099, 22).
+		WithOutboundRule(LBOutboundRuleSpec{Name: "out", Frontends: []string{"public"}, BackendPool: "web", AllocatedPorts: 1024}).
+		WithTag("app", "web")
+
+	lb, err := spec.LoadBalancer(lbID)
+	if err != nil {
+		t.Fatalf("cannot build load balancer: %v", err)
+	}
+	if spec.ResourceGroup != "rg" || lb.Sku.Name != network.LoadBalancerSkuNameStandard || *lb.Location != "westus2" || *lb.Tags["app"] != "web" {
+		t.Errorf("unexpected load balancer: %+v", lb)
+	}
+	https := (*lb.LoadBalancingRules)[0]
+	if *https.Probe.ID != lbID+"/probes/https" || *https.BackendAddressPool.ID != lbID+"/backendAddressPools/web" ||
+		*https.FrontendIPConfiguration.ID != lbID+"/frontendIPConfigurations/public" || !*https.DisableOutboundSnat {
+		t.Errorf("unexpected https rule: %+v", https.LoadBalancingRulePropertiesFormat)
+	}
+	pool := (*lb.InboundNatPools)[0]
+	if *pool.FrontendPortRangeEnd != 50099 || *pool.FrontendIPConfiguration.ID != lbID+"/frontendIPConfigurations/public" {
+		t.Errorf("unexpected NAT pool: %+v", pool.InboundNatPoolPropertiesFormat)
+	}
+	out := (*lb.OutboundRules)[0]
+	if out.Protocol != network.LoadBalancerOutboundRuleProtocolAll || *out.AllocatedOutboundPorts != 1024 || len(*out.FrontendIPConfigurations) != 1 {
+		t.Errorf("unexpected outbound rule: %+v", out.OutboundRulePropertiesFormat)
+	}
+
+	nva, err := NewLoadBalancerSpec("nva").
+		WithStandardSKU().
+		WithPrivateFrontend("internal", "/subnets/nva", "10.0.1.4", "1", "2").
+		WithBackendPool("nva").
+		WithTCPProbe("tcp", 22).
+		WithHAPortsRule("ha", "internal", "nva", "tcp").
+		LoadBalancer(lbID)
+	if err != nil {
+		t.Fatalf("cannot build internal load balancer: %v", err)
+	}
+	internal := (*nva.FrontendIPConfigurations)[0]
+	if internal.PrivateIPAllocationMethod != network.Static || *internal.PrivateIPAddress != "10.0.1.4" || len(*internal.Zones) != 2 {
+		t.Errorf("unexpected internal frontend: %+v", internal.FrontendIPConfigurationPropertiesFormat)
+	}
+	if ha := (*nva.LoadBalancingRules)[0]; ha.Protocol != network.TransportProtocolAll || *ha.FrontendPort != 0 {
+		t.Errorf("unexpected HA ports rule: %+v", ha.LoadBalancingRulePropertiesFormat)
+	}
+}
+
+func TestLoadBalancerSpecValidate(t *testing.T) {
+	basic := func() *LoadBalancerSpec {
+		return NewLoadBalancerSpec("lb").
+			WithPublicFrontend("fe", "/pip").
+			WithBackendPool("pool").
+			WithHTTPProbe("http", 80, "/")
+	}
+	standard := func() *LoadBalancerSpec {
+		return basic().WithStandardSKU()
+	}
+	internal := func() *LoadBalancerSpec {
+		return NewLoadBalancerSpec("lb").
+			WithPrivateFrontend("internal", "/subnet", "").
+			WithBackendPool("pool").
+			WithHTTPProbe("http", 80, "/")
+	}
+	rule := func(name string, port int32) LBRuleSpec {
+		return LBRuleSpec{Name: name, Frontend: "fe", BackendPool: "pool", Probe: "http", Protocol: network.TransportProtocolTCP, FrontendPort: port, BackendPort: port}
+	}
+	unknownProbe := rule("r", 80)
+	unknownProbe.Probe = "missing"
+
+	noSNAT := rule("r", 80)
+	noSNAT.DisableOutboundSNAT = true
+	outbound := LBOutboundRuleSpec{Name: "out", Frontends: []string{"fe"}, BackendPool: "pool"}
+	for name, spec := range map[string]*LoadBalancerSpec{
+		"basic":    basic().WithRule(rule("r", 80)),
+		"outbound": standard().WithRule(noSNAT).WithOutboundRule(outbound),
+		"ha ports": internal().WithStandardSKU().WithHAPortsRule("ha", "internal", "pool", "http"),
+	} {
+		if err := spec.Validate(); err != nil {
+			t.Fatalf("%s: valid spec refused: %v", name, err)
+		}
+	}
+	invalid := map[string]*LoadBalancerSpec{
+		"no frontend":          NewLoadBalancerSpec("lb"),
+		"frontend without ip":  NewLoadBalancerSpec("lb").WithPublicFrontend("fe", ""),
+		"duplicate pool":       basic().WithBackendPool("POOL"),
+		"unknown probe":        basic().WithRule(unknownProbe),
+		"probe without path":   basic().WithHTTPProbe("bad", 80, ""),
+		"https on basic":       basic().WithHTTPSProbe("https", 443, "/"),
+		"shared frontend port": basic().WithRule(rule("a", 80)).WithRule(rule("b", 80)),
+		"rule in nat pool":     basic().WithRule(rule("a", 50010)).WithNATPool("ssh", "fe", network.TransportProtocolTCP, 50000, 50099, 22),
+		"mixed frontends":      basic().WithPrivateFrontend("internal", "/subnet", ""),
+		"ha ports on basic":    internal().WithHAPortsRule("ha", "internal", "pool", "http"),
+		"ha ports public":      standard().WithHAPortsRule("ha", "fe", "pool", "http"),
+		"ha ports and rule":    internal().WithStandardSKU().WithHAPortsRule("ha", "internal", "pool", "http").WithRule(LBRuleSpec{Name: "udp", Frontend: "internal", BackendPool: "pool", Protocol: network.TransportProtocolUDP, FrontendPort: 53, BackendPort: 53}),
+		"outbound on basic":    basic().WithOutboundRule(outbound),
+		"outbound internal":    internal().WithStandardSKU().WithOutboundRule(LBOutboundRuleSpec{Name: "out", Frontends: []string{"internal"}, BackendPool: "pool"}),
+		"outbound ports":       standard().WithOutboundRule(LBOutboundRuleSpec{Name: "out", Frontends: []string{"fe"}, BackendPool: "pool", AllocatedPorts: 100}),
+		"outbound snat":        standard().WithRule(rule("r", 80)).WithOutboundRule(outbound),
+		"inverted nat pool":    basic().WithNATPool("ssh", "fe", network.TransportProtocolTCP, 50099, 50000, 22),
+	}
+	for name, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// maxNatGatewayAddresses is how many public IP addresses, alone or in
+// prefixes, a NAT gateway can use.
+const maxNatGatewayAddresses = 16
+
+func getNatGatewaysClient() network.NatGatewaysClient {
+	natClient := network.NewNatGatewaysClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	natClient.Authorizer = auth
+	_ = natClient.AddToUserAgent(config.UserAgent())
+	return natClient
+}
+
+// natGateway returns a NAT gateway giving outbound connectivity through
+// Standard IPv4 public IPs and prefixes. With a zone, the gateway and its
+// addresses are in that zone.
+func natGateway(location, zone string, idleTimeoutMinutes int32, ips []network.PublicIPAddress, prefixes []network.PublicIPPrefix) (nat network.NatGateway, err error) {
+	if len(ips)+len(prefixes) == 0 {
+		return nat, fmt.Errorf("a NAT gateway needs a public IP address or prefix")
+	}
+	if idleTimeoutMinutes != 0 && (idleTimeoutMinutes < 4 || idleTimeoutMinutes > 120) {
+		return nat, fmt.Errorf("NAT gateway idle timeout must be 4 to 120 minutes")
+	}
+	inZone := func(zones *[]string) bool {
+		return zone == "" || zones == nil || len(*zones) == 1 && (*zones)[0] == zone
+	}
+
+	addresses := len(ips)
+	ipRefs := []network.SubResource{}
+	for _, ip := range ips {
+		name := to.String(ip.Name)
+		if ip.Sku == nil || ip.Sku.Name != network.PublicIPAddressSkuNameStandard {
+			return nat, fmt.Errorf("public IP %s must be Standard for a NAT gateway", name)
+		}
+		if ip.PublicIPAddressPropertiesFormat != nil && ip.PublicIPAddressVersion == network.IPv6 {
+			return nat, fmt.Errorf("public IP %s must be IPv4 for a NAT gateway", name)
+		}
+		if !inZone(ip.Zones) {
+			return nat, fmt.Errorf("public IP %s is not in zone %s of the NAT gateway", name, zone)
+		}
+		ipRefs = append(ipRefs, network.SubResource{ID: ip.ID})
+	}
+	prefixRefs := []network.SubResource{}
+	for _, prefix := range prefixes {
+		name := to.String(prefix.Name)
+		if prefix.PublicIPPrefixPropertiesFormat == nil || prefix.PublicIPAddressVersion == network.IPv6 {
+			return nat, fmt.Errorf("public IP prefix %s must be IPv4 for a NAT gateway", name)
+		}
+		size, err := prefixSize(prefix.PublicIPAddressVersion, to.Int32(prefix.PrefixLength))
+		if err != nil {
+			return nat, fmt.Errorf("public IP prefix %s: %v", name, err)
+		}
+		if !inZone(prefix.Zones) {
+			return nat, fmt.Errorf("public IP prefix %s is not in zone %s of the NAT gateway", name, zone)
+		}
+		addresses += size
+		prefixRefs = append(prefixRefs, network.SubResource{ID: prefix.ID})
+	}
+	if addresses > maxNatGatewayAddresses {
+		return nat, fmt.Errorf("a NAT gateway can use at most %d addresses, not %d", maxNatGatewayAddresses, addresses)
+	}
+
+	nat = network.NatGateway{
+		Location: to.StringPtr(location),
+		Sku:      &network.NatGatewaySku{Name: network.NatGatewaySkuNameStandard},
+		NatGatewayPropertiesFormat: &network.NatGatewayPropertiesFormat{
+			IdleTimeoutInMinutes: optionalInt32(idleTimeoutMinutes),
+			PublicIPAddresses:    &ipRefs,
+			PublicIPPrefixes:     &prefixRefs,
+		},
+	}
+	if zone != "" {
+		nat.Zones = &[]string{zone}
+	}
+	return nat, nil
+}
+
+// CreateNatGateway creates a NAT gateway using existing Standard public
+// IPs and prefixes. Subnets associated with it send all outbound traffic
+// from those addresses. zone is optional; idleTimeoutMinutes 0 keeps the
+// default of 4.
+func CreateNatGateway(ctx context.Context, natName, zone string, idleTimeoutMinutes int32, ipNames, prefixNames []string) (nat network.NatGateway, err error) {
+	var ips []network.PublicIPAddress
+	for _, name := range ipNames {
+		ip, err := GetPublicIP(ctx, name)
+		if err != nil {
+			return nat, fmt.Errorf("cannot get public ip address %s: %v", name, err)
+		}
+		ips = append(ips, ip)
+	}
+	var prefixes []network.PublicIPPrefix
+	for _, name := range prefixNames {
+		prefix, err := GetPublicIPPrefix(ctx, name)
+		if err != nil {
+			return nat, fmt.Errorf("cannot get public ip prefix %s: %v", name, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	params, err := natGateway(config.Location(), zone, idleTimeoutMinutes, ips, prefixes)
+	if err != nil {
+		return nat, err
+	}
+
+	natClient := getNatGatewaysClient()
+	future, err := natClient.CreateOrUpdate(ctx, config.GroupName(), natName, params)
+	if err != nil {
+		return nat, fmt.Errorf("cannot create nat gateway: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, natClient.Client)
+	if err != nil {
+		return nat, fmt.Errorf("cannot get nat gateway create or update future response: %v", err)
+	}
+
+	return future.Result(natClient)
+}
+
+// GetNatGateway returns an existing NAT gateway
+func GetNatGateway(ctx context.Context, natName string) (network.NatGateway, error) {
+	natClient := getNatGatewaysClient()
+	return natClient.Get(ctx, config.GroupName(), natName, "")
+}
+
+// AssociateNatGateway sends the outbound traffic of a subnet through a NAT
+// gateway. It takes precedence over load balancer outbound rules and
+// instance public IPs for outbound connections.
+func AssociateNatGateway(ctx context.Context, vnetName, subnetName, natName string) (network.Subnet, error) {
+	nat, err := GetNatGateway(ctx, natName)
+	if err != nil {
+		return network.Subnet{}, fmt.Errorf("cannot get nat gateway: %v", err)
+	}
+	return updateSubnet(ctx, vnetName, subnetName, func(subnet *network.Subnet) {
+		subnet.NatGateway = &network.SubResource{ID: nat.ID}
+	})
+}
+
+// DissociateNatGateway removes the NAT gateway of a subnet.
+func DissociateNatGateway(ctx context.Context, vnetName, subnetName string) (network.Subnet, error) {
+	return updateSubnet(ctx, vnetName, subnetName, func(subnet *network.Subnet) {
+		subnet.NatGateway = nil
+	})
+}
@@ -0,0 +1,235 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func getASGClient() network.ApplicationSecurityGroupsClient {
+	asgClient := network.NewApplicationSecurityGroupsClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	asgClient.Authorizer = auth
+	_ = asgClient.AddToUserAgent(config.UserAgent())
+	return asgClient
+}
+
+func ipConfigurations(nic network.Interface) []network.InterfaceIPConfiguration {
+	if nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil {
+		return nil
+	}
+	return *nic.IPConfigurations
+}
+
+// primaryIPConfiguration returns the primary IP configuration of a NIC,
+// which is its first one unless another is marked primary.
+func primaryIPConfiguration(nic network.Interface) (network.InterfaceIPConfiguration, bool) {
+	configs := ipConfigurations(nic)
+	for _, c := range configs {
+		if c.InterfaceIPConfigurationPropertiesFormat != nil && to.Bool(c.Primary) {
+			return c, true
+		}
+	}
+	if len(configs) == 0 {
+		return network.InterfaceIPConfiguration{}, false
+	}
+	return configs[0], true
+}
+
+// addIPConfiguration adds a secondary IP configuration to a NIC in the
+// subnet of its primary one. The private address is static when
+// privateIP is set and dynamic otherwise.
+func addIPConfiguration(nic *network.Interface, name, privateIP string, publicIP *network.PublicIPAddress) error {
+	primary, ok := primaryIPConfiguration(*nic)
+	if !ok || primary.InterfaceIPConfigurationPropertiesFormat == nil || primary.Subnet == nil {
+		return fmt.Errorf("nic %s has no primary IP configuration", to.String(nic.Name))
+	}
+	configs := ipConfigurations(*nic)
+	for _, c := range configs {
+		if strings.EqualFold(to.String(c.Name), name) {
+			return fmt.Errorf("nic %s already has IP configuration %s", to.String(nic.Name), name)
+		}
+		if privateIP != "" && c.InterfaceIPConfigurationPropertiesFormat != nil && to.String(c.PrivateIPAddress) == privateIP {
+			return fmt.Errorf("%s is already used by IP configuration %s", privateIP, to.String(c.Name))
+		}
+	}
+
+	props := &network.InterfaceIPConfigurationPropertiesFormat{
+		Subnet:                    &network.Subnet{ID: primary.Subnet.ID},
+		Primary:                   to.BoolPtr(false),
+		PrivateIPAllocationMethod: network.Dynamic,
+		PublicIPAddress:           publicIP,
+	}
+	if privateIP != "" {
+		if net.ParseIP(privateIP) == nil {
+			return fmt.Errorf("invalid private IP address %q", privateIP)
+		}
+		props.PrivateIPAllocationMethod = network.Static
+		props.PrivateIPAddress = to.StringPtr(privateIP)
+	}
+	configs = append(configs, network.InterfaceIPConfiguration{
+		Name:                                     to.StringPtr(name),
+		InterfaceIPConfigurationPropertiesFormat: props,
+	})
+	nic.IPConfigurations = &configs
+	return nil
+}
+
+// removeIPConfiguration removes a secondary IP configuration of a NIC.
+func removeIPConfiguration(nic *network.Interface, name string) error {
+	primary, _ := primaryIPConfiguration(*nic)
+	if strings.EqualFold(to.String(primary.Name), name) {
+		return fmt.Errorf("cannot remove the primary IP configuration %s of nic %s", name, to.String(nic.Name))
+	}
+	var kept []network.InterfaceIPConfiguration
+	for _, c := range ipConfigurations(*nic) {
+		if !strings.EqualFold(to.String(c.Name), name) {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == len(ipConfigurations(*nic)) {
+		return fmt.Errorf("nic %s has no IP configuration %s", to.String(nic.Name), name)
+	}
+	nic.IPConfigurations = &kept
+	return nil
+}
+
+// setApplicationSecurityGroups replaces the application security groups of
+// an IP configuration of a NIC.
+func setApplicationSecurityGroups(nic *network.Interface, ipConfigName string, asgIDs []string) error {
+	configs := ipConfigurations(*nic)
+	for i, c := range configs {
+		if !strings.EqualFold(to.String(c.Name), ipConfigName) {
+			continue
+		}
+		asgs := make([]network.ApplicationSecurityGroup, 0, len(asgIDs))
+		for _, id := range asgIDs {
+			asgs = append(asgs, network.ApplicationSecurityGroup{ID: to.StringPtr(id)})
+		}
+		if c.InterfaceIPConfigurationPropertiesFormat == nil {
+			configs[i].InterfaceIPConfigurationPropertiesFormat = &network.InterfaceIPConfigurationPropertiesFormat{}
+		}
+		configs[i].ApplicationSecurityGroups = &asgs
+		return nil
+	}
+	return fmt.Errorf("nic %s has no IP configuration %s", to.String(nic.Name), ipConfigName)
+}
+
+// updateNIC gets a NIC, changes it and puts it back.
+func updateNIC(ctx context.Context, nicName string, change func(*network.Interface) error) (nic network.Interface, err error) {
+	nic, err = GetNic(ctx, nicName)
+	if err != nil {
+		return nic, fmt.Errorf("cannot get nic: %v", err)
+	}
+	if nic.InterfacePropertiesFormat == nil {
+		nic.InterfacePropertiesFormat = &network.InterfacePropertiesFormat{}
+	}
+	if err := change(&nic); err != nil {
+		return nic, err
+	}
+
+	nicClient := getNicClient()
+	future, err := nicClient.CreateOrUpdate(ctx, config.GroupName(), nicName, nic)
+	if err != nil {
+		return nic, fmt.Errorf("cannot update nic: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, nicClient.Client)
+	if err != nil {
+		return nic, fmt.Errorf("cannot get nic create or update future response: %v", err)
+	}
+
+	return future.Result(nicClient)
+}
+
+// AddNICIPConfiguration adds a secondary IP configuration to a network
+// interface, in the subnet of its primary one. privateIP makes the private
+// address static; leave it empty for a dynamic one. ipName optionally
+// associates a public IP address.
+func AddNICIPConfiguration(ctx context.Context, nicName, ipConfigName, privateIP, ipName string) (network.Interface, error) {
+	var publicIP *network.PublicIPAddress
+	if ipName != "" {
+		ip, err := GetPublicIP(ctx, ipName)
+		if err != nil {
+			return network.Interface{}, fmt.Errorf("cannot get public ip: %v", err)
+		}
+		publicIP = &network.PublicIPAddress{ID: ip.ID}
+	}
+	return updateNIC(ctx, nicName, func(nic *network.Interface) error {
+		return addIPConfiguration(nic, ipConfigName, privateIP, publicIP)
+	})
+}
+
+// RemoveNICIPConfiguration removes a secondary IP configuration from a
+// network interface.
+func RemoveNICIPConfiguration(ctx context.Context, nicName, ipConfigName string) (network.Interface, error) {
+	return updateNIC(ctx, nicName, func(nic *network.Interface) error {
+		return removeIPConfiguration(nic, ipConfigName)
+	})
+}
+
+// SetNICAcceleratedNetworking enables or disables accelerated networking
+// on a network interface. Only some VM sizes support it, and the NIC's VM
+// must be deallocated to change it.
+func SetNICAcceleratedNetworking(ctx context.Context, nicName string, enabled bool) (network.Interface, error) {
+	return updateNIC(ctx, nicName, func(nic *network.Interface) error {
+		nic.EnableAcceleratedNetworking = to.BoolPtr(enabled)
+		return nil
+	})
+}
+
+// SetNICIPForwarding enables or disables IP forwarding on a network
+// interface, which network virtual appliances need to receive traffic not
+// addressed to them.
+func SetNICIPForwarding(ctx context.Context, nicName string, enabled bool) (network.Interface, error) {
+	return updateNIC(ctx, nicName, func(nic *network.Interface) error {
+		nic.EnableIPForwarding = to.BoolPtr(enabled)
+		return nil
+	})
+}
+
+// CreateApplicationSecurityGroup creates an application security group
+func CreateApplicationSecurityGroup(ctx context.Context, asgName string) (asg network.ApplicationSecurityGroup, err error) {
+	asgClient := getASGClient()
+	future, err := asgClient.CreateOrUpdate(ctx, config.GroupName(), asgName, network.ApplicationSecurityGroup{
+		Location: to.StringPtr(config.Location()),
+	})
+	if err != nil {
+		return asg, fmt.Errorf("cannot create application security group: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, asgClient.Client)
+	if err != nil {
+		return asg, fmt.Errorf("cannot get the application security group create or update future response: %v", err)
+	}
+
+	return future.Result(asgClient)
+}
+
+// SetNICApplicationSecurityGroups makes an IP configuration of a network
+// interface a member of exactly the given application security groups;
+// with none it leaves them all. The groups must be in the NIC's virtual
+// network's region.
+func SetNICApplicationSecurityGroups(ctx context.Context, nicName, ipConfigName string, asgNames ...string) (network.Interface, error) {
+	asgClient := getASGClient()
+	var ids []string
+	for _, name := range asgNames {
+		asg, err := asgClient.Get(ctx, config.GroupName(), name)
+		if err != nil {
+			return network.Interface{}, fmt.Errorf("cannot get application security group %s: %v", name, err)
+		}
+		ids = append(ids, to.String(asg.ID))
+	}
+	return updateNIC(ctx, nicName, func(nic *network.Interface) error {
+		return setApplicationSecurityGroups(nic, ipConfigName, ids)
+	})
+}
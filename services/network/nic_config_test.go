@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func nicWithIPConfig() network.Interface {
+	return network.Interface{
+		Name: to.StringPtr("nic"),
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+			IPConfigurations: &[]network.InterfaceIPConfiguration{{
+				Name: to.StringPtr("ipConfig1"),
+				InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+					Subnet:           &network.Subnet{ID: to.StringPtr("/subnets/web")},
+					PrivateIPAddress: to.StringPtr("10.0.0.4"),
+				},
+			}},
+		},
+	}
+}
+
+func TestAddRemoveIPConfiguration(t *testing.T) {
+	nic := nicWithIPConfig()
+	if err := addIPConfiguration(&nic, "static", "10.0.0.10", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := addIPConfiguration(&nic, "dynamic", "", &network.PublicIPAddress{ID: to.StringPtr("/pip")}); err != nil {
+		t.Fatal(err)
+	}
+	configs := ipConfigurations(nic)
+	if len(configs) != 3 {
+		t.Fatalf("got %d IP configurations, want 3", len(configs))
+	}
+	static, dynamic := configs[1], configs[2]
+	if static.PrivateIPAllocationMethod != network.Static || *static.PrivateIPAddress != "10.0.0.10" || *static.Subnet.ID != "/subnets/web" || *static.Primary {
+		t.Errorf("unexpected static IP configuration: %+v", static.InterfaceIPConfigurationPropertiesFormat)
+	}
+	if dynamic.PrivateIPAllocationMethod != network.Dynamic || dynamic.PrivateIPAddress != nil || *dynamic.PublicIPAddress.ID != "/pip" {
+		t.Errorf("unexpected dynamic IP configuration: %+v", dynamic.InterfaceIPConfigurationPropertiesFormat)
+	}
+
+	for name, err := range map[string]error{
+		"duplicate name":    addIPConfiguration(&nic, "STATIC", "", nil),
+		"duplicate ip":      addIPConfiguration(&nic, "other", "10.0.0.4", nil),
+		"invalid ip":        addIPConfiguration(&nic, "other", "10.0.0", nil),
+		"remove primary":    removeIPConfiguration(&nic, "ipConfig1"),
+		"remove unknown":    removeIPConfiguration(&nic, "missing"),
+		"asg of unknown":    setApplicationSecurityGroups(&nic, "missing", []string{"/asgs/web"}),
+		"no primary to add": addIPConfiguration(&network.Interface{}, "other", "", nil),
+	} {
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if err := removeIPConfiguration(&nic, "static"); err != nil || len(ipConfigurations(nic)) != 2 {
+		t.Errorf("cannot remove IP configuration: %v", err)
+	}
+	if err := setApplicationSecurityGroups(&nic, "dynamic", []string{"/asgs/web", "/asgs/admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if asgs := *ipConfigurations(nic)[1].ApplicationSecurityGroups; len(asgs) != 2 || *asgs[1].ID != "/asgs/admin" {
+		t.Errorf("unexpected application security groups: %+v", asgs)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func getPeeringsClient() network.VirtualNetworkPeeringsClient {
+	peeringsClient := network.NewVirtualNetworkPeeringsClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	peeringsClient.Authorizer = auth
+	_ = peeringsClient.AddToUserAgent(config.UserAgent())
+	return peeringsClient
+}
+
+// HubSpokePeering are the options of a peering between a hub and a spoke
+// virtual network.
+type HubSpokePeering struct {
+	// AllowForwardedTraffic lets each side accept traffic a network
+	// virtual appliance in the other forwarded, rather than only traffic
+	// originating there.
+	AllowForwardedTraffic bool
+	// GatewayTransit lets the spoke use the hub's VPN or ExpressRoute
+	// gateway. The hub must have a gateway and the spoke must not.
+	GatewayTransit bool
+}
+
+// peeringName is the name of the peering from one network to another.
+func peeringName(from, to string) string {
+	return from + "-to-" + to
+}
+
+// checkPeerable returns an error when the address spaces of two virtual
+// networks overlap, which Azure refuses to peer.
+func checkPeerable(a, b network.VirtualNetwork) error {
+	for _, prefixA := range vnetAddressSpace(a) {
+		blockA, err := parseIPv4Block(prefixA)
+		if err != nil {
+			continue
+		}
+		for _, prefixB := range vnetAddressSpace(b) {
+			blockB, err := parseIPv4Block(prefixB)
+			if err == nil && blockA.overlaps(blockB) {
+				return fmt.Errorf("cannot peer %s and %s: %s overlaps %s", to.String(a.Name), to.String(b.Name), prefixA, prefixB)
+			}
+		}
+	}
+	return nil
+}
+
+func createPeering(ctx context.Context, vnetName, name, remoteID string, props network.VirtualNetworkPeeringPropertiesFormat) (peering network.VirtualNetworkPeering, err error) {
+	props.AllowVirtualNetworkAccess = to.BoolPtr(true)
+	props.RemoteVirtualNetwork = &network.SubResource{ID: to.StringPtr(remoteID)}
+
+	peeringsClient := getPeeringsClient()
+	future, err := peeringsClient.CreateOrUpdate(ctx, config.GroupName(), vnetName, name, network.VirtualNetworkPeering{
+		Name:                                  to.StringPtr(name),
+		VirtualNetworkPeeringPropertiesFormat: &props,
+	})
+	if err != nil {
+		return peering, fmt.Errorf("cannot create peering %s: %v", name, err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, peeringsClient.Client)
+	if err != nil {
+		return peering, fmt.Errorf("cannot get the peering create or update future response: %v", err)
+	}
+
+	return future.Result(peeringsClient)
+}
+
+// hasPeering reports whether a virtual network has a peering with a name.
+func hasPeering(vnet network.VirtualNetwork, name string) bool {
+	if vnet.VirtualNetworkPropertiesFormat == nil || vnet.VirtualNetworkPeerings == nil {
+		return false
+	}
+	for _, p := range *vnet.VirtualNetworkPeerings {
+		if strings.EqualFold(to.String(p.Name), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func deletePeering(ctx context.Context, vnetName, name string) error {
+	peeringsClient := getPeeringsClient()
+	future, err := peeringsClient.Delete(ctx, config.GroupName(), vnetName, name)
+	if err != nil {
+		return fmt.Errorf("cannot delete peering %s: %v", name, err)
+	}
+	err = future.WaitForCompletionRef(ctx, peeringsClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the peering delete future response: %v", err)
+	}
+	return nil
+}
+
+// PeerHubAndSpoke peers a hub virtual network with a spoke in both
+// directions. Both peerings must exist for traffic to flow; the hub's is
+// created first so that the spoke can use its gateway. When the spoke's
+// peering cannot be created, the hub's is deleted again rather than left
+// Initiated, unless it existed before the call.
+func PeerHubAndSpoke(ctx context.Context, hubName, spokeName string, options HubSpokePeering) (hubToSpoke, spokeToHub network.VirtualNetworkPeering, err error) {
+	hub, err := GetVirtualNetwork(ctx, hubName)
+	if err != nil {
+		return hubToSpoke, spokeToHub, err
+	}
+	spoke, err := GetVirtualNetwork(ctx, spokeName)
+	if err != nil {
+		return hubToSpoke, spokeToHub, err
+	}
+	if err := checkPeerable(hub, spoke); err != nil {
+		return hubToSpoke, spokeToHub, err
+	}
+
+	hubPeeringExisted := hasPeering(hub, peeringName(hubName, spokeName))
+	hubToSpoke, err = createPeering(ctx, hubName, peeringName(hubName, spokeName), to.String(spoke.ID), network.VirtualNetworkPeeringPropertiesFormat{
+		AllowForwardedTraffic: to.BoolPtr(options.AllowForwardedTraffic),
+		AllowGatewayTransit:   to.BoolPtr(options.GatewayTransit),
+		UseRemoteGateways:     to.BoolPtr(false),
+	})
+	if err != nil {
+		return hubToSpoke, spokeToHub, err
+	}
+	spokeToHub, err = createPeering(ctx, spokeName, peeringName(spokeName, hubName), to.String(hub.ID), network.VirtualNetworkPeeringPropertiesFormat{
+		AllowForwardedTraffic: to.BoolPtr(options.AllowForwardedTraffic),
+		AllowGatewayTransit:   to.BoolPtr(false),
+		UseRemoteGateways:     to.BoolPtr(options.GatewayTransit),
+	})
+	if err != nil && !hubPeeringExisted {
+		if deleteErr := deletePeering(ctx, hubName, peeringName(hubName, spokeName)); deleteErr != nil {
+			return hubToSpoke, spokeToHub, fmt.Errorf("%v (cannot roll back the hub peering: %v)", err, deleteErr)
+		}
+		return network.VirtualNetworkPeering{}, spokeToHub, err
+	}
+	return hubToSpoke, spokeToHub, err
+}
+
+// GetVirtualNetworkPeering returns a peering of a virtual network
+func GetVirtualNetworkPeering(ctx context.Context, vnetName, peeringName string) (network.VirtualNetworkPeering, error) {
+	peeringsClient := getPeeringsClient()
+	return peeringsClient.Get(ctx, config.GroupName(), vnetName, peeringName)
+}
+
+// UnpeerHubAndSpoke deletes both peerings PeerHubAndSpoke created.
+func UnpeerHubAndSpoke(ctx context.Context, hubName, spokeName string) error {
+	for _, p := range [][2]string{{spokeName, hubName}, {hubName, spokeName}} {
+		if err := deletePeering(ctx, p[0], peeringName(p[0], p[1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
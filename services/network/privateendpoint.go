@@ -0,0 +1,291 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	network20200601 "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2020-06-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// Private DNS zone groups need network API version 2020-03-01 or later, so
+// private endpoints are managed with a newer API than the rest of this
+// package.
+
+func getPrivateEndpointsClient() network20200601.PrivateEndpointsClient {
+	endpointsClient := network20200601.NewPrivateEndpointsClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	endpointsClient.Authorizer = auth
+	_ = endpointsClient.AddToUserAgent(config.UserAgent())
+	return endpointsClient
+}
+
+func getPrivateDNSZoneGroupsClient() network20200601.PrivateDNSZoneGroupsClient {
+	zoneGroupsClient := network20200601.NewPrivateDNSZoneGroupsClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	zoneGroupsClient.Authorizer = auth
+	_ = zoneGroupsClient.AddToUserAgent(config.UserAgent())
+	return zoneGroupsClient
+}
+
+func getPrivateZonesClient() privatedns.PrivateZonesClient {
+	zonesClient := privatedns.NewPrivateZonesClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	zonesClient.Authorizer = auth
+	_ = zonesClient.AddToUserAgent(config.UserAgent())
+	return zonesClient
+}
+
+func getVirtualNetworkLinksClient() privatedns.VirtualNetworkLinksClient {
+	linksClient := privatedns.NewVirtualNetworkLinksClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	linksClient.Authorizer = auth
+	_ = linksClient.AddToUserAgent(config.UserAgent())
+	return linksClient
+}
+
+// privateLinkZones are the private DNS zones of the public cloud by
+// resource type and sub-resource (group ID), all lowercase.
+var privateLinkZones = map[string]map[string]string{
+	"microsoft.storage/storageaccounts": {
+		"blob":            "privatelink.blob.core.windows.net",
+		"blob_secondary":  "privatelink.blob.core.windows.net",
+		"table":           "privatelink.table.core.windows.net",
+		"table_secondary": "privatelink.table.core.windows.net",
+		"queue":           "privatelink.queue.core.windows.net",
+		"queue_secondary": "privatelink.queue.core.windows.net",
+		"file":            "privatelink.file.core.windows.net",
+		"web":             "privatelink.web.core.windows.net",
+		"web_secondary":   "privatelink.web.core.windows.net",
+		"dfs":             "privatelink.dfs.core.windows.net",
+		"dfs_secondary":   "privatelink.dfs.core.windows.net",
+	},
+	"microsoft.sql/servers": {
+		"sqlserver": "privatelink.database.windows.net",
+	},
+	"microsoft.keyvault/vaults": {
+		"vault": "privatelink.vaultcore.azure.net",
+	},
+	"microsoft.documentdb/databaseaccounts": {
+		"sql":       "privatelink.documents.azure.com",
+		"mongodb":   "privatelink.mongo.cosmos.azure.com",
+		"cassandra": "privatelink.cassandra.cosmos.azure.com",
+		"gremlin":   "privatelink.gremlin.cosmos.azure.com",
+		"table":     "privatelink.table.cosmos.azure.com",
+	},
+}
+
+// resourceType returns the lowercase provider and type of a resource ID,
+// like microsoft.storage/storageaccounts.
+func resourceType(resourceID string) (string, error) {
+	parts := strings.Split(strings.Trim(resourceID, "/"), "/")
+	for i, part := range parts {
+		if strings.EqualFold(part, "providers") && i+2 < len(parts) {
+			return strings.ToLower(parts[i+1] + "/" + parts[i+2]), nil
+		}
+	}
+	return "", fmt.Errorf("invalid resource ID %q", resourceID)
+}
+
+// PrivateDNSZoneName returns the name of the private DNS zone for private
+// endpoints to a sub-resource of a storage account, SQL server, key vault
+// or Cosmos DB account, like privatelink.blob.core.windows.net for the
+// blob sub-resource of a storage account.
+func PrivateDNSZoneName(resourceID, subResource string) (string, error) {
+	typ, err := resourceType(resourceID)
+	if err != nil {
+		return "", err
+	}
+	zones, ok := privateLinkZones[typ]
+	if !ok {
+		return "", fmt.Errorf("no private DNS zone is known for resources of type %s", typ)
+	}
+	zone, ok := zones[strings.ToLower(subResource)]
+	if !ok {
+		return "", fmt.Errorf("%s has no sub-resource %s", typ, subResource)
+	}
+	return zone, nil
+}
+
+// isNotFound reports whether a request failed because the resource does
+// not exist.
+func isNotFound(err error) bool {
+	detailed, ok := err.(autorest.DetailedError)
+	return ok && detailed.StatusCode == http.StatusNotFound
+}
+
+// CreatePrivateDNSZone creates a private DNS zone, or returns it if it
+// already exists.
+func CreatePrivateDNSZone(ctx context.Context, zoneName string) (zone privatedns.PrivateZone, err error) {
+	zonesClient := getPrivateZonesClient()
+	zone, err = zonesClient.Get(ctx, config.GroupName(), zoneName)
+	if err == nil {
+		return zone, nil
+	}
+	if !isNotFound(err) {
+		return zone, fmt.Errorf("cannot get private DNS zone: %v", err)
+	}
+
+	future, err := zonesClient.CreateOrUpdate(ctx, config.GroupName(), zoneName, privatedns.PrivateZone{
+		Location: to.StringPtr("global"),
+	}, "", "*")
+	if err != nil {
+		return zone, fmt.Errorf("cannot create private DNS zone: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, zonesClient.Client)
+	if err != nil {
+		return zone, fmt.Errorf("cannot get the private DNS zone create or update future response: %v", err)
+	}
+
+	return future.Result(zonesClient)
+}
+
+// LinkPrivateDNSZone links a private DNS zone to a virtual network so that
+// the network resolves names in the zone. Auto-registration is off; the
+// records of private endpoints are kept by their DNS zone groups.
+func LinkPrivateDNSZone(ctx context.Context, zoneName, vnetName string) (link privatedns.VirtualNetworkLink, err error) {
+	vnet, err := GetVirtualNetwork(ctx, vnetName)
+	if err != nil {
+		return link, err
+	}
+
+	linksClient := getVirtualNetworkLinksClient()
+	future, err := linksClient.CreateOrUpdate(ctx, config.GroupName(), zoneName, vnetName+"-link", privatedns.VirtualNetworkLink{
+		Location: to.StringPtr("global"),
+		VirtualNetworkLinkProperties: &privatedns.VirtualNetworkLinkProperties{
+			VirtualNetwork:      &privatedns.SubResource{ID: vnet.ID},
+			RegistrationEnabled: to.BoolPtr(false),
+		},
+	}, "", "")
+	if err != nil {
+		return link, fmt.Errorf("cannot create virtual network link: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, linksClient.Client)
+	if err != nil {
+		return link, fmt.Errorf("cannot get the virtual network link create or update future response: %v", err)
+	}
+
+	return future.Result(linksClient)
+}
+
+// privateDNSZoneGroup returns the DNS zone group that keeps the A records
+// of a private endpoint in a private DNS zone.
+func privateDNSZoneGroup(zoneName, zoneID string) network20200601.PrivateDNSZoneGroup {
+	return network20200601.PrivateDNSZoneGroup{
+		Name: to.StringPtr("default"),
+		PrivateDNSZoneGroupPropertiesFormat: &network20200601.PrivateDNSZoneGroupPropertiesFormat{
+			PrivateDNSZoneConfigs: &[]network20200601.PrivateDNSZoneConfig{{
+				Name: to.StringPtr(strings.Replace(zoneName, ".", "-", -1)),
+				PrivateDNSZonePropertiesFormat: &network20200601.PrivateDNSZonePropertiesFormat{
+					PrivateDNSZoneID: to.StringPtr(zoneID),
+				},
+			}},
+		},
+	}
+}
+
+// disablePrivateEndpointPolicies turns off network policies for private
+// endpoints in a subnet, which private endpoints require.
+func disablePrivateEndpointPolicies(ctx context.Context, vnetName, subnetName string) (network.Subnet, error) {
+	subnet, err := GetVirtualNetworkSubnet(ctx, vnetName, subnetName)
+	if err != nil {
+		return subnet, fmt.Errorf("cannot get subnet: %v", err)
+	}
+	if subnet.SubnetPropertiesFormat != nil && strings.EqualFold(to.String(subnet.PrivateEndpointNetworkPolicies), "Disabled") {
+		return subnet, nil
+	}
+	return updateSubnet(ctx, vnetName, subnetName, func(subnet *network.Subnet) {
+		subnet.PrivateEndpointNetworkPolicies = to.StringPtr("Disabled")
+	})
+}
+
+// CreatePrivateEndpointWithDNS creates a private endpoint in a subnet for a
+// sub-resource of a storage account, SQL server, key vault or Cosmos DB
+// account. The private DNS zone of the sub-resource is created if needed
+// and linked to the virtual network, and a DNS zone group on the endpoint
+// keeps the zone's A records in sync with the endpoint's addresses.
+func CreatePrivateEndpointWithDNS(ctx context.Context, endpointName, vnetName, subnetName, resourceID, subResource string) (endpoint network20200601.PrivateEndpoint, err error) {
+	zoneName, err := PrivateDNSZoneName(resourceID, subResource)
+	if err != nil {
+		return endpoint, err
+	}
+	subnet, err := disablePrivateEndpointPolicies(ctx, vnetName, subnetName)
+	if err != nil {
+		return endpoint, err
+	}
+	zone, err := CreatePrivateDNSZone(ctx, zoneName)
+	if err != nil {
+		return endpoint, err
+	}
+	if _, err := LinkPrivateDNSZone(ctx, zoneName, vnetName); err != nil {
+		return endpoint, err
+	}
+
+	endpointsClient := getPrivateEndpointsClient()
+	future, err := endpointsClient.CreateOrUpdate(ctx, config.GroupName(), endpointName, network20200601.PrivateEndpoint{
+		Location: to.StringPtr(config.Location()),
+		PrivateEndpointProperties: &network20200601.PrivateEndpointProperties{
+			Subnet: &network20200601.Subnet{ID: subnet.ID},
+			PrivateLinkServiceConnections: &[]network20200601.PrivateLinkServiceConnection{{
+				Name: to.StringPtr(endpointName),
+				PrivateLinkServiceConnectionProperties: &network20200601.PrivateLinkServiceConnectionProperties{
+					PrivateLinkServiceID: to.StringPtr(resourceID),
+					GroupIds:             &[]string{subResource},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return endpoint, fmt.Errorf("cannot create private endpoint: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, endpointsClient.Client)
+	if err != nil {
+		return endpoint, fmt.Errorf("cannot get the private endpoint create or update future response: %v", err)
+	}
+	endpoint, err = future.Result(endpointsClient)
+	if err != nil {
+		return endpoint, err
+	}
+
+	zoneGroupsClient := getPrivateDNSZoneGroupsClient()
+	groupFuture, err := zoneGroupsClient.CreateOrUpdate(ctx, config.GroupName(), endpointName, "default", privateDNSZoneGroup(zoneName, to.String(zone.ID)))
+	if err != nil {
+		return endpoint, fmt.Errorf("cannot create private DNS zone group: %v", err)
+	}
+
+	err = groupFuture.WaitForCompletionRef(ctx, zoneGroupsClient.Client)
+	if err != nil {
+		return endpoint, fmt.Errorf("cannot get the private DNS zone group create or update future response: %v", err)
+	}
+	return endpoint, nil
+}
+
+// DeletePrivateEndpoint deletes a private endpoint. Its DNS zone group and
+// the A records it kept are deleted with it; the zone and its virtual
+// network link are left for other endpoints.
+func DeletePrivateEndpoint(ctx context.Context, endpointName string) error {
+	endpointsClient := getPrivateEndpointsClient()
+	future, err := endpointsClient.Delete(ctx, config.GroupName(), endpointName)
+	if err != nil {
+		return fmt.Errorf("cannot delete private endpoint: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, endpointsClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the private endpoint delete future response: %v", err)
+	}
+	return nil
+}
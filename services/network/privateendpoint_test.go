@@ -0,0 +1,66 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestPrivateDNSZoneName(t *testing.T) {
+	const group = "/subscriptions/sub/resourceGroups/rg/providers/"
+	tests := []struct {
+		resourceID, subResource, zone string
+	}{
+		{group + "Microsoft.Storage/storageAccounts/data", "blob", "privatelink.blob.core.windows.net"},
+		{group + "Microsoft.Storage/storageAccounts/data", "dfs_secondary", "privatelink.dfs.core.windows.net"},
+		{group + "Microsoft.Sql/servers/db", "sqlServer", "privatelink.database.windows.net"},
+		{group + "Microsoft.KeyVault/vaults/kv", "vault", "privatelink.vaultcore.azure.net"},
+		{group + "Microsoft.DocumentDB/databaseAccounts/cosmos", "Sql", "privatelink.documents.azure.com"},
+		{group + "Microsoft.DocumentDB/databaseAccounts/cosmos", "MongoDB", "privatelink.mongo.cosmos.azure.com"},
+		{group + "microsoft.documentdb/databaseaccounts/cosmos", "Table", "privatelink.table.cosmos.azure.com"},
+	}
+	for _, test := range tests {
+		zone, err := PrivateDNSZoneName(test.resourceID, test.subResource)
+		if err != nil || zone != test.zone {
+			t.Errorf("%s %s: got %q, %v, want %q", test.resourceID, test.subResource, zone, err, test.zone)
+		}
+	}
+
+	for _, bad := range [][2]string{
+		{group + "Microsoft.Storage/storageAccounts/data", "vault"},
+		{group + "Microsoft.Web/sites/app", "sites"},
+		{"not-an-id", "blob"},
+	} {
+		if zone, err := PrivateDNSZoneName(bad[0], bad[1]); err == nil {
+			t.Errorf("%s %s: expected an error, got %s", bad[0], bad[1], zone)
+		}
+	}
+}
+
+func TestPrivateDNSZoneGroup(t *testing.T) {
+	group := privateDNSZoneGroup("privatelink.blob.core.windows.net", "/zones/blob")
+	configs := *group.PrivateDNSZoneConfigs
+	if len(configs) != 1 || *configs[0].Name != "privatelink-blob-core-windows-net" || *configs[0].PrivateDNSZoneID != "/zones/blob" {
+		t.Errorf("unexpected zone group: %+v", configs)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !isNotFound(autorest.DetailedError{StatusCode: http.StatusNotFound}) {
+		t.Errorf("expected a 404 to be not found")
+	}
+	for _, err := range []error{
+		autorest.DetailedError{StatusCode: http.StatusForbidden},
+		autorest.DetailedError{Original: errors.New("connection reset")},
+		errors.New("404"),
+	} {
+		if isNotFound(err) {
+			t.Errorf("%#v reported as not found", err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// dnsLabelPattern is what Azure accepts as the domain name label of a
+// public IP.
+var dnsLabelPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{1,61}[a-z0-9]$`)
+
+func getPublicIPPrefixesClient() network.PublicIPPrefixesClient {
+	prefixesClient := network.NewPublicIPPrefixesClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	prefixesClient.Authorizer = auth
+	_ = prefixesClient.AddToUserAgent(config.UserAgent())
+	return prefixesClient
+}
+
+// PublicIPSpec describes a public IP address. Start from NewPublicIPSpec
+// and change it with the With methods.
+type PublicIPSpec struct {
+	Name     string
+	Location string
+	SKU      network.PublicIPAddressSkuName
+	Version  network.IPVersion
+	Zones    []string
+	// DNSLabel gives the address the name
+	// <label>.<location>.cloudapp.azure.com.
+	DNSLabel           string
+	PrefixID           string
+	IdleTimeoutMinutes int32
+}
+
+// NewPublicIPSpec returns a spec for a Basic static IPv4 address in the
+// configured location.
+func NewPublicIPSpec(name string) *PublicIPSpec {
+	return &PublicIPSpec{Name: name, Location: config.Location(), SKU: network.PublicIPAddressSkuNameBasic, Version: network.IPv4}
+}
+
+// WithLocation sets the region of the address.
+func (s *PublicIPSpec) WithLocation(location string) *PublicIPSpec {
+	s.Location = location
+	return s
+}
+
+// WithStandardSKU makes it a Standard address, which Standard load
+// balancers, NAT gateways and zones require. Standard addresses are always
+// static and closed to inbound traffic unless an NSG allows it.
+func (s *PublicIPSpec) WithStandardSKU() *PublicIPSpec {
+	s.SKU = network.PublicIPAddressSkuNameStandard
+	return s
+}
+
+// WithIPv6 makes it an IPv6 address.
+func (s *PublicIPSpec) WithIPv6() *PublicIPSpec {
+	s.Version = network.IPv6
+	return s
+}
+
+// WithZones pins a Standard address to one availability zone, or makes it
+// zone-redundant when given all the region's zones.
+func (s *PublicIPSpec) WithZones(zones ...string) *PublicIPSpec {
+	s.Zones = zones
+	return s
+}
+
+// WithDNSLabel sets the domain name label of the address.
+func (s *PublicIPSpec) WithDNSLabel(label string) *PublicIPSpec {
+	s.DNSLabel = label
+	return s
+}
+
+// WithPrefix allocates the address from a public IP prefix, so that it
+// is one of a known contiguous range.
+func (s *PublicIPSpec) WithPrefix(prefixID string) *PublicIPSpec {
+	s.PrefixID = prefixID
+	return s
+}
+
+// WithIdleTimeout sets the TCP idle timeout of the address, from 4 to 30
+// minutes.
+func (s *PublicIPSpec) WithIdleTimeout(minutes int32) *PublicIPSpec {
+	s.IdleTimeoutMinutes = minutes
+	return s
+}
+
+// Validate checks the spec for mistakes Azure would refuse.
+func (s *PublicIPSpec) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("public IP has no name")
+	}
+	if s.Version != network.IPv4 && s.Version != network.IPv6 {
+		return fmt.Errorf("public IP %s has unknown IP version %s", s.Name, s.Version)
+	}
+	standard := s.SKU == network.PublicIPAddressSkuNameStandard
+	if !standard && s.SKU != network.PublicIPAddressSkuNameBasic {
+		return fmt.Errorf("public IP %s has unknown SKU %s", s.Name, s.SKU)
+	}
+	if len(s.Zones) > 0 && !standard {
+		return fmt.Errorf("public IP %s must be Standard to use zones", s.Name)
+	}
+	if s.PrefixID != "" && !standard {
+		return fmt.Errorf("public IP %s must be Standard to come from a prefix", s.Name)
+	}
+	if s.DNSLabel != "" && !dnsLabelPattern.MatchString(s.DNSLabel) {
+		return fmt.Errorf("public IP %s has invalid DNS label %q: use 3 to 63 lowercase letters, digits and hyphens, starting with a letter", s.Name, s.DNSLabel)
+	}
+	if s.IdleTimeoutMinutes != 0 && (s.IdleTimeoutMinutes < 4 || s.IdleTimeoutMinutes > 30) {
+		return fmt.Errorf("public IP %s idle timeout must be 4 to 30 minutes", s.Name)
+	}
+	return nil
+}
+
+// PublicIPAddress validates the spec and returns the API representation of
+// the address. Standard addresses and Basic IPv4 ones are static; Basic
+// IPv6 addresses can only be dynamic.
+func (s *PublicIPSpec) PublicIPAddress() (ip network.PublicIPAddress, err error) {
+	if err := s.Validate(); err != nil {
+		return ip, err
+	}
+	allocation := network.Static
+	if s.SKU == network.PublicIPAddressSkuNameBasic && s.Version == network.IPv6 {
+		allocation = network.Dynamic
+	}
+	ip = network.PublicIPAddress{
+		Name:     to.StringPtr(s.Name),
+		Location: to.StringPtr(s.Location),
+		Sku:      &network.PublicIPAddressSku{Name: s.SKU},
+		PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+			PublicIPAddressVersion:   s.Version,
+			PublicIPAllocationMethod: allocation,
+			IdleTimeoutInMinutes:     optionalInt32(s.IdleTimeoutMinutes),
+		},
+	}
+	if len(s.Zones) > 0 {
+		zones := append([]string(nil), s.Zones...)
+		ip.Zones = &zones
+	}
+	if s.DNSLabel != "" {
+		ip.DNSSettings = &network.PublicIPAddressDNSSettings{DomainNameLabel: to.StringPtr(s.DNSLabel)}
+	}
+	if s.PrefixID != "" {
+		ip.PublicIPPrefix = &network.SubResource{ID: to.StringPtr(s.PrefixID)}
+	}
+	return ip, nil
+}
+
+// CreatePublicIPFromSpec creates or updates the public IP a spec describes.
+func CreatePublicIPFromSpec(ctx context.Context, spec *PublicIPSpec) (ip network.PublicIPAddress, err error) {
+	address, err := spec.PublicIPAddress()
+	if err != nil {
+		return ip, err
+	}
+
+	ipClient := getIPClient()
+	future, err := ipClient.CreateOrUpdate(ctx, config.GroupName(), spec.Name, address)
+	if err != nil {
+		return ip, fmt.Errorf("cannot create public ip address: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, ipClient.Client)
+	if err != nil {
+		return ip, fmt.Errorf("cannot get public ip address create or update future response: %v", err)
+	}
+
+	return future.Result(ipClient)
+}
+
+// GetPublicIPFQDN returns the fully qualified domain name of a public IP
+// with a DNS label.
+func GetPublicIPFQDN(ctx context.Context, ipName string) (string, error) {
+	ip, err := GetPublicIP(ctx, ipName)
+	if err != nil {
+		return "", fmt.Errorf("cannot get public ip address: %v", err)
+	}
+	if ip.PublicIPAddressPropertiesFormat == nil || ip.DNSSettings == nil || ip.DNSSettings.Fqdn == nil {
+		return "", fmt.Errorf("public ip address %s has no DNS label", ipName)
+	}
+	return *ip.DNSSettings.Fqdn, nil
+}
+
+// prefixSize returns the number of addresses of a public IP prefix length.
+func prefixSize(version network.IPVersion, length int32) (int, error) {
+	bits, min := int32(32), int32(28)
+	if version == network.IPv6 {
+		bits, min = 128, 124
+	}
+	if length < min || length > bits-1 {
+		return 0, fmt.Errorf("%s public IP prefix length must be %d to %d", version, min, bits-1)
+	}
+	return 1 << uint(bits-length), nil
+}
+
+// freePrefixAddresses returns how many more addresses a public IP prefix
+// can give out.
+func freePrefixAddresses(prefix network.PublicIPPrefix) (int, error) {
+	if prefix.PublicIPPrefixPropertiesFormat == nil || prefix.PrefixLength == nil {
+		return 0, fmt.Errorf("public IP prefix %s has no length", to.String(prefix.Name))
+	}
+	size, err := prefixSize(prefix.PublicIPAddressVersion, *prefix.PrefixLength)
+	if err != nil {
+		return 0, err
+	}
+	if prefix.PublicIPAddresses != nil {
+		size -= len(*prefix.PublicIPAddresses)
+	}
+	return size, nil
+}
+
+// CreatePublicIPPrefix creates a Standard public IP prefix of 2^(32-length)
+// IPv4 addresses, with length 28 to 31, or of 2^(128-length) IPv6 ones,
+// with length 124 to 127.
+func CreatePublicIPPrefix(ctx context.Context, prefixName string, version network.IPVersion, length int32, zones ...string) (prefix network.PublicIPPrefix, err error) {
+	if _, err := prefixSize(version, length); err != nil {
+		return prefix, err
+	}
+	params := network.PublicIPPrefix{
+		Location: to.StringPtr(config.Location()),
+		Sku:      &network.PublicIPPrefixSku{Name: network.PublicIPPrefixSkuNameStandard},
+		PublicIPPrefixPropertiesFormat: &network.PublicIPPrefixPropertiesFormat{
+			PublicIPAddressVersion: version,
+			PrefixLength:           to.Int32Ptr(length),
+		},
+	}
+	if len(zones) > 0 {
+		params.Zones = &zones
+	}
+
+	prefixesClient := getPublicIPPrefixesClient()
+	future, err := prefixesClient.CreateOrUpdate(ctx, config.GroupName(), prefixName, params)
+	if err != nil {
+		return prefix, fmt.Errorf("cannot create public ip prefix: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, prefixesClient.Client)
+	if err != nil {
+		return prefix, fmt.Errorf("cannot get public ip prefix create or update future response: %v", err)
+	}
+
+	return future.Result(prefixesClient)
+}
+
+// GetPublicIPPrefix returns an existing public IP prefix
+func GetPublicIPPrefix(ctx context.Context, prefixName string) (network.PublicIPPrefix, error) {
+	prefixesClient := getPublicIPPrefixesClient()
+	return prefixesClient.Get(ctx, config.GroupName(), prefixName, "")
+}
+
+// AllocatePublicIPFromPrefix creates a Standard public IP from the next
+// free address of a prefix, with the prefix's IP version and zones.
+func AllocatePublicIPFromPrefix(ctx context.Context, prefixName, ipName string) (ip network.PublicIPAddress, err error) {
+	prefix, err := GetPublicIPPrefix(ctx, prefixName)
+	if err != nil {
+		return ip, fmt.Errorf("cannot get public ip prefix: %v", err)
+	}
+	free, err := freePrefixAddresses(prefix)
+	if err != nil {
+		return ip, err
+	}
+	if free == 0 {
+		return ip, fmt.Errorf("public ip prefix %s has no free addresses", prefixName)
+	}
+
+	spec := NewPublicIPSpec(ipName).WithStandardSKU().WithPrefix(to.String(prefix.ID))
+	if prefix.PublicIPAddressVersion == network.IPv6 {
+		spec.WithIPv6()
+	}
+	if prefix.Zones != nil {
+		spec.WithZones(*prefix.Zones...)
+	}
+	return CreatePublicIPFromSpec(ctx, spec)
+}
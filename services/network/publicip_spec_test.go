@@ -0,0 +1,121 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestPublicIPSpec(t *testing.T) {
+	ip, err := NewPublicIPSpec("web").
+		WithLocation("westus2").
+		WithStandardSKU().
+		WithZones("1").
+		WithDNSLabel("web-frontend-01").
+		WithPrefix("/publicIPPrefixes/egress").
+		WithIdleTimeout(10).
+		PublicIPAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.Sku.Name != network.PublicIPAddressSkuNameStandard || ip.PublicIPAllocationMethod != network.Static || *ip.Location != "westus2" ||
+		(*ip.Zones)[0] != "1" || *ip.DNSSettings.DomainNameLabel != "web-frontend-01" || *ip.PublicIPPrefix.ID != "/publicIPPrefixes/egress" || *ip.IdleTimeoutInMinutes != 10 {
+		t.Errorf("unexpected public IP: %+v", ip.PublicIPAddressPropertiesFormat)
+	}
+
+	ip, err = NewPublicIPSpec("v6").WithIPv6().PublicIPAddress()
+	if err != nil || ip.PublicIPAddressVersion != network.IPv6 || ip.PublicIPAllocationMethod != network.Dynamic || ip.DNSSettings != nil || ip.IdleTimeoutInMinutes != nil {
+		t.Errorf("unexpected basic IPv6 address: %+v, %v", ip.PublicIPAddressPropertiesFormat, err)
+	}
+
+	invalid := map[string]*PublicIPSpec{
+		"no name":         NewPublicIPSpec(""),
+		"zones on basic":  NewPublicIPSpec("ip").WithZones("1"),
+		"prefix on basic": NewPublicIPSpec("ip").WithPrefix("/prefix"),
+		"uppercase label": NewPublicIPSpec("ip").WithDNSLabel("Web"),
+		"short label":     NewPublicIPSpec("ip").WithDNSLabel("ab"),
+		"trailing hyphen": NewPublicIPSpec("ip").WithDNSLabel("web-"),
+		"idle timeout":    NewPublicIPSpec("ip").WithIdleTimeout(31),
+		"unknown version": &PublicIPSpec{Name: "ip", SKU: network.PublicIPAddressSkuNameBasic, Version: "IPv5"},
+	}
+	for name, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestFreePrefixAddresses(t *testing.T) {
+	prefix := network.PublicIPPrefix{PublicIPPrefixPropertiesFormat: &network.PublicIPPrefixPropertiesFormat{
+		PublicIPAddressVersion: network.IPv4,
+		PrefixLength:           to.Int32Ptr(30),
+		PublicIPAddresses:      &[]network.ReferencedPublicIPAddress{{ID: to.StringPtr("/ip1")}},
+	}}
+	if free, err := freePrefixAddresses(prefix); err != nil || free != 3 {
+		t.Errorf("got %d free addresses, %v, want 3", free, err)
+	}
+	if size, err := prefixSize(network.IPv6, 124); err != nil || size != 16 {
+		t.Errorf("got IPv6 /124 size %d, %v, want 16", size, err)
+	}
+	for _, length := range []int32{27, 32} {
+		if _, err := prefixSize(network.IPv4, length); err == nil {
+			t.Errorf("expected an error for IPv4 length %d", length)
+		}
+	}
+}
+
+func TestNatGateway(t *testing.T) {
+	standard := func(name string, zones ...string) network.PublicIPAddress {
+		ip := network.PublicIPAddress{
+			Name: to.StringPtr(name), ID: to.StringPtr("/publicIPAddresses/" + name),
+			Sku:                             &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameStandard},
+			PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{PublicIPAddressVersion: network.IPv4},
+		}
+		if len(zones) > 0 {
+			ip.Zones = &zones
+		}
+		return ip
+	}
+	prefix := func(name string, length int32) network.PublicIPPrefix {
+		return network.PublicIPPrefix{
+			Name: to.StringPtr(name), ID: to.StringPtr("/publicIPPrefixes/" + name),
+			PublicIPPrefixPropertiesFormat: &network.PublicIPPrefixPropertiesFormat{PublicIPAddressVersion: network.IPv4, PrefixLength: to.Int32Ptr(length)},
+		}
+	}
+
+	nat, err := natGateway("westus2", "1", 10, []network.PublicIPAddress{standard("a", "1")}, []network.PublicIPPrefix{prefix("p", 29)})
+	if err != nil {
+		t.Fatalf("cannot build NAT gateway: %v", err)
+	}
+	if nat.Sku.Name != network.NatGatewaySkuNameStandard || (*nat.Zones)[0] != "1" || *nat.IdleTimeoutInMinutes != 10 ||
+		*(*nat.PublicIPAddresses)[0].ID != "/publicIPAddresses/a" || *(*nat.PublicIPPrefixes)[0].ID != "/publicIPPrefixes/p" {
+		t.Errorf("unexpected NAT gateway: %+v", nat.NatGatewayPropertiesFormat)
+	}
+
+	basic := standard("basic")
+	basic.Sku.Name = network.PublicIPAddressSkuNameBasic
+	v6 := standard("v6")
+	v6.PublicIPAddressVersion = network.IPv6
+	ip := []network.PublicIPAddress{standard("a")}
+	for name, err := range map[string]error{
+		"no addresses":  errOf(natGateway("westus2", "", 0, nil, nil)),
+		"basic ip":      errOf(natGateway("westus2", "", 0, []network.PublicIPAddress{basic}, nil)),
+		"ipv6":          errOf(natGateway("westus2", "", 0, []network.PublicIPAddress{v6}, nil)),
+		"other zone":    errOf(natGateway("westus2", "1", 0, []network.PublicIPAddress{standard("a", "2")}, nil)),
+		"too many":      errOf(natGateway("westus2", "", 0, ip, []network.PublicIPPrefix{prefix("p", 28)})),
+		"idle timeout":  errOf(natGateway("westus2", "", 121, ip, nil)),
+		"prefix length": errOf(natGateway("westus2", "", 0, nil, []network.PublicIPPrefix{prefix("p", 24)})),
+	} {
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func errOf(_ network.NatGateway, err error) error {
+	return err
+}
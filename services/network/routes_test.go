@@ -0,0 +1,144 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestUserRouteValidate(t *testing.T) {
+	valid := []UserRoute{
+		{Name: "firewall", AddressPrefix: "0.0.0.0/0", NextHopType: network.RouteNextHopTypeVirtualAppliance, NextHopIPAddress: "10.0.1.4"},
+		{Name: "drop", AddressPrefix: "10.2.0.0/16", NextHopType: network.RouteNextHopTypeNone},
+	}
+	for _, r := range valid {
+		if err := r.validate(); err != nil {
+			t.Errorf("valid route refused: %v", err)
+		}
+	}
+	invalid := []UserRoute{
+		{AddressPrefix: "10.0.0.0/8", NextHopType: network.RouteNextHopTypeNone},
+		{Name: "prefix", AddressPrefix: "10.0.0.0", NextHopType: network.RouteNextHopTypeNone},
+		{Name: "appliance", AddressPrefix: "0.0.0.0/0", NextHopType: network.RouteNextHopTypeVirtualAppliance},
+		{Name: "address", AddressPrefix: "0.0.0.0/0", NextHopType: network.RouteNextHopTypeInternet, NextHopIPAddress: "10.0.1.4"},
+		{Name: "peering", AddressPrefix: "10.1.0.0/16", NextHopType: NextHopVNetPeering},
+	}
+	for _, r := range invalid {
+		if err := r.validate(); err == nil {
+			t.Errorf("expected an error for route %+v", r)
+		}
+	}
+}
+
+func TestNextHop(t *testing.T) {
+	table := network.RouteTable{RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+		Routes: &[]network.Route{
+			UserRoute{Name: "firewall", AddressPrefix: "0.0.0.0/0", NextHopType: network.RouteNextHopTypeVirtualAppliance, NextHopIPAddress: "10.0.1.4"}.route(),
+			UserRoute{Name: "spoke", AddressPrefix: "10.1.0.0/16", NextHopType: network.RouteNextHopTypeVirtualAppliance, NextHopIPAddress: "10.0.1.4"}.route(),
+			UserRoute{Name: "blackhole", AddressPrefix: "10.1.9.0/24", NextHopType: network.RouteNextHopTypeNone}.route(),
+		},
+	}}
+	routes := append(SystemRoutes([]string{"10.0.0.0/16"}, map[string][]string{"to-spoke": {"10.1.0.0/16"}, "to-shared": {"192.168.0.0/16"}}),
+		TableRoutes(table)...)
+	routes = append(routes, RouteEntry{Source: RouteSourceGateway, Name: "onprem", AddressPrefix: "172.16.0.0/12", NextHopType: network.RouteNextHopTypeVirtualNetworkGateway})
+
+	tests := []struct {
+		destination string
+		name        string
+		hop         network.RouteNextHopType
+	}{
+		{"10.0.2.5", "vnet", network.RouteNextHopTypeVnetLocal},
+		{"10.1.2.5", "spoke", network.RouteNextHopTypeVirtualAppliance},
+		{"10.1.9.5", "blackhole", network.RouteNextHopTypeNone},
+		{"192.168.3.1", "to-shared", NextHopVNetPeering},
+		{"172.20.0.1", "onprem", network.RouteNextHopTypeVirtualNetworkGateway},
+		{"10.9.0.1", "reserved", network.RouteNextHopTypeNone},
+		{"100.64.0.1", "reserved", network.RouteNextHopTypeNone},
+		{"8.8.8.8", "firewall", network.RouteNextHopTypeVirtualAppliance},
+	}
+	for _, test := range tests {
+		got, err := NextHop(routes, test.destination)
+		if err != nil {
+			t.Errorf("%s: %v", test.destination, err)
+			continue
+		}
+		if got.Name != test.name || got.NextHopType != test.hop {
+			t.Errorf("%s: got route %v, want %s via %s", test.destination, got, test.name, test.hop)
+		}
+	}
+
+	if _, err := NextHop(routes, "fd00::1"); err == nil {
+		t.Errorf("expected an error for an IPv6 destination")
+	}
+	if _, err := NextHop(nil, "10.0.0.1"); err == nil {
+		t.Errorf("expected an error without routes")
+	}
+}
+
+func TestSystemRoutesUsedReservedRange(t *testing.T) {
+	for _, r := range SystemRoutes([]string{"10.0.0.0/8"}, nil) {
+		if r.AddressPrefix == "10.0.0.0/8" && r.NextHopType == network.RouteNextHopTypeNone {
+			t.Errorf("reserved range in the address space should not be dropped")
+		}
+	}
+}
+
+func TestCheckPeerable(t *testing.T) {
+	vnet := func(name string, prefixes ...string) network.VirtualNetwork {
+		return network.VirtualNetwork{Name: to.StringPtr(name), VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+			AddressSpace: &network.AddressSpace{AddressPrefixes: &prefixes},
+		}}
+	}
+	if err := checkPeerable(vnet("hub", "10.0.0.0/16"), vnet("spoke", "10.1.0.0/16", "fd00::/48")); err != nil {
+		t.Errorf("disjoint networks refused: %v", err)
+	}
+	if err := checkPeerable(vnet("hub", "10.0.0.0/16"), vnet("spoke", "10.2.0.0/16", "10.0.128.0/17")); err == nil {
+		t.Errorf("expected an error for overlapping networks")
+	}
+}
+
+func TestConnectedPeerAddressSpaces(t *testing.T) {
+	peering := func(name string, state network.VirtualNetworkPeeringState, prefix string) network.VirtualNetworkPeering {
+		return network.VirtualNetworkPeering{Name: to.StringPtr(name), VirtualNetworkPeeringPropertiesFormat: &network.VirtualNetworkPeeringPropertiesFormat{
+			PeeringState:       state,
+			RemoteAddressSpace: &network.AddressSpace{AddressPrefixes: &[]string{prefix}},
+		}}
+	}
+	vnet := network.VirtualNetwork{VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+		AddressSpace: &network.AddressSpace{AddressPrefixes: &[]string{"10.0.0.0/16"}},
+		VirtualNetworkPeerings: &[]network.VirtualNetworkPeering{
+			peering("to-spoke", network.VirtualNetworkPeeringStateConnected, "10.1.0.0/16"),
+			peering("to-shared", network.VirtualNetworkPeeringStateInitiated, "10.2.0.0/16"),
+			peering("to-old", network.VirtualNetworkPeeringStateDisconnected, "10.3.0.0/16"),
+		},
+	}}
+	routes := SystemRoutes(vnetAddressSpace(vnet), connectedPeerAddressSpaces(vnet))
+	for destination, want := range map[string]network.RouteNextHopType{
+		"10.1.0.4": NextHopVNetPeering,
+		"10.2.0.4": network.RouteNextHopTypeNone,
+		"10.3.0.4": network.RouteNextHopTypeNone,
+	} {
+		if got, err := NextHop(routes, destination); err != nil || got.NextHopType != want {
+			t.Errorf("%s: got %v, %v, want %s", destination, got, err, want)
+		}
+	}
+	if got := connectedPeerAddressSpaces(network.VirtualNetwork{}); len(got) != 0 {
+		t.Errorf("expected no peers, got %v", got)
+	}
+}
+
+func TestHasPeering(t *testing.T) {
+	hub := network.VirtualNetwork{VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+		VirtualNetworkPeerings: &[]network.VirtualNetworkPeering{{Name: to.StringPtr("Hub-to-Spoke")}},
+	}}
+	if !hasPeering(hub, peeringName("hub", "spoke")) {
+		t.Errorf("expected the existing peering to be found")
+	}
+	if hasPeering(hub, peeringName("hub", "other")) || hasPeering(network.VirtualNetwork{}, peeringName("hub", "spoke")) {
+		t.Errorf("unexpected peering found")
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func getRouteTablesClient() network.RouteTablesClient {
+	routeTablesClient := network.NewRouteTablesClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	routeTablesClient.Authorizer = auth
+	_ = routeTablesClient.AddToUserAgent(config.UserAgent())
+	return routeTablesClient
+}
+
+func getRoutesClient() network.RoutesClient {
+	routesClient := network.NewRoutesClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	routesClient.Authorizer = auth
+	_ = routesClient.AddToUserAgent(config.UserAgent())
+	return routesClient
+}
+
+// UserRoute is a user-defined route. NextHopIPAddress is the address of the
+// network virtual appliance for VirtualAppliance routes.
+type UserRoute struct {
+	Name             string
+	AddressPrefix    string
+	NextHopType      network.RouteNextHopType
+	NextHopIPAddress string
+}
+
+func (r UserRoute) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("route to %s has no name", r.AddressPrefix)
+	}
+	if _, _, err := net.ParseCIDR(r.AddressPrefix); err != nil {
+		return fmt.Errorf("route %s has invalid address prefix %q", r.Name, r.AddressPrefix)
+	}
+	switch r.NextHopType {
+	case network.RouteNextHopTypeVirtualAppliance:
+		if net.ParseIP(r.NextHopIPAddress) == nil {
+			return fmt.Errorf("route %s needs the IP address of the virtual appliance", r.Name)
+		}
+	case network.RouteNextHopTypeInternet, network.RouteNextHopTypeNone,
+		network.RouteNextHopTypeVirtualNetworkGateway, network.RouteNextHopTypeVnetLocal:
+		if r.NextHopIPAddress != "" {
+			return fmt.Errorf("route %s cannot have a next hop address with next hop type %s", r.Name, r.NextHopType)
+		}
+	default:
+		return fmt.Errorf("route %s has unknown next hop type %s", r.Name, r.NextHopType)
+	}
+	return nil
+}
+
+func (r UserRoute) route() network.Route {
+	props := &network.RoutePropertiesFormat{
+		AddressPrefix: to.StringPtr(r.AddressPrefix),
+		NextHopType:   r.NextHopType,
+	}
+	if r.NextHopIPAddress != "" {
+		props.NextHopIPAddress = to.StringPtr(r.NextHopIPAddress)
+	}
+	return network.Route{Name: to.StringPtr(r.Name), RoutePropertiesFormat: props}
+}
+
+// CreateRouteTable creates a route table with user-defined routes. With
+// disableGatewayPropagation, subnets using it do not learn routes from
+// virtual network gateways, so a default route to a firewall cannot be
+// bypassed.
+func CreateRouteTable(ctx context.Context, tableName string, disableGatewayPropagation bool, routes ...UserRoute) (table network.RouteTable, err error) {
+	var apiRoutes []network.Route
+	for _, r := range routes {
+		if err := r.validate(); err != nil {
+			return table, err
+		}
+		apiRoutes = append(apiRoutes, r.route())
+	}
+
+	routeTablesClient := getRouteTablesClient()
+	future, err := routeTablesClient.CreateOrUpdate(ctx, config.GroupName(), tableName, network.RouteTable{
+		Location: to.StringPtr(config.Location()),
+		RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+			Routes:                     &apiRoutes,
+			DisableBgpRoutePropagation: to.BoolPtr(disableGatewayPropagation),
+		},
+	})
+	if err != nil {
+		return table, fmt.Errorf("cannot create route table: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, routeTablesClient.Client)
+	if err != nil {
+		return table, fmt.Errorf("cannot get the route table create or update future response: %v", err)
+	}
+
+	return future.Result(routeTablesClient)
+}
+
+// GetRouteTable returns an existing route table
+func GetRouteTable(ctx context.Context, tableName string) (network.RouteTable, error) {
+	routeTablesClient := getRouteTablesClient()
+	return routeTablesClient.Get(ctx, config.GroupName(), tableName, "")
+}
+
+// SetRoute creates or replaces a route of a route table.
+func SetRoute(ctx context.Context, tableName string, route UserRoute) (r network.Route, err error) {
+	if err := route.validate(); err != nil {
+		return r, err
+	}
+
+	routesClient := getRoutesClient()
+	future, err := routesClient.CreateOrUpdate(ctx, config.GroupName(), tableName, route.Name, route.route())
+	if err != nil {
+		return r, fmt.Errorf("cannot create route: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, routesClient.Client)
+	if err != nil {
+		return r, fmt.Errorf("cannot get the route create or update future response: %v", err)
+	}
+
+	return future.Result(routesClient)
+}
+
+// DeleteRoute deletes a route of a route table.
+func DeleteRoute(ctx context.Context, tableName, routeName string) error {
+	routesClient := getRoutesClient()
+	future, err := routesClient.Delete(ctx, config.GroupName(), tableName, routeName)
+	if err != nil {
+		return fmt.Errorf("cannot delete route: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, routesClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the route delete future response: %v", err)
+	}
+	return nil
+}
+
+// AssociateRouteTable makes a subnet use a route table, replacing any
+// table it used.
+func AssociateRouteTable(ctx context.Context, vnetName, subnetName, tableName string) (network.Subnet, error) {
+	table, err := GetRouteTable(ctx, tableName)
+	if err != nil {
+		return network.Subnet{}, fmt.Errorf("cannot get route table: %v", err)
+	}
+	return updateSubnet(ctx, vnetName, subnetName, func(subnet *network.Subnet) {
+		subnet.RouteTable = &network.RouteTable{ID: table.ID}
+	})
+}
+
+// DissociateRouteTable removes the route table of a subnet, which then
+// uses the system routes only.
+func DissociateRouteTable(ctx context.Context, vnetName, subnetName string) (network.Subnet, error) {
+	return updateSubnet(ctx, vnetName, subnetName, func(subnet *network.Subnet) {
+		subnet.RouteTable = nil
+	})
+}
@@ -0,0 +1,498 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+)
+
+// portRange is an inclusive range of ports.
+type portRange struct{ low, high int }
+
+func parsePortRanges(ranges []string) ([]portRange, error) {
+	var parsed []portRange
+	for _, r := range ranges {
+		if r == "*" {
+			parsed = append(parsed, portRange{0, 65535})
+			continue
+		}
+		bounds := strings.SplitN(r, "-", 2)
+		low, err := strconv.Atoi(bounds[0])
+		high := low
+		if err == nil && len(bounds) == 2 {
+			high, err = strconv.Atoi(bounds[1])
+		}
+		if err != nil || low < 0 || high > 65535 || low > high {
+			return nil, fmt.Errorf("invalid port range %q", r)
+		}
+		parsed = append(parsed, portRange{low, high})
+	}
+	return parsed, nil
+}
+
+// portsCover reports whether the union of outer contains every port of
+// inner.
+func portsCover(outer, inner []portRange) bool {
+	for _, in := range inner {
+		for next := in.low; next <= in.high; {
+			advanced := false
+			for _, out := range outer {
+				if out.low <= next && next <= out.high {
+					next = out.high + 1
+					advanced = true
+				}
+			}
+			if !advanced {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// addressPrefix is "*", a CIDR block or IP address, or a service tag such
+// as "VirtualNetwork".
+type addressPrefix struct {
+	any  bool
+	cidr *net.IPNet
+	tag  string
+}
+
+func parseAddressPrefixes(prefixes []string) ([]addressPrefix, error) {
+	var parsed []addressPrefix
+	for _, p := range prefixes {
+		switch {
+		case p == "*":
+			parsed = append(parsed, addressPrefix{any: true})
+		case strings.Contains(p, "/"):
+			_, cidr, err := net.ParseCIDR(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address prefix %q", p)
+			}
+			parsed = append(parsed, addressPrefix{cidr: cidr})
+		case net.ParseIP(p) != nil:
+			ip := net.ParseIP(p)
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			parsed = append(parsed, addressPrefix{cidr: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}})
+		case p != "" && !strings.ContainsAny(p, " ,"):
+			parsed = append(parsed, addressPrefix{tag: strings.ToLower(p)})
+		default:
+			return nil, fmt.Errorf("invalid address prefix %q", p)
+		}
+	}
+	return parsed, nil
+}
+
+// covers reports whether every address of inner is in a. Service tags are
+// opaque: a tag only covers the same tag.
+func (a addressPrefix) covers(inner addressPrefix) bool {
+	switch {
+	case a.any:
+		return true
+	case inner.any:
+		return false
+	case a.tag != "" || inner.tag != "":
+		return a.tag == inner.tag
+	}
+	innerOnes, _ := inner.cidr.Mask.Size()
+	ones, _ := a.cidr.Mask.Size()
+	return len(a.cidr.IP) == len(inner.cidr.IP) && ones <= innerOnes && a.cidr.Contains(inner.cidr.IP)
+}
+
+func addressesCover(outer, inner []addressPrefix) bool {
+	for _, in := range inner {
+		covered := false
+		for _, out := range outer {
+			covered = covered || out.covers(in)
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// parsedRule is a rule with its addresses and ports parsed.
+type parsedRule struct {
+	SecurityRuleSpec
+	sources, destinations         []addressPrefix
+	sourcePorts, destinationPorts []portRange
+}
+
+func parseRule(rule SecurityRuleSpec) (parsedRule, error) {
+	p := parsedRule{SecurityRuleSpec: rule.normalized()}
+	var err error
+	if p.sources, err = parseAddressPrefixes(p.SourceAddressPrefixes); err != nil {
+		return p, err
+	}
+	if p.destinations, err = parseAddressPrefixes(p.DestinationAddressPrefixes); err != nil {
+		return p, err
+	}
+	if p.sourcePorts, err = parsePortRanges(p.SourcePortRanges); err != nil {
+		return p, err
+	}
+	p.destinationPorts, err = parsePortRanges(p.DestinationPortRanges)
+	return p, err
+}
+
+// parseRules parses rules and sorts them by direction and priority, which
+// is the order Azure evaluates them in.
+func parseRules(rules []SecurityRuleSpec) ([]parsedRule, error) {
+	parsed := make([]parsedRule, 0, len(rules))
+	for _, rule := range rules {
+		p, err := parseRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		parsed = append(parsed, p)
+	}
+	sort.SliceStable(parsed, func(i, j int) bool {
+		if parsed[i].Direction != parsed[j].Direction {
+			return parsed[i].Direction < parsed[j].Direction
+		}
+		return parsed[i].Priority < parsed[j].Priority
+	})
+	return parsed, nil
+}
+
+// covers reports whether every flow r matches is matched by a as well.
+func (a parsedRule) covers(r parsedRule) bool {
+	return a.Direction == r.Direction &&
+		(a.Protocol == network.SecurityRuleProtocolAsterisk || a.Protocol == r.Protocol) &&
+		addressesCover(a.sources, r.sources) && addressesCover(a.destinations, r.destinations) &&
+		portsCover(a.sourcePorts, r.sourcePorts) && portsCover(a.destinationPorts, r.destinationPorts)
+}
+
+// SecurityRuleFindingKind is the kind of problem the analyser found.
+type SecurityRuleFindingKind string
+
+// Kinds of findings.
+const (
+	// FindingShadowedRule is a rule that never matches because a rule
+	// with a higher precedence matches all its traffic.
+	FindingShadowedRule SecurityRuleFindingKind = "ShadowedRule"
+	// FindingDuplicatePriority is two rules of the same direction with the
+	// same priority, which Azure rejects.
+	FindingDuplicatePriority SecurityRuleFindingKind = "DuplicatePriority"
+	// FindingBroadAdminSource is a rule allowing any source to reach an
+	// administration port.
+	FindingBroadAdminSource SecurityRuleFindingKind = "BroadAdminSource"
+)
+
+// SecurityRuleFinding is a problem with a rule, caused by Other if set.
+type SecurityRuleFinding struct {
+	Kind    SecurityRuleFindingKind
+	Rule    string
+	Other   string
+	Message string
+}
+
+// adminPorts are the ports of remote administration protocols: SSH, RDP
+// and WinRM.
+var adminPorts = map[int]string{22: "SSH", 3389: "RDP", 5985: "WinRM", 5986: "WinRM"}
+
+// isBroadSource reports whether a source prefix admits the whole internet.
+func isBroadSource(p string) bool {
+	switch strings.ToLower(p) {
+	case "*", "internet", "any", "0.0.0.0/0", "::/0":
+		return true
+	}
+	return false
+}
+
+// AnalyzeSecurityRules checks rules locally for shadowed rules, duplicate
+// priorities and administration ports open to any source. Rules must be
+// valid; see SecurityRuleSet.Validate.
+func AnalyzeSecurityRules(rules []SecurityRuleSpec) ([]SecurityRuleFinding, error) {
+	parsed, err := parseRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	var findings []SecurityRuleFinding
+	for i, r := range parsed {
+		for _, earlier := range parsed[:i] {
+			if earlier.Direction != r.Direction {
+				continue
+			}
+			if earlier.Priority == r.Priority {
+				findings = append(findings, SecurityRuleFinding{
+					Kind: FindingDuplicatePriority, Rule: r.Name, Other: earlier.Name,
+					Message: fmt.Sprintf("%s and %s both have %s priority %d", earlier.Name, r.Name, strings.ToLower(string(r.Direction)), r.Priority),
+				})
+				continue
+			}
+			if earlier.covers(r) {
+				effect := "redundant"
+				switch {
+				case earlier.Access == r.Access:
+				case earlier.Access == network.SecurityRuleAccessAllow:
+					effect = "never applied: the traffic is allowed"
+				default:
+					effect = "never applied: the traffic is denied"
+				}
+				findings = append(findings, SecurityRuleFinding{
+					Kind: FindingShadowedRule, Rule: r.Name, Other: earlier.Name,
+					Message: fmt.Sprintf("%s is shadowed by %s (priority %d) and %s", r.Name, earlier.Name, earlier.Priority, effect),
+				})
+				break
+			}
+		}
+
+		if r.Direction != network.SecurityRuleDirectionInbound || r.Access != network.SecurityRuleAccessAllow {
+			continue
+		}
+		broad := false
+		for _, p := range r.SourceAddressPrefixes {
+			broad = broad || isBroadSource(p)
+		}
+		if !broad || r.Protocol == network.SecurityRuleProtocolUDP || r.Protocol == network.SecurityRuleProtocolIcmp {
+			continue
+		}
+		var open []string
+		for port, name := range adminPorts {
+			if portsCover(r.destinationPorts, []portRange{{port, port}}) {
+				open = append(open, fmt.Sprintf("%d (%s)", port, name))
+			}
+		}
+		if len(open) > 0 {
+			sort.Strings(open)
+			findings = append(findings, SecurityRuleFinding{
+				Kind: FindingBroadAdminSource, Rule: r.Name,
+				Message: fmt.Sprintf("%s allows any source to reach %s", r.Name, strings.Join(open, ", ")),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// AnalyzeNetworkSecurityGroup runs AnalyzeSecurityRules on the rules of an
+// existing network security group.
+func AnalyzeNetworkSecurityGroup(ctx context.Context, nsgName string) ([]SecurityRuleFinding, error) {
+	nsg, err := GetNetworkSecurityGroup(ctx, nsgName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get nsg: %v", err)
+	}
+	var rules []SecurityRuleSpec
+	if nsg.SecurityGroupPropertiesFormat != nil && nsg.SecurityRules != nil {
+		for _, rule := range *nsg.SecurityRules {
+			rules = append(rules, securityRuleSpec(rule))
+		}
+	}
+	return AnalyzeSecurityRules(rules)
+}
+
+// Flow is the 5-tuple of a connection with its direction. Addresses are
+// IP addresses or service tags.
+type Flow struct {
+	Direction          network.SecurityRuleDirection
+	Protocol           network.SecurityRuleProtocol
+	SourceAddress      string
+	SourcePort         int
+	DestinationAddress string
+	DestinationPort    int
+}
+
+// FlowDecision is whether a flow is allowed and the rule deciding it.
+type FlowDecision struct {
+	Access network.SecurityRuleAccess
+	Rule   string
+	// Default is set when a default rule of the group decided.
+	Default bool
+	// Indeterminate is set, with Access empty, when whether Rule matches
+	// the flow depends on the addresses of a service tag that cannot be
+	// resolved locally.
+	Indeterminate bool
+}
+
+// defaultSecurityRules are the rules Azure adds to every network security
+// group.
+var defaultSecurityRules = []SecurityRuleSpec{
+	{Name: "AllowVnetInBound", Priority: 65000, Direction: network.SecurityRuleDirectionInbound, Access: network.SecurityRuleAccessAllow,
+		SourceAddressPrefixes: []string{"VirtualNetwork"}, DestinationAddressPrefixes: []string{"VirtualNetwork"}},
+	{Name: "AllowAzureLoadBalancerInBound", Priority: 65001, Direction: network.SecurityRuleDirectionInbound, Access: network.SecurityRuleAccessAllow,
+		SourceAddressPrefixes: []string{"AzureLoadBalancer"}},
+	{Name: "DenyAllInBound", Priority: 65500, Direction: network.SecurityRuleDirectionInbound, Access: network.SecurityRuleAccessDeny},
+	{Name: "AllowVnetOutBound", Priority: 65000, Direction: network.SecurityRuleDirectionOutbound, Access: network.SecurityRuleAccessAllow,
+		SourceAddressPrefixes: []string{"VirtualNetwork"}, DestinationAddressPrefixes: []string{"VirtualNetwork"}},
+	{Name: "AllowInternetOutBound", Priority: 65001, Direction: network.SecurityRuleDirectionOutbound, Access: network.SecurityRuleAccessAllow,
+		DestinationAddressPrefixes: []string{"Internet"}},
+	{Name: "DenyAllOutBound", Priority: 65500, Direction: network.SecurityRuleDirectionOutbound, Access: network.SecurityRuleAccessDeny},
+}
+
+// addressMatch is whether a flow matches a rule. A rule naming a service
+// tag whose addresses are unknown locally may or may not match.
+type addressMatch int
+
+const (
+	noMatch addressMatch = iota
+	match
+	unknownMatch
+)
+
+// fixedServiceTags are the service tags whose addresses are the same
+// everywhere. AzureLoadBalancer is the virtual IP of the host, from which
+// load balancer probes come.
+var fixedServiceTags = map[string]string{
+	"azureloadbalancer": "168.63.129.16/32",
+}
+
+// tagResolver resolves service tags in rules to addresses. virtualNetwork
+// holds the address prefixes the VirtualNetwork tag stands for; without
+// them neither VirtualNetwork nor Internet, everything outside it, can be
+// resolved.
+type tagResolver struct {
+	virtualNetwork []addressPrefix
+}
+
+func newTagResolver(virtualNetwork []string) (tagResolver, error) {
+	prefixes, err := parseAddressPrefixes(virtualNetwork)
+	if err != nil {
+		return tagResolver{}, err
+	}
+	for _, p := range prefixes {
+		if p.cidr == nil {
+			return tagResolver{}, fmt.Errorf("virtual network prefix %s is not a CIDR block", p.tag)
+		}
+	}
+	return tagResolver{virtualNetwork: prefixes}, nil
+}
+
+func (t tagResolver) inVirtualNetwork(ip net.IP) bool {
+	for _, p := range t.virtualNetwork {
+		if p.cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAddress reports whether an address of a flow is in a prefix. Flows
+// naming a service tag only match the same tag.
+func (t tagResolver) matchAddress(a addressPrefix, address string) addressMatch {
+	ip := net.ParseIP(address)
+	switch {
+	case a.any:
+		return match
+	case ip == nil:
+		if a.tag != "" && a.tag == strings.ToLower(address) {
+			return match
+		}
+		return noMatch
+	case a.cidr != nil:
+		if a.cidr.Contains(ip) {
+			return match
+		}
+		return noMatch
+	}
+	if fixed, ok := fixedServiceTags[a.tag]; ok {
+		_, block, _ := net.ParseCIDR(fixed)
+		if block.Contains(ip) {
+			return match
+		}
+		return noMatch
+	}
+	resolved := a.tag == "virtualnetwork" || a.tag == "internet"
+	if !resolved || len(t.virtualNetwork) == 0 {
+		return unknownMatch
+	}
+	if t.inVirtualNetwork(ip) == (a.tag == "virtualnetwork") {
+		return match
+	}
+	return noMatch
+}
+
+// matchAddresses combines the matches of the prefixes of a rule: a known
+// match wins over an unknown one.
+func (t tagResolver) matchAddresses(prefixes []addressPrefix, address string) addressMatch {
+	result := noMatch
+	for _, p := range prefixes {
+		switch t.matchAddress(p, address) {
+		case match:
+			return match
+		case unknownMatch:
+			result = unknownMatch
+		}
+	}
+	return result
+}
+
+func (t tagResolver) matchRule(r parsedRule, flow Flow) addressMatch {
+	if r.Direction != flow.Direction {
+		return noMatch
+	}
+	if r.Protocol != network.SecurityRuleProtocolAsterisk && !strings.EqualFold(string(r.Protocol), string(flow.Protocol)) {
+		return noMatch
+	}
+	if !portsCover(r.sourcePorts, []portRange{{flow.SourcePort, flow.SourcePort}}) ||
+		!portsCover(r.destinationPorts, []portRange{{flow.DestinationPort, flow.DestinationPort}}) {
+		return noMatch
+	}
+	source := t.matchAddresses(r.sources, flow.SourceAddress)
+	destination := t.matchAddresses(r.destinations, flow.DestinationAddress)
+	switch {
+	case source == noMatch || destination == noMatch:
+		return noMatch
+	case source == unknownMatch || destination == unknownMatch:
+		return unknownMatch
+	}
+	return match
+}
+
+// VirtualNetworkTagPrefixes returns the address prefixes the
+// VirtualNetwork service tag stands for in a virtual network: its own
+// address space and those of its peered networks.
+func VirtualNetworkTagPrefixes(vnet network.VirtualNetwork) []string {
+	prefixes := append([]string(nil), vnetAddressSpace(vnet)...)
+	peers := peerAddressSpaces(vnet)
+	names := make([]string, 0, len(peers))
+	for name := range peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prefixes = append(prefixes, peers[name]...)
+	}
+	return prefixes
+}
+
+// EffectiveAccess evaluates rules, followed by the default rules, for a
+// flow the way Azure does: the matching rule with the lowest priority
+// number decides. virtualNetwork holds the address prefixes the
+// VirtualNetwork service tag stands for, such as VirtualNetworkTagPrefixes
+// returns. When the first rule that may match names a service tag that
+// cannot be resolved, the decision is indeterminate.
+func EffectiveAccess(rules []SecurityRuleSpec, virtualNetwork []string, flow Flow) (FlowDecision, error) {
+	if flow.Direction == "" {
+		flow.Direction = network.SecurityRuleDirectionInbound
+	}
+	tags, err := newTagResolver(virtualNetwork)
+	if err != nil {
+		return FlowDecision{}, err
+	}
+	parsed, err := parseRules(rules)
+	if err != nil {
+		return FlowDecision{}, err
+	}
+	defaults, _ := parseRules(defaultSecurityRules)
+	for i, r := range append(parsed, defaults...) {
+		isDefault := i >= len(parsed)
+		switch tags.matchRule(r, flow) {
+		case match:
+			return FlowDecision{Access: r.Access, Rule: r.Name, Default: isDefault}, nil
+		case unknownMatch:
+			return FlowDecision{Rule: r.Name, Default: isDefault, Indeterminate: true}, nil
+		}
+	}
+	return FlowDecision{}, fmt.Errorf("no rule matches the flow")
+}
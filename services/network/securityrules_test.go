@@ -0,0 +1,188 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const webRuleSet = `
+rules:
+- name: allow-ssh
+  priority: 100
+  access: Allow
+  protocol: Tcp
+  sources: 10.0.0.0/8
+  destinationPorts:
+  - "22"
+- name: allow-web
+  description: HTTP and HTTPS
+  priority: 110
+  access: Allow
+  protocol: Tcp
+  destinationPorts:
+  - "80"
+  - "443"
+- name: deny-out
+  priority: 4096
+  direction: Outbound
+  access: Deny
+`
+
+func TestParseSecurityRuleSet(t *testing.T) {
+	set, err := ParseSecurityRuleSet([]byte(webRuleSet))
+	if err != nil {
+		t.Fatalf("cannot parse rule set: %v", err)
+	}
+	if err := set.Validate(); err != nil {
+		t.Fatalf("rule set is invalid: %v", err)
+	}
+	want := SecurityRuleSpec{
+		Name: "allow-web", Description: "HTTP and HTTPS", Priority: 110,
+		Access: network.SecurityRuleAccessAllow, Protocol: network.SecurityRuleProtocolTCP,
+		DestinationPortRanges: []string{"80", "443"},
+	}
+	if len(set.Rules) != 3 || !reflect.DeepEqual(set.Rules[1], want) {
+		t.Errorf("unexpected rules: %+v", set.Rules)
+	}
+
+	for name, doc := range map[string]string{
+		"no rules":      "name: x\n",
+		"bad priority":  "rules:\n- name: a\n  priority: high\n",
+		"unknown field": "rules:\n- name: a\n  port: 22\n",
+	} {
+		if _, err := ParseSecurityRuleSet([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	invalid := map[string]SecurityRuleSpec{
+		"low priority": {Name: "a", Priority: 99, Access: network.SecurityRuleAccessAllow},
+		"bad port":     {Name: "a", Priority: 100, Access: network.SecurityRuleAccessAllow, DestinationPortRanges: []string{"90-80"}},
+		"bad prefix":   {Name: "a", Priority: 100, Access: network.SecurityRuleAccessAllow, SourceAddressPrefixes: []string{"10.0.0.0/33"}},
+		"no access":    {Name: "a", Priority: 100},
+	}
+	for name, rule := range invalid {
+		if err := NewSecurityRuleSet().WithRule(rule).Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPlanSecurityRules(t *testing.T) {
+	set, _ := ParseSecurityRuleSet([]byte(webRuleSet))
+	var existing []network.SecurityRule
+	for _, rule := range set.Rules {
+		existing = append(existing, rule.securityRule())
+	}
+	if plan := planSecurityRules(existing, set); !plan.Empty() {
+		t.Errorf("applied rule set should converge, got %+v", plan)
+	}
+
+	// Azure returns a single destination port range as a list in any order
+	web := existing[1]
+	web.DestinationPortRange = nil
+	web.DestinationPortRanges = &[]string{"443", "80"}
+	existing[1] = web
+	existing[0].Priority = to.Int32Ptr(200)
+	existing = append(existing, network.SecurityRule{Name: to.StringPtr("ALLOW-HTTP")})
+	plan := planSecurityRules(existing[1:], set)
+	want := SecurityRulePlan{Create: []string{"allow-ssh"}, Delete: []string{"ALLOW-HTTP"}}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got plan %+v, want %+v", plan, want)
+	}
+	if plan := planSecurityRules(existing, set); !reflect.DeepEqual(plan.Update, []string{"allow-ssh"}) {
+		t.Errorf("expected allow-ssh to be updated, got %+v", plan)
+	}
+}
+
+func TestAnalyzeSecurityRules(t *testing.T) {
+	allow, deny := network.SecurityRuleAccessAllow, network.SecurityRuleAccessDeny
+	rules := []SecurityRuleSpec{
+		{Name: "allow-admin", Priority: 100, Access: allow, Protocol: network.SecurityRuleProtocolTCP, DestinationPortRanges: []string{"20-30", "3389"}},
+		{Name: "deny-ssh", Priority: 200, Access: deny, Protocol: network.SecurityRuleProtocolTCP, SourceAddressPrefixes: []string{"192.168.1.0/24"}, DestinationPortRanges: []string{"22"}},
+		{Name: "allow-vnet", Priority: 300, Access: allow, SourceAddressPrefixes: []string{"10.0.0.0/8"}},
+		{Name: "allow-subnet", Priority: 310, Access: allow, Protocol: network.SecurityRuleProtocolUDP, SourceAddressPrefixes: []string{"10.1.0.0/16"}},
+		{Name: "dup", Priority: 300, Access: deny, SourceAddressPrefixes: []string{"VirtualNetwork"}},
+		{Name: "out", Priority: 300, Direction: network.SecurityRuleDirectionOutbound, Access: deny},
+	}
+	findings, err := AnalyzeSecurityRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[SecurityRuleFindingKind][]string{}
+	for _, f := range findings {
+		got[f.Kind] = append(got[f.Kind], f.Rule+"<"+f.Other)
+	}
+	want := map[SecurityRuleFindingKind][]string{
+		FindingBroadAdminSource:  {"allow-admin<"},
+		FindingShadowedRule:      {"deny-ssh<allow-admin", "allow-subnet<allow-vnet"},
+		FindingDuplicatePriority: {"dup<allow-vnet"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got findings %v, want %v", got, want)
+	}
+}
+
+func TestEffectiveAccess(t *testing.T) {
+	set, _ := ParseSecurityRuleSet([]byte(webRuleSet))
+	tcp := network.SecurityRuleProtocolTCP
+	peering := "hub"
+	vnet := VirtualNetworkTagPrefixes(network.VirtualNetwork{VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+		AddressSpace: &network.AddressSpace{AddressPrefixes: &[]string{"10.0.0.0/16"}},
+		VirtualNetworkPeerings: &[]network.VirtualNetworkPeering{{Name: &peering, VirtualNetworkPeeringPropertiesFormat: &network.VirtualNetworkPeeringPropertiesFormat{
+			RemoteAddressSpace: &network.AddressSpace{AddressPrefixes: &[]string{"10.100.0.0/16"}},
+		}}},
+	}})
+	if !reflect.DeepEqual(vnet, []string{"10.0.0.0/16", "10.100.0.0/16"}) {
+		t.Fatalf("unexpected VirtualNetwork prefixes %q", vnet)
+	}
+	cases := []struct {
+		flow      Flow
+		access    network.SecurityRuleAccess
+		rule      string
+		isDefault bool
+	}{
+		{Flow{Protocol: tcp, SourceAddress: "10.2.3.4", SourcePort: 50000, DestinationAddress: "10.0.0.4", DestinationPort: 22}, network.SecurityRuleAccessAllow, "allow-ssh", false},
+		{Flow{Protocol: tcp, SourceAddress: "203.0.113.9", SourcePort: 50000, DestinationAddress: "10.0.0.4", DestinationPort: 22}, network.SecurityRuleAccessDeny, "DenyAllInBound", true},
+		{Flow{Protocol: tcp, SourceAddress: "203.0.113.9", SourcePort: 50000, DestinationAddress: "10.0.0.4", DestinationPort: 443}, network.SecurityRuleAccessAllow, "allow-web", false},
+		{Flow{Protocol: network.SecurityRuleProtocolUDP, SourceAddress: "AzureLoadBalancer", DestinationAddress: "10.0.0.4", DestinationPort: 80}, network.SecurityRuleAccessAllow, "AllowAzureLoadBalancerInBound", true},
+		{Flow{Direction: network.SecurityRuleDirectionOutbound, Protocol: tcp, SourceAddress: "10.0.0.4", DestinationAddress: "8.8.8.8", DestinationPort: 53}, network.SecurityRuleAccessDeny, "deny-out", false},
+		{Flow{Protocol: tcp, SourceAddress: "10.0.1.4", SourcePort: 50000, DestinationAddress: "10.0.2.5", DestinationPort: 8080}, network.SecurityRuleAccessAllow, "AllowVnetInBound", true},
+		{Flow{Protocol: tcp, SourceAddress: "10.100.0.4", SourcePort: 50000, DestinationAddress: "10.0.2.5", DestinationPort: 8080}, network.SecurityRuleAccessAllow, "AllowVnetInBound", true},
+		{Flow{Protocol: tcp, SourceAddress: "168.63.129.16", SourcePort: 50000, DestinationAddress: "10.0.2.5", DestinationPort: 8080}, network.SecurityRuleAccessAllow, "AllowAzureLoadBalancerInBound", true},
+	}
+	for _, c := range cases {
+		decision, err := EffectiveAccess(set.Rules, vnet, c.flow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Access != c.access || decision.Rule != c.rule || decision.Default != c.isDefault {
+			t.Errorf("%+v: got %+v", c.flow, decision)
+		}
+	}
+
+	decision, _ := EffectiveAccess(nil, vnet, Flow{Direction: network.SecurityRuleDirectionOutbound, Protocol: tcp, SourceAddress: "10.0.0.4", DestinationAddress: "8.8.8.8", DestinationPort: 443})
+	if decision.Rule != "AllowInternetOutBound" {
+		t.Errorf("expected internet traffic to be allowed by default, got %+v", decision)
+	}
+
+	// the addresses of Storage are not known locally, and without the
+	// virtual network neither are those of VirtualNetwork
+	storage := []SecurityRuleSpec{{Name: "deny-storage", Priority: 100, Access: network.SecurityRuleAccessDeny, SourceAddressPrefixes: []string{"Storage"}}}
+	flow := Flow{Protocol: tcp, SourceAddress: "20.38.96.10", SourcePort: 443, DestinationAddress: "10.0.2.5", DestinationPort: 8080}
+	if decision, err := EffectiveAccess(storage, vnet, flow); err != nil || !decision.Indeterminate || decision.Rule != "deny-storage" || decision.Access != "" {
+		t.Errorf("expected a Storage rule to be indeterminate, got %+v, %v", decision, err)
+	}
+	flow.SourceAddress = "10.0.1.4"
+	if decision, err := EffectiveAccess(nil, nil, flow); err != nil || !decision.Indeterminate || decision.Rule != "AllowVnetInBound" {
+		t.Errorf("expected VirtualNetwork to be indeterminate without its prefixes, got %+v, %v", decision, err)
+	}
+	if _, err := EffectiveAccess(nil, []string{"Storage"}, flow); err == nil {
+		t.Errorf("expected a service tag as virtual network prefix to be rejected")
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"gopkg.in/yaml.v2"
+)
+
+// SecurityRuleSpec describes a network security rule. Empty address and
+// port lists mean "*", an empty protocol means any and an empty direction
+// means inbound.
+type SecurityRuleSpec struct {
+	Name                       string
+	Description                string
+	Priority                   int32
+	Direction                  network.SecurityRuleDirection
+	Access                     network.SecurityRuleAccess
+	Protocol                   network.SecurityRuleProtocol
+	SourceAddressPrefixes      []string
+	SourcePortRanges           []string
+	DestinationAddressPrefixes []string
+	DestinationPortRanges      []string
+}
+
+// SecurityRuleSet is the complete list of rules a network security group
+// should have. Build it with WithRule or parse it with
+// ParseSecurityRuleSet, then converge a group to it with
+// ApplySecurityRuleSet.
+type SecurityRuleSet struct {
+	Rules []SecurityRuleSpec
+}
+
+// NewSecurityRuleSet returns an empty rule set.
+func NewSecurityRuleSet() *SecurityRuleSet {
+	return &SecurityRuleSet{}
+}
+
+// WithRule adds a rule to the set.
+func (s *SecurityRuleSet) WithRule(rule SecurityRuleSpec) *SecurityRuleSet {
+	s.Rules = append(s.Rules, rule)
+	return s
+}
+
+// ParseSecurityRuleSet parses a rule set from YAML such as
+//
+//	rules:
+//	- name: allow-ssh
+//	  priority: 100
+//	  access: Allow
+//	  protocol: Tcp
+//	  sources: 10.0.0.0/8
+//	  destinationPorts:
+//	  - "22"
+//
+// Besides the fields above a rule has description, direction, sourcePorts
+// and destinations. Address and port fields take a single value or a
+// sequence.
+func ParseSecurityRuleSet(data []byte) (*SecurityRuleSet, error) {
+	var doc struct {
+		Rules []struct {
+			Name             string                        `yaml:"name"`
+			Description      string                        `yaml:"description"`
+			Priority         int32                         `yaml:"priority"`
+			Direction        network.SecurityRuleDirection `yaml:"direction"`
+			Access           network.SecurityRuleAccess    `yaml:"access"`
+			Protocol         network.SecurityRuleProtocol  `yaml:"protocol"`
+			Sources          yamlList                      `yaml:"sources"`
+			SourcePorts      yamlList                      `yaml:"sourcePorts"`
+			Destinations     yamlList                      `yaml:"destinations"`
+			DestinationPorts yamlList                      `yaml:"destinationPorts"`
+		} `yaml:"rules"`
+	}
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse rule set: %v", err)
+	}
+	if doc.Rules == nil {
+		return nil, fmt.Errorf("rule set has no rules sequence")
+	}
+	set := NewSecurityRuleSet()
+	for _, r := range doc.Rules {
+		set.WithRule(SecurityRuleSpec{
+			Name:                       r.Name,
+			Description:                r.Description,
+			Priority:                   r.Priority,
+			Direction:                  r.Direction,
+			Access:                     r.Access,
+			Protocol:                   r.Protocol,
+			SourceAddressPrefixes:      r.Sources,
+			SourcePortRanges:           r.SourcePorts,
+			DestinationAddressPrefixes: r.Destinations,
+			DestinationPortRanges:      r.DestinationPorts,
+		})
+	}
+	return set, nil
+}
+
+// yamlList is a YAML field holding a single value or a sequence.
+type yamlList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *yamlList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = yamlList{s}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("expected a scalar or a sequence")
+	}
+	*l = list
+	return nil
+}
+
+// normalized returns the rule with defaults filled in and its lists
+// sorted, so that equal rules compare equal.
+func (r SecurityRuleSpec) normalized() SecurityRuleSpec {
+	list := func(l []string) []string {
+		if len(l) == 0 {
+			return []string{"*"}
+		}
+		sorted := append([]string(nil), l...)
+		sort.Strings(sorted)
+		return sorted
+	}
+	if r.Direction == "" {
+		r.Direction = network.SecurityRuleDirectionInbound
+	}
+	if r.Protocol == "" {
+		r.Protocol = network.SecurityRuleProtocolAsterisk
+	}
+	r.SourceAddressPrefixes = list(r.SourceAddressPrefixes)
+	r.SourcePortRanges = list(r.SourcePortRanges)
+	r.DestinationAddressPrefixes = list(r.DestinationAddressPrefixes)
+	r.DestinationPortRanges = list(r.DestinationPortRanges)
+	return r
+}
+
+// Validate checks each rule and that names are unique.
+func (s *SecurityRuleSet) Validate() error {
+	names := map[string]bool{}
+	for _, rule := range s.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule with priority %d has no name", rule.Priority)
+		}
+		if names[strings.ToLower(rule.Name)] {
+			return fmt.Errorf("rule %s is defined twice", rule.Name)
+		}
+		names[strings.ToLower(rule.Name)] = true
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r SecurityRuleSpec) validate() error {
+	r = r.normalized()
+	if r.Priority < 100 || r.Priority > 4096 {
+		return fmt.Errorf("priority %d is not between 100 and 4096", r.Priority)
+	}
+	if r.Direction != network.SecurityRuleDirectionInbound && r.Direction != network.SecurityRuleDirectionOutbound {
+		return fmt.Errorf("unknown direction %s", r.Direction)
+	}
+	if r.Access != network.SecurityRuleAccessAllow && r.Access != network.SecurityRuleAccessDeny {
+		return fmt.Errorf("unknown access %s", r.Access)
+	}
+	known := false
+	for _, p := range network.PossibleSecurityRuleProtocolValues() {
+		known = known || p == r.Protocol
+	}
+	if !known {
+		return fmt.Errorf("unknown protocol %s", r.Protocol)
+	}
+	for _, ports := range [][]string{r.SourcePortRanges, r.DestinationPortRanges} {
+		if _, err := parsePortRanges(ports); err != nil {
+			return err
+		}
+	}
+	for _, prefixes := range [][]string{r.SourceAddressPrefixes, r.DestinationAddressPrefixes} {
+		if _, err := parseAddressPrefixes(prefixes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// singleOrList fills the singular field of a rule when a list has one
+// value and the plural one otherwise, as the API requires.
+func singleOrList(l []string) (*string, *[]string) {
+	if len(l) == 1 {
+		return to.StringPtr(l[0]), nil
+	}
+	return nil, &l
+}
+
+// securityRule returns the API representation of the rule.
+func (r SecurityRuleSpec) securityRule() network.SecurityRule {
+	r = r.normalized()
+	props := &network.SecurityRulePropertiesFormat{
+		Priority:  to.Int32Ptr(r.Priority),
+		Direction: r.Direction,
+		Access:    r.Access,
+		Protocol:  r.Protocol,
+	}
+	if r.Description != "" {
+		props.Description = to.StringPtr(r.Description)
+	}
+	props.SourceAddressPrefix, props.SourceAddressPrefixes = singleOrList(r.SourceAddressPrefixes)
+	props.SourcePortRange, props.SourcePortRanges = singleOrList(r.SourcePortRanges)
+	props.DestinationAddressPrefix, props.DestinationAddressPrefixes = singleOrList(r.DestinationAddressPrefixes)
+	props.DestinationPortRange, props.DestinationPortRanges = singleOrList(r.DestinationPortRanges)
+	return network.SecurityRule{Name: to.StringPtr(r.Name), SecurityRulePropertiesFormat: props}
+}
+
+// securityRuleSpec reads a rule of a security group back into a spec.
+// Application security groups are not represented.
+func securityRuleSpec(rule network.SecurityRule) SecurityRuleSpec {
+	spec := SecurityRuleSpec{Name: to.String(rule.Name)}
+	props := rule.SecurityRulePropertiesFormat
+	if props == nil {
+		return spec.normalized()
+	}
+	list := func(single *string, plural *[]string) []string {
+		if plural != nil && len(*plural) > 0 {
+			return *plural
+		}
+		if single != nil {
+			return []string{*single}
+		}
+		return nil
+	}
+	spec.Description = to.String(props.Description)
+	spec.Priority = to.Int32(props.Priority)
+	spec.Direction = props.Direction
+	spec.Access = props.Access
+	spec.Protocol = props.Protocol
+	spec.SourceAddressPrefixes = list(props.SourceAddressPrefix, props.SourceAddressPrefixes)
+	spec.SourcePortRanges = list(props.SourcePortRange, props.SourcePortRanges)
+	spec.DestinationAddressPrefixes = list(props.DestinationAddressPrefix, props.DestinationAddressPrefixes)
+	spec.DestinationPortRanges = list(props.DestinationPortRange, props.DestinationPortRanges)
+	return spec.normalized()
+}
+
+// SecurityRulePlan lists the rules that converging a security group to a
+// rule set creates, updates and deletes.
+type SecurityRulePlan struct {
+	Create []string
+	Update []string
+	Delete []string
+}
+
+// Empty reports whether the group already matches the rule set.
+func (p SecurityRulePlan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// planSecurityRules compares the rules of a security group with a rule
+// set. Rule names are compared case-insensitively, as Azure does.
+func planSecurityRules(existing []network.SecurityRule, set *SecurityRuleSet) (plan SecurityRulePlan) {
+	current := map[string]SecurityRuleSpec{}
+	for _, rule := range existing {
+		current[strings.ToLower(to.String(rule.Name))] = securityRuleSpec(rule)
+	}
+	wanted := map[string]bool{}
+	for _, rule := range set.Rules {
+		key := strings.ToLower(rule.Name)
+		wanted[key] = true
+		have, ok := current[key]
+		switch {
+		case !ok:
+			plan.Create = append(plan.Create, rule.Name)
+		case !reflect.DeepEqual(have, rule.normalized()):
+			plan.Update = append(plan.Update, rule.Name)
+		}
+	}
+	for _, rule := range existing {
+		if !wanted[strings.ToLower(to.String(rule.Name))] {
+			plan.Delete = append(plan.Delete, to.String(rule.Name))
+		}
+	}
+	sort.Strings(plan.Delete)
+	return plan
+}
+
+// ApplySecurityRuleSet converges the rules of an existing network security
+// group to a rule set and returns what it changed. The group is updated in
+// a single request, so rules can swap priorities; it is not updated at all
+// when it already matches.
+func ApplySecurityRuleSet(ctx context.Context, nsgName string, set *SecurityRuleSet) (plan SecurityRulePlan, err error) {
+	if err := set.Validate(); err != nil {
+		return plan, err
+	}
+	nsgClient := getNsgClient()
+	nsg, err := nsgClient.Get(ctx, config.GroupName(), nsgName, "")
+	if err != nil {
+		return plan, fmt.Errorf("cannot get nsg: %v", err)
+	}
+	if nsg.SecurityGroupPropertiesFormat == nil {
+		nsg.SecurityGroupPropertiesFormat = &network.SecurityGroupPropertiesFormat{}
+	}
+	var existing []network.SecurityRule
+	if nsg.SecurityRules != nil {
+		existing = *nsg.SecurityRules
+	}
+	plan = planSecurityRules(existing, set)
+	if plan.Empty() {
+		return plan, nil
+	}
+
+	rules := make([]network.SecurityRule, 0, len(set.Rules))
+	for _, rule := range set.Rules {
+		rules = append(rules, rule.securityRule())
+	}
+	future, err := nsgClient.CreateOrUpdate(ctx, config.GroupName(), nsgName, network.SecurityGroup{
+		Location: nsg.Location,
+		Tags:     nsg.Tags,
+		SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+			SecurityRules: &rules,
+		},
+	})
+	if err != nil {
+		return plan, fmt.Errorf("cannot update nsg: %v", err)
+	}
+	err = future.WaitForCompletionRef(ctx, nsgClient.Client)
+	if err != nil {
+		return plan, fmt.Errorf("cannot get nsg create or update future response: %v", err)
+	}
+	return plan, nil
+}
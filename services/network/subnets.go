@@ -21,10 +21,26 @@ func getSubnetsClient() network.SubnetsClient {
 	return subnetsClient
 }
 
-// CreateVirtualNetworkSubnet creates a subnet in an existing vnet
+// defaultSubnetSize is the size of the subnets CreateVirtualNetworkSubnet
+// and CreateVirtualNetworkAndSubnets create.
+const defaultSubnetSize = "/24"
+
+// CreateVirtualNetworkSubnet creates a subnet in an existing vnet with the
+// next free /24 prefix of its address space
 func CreateVirtualNetworkSubnet(ctx context.Context, vnetName, subnetName string) (subnet network.Subnet, err error) {
-	subnetsClient := getSubnetsClient()
+	return CreateVirtualNetworkSubnetWithPrefix(ctx, vnetName, subnetName, defaultSubnetSize)
+}
 
+// CreateVirtualNetworkSubnetWithPrefix creates a subnet in an existing vnet
+// with a literal address prefix, or with a size such as "/24" to get the
+// next free prefix of that size
+func CreateVirtualNetworkSubnetWithPrefix(ctx context.Context, vnetName, subnetName, addressPrefix string) (subnet network.Subnet, err error) {
+	addressPrefix, err = resolveSubnetPrefix(ctx, vnetName, addressPrefix)
+	if err != nil {
+		return subnet, fmt.Errorf("cannot allocate subnet prefix: %v", err)
+	}
+
+	subnetsClient := getSubnetsClient()
 	future, err := subnetsClient.CreateOrUpdate(
 		ctx,
 		config.GroupName(),
@@ -32,7 +48,7 @@ func CreateVirtualNetworkSubnet(ctx context.Context, vnetName, subnetName string
 		subnetName,
 		network.Subnet{
 			SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
-				AddressPrefix: to.StringPtr("10.0.0.0/16"),
+				AddressPrefix: to.StringPtr(addressPrefix),
 			},
 		})
 	if err != nil {
@@ -47,12 +63,17 @@ func CreateVirtualNetworkSubnet(ctx context.Context, vnetName, subnetName string
 	return future.Result(subnetsClient)
 }
 
-// CreateSubnetWithNetworkSecurityGroup create a subnet referencing a network security group
+// CreateSubnetWithNetworkSecurityGroup create a subnet referencing a network security group.
+// The address prefix may be a size such as "/24", as in CreateVirtualNetworkSubnetWithPrefix
 func CreateSubnetWithNetworkSecurityGroup(ctx context.Context, vnetName, subnetName, addressPrefix, nsgName string) (subnet network.Subnet, err error) {
 	nsg, err := GetNetworkSecurityGroup(ctx, nsgName)
 	if err != nil {
 		return subnet, fmt.Errorf("cannot get nsg: %v", err)
 	}
+	addressPrefix, err = resolveSubnetPrefix(ctx, vnetName, addressPrefix)
+	if err != nil {
+		return subnet, fmt.Errorf("cannot allocate subnet prefix: %v", err)
+	}
 
 	subnetsClient := getSubnetsClient()
 	future, err := subnetsClient.CreateOrUpdate(
@@ -78,11 +99,39 @@ func CreateSubnetWithNetworkSecurityGroup(ctx context.Context, vnetName, subnetN
 	return future.Result(subnetsClient)
 }
 
-// DeleteVirtualNetworkSubnet deletes a subnet
-func DeleteVirtualNetworkSubnet() {}
+// DeleteVirtualNetworkSubnet deletes a subnet. NICs and other resources in the subnet must be deleted first
+func DeleteVirtualNetworkSubnet(ctx context.Context, vnetName, subnetName string) (result network.SubnetsDeleteFuture, err error) {
+	subnetsClient := getSubnetsClient()
+	return subnetsClient.Delete(ctx, config.GroupName(), vnetName, subnetName)
+}
 
 // GetVirtualNetworkSubnet returns an existing subnet from a virtual network
 func GetVirtualNetworkSubnet(ctx context.Context, vnetName string, subnetName string) (network.Subnet, error) {
 	subnetsClient := getSubnetsClient()
 	return subnetsClient.Get(ctx, config.GroupName(), vnetName, subnetName, "")
 }
+
+// updateSubnet gets a subnet, changes it and puts it back.
+func updateSubnet(ctx context.Context, vnetName, subnetName string, change func(*network.Subnet)) (subnet network.Subnet, err error) {
+	subnet, err = GetVirtualNetworkSubnet(ctx, vnetName, subnetName)
+	if err != nil {
+		return subnet, fmt.Errorf("cannot get subnet: %v", err)
+	}
+	if subnet.SubnetPropertiesFormat == nil {
+		subnet.SubnetPropertiesFormat = &network.SubnetPropertiesFormat{}
+	}
+	change(&subnet)
+
+	subnetsClient := getSubnetsClient()
+	future, err := subnetsClient.CreateOrUpdate(ctx, config.GroupName(), vnetName, subnetName, subnet)
+	if err != nil {
+		return subnet, fmt.Errorf("cannot update subnet: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, subnetsClient.Client)
+	if err != nil {
+		return subnet, fmt.Errorf("cannot get the subnet create or update future response: %v", err)
+	}
+
+	return future.Result(subnetsClient)
+}
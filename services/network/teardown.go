@@ -0,0 +1,473 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// NetworkResourceKind is a kind of network resource a teardown deletes.
+type NetworkResourceKind string
+
+// Kinds of network resources, in the order a teardown deletes them when
+// nothing else decides.
+const (
+	KindNetworkInterface     NetworkResourceKind = "networkInterface"
+	KindLoadBalancer         NetworkResourceKind = "loadBalancer"
+	KindSubnet               NetworkResourceKind = "subnet"
+	KindNetworkSecurityGroup NetworkResourceKind = "networkSecurityGroup"
+	KindRouteTable           NetworkResourceKind = "routeTable"
+	KindNatGateway           NetworkResourceKind = "natGateway"
+	KindVirtualNetwork       NetworkResourceKind = "virtualNetwork"
+	KindPublicIP             NetworkResourceKind = "publicIPAddress"
+	KindPublicIPPrefix       NetworkResourceKind = "publicIPPrefix"
+)
+
+var teardownRank = map[NetworkResourceKind]int{
+	KindNetworkInterface:     0,
+	KindLoadBalancer:         1,
+	KindSubnet:               2,
+	KindNetworkSecurityGroup: 3,
+	KindRouteTable:           3,
+	KindNatGateway:           3,
+	KindVirtualNetwork:       4,
+	KindPublicIP:             5,
+	KindPublicIPPrefix:       6,
+}
+
+// NetworkResource is a resource of a network teardown. VirtualNetwork is
+// the network of a subnet.
+type NetworkResource struct {
+	Kind           NetworkResourceKind
+	Name           string
+	VirtualNetwork string
+	ID             string
+}
+
+func (r NetworkResource) String() string {
+	if r.Kind == KindSubnet {
+		return fmt.Sprintf("%s %s/%s", r.Kind, r.VirtualNetwork, r.Name)
+	}
+	return fmt.Sprintf("%s %s", r.Kind, r.Name)
+}
+
+// networkInventory is the network resources of a resource group.
+type networkInventory struct {
+	nics        []network.Interface
+	lbs         []network.LoadBalancer
+	vnets       []network.VirtualNetwork
+	nsgs        []network.SecurityGroup
+	routeTables []network.RouteTable
+	natGateways []network.NatGateway
+	publicIPs   []network.PublicIPAddress
+	ipPrefixes  []network.PublicIPPrefix
+}
+
+// teardownGraph records which resources use which, by lower-case ID.
+type teardownGraph struct {
+	resources map[string]NetworkResource
+	// users of a resource must be deleted before it
+	users map[string]map[string]bool
+}
+
+func (g *teardownGraph) add(r NetworkResource) {
+	g.resources[strings.ToLower(r.ID)] = r
+}
+
+// use records that user refers to target. References to resources outside
+// the inventory, such as in another resource group, are ignored.
+func (g *teardownGraph) use(user string, target *string) {
+	if target == nil {
+		return
+	}
+	u, t := strings.ToLower(user), strings.ToLower(*target)
+	if _, ok := g.resources[t]; !ok || u == t {
+		return
+	}
+	if g.users[t] == nil {
+		g.users[t] = map[string]bool{}
+	}
+	g.users[t][u] = true
+}
+
+// parentID returns the ID of the resource a child, such as a backend
+// pool, belongs to: the part of childID before "/segment/".
+func parentID(childID *string, segment string) *string {
+	if childID == nil {
+		return nil
+	}
+	i := strings.Index(strings.ToLower(*childID), "/"+strings.ToLower(segment)+"/")
+	if i < 0 {
+		return nil
+	}
+	return to.StringPtr((*childID)[:i])
+}
+
+func newTeardownGraph(inv networkInventory) *teardownGraph {
+	g := &teardownGraph{resources: map[string]NetworkResource{}, users: map[string]map[string]bool{}}
+	for _, nic := range inv.nics {
+		g.add(NetworkResource{Kind: KindNetworkInterface, Name: to.String(nic.Name), ID: to.String(nic.ID)})
+	}
+	for _, lb := range inv.lbs {
+		g.add(NetworkResource{Kind: KindLoadBalancer, Name: to.String(lb.Name), ID: to.String(lb.ID)})
+	}
+	for _, vnet := range inv.vnets {
+		g.add(NetworkResource{Kind: KindVirtualNetwork, Name: to.String(vnet.Name), ID: to.String(vnet.ID)})
+		if vnet.VirtualNetworkPropertiesFormat != nil && vnet.Subnets != nil {
+			for _, subnet := range *vnet.Subnets {
+				g.add(NetworkResource{Kind: KindSubnet, Name: to.String(subnet.Name), VirtualNetwork: to.String(vnet.Name), ID: to.String(subnet.ID)})
+			}
+		}
+	}
+	for _, nsg := range inv.nsgs {
+		g.add(NetworkResource{Kind: KindNetworkSecurityGroup, Name: to.String(nsg.Name), ID: to.String(nsg.ID)})
+	}
+	for _, rt := range inv.routeTables {
+		g.add(NetworkResource{Kind: KindRouteTable, Name: to.String(rt.Name), ID: to.String(rt.ID)})
+	}
+	for _, nat := range inv.natGateways {
+		g.add(NetworkResource{Kind: KindNatGateway, Name: to.String(nat.Name), ID: to.String(nat.ID)})
+	}
+	for _, ip := range inv.publicIPs {
+		g.add(NetworkResource{Kind: KindPublicIP, Name: to.String(ip.Name), ID: to.String(ip.ID)})
+	}
+	for _, prefix := range inv.ipPrefixes {
+		g.add(NetworkResource{Kind: KindPublicIPPrefix, Name: to.String(prefix.Name), ID: to.String(prefix.ID)})
+	}
+
+	for _, nic := range inv.nics {
+		if nic.InterfacePropertiesFormat == nil {
+			continue
+		}
+		id := to.String(nic.ID)
+		if nic.NetworkSecurityGroup != nil {
+			g.use(id, nic.NetworkSecurityGroup.ID)
+		}
+		if nic.IPConfigurations == nil {
+			continue
+		}
+		for _, ipConfig := range *nic.IPConfigurations {
+			props := ipConfig.InterfaceIPConfigurationPropertiesFormat
+			if props == nil {
+				continue
+			}
+			if props.Subnet != nil {
+				g.use(id, props.Subnet.ID)
+			}
+			if props.PublicIPAddress != nil {
+				g.use(id, props.PublicIPAddress.ID)
+			}
+			if props.LoadBalancerBackendAddressPools != nil {
+				for _, pool := range *props.LoadBalancerBackendAddressPools {
+					g.use(id, parentID(pool.ID, "backendAddressPools"))
+				}
+			}
+			if props.LoadBalancerInboundNatRules != nil {
+				for _, rule := range *props.LoadBalancerInboundNatRules {
+					g.use(id, parentID(rule.ID, "inboundNatRules"))
+				}
+			}
+		}
+	}
+	for _, lb := range inv.lbs {
+		if lb.LoadBalancerPropertiesFormat == nil || lb.FrontendIPConfigurations == nil {
+			continue
+		}
+		for _, fe := range *lb.FrontendIPConfigurations {
+			if fe.FrontendIPConfigurationPropertiesFormat == nil {
+				continue
+			}
+			if fe.PublicIPAddress != nil {
+				g.use(to.String(lb.ID), fe.PublicIPAddress.ID)
+			}
+			if fe.Subnet != nil {
+				g.use(to.String(lb.ID), fe.Subnet.ID)
+			}
+		}
+	}
+	for _, vnet := range inv.vnets {
+		if vnet.VirtualNetworkPropertiesFormat == nil || vnet.Subnets == nil {
+			continue
+		}
+		for _, subnet := range *vnet.Subnets {
+			id := to.String(subnet.ID)
+			g.use(id, vnet.ID)
+			if subnet.SubnetPropertiesFormat == nil {
+				continue
+			}
+			if subnet.NetworkSecurityGroup != nil {
+				g.use(id, subnet.NetworkSecurityGroup.ID)
+			}
+			if subnet.RouteTable != nil {
+				g.use(id, subnet.RouteTable.ID)
+			}
+			if subnet.NatGateway != nil {
+				g.use(id, subnet.NatGateway.ID)
+			}
+		}
+	}
+	for _, nat := range inv.natGateways {
+		if nat.NatGatewayPropertiesFormat == nil {
+			continue
+		}
+		if nat.PublicIPAddresses != nil {
+			for _, ip := range *nat.PublicIPAddresses {
+				g.use(to.String(nat.ID), ip.ID)
+			}
+		}
+		if nat.PublicIPPrefixes != nil {
+			for _, prefix := range *nat.PublicIPPrefixes {
+				g.use(to.String(nat.ID), prefix.ID)
+			}
+		}
+	}
+	for _, ip := range inv.publicIPs {
+		if ip.PublicIPAddressPropertiesFormat != nil && ip.PublicIPPrefix != nil {
+			g.use(to.String(ip.ID), ip.PublicIPPrefix.ID)
+		}
+	}
+	return g
+}
+
+// planTeardown orders the resources of an inventory so that each is
+// deleted after everything using it.
+func planTeardown(inv networkInventory) ([]NetworkResource, error) {
+	g := newTeardownGraph(inv)
+	remaining := map[string]bool{}
+	for id := range g.resources {
+		remaining[id] = true
+	}
+	var plan []NetworkResource
+	for len(remaining) > 0 {
+		// delete the unused resource of the lowest rank next
+		next := ""
+		for id := range remaining {
+			used := false
+			for user := range g.users[id] {
+				used = used || remaining[user]
+			}
+			if used {
+				continue
+			}
+			if next == "" || teardownLess(g.resources[id], g.resources[next]) {
+				next = id
+			}
+		}
+		if next == "" {
+			return nil, fmt.Errorf("network resources depend on each other in a cycle")
+		}
+		plan = append(plan, g.resources[next])
+		delete(remaining, next)
+	}
+	return plan, nil
+}
+
+func teardownLess(a, b NetworkResource) bool {
+	if teardownRank[a.Kind] != teardownRank[b.Kind] {
+		return teardownRank[a.Kind] < teardownRank[b.Kind]
+	}
+	return a.String() < b.String()
+}
+
+// listNetworkInventory lists the network resources of the configured
+// resource group.
+func listNetworkInventory(ctx context.Context) (inv networkInventory, err error) {
+	nics, err := getNicClient().ListComplete(ctx, config.GroupName())
+	for ; err == nil && nics.NotDone(); err = nics.NextWithContext(ctx) {
+		inv.nics = append(inv.nics, nics.Value())
+	}
+	if err != nil {
+		return inv, fmt.Errorf("cannot list network interfaces: %v", err)
+	}
+	lbs, err := getLBClient().ListComplete(ctx, config.GroupName())
+	for ; err == nil && lbs.NotDone(); err = lbs.NextWithContext(ctx) {
+		inv.lbs = append(inv.lbs, lbs.Value())
+	}
+	if err != nil {
+		return inv, fmt.Errorf("cannot list load balancers: %v", err)
+	}
+	vnets, err := getVnetClient().ListComplete(ctx, config.GroupName())
+	for ; err == nil && vnets.NotDone(); err = vnets.NextWithContext(ctx) {
+		inv.vnets = append(inv.vnets, vnets.Value())
+	}
+	if err != nil {
+		return inv, fmt.Errorf("cannot list virtual networks: %v", err)
+	}
+	nsgs, err := getNsgClient().ListComplete(ctx, config.GroupName())
+	for ; err == nil && nsgs.NotDone(); err = nsgs.NextWithContext(ctx) {
+		inv.nsgs = append(inv.nsgs, nsgs.Value())
+	}
+	if err != nil {
+		return inv, fmt.Errorf("cannot list network security groups: %v", err)
+	}
+	routeTables, err := getRouteTablesClient().ListComplete(ctx, config.GroupName())
+	for ; err == nil && routeTables.NotDone(); err = routeTables.NextWithContext(ctx) {
+		inv.routeTables = append(inv.routeTables, routeTables.Value())
+	}
+	if err != nil {
+		return inv, fmt.Errorf("cannot list route tables: %v", err)
+	}
+	natGateways, err := getNatGatewaysClient().ListComplete(ctx, config.GroupName())
+	for ; err == nil && natGateways.NotDone(); err = natGateways.NextWithContext(ctx) {
+		inv.natGateways = append(inv.natGateways, natGateways.Value())
+	}
+	if err != nil {
+		return inv, fmt.Errorf("cannot list NAT gateways: %v", err)
+	}
+	ips, err := getIPClient().ListComplete(ctx, config.GroupName())
+	for ; err == nil && ips.NotDone(); err = ips.NextWithContext(ctx) {
+		inv.publicIPs = append(inv.publicIPs, ips.Value())
+	}
+	if err != nil {
+		return inv, fmt.Errorf("cannot list public IP addresses: %v", err)
+	}
+	prefixes, err := getPublicIPPrefixesClient().ListComplete(ctx, config.GroupName())
+	for ; err == nil && prefixes.NotDone(); err = prefixes.NextWithContext(ctx) {
+		inv.ipPrefixes = append(inv.ipPrefixes, prefixes.Value())
+	}
+	if err != nil {
+		return inv, fmt.Errorf("cannot list public IP prefixes: %v", err)
+	}
+	return inv, nil
+}
+
+// PlanNetworkTeardown lists the network resources of the configured
+// resource group in the order TeardownNetwork deletes them: network
+// interfaces, load balancers, subnets, security groups, route tables and
+// NAT gateways, virtual networks, public IPs, then public IP prefixes, each
+// after everything using it.
+func PlanNetworkTeardown(ctx context.Context) ([]NetworkResource, error) {
+	inv, err := listNetworkInventory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return planTeardown(inv)
+}
+
+// deleteNetworkResource deletes a resource and waits for the deletion.
+func deleteNetworkResource(ctx context.Context, r NetworkResource) error {
+	var future azure.Future
+	var err error
+	var client network.BaseClient
+	group := config.GroupName()
+	switch r.Kind {
+	case KindNetworkInterface:
+		c := getNicClient()
+		client = c.BaseClient
+		var f network.InterfacesDeleteFuture
+		f, err = c.Delete(ctx, group, r.Name)
+		future = f.Future
+	case KindLoadBalancer:
+		c := getLBClient()
+		client = c.BaseClient
+		var f network.LoadBalancersDeleteFuture
+		f, err = c.Delete(ctx, group, r.Name)
+		future = f.Future
+	case KindSubnet:
+		c := getSubnetsClient()
+		client = c.BaseClient
+		var f network.SubnetsDeleteFuture
+		f, err = c.Delete(ctx, group, r.VirtualNetwork, r.Name)
+		future = f.Future
+	case KindNetworkSecurityGroup:
+		c := getNsgClient()
+		client = c.BaseClient
+		var f network.SecurityGroupsDeleteFuture
+		f, err = c.Delete(ctx, group, r.Name)
+		future = f.Future
+	case KindRouteTable:
+		c := getRouteTablesClient()
+		client = c.BaseClient
+		var f network.RouteTablesDeleteFuture
+		f, err = c.Delete(ctx, group, r.Name)
+		future = f.Future
+	case KindNatGateway:
+		c := getNatGatewaysClient()
+		client = c.BaseClient
+		var f network.NatGatewaysDeleteFuture
+		f, err = c.Delete(ctx, group, r.Name)
+		future = f.Future
+	case KindVirtualNetwork:
+		c := getVnetClient()
+		client = c.BaseClient
+		var f network.VirtualNetworksDeleteFuture
+		f, err = c.Delete(ctx, group, r.Name)
+		future = f.Future
+	case KindPublicIP:
+		c := getIPClient()
+		client = c.BaseClient
+		var f network.PublicIPAddressesDeleteFuture
+		f, err = c.Delete(ctx, group, r.Name)
+		future = f.Future
+	case KindPublicIPPrefix:
+		c := getPublicIPPrefixesClient()
+		client = c.BaseClient
+		var f network.PublicIPPrefixesDeleteFuture
+		f, err = c.Delete(ctx, group, r.Name)
+		future = f.Future
+	default:
+		return fmt.Errorf("cannot delete unknown resource kind %s", r.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot delete %s: %v", r, err)
+	}
+	if err := future.WaitForCompletionRef(ctx, client.Client); err != nil {
+		return fmt.Errorf("cannot get the %s delete future response: %v", r, err)
+	}
+	return nil
+}
+
+// isInUse reports whether a deletion failed because the resource is still
+// referenced, such as InUseSubnetCannotBeDeleted or PublicIPAddressInUse.
+// Azure releases references asynchronously, so these are retried.
+func isInUse(err error) bool {
+	return strings.Contains(err.Error(), "InUse")
+}
+
+// teardownRetryDelays are the waits before retrying a deletion that
+// failed because the resource was in use.
+var teardownRetryDelays = []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+
+// executeTeardown deletes the resources of a plan in order, retrying after
+// each of delays while a deletion fails because the resource is in use. It
+// returns the resources it deleted.
+func executeTeardown(ctx context.Context, plan []NetworkResource, del func(context.Context, NetworkResource) error, delays []time.Duration) ([]NetworkResource, error) {
+	var deleted []NetworkResource
+	for _, r := range plan {
+		err := del(ctx, r)
+		for attempt := 0; err != nil && isInUse(err) && attempt < len(delays); attempt++ {
+			select {
+			case <-ctx.Done():
+				return deleted, ctx.Err()
+			case <-time.After(delays[attempt]):
+			}
+			err = del(ctx, r)
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, r)
+	}
+	return deleted, nil
+}
+
+// TeardownNetwork deletes the network resources of the configured resource
+// group in the order of PlanNetworkTeardown, leaving the group and any
+// other resources in place. It returns the resources it deleted, also when
+// it fails part way.
+func TeardownNetwork(ctx context.Context) ([]NetworkResource, error) {
+	plan, err := PlanNetworkTeardown(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return executeTeardown(ctx, plan, deleteNetworkResource, teardownRetryDelays)
+}
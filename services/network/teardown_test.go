@@ -0,0 +1,137 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func teardownInventory() networkInventory {
+	const prefix = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/"
+	id := func(kind, name string) *string { return to.StringPtr(prefix + kind + "/" + name) }
+	subnetID := id("virtualNetworks", "vnet/subnets/web")
+
+	return networkInventory{
+		nics: []network.Interface{{
+			Name: to.StringPtr("nic"), ID: id("networkInterfaces", "nic"),
+			InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+				IPConfigurations: &[]network.InterfaceIPConfiguration{{
+					InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+						Subnet:                          &network.Subnet{ID: subnetID},
+						LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{{ID: id("loadBalancers", "lb/backendAddressPools/pool")}},
+					},
+				}},
+			},
+		}},
+		lbs: []network.LoadBalancer{{
+			Name: to.StringPtr("lb"), ID: id("loadBalancers", "lb"),
+			LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+				FrontendIPConfigurations: &[]network.FrontendIPConfiguration{{
+					FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
+						PublicIPAddress: &network.PublicIPAddress{ID: id("publicIPAddresses", "lb-ip")},
+					},
+				}},
+			},
+		}},
+		vnets: []network.VirtualNetwork{{
+			Name: to.StringPtr("vnet"), ID: id("virtualNetworks", "vnet"),
+			VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+				Subnets: &[]network.Subnet{{
+					Name: to.StringPtr("web"), ID: subnetID,
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						// IDs are compared case-insensitively
+						NetworkSecurityGroup: &network.SecurityGroup{ID: to.StringPtr(prefix + "networksecuritygroups/NSG")},
+						RouteTable:           &network.RouteTable{ID: id("routeTables", "routes")},
+						NatGateway:           &network.SubResource{ID: id("natGateways", "nat")},
+					},
+				}},
+			},
+		}},
+		nsgs:        []network.SecurityGroup{{Name: to.StringPtr("nsg"), ID: id("networkSecurityGroups", "nsg")}},
+		routeTables: []network.RouteTable{{Name: to.StringPtr("routes"), ID: id("routeTables", "routes")}},
+		natGateways: []network.NatGateway{{
+			Name: to.StringPtr("nat"), ID: id("natGateways", "nat"),
+			NatGatewayPropertiesFormat: &network.NatGatewayPropertiesFormat{
+				PublicIPAddresses: &[]network.SubResource{{ID: id("publicIPAddresses", "nat-ip")}},
+			},
+		}},
+		publicIPs: []network.PublicIPAddress{
+			{Name: to.StringPtr("lb-ip"), ID: id("publicIPAddresses", "lb-ip")},
+			{Name: to.StringPtr("unused-ip"), ID: id("publicIPAddresses", "unused-ip")},
+			{
+				Name: to.StringPtr("nat-ip"), ID: id("publicIPAddresses", "nat-ip"),
+				PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{PublicIPPrefix: &network.SubResource{ID: id("publicIPPrefixes", "egress")}},
+			},
+		},
+		ipPrefixes: []network.PublicIPPrefix{{Name: to.StringPtr("egress"), ID: id("publicIPPrefixes", "egress")}},
+	}
+}
+
+func TestPlanTeardown(t *testing.T) {
+	plan, err := planTeardown(teardownInventory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range plan {
+		got = append(got, r.String())
+	}
+	want := []string{
+		"networkInterface nic",
+		"loadBalancer lb",
+		"subnet vnet/web",
+		"natGateway nat",
+		"networkSecurityGroup nsg",
+		"routeTable routes",
+		"virtualNetwork vnet",
+		"publicIPAddress lb-ip",
+		"publicIPAddress nat-ip",
+		"publicIPAddress unused-ip",
+		"publicIPPrefix egress",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got plan %v, want %v", got, want)
+	}
+}
+
+func TestExecuteTeardown(t *testing.T) {
+	plan, _ := planTeardown(teardownInventory())
+	attempts := map[string]int{}
+	del := func(ctx context.Context, r NetworkResource) error {
+		attempts[r.Name]++
+		switch {
+		case r.Name == "web" && attempts[r.Name] < 3:
+			return errors.New("Code=\"InUseSubnetCannotBeDeleted\"")
+		case r.Name == "lb-ip":
+			return errors.New("Code=\"AuthorizationFailed\"")
+		}
+		return nil
+	}
+	deleted, err := executeTeardown(context.Background(), plan, del, []time.Duration{0, 0, 0})
+	if err == nil {
+		t.Fatalf("expected the failed deletion to be returned")
+	}
+	if attempts["web"] != 3 || attempts["lb-ip"] != 1 {
+		t.Errorf("unexpected attempts: %v", attempts)
+	}
+	if len(deleted) != 7 || deleted[6].Name != "vnet" {
+		t.Errorf("unexpected deleted resources: %v", deleted)
+	}
+
+	attempts = map[string]int{}
+	always := func(ctx context.Context, r NetworkResource) error {
+		attempts[r.Name]++
+		return errors.New("Code=\"InUseNetworkSecurityGroupCannotBeDeleted\"")
+	}
+	if _, err := executeTeardown(context.Background(), plan[:1], always, []time.Duration{0, 0}); err == nil || attempts["nic"] != 3 {
+		t.Errorf("expected 3 attempts and an error, got %d, %v", attempts["nic"], err)
+	}
+}
@@ -32,7 +32,7 @@ func CreateVirtualNetwork(ctx context.Context, vnetName string) (vnet network.Vi
 			Location: to.StringPtr(config.Location()),
 			VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
 				AddressSpace: &network.AddressSpace{
-					AddressPrefixes: &[]string{"10.0.0.0/8"},
+					AddressPrefixes: &[]string{defaultAddressSpace},
 				},
 			},
 		})
@@ -49,8 +49,46 @@ func CreateVirtualNetwork(ctx context.Context, vnetName string) (vnet network.Vi
 	return future.Result(vnetClient)
 }
 
+// defaultAddressSpace is the address space of the virtual networks the
+// samples create.
+const defaultAddressSpace = "10.0.0.0/8"
+
+// planSubnets returns subnets with the given names, each with the next
+// free prefix of a size such as "/24" in an address space.
+func planSubnets(addressSpace []string, size string, subnetNames ...string) ([]network.Subnet, error) {
+	length, err := parseSubnetSize(size)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := NewAddressPlan(addressSpace)
+	if err != nil {
+		return nil, err
+	}
+	subnets := make([]network.Subnet, 0, len(subnetNames))
+	for _, name := range subnetNames {
+		prefix, err := plan.Allocate(length)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, network.Subnet{
+			Name: to.StringPtr(name),
+			SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+				AddressPrefix: to.StringPtr(prefix),
+			},
+		})
+	}
+	return subnets, nil
+}
+
 // CreateVirtualNetworkAndSubnets creates a virtual network with two subnets
+// of the default size, allocated from its address space.
 func CreateVirtualNetworkAndSubnets(ctx context.Context, vnetName, subnet1Name, subnet2Name string) (vnet network.VirtualNetwork, err error) {
+	addressSpace := []string{defaultAddressSpace}
+	subnets, err := planSubnets(addressSpace, defaultSubnetSize, subnet1Name, subnet2Name)
+	if err != nil {
+		return vnet, fmt.Errorf("cannot allocate subnet prefixes: %v", err)
+	}
+
 	vnetClient := getVnetClient()
 	future, err := vnetClient.CreateOrUpdate(
 		ctx,
@@ -60,22 +98,9 @@ func CreateVirtualNetworkAndSubnets(ctx context.Context, vnetName, subnet1Name,
 			Location: to.StringPtr(config.Location()),
 			VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
 				AddressSpace: &network.AddressSpace{
-					AddressPrefixes: &[]string{"10.0.0.0/8"},
-				},
-				Subnets: &[]network.Subnet{
-					{
-						Name: to.StringPtr(subnet1Name),
-						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
-							AddressPrefix: to.StringPtr("10.0.0.0/16"),
-						},
-					},
-					{
-						Name: to.StringPtr(subnet2Name),
-						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
-							AddressPrefix: to.StringPtr("10.1.0.0/16"),
-						},
-					},
+					AddressPrefixes: &addressSpace,
 				},
+				Subnets: &subnets,
 			},
 		})
 
@@ -91,6 +116,16 @@ func CreateVirtualNetworkAndSubnets(ctx context.Context, vnetName, subnet1Name,
 	return future.Result(vnetClient)
 }
 
+// GetVirtualNetwork returns an existing virtual network
+func GetVirtualNetwork(ctx context.Context, vnetName string) (vnet network.VirtualNetwork, err error) {
+	vnetClient := getVnetClient()
+	vnet, err = vnetClient.Get(ctx, config.GroupName(), vnetName, "")
+	if err != nil {
+		return vnet, fmt.Errorf("cannot get virtual network: %v", err)
+	}
+	return vnet, nil
+}
+
 // DeleteVirtualNetwork deletes a virtual network given an existing virtual network
 func DeleteVirtualNetwork(ctx context.Context, vnetName string) (result network.VirtualNetworksDeleteFuture, err error) {
 	vnetClient := getVnetClient()
@@ -0,0 +1,524 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func getWatchersClient() network.WatchersClient {
+	watchersClient := network.NewWatchersClient(config.SubscriptionID())
+	auth, _ := iam.GetResourceManagementAuthorizer()
+	watchersClient.Authorizer = auth
+	_ = watchersClient.AddToUserAgent(config.UserAgent())
+	return watchersClient
+}
+
+// NetworkWatcher is the network watcher of a region. Azure enables one per
+// region automatically, named NetworkWatcher_<location> in resource group
+// NetworkWatcherRG.
+type NetworkWatcher struct {
+	ResourceGroup string
+	Name          string
+}
+
+// resourceGroupName returns the resource group of a resource ID.
+func resourceGroupName(resourceID string) string {
+	parts := strings.Split(strings.Trim(resourceID, "/"), "/")
+	for i, part := range parts {
+		if strings.EqualFold(part, "resourceGroups") && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// vmID returns the ID of a virtual machine in the configured group.
+func vmID(vmName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		config.SubscriptionID(), config.GroupName(), vmName)
+}
+
+// GetNetworkWatcher returns the network watcher of the configured location,
+// creating one in the configured group if the subscription has none there.
+func GetNetworkWatcher(ctx context.Context) (watcher NetworkWatcher, err error) {
+	watchersClient := getWatchersClient()
+	list, err := watchersClient.ListAll(ctx)
+	if err != nil {
+		return watcher, fmt.Errorf("cannot list network watchers: %v", err)
+	}
+	location := config.Location()
+	if list.Value != nil {
+		for _, w := range *list.Value {
+			if strings.EqualFold(strings.Replace(to.String(w.Location), " ", "", -1), location) {
+				return NetworkWatcher{ResourceGroup: resourceGroupName(to.String(w.ID)), Name: to.String(w.Name)}, nil
+			}
+		}
+	}
+
+	name := "NetworkWatcher_" + location
+	_, err = watchersClient.CreateOrUpdate(ctx, config.GroupName(), name, network.Watcher{
+		Location: to.StringPtr(location),
+	})
+	if err != nil {
+		return watcher, fmt.Errorf("cannot create network watcher: %v", err)
+	}
+	return NetworkWatcher{ResourceGroup: config.GroupName(), Name: name}, nil
+}
+
+// IPFlow is a packet to or from a VM, as IP flow verify tests it. Local is
+// the VM's side. NICName picks the NIC of a VM with several.
+type IPFlow struct {
+	VMName     string
+	NICName    string
+	Direction  network.Direction
+	Protocol   network.IPFlowProtocol
+	LocalIP    string
+	LocalPort  string
+	RemoteIP   string
+	RemotePort string
+}
+
+// IPFlowVerdict is whether the security rules of a VM let a flow through,
+// and the rule deciding it.
+type IPFlowVerdict struct {
+	Flow     IPFlow
+	Access   network.Access
+	RuleName string
+}
+
+func (v IPFlowVerdict) String() string {
+	f := v.Flow
+	peer := "from"
+	if f.Direction == network.Outbound {
+		peer = "to"
+	}
+	return fmt.Sprintf("%s %s %s:%s %s %s:%s: %s by %s",
+		f.Direction, f.Protocol, f.LocalIP, f.LocalPort, peer, f.RemoteIP, f.RemotePort, v.Access, v.RuleName)
+}
+
+// VerifyIPFlow checks whether the network security groups of a VM allow a
+// flow, and names the rule that allows or denies it.
+func VerifyIPFlow(ctx context.Context, watcher NetworkWatcher, flow IPFlow) (verdict IPFlowVerdict, err error) {
+	params := network.VerificationIPFlowParameters{
+		TargetResourceID: to.StringPtr(vmID(flow.VMName)),
+		Direction:        flow.Direction,
+		Protocol:         flow.Protocol,
+		LocalIPAddress:   to.StringPtr(flow.LocalIP),
+		LocalPort:        to.StringPtr(flow.LocalPort),
+		RemoteIPAddress:  to.StringPtr(flow.RemoteIP),
+		RemotePort:       to.StringPtr(flow.RemotePort),
+	}
+	if flow.NICName != "" {
+		nic, err := GetNic(ctx, flow.NICName)
+		if err != nil {
+			return verdict, fmt.Errorf("cannot get nic: %v", err)
+		}
+		params.TargetNicResourceID = nic.ID
+	}
+
+	watchersClient := getWatchersClient()
+	future, err := watchersClient.VerifyIPFlow(ctx, watcher.ResourceGroup, watcher.Name, params)
+	if err != nil {
+		return verdict, fmt.Errorf("cannot verify ip flow: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, watchersClient.Client)
+	if err != nil {
+		return verdict, fmt.Errorf("cannot get the ip flow verify future response: %v", err)
+	}
+
+	result, err := future.Result(watchersClient)
+	if err != nil {
+		return verdict, err
+	}
+	return ipFlowVerdict(flow, result), nil
+}
+
+func ipFlowVerdict(flow IPFlow, result network.VerificationIPFlowResult) IPFlowVerdict {
+	return IPFlowVerdict{Flow: flow, Access: result.Access, RuleName: to.String(result.RuleName)}
+}
+
+// systemRouteTable is the route table ID next hop reports for system
+// routes.
+const systemRouteTable = "System Route"
+
+// NextHopReport is where Azure sends a packet from a VM.
+type NextHopReport struct {
+	SourceIP         string
+	DestinationIP    string
+	NextHopType      network.NextHopType
+	NextHopIPAddress string
+	// RouteTable is the name of the route table with the route used, or
+	// empty for a system route.
+	RouteTable string
+}
+
+func (r NextHopReport) String() string {
+	hop := string(r.NextHopType)
+	if r.NextHopIPAddress != "" {
+		hop += " " + r.NextHopIPAddress
+	}
+	route := "system route"
+	if r.RouteTable != "" {
+		route = "route table " + r.RouteTable
+	}
+	return fmt.Sprintf("%s to %s: next hop %s (%s)", r.SourceIP, r.DestinationIP, hop, route)
+}
+
+// GetNextHop returns the next hop of packets from sourceIP, an address of
+// a VM, to destinationIP. See PredictNextHop for a local prediction.
+func GetNextHop(ctx context.Context, watcher NetworkWatcher, vmName, sourceIP, destinationIP string) (report NextHopReport, err error) {
+	watchersClient := getWatchersClient()
+	future, err := watchersClient.GetNextHop(ctx, watcher.ResourceGroup, watcher.Name, network.NextHopParameters{
+		TargetResourceID:     to.StringPtr(vmID(vmName)),
+		SourceIPAddress:      to.StringPtr(sourceIP),
+		DestinationIPAddress: to.StringPtr(destinationIP),
+	})
+	if err != nil {
+		return report, fmt.Errorf("cannot get next hop: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, watchersClient.Client)
+	if err != nil {
+		return report, fmt.Errorf("cannot get the next hop future response: %v", err)
+	}
+
+	result, err := future.Result(watchersClient)
+	if err != nil {
+		return report, err
+	}
+	return nextHopReport(sourceIP, destinationIP, result), nil
+}
+
+func nextHopReport(sourceIP, destinationIP string, result network.NextHopResult) NextHopReport {
+	report := NextHopReport{
+		SourceIP:         sourceIP,
+		DestinationIP:    destinationIP,
+		NextHopType:      result.NextHopType,
+		NextHopIPAddress: to.String(result.NextHopIPAddress),
+	}
+	if table := to.String(result.RouteTableID); table != "" && table != systemRouteTable {
+		report.RouteTable = path.Base(table)
+	}
+	return report
+}
+
+// EffectiveSecurityRule is a rule applied to a NIC, from its own network
+// security group, its subnet's or the defaults.
+type EffectiveSecurityRule struct {
+	Name             string
+	Direction        network.SecurityRuleDirection
+	Priority         int32
+	Access           network.SecurityRuleAccess
+	Protocol         network.EffectiveSecurityRuleProtocol
+	Source           string
+	SourcePorts      string
+	Destination      string
+	DestinationPorts string
+}
+
+// NICSecurityView is the effective security rules of a NIC, in the order
+// Azure evaluates them.
+type NICSecurityView struct {
+	NIC   string
+	Rules []EffectiveSecurityRule
+}
+
+// SecurityGroupView is the effective security rules of the NICs of a VM.
+type SecurityGroupView []NICSecurityView
+
+func (v SecurityGroupView) String() string {
+	var buf bytes.Buffer
+	for i, nic := range v {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "nic %s\n", nic.NIC)
+		w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DIRECTION\tPRIORITY\tACCESS\tPROTOCOL\tSOURCE\tDESTINATION\tRULE")
+		for _, r := range nic.Rules {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s:%s\t%s:%s\t%s\n",
+				r.Direction, r.Priority, r.Access, r.Protocol, r.Source, r.SourcePorts, r.Destination, r.DestinationPorts, r.Name)
+		}
+		_ = w.Flush()
+	}
+	return buf.String()
+}
+
+// GetSecurityGroupView returns the effective security rules of the NICs of
+// a VM, combining the network security groups of the NICs and subnets.
+func GetSecurityGroupView(ctx context.Context, watcher NetworkWatcher, vmName string) (SecurityGroupView, error) {
+	watchersClient := getWatchersClient()
+	future, err := watchersClient.GetVMSecurityRules(ctx, watcher.ResourceGroup, watcher.Name, network.SecurityGroupViewParameters{
+		TargetResourceID: to.StringPtr(vmID(vmName)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get security group view: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, watchersClient.Client)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the security group view future response: %v", err)
+	}
+
+	result, err := future.Result(watchersClient)
+	if err != nil {
+		return nil, err
+	}
+	return securityGroupView(result), nil
+}
+
+// joinOr joins a single value or a list of them, as security rules have
+// both.
+func joinOr(single *string, list *[]string) string {
+	if list != nil && len(*list) > 0 {
+		return strings.Join(*list, ",")
+	}
+	if single == nil {
+		return "*"
+	}
+	return *single
+}
+
+func securityGroupView(result network.SecurityGroupViewResult) SecurityGroupView {
+	view := SecurityGroupView{}
+	if result.NetworkInterfaces == nil {
+		return view
+	}
+	for _, nic := range *result.NetworkInterfaces {
+		nicView := NICSecurityView{NIC: path.Base(to.String(nic.ID))}
+		if nic.SecurityRuleAssociations != nil && nic.SecurityRuleAssociations.EffectiveSecurityRules != nil {
+			for _, r := range *nic.SecurityRuleAssociations.EffectiveSecurityRules {
+				nicView.Rules = append(nicView.Rules, EffectiveSecurityRule{
+					Name:             to.String(r.Name),
+					Direction:        r.Direction,
+					Priority:         to.Int32(r.Priority),
+					Access:           r.Access,
+					Protocol:         r.Protocol,
+					Source:           joinOr(r.SourceAddressPrefix, r.SourceAddressPrefixes),
+					SourcePorts:      joinOr(r.SourcePortRange, r.SourcePortRanges),
+					Destination:      joinOr(r.DestinationAddressPrefix, r.DestinationAddressPrefixes),
+					DestinationPorts: joinOr(r.DestinationPortRange, r.DestinationPortRanges),
+				})
+			}
+		}
+		sort.SliceStable(nicView.Rules, func(i, j int) bool {
+			a, b := nicView.Rules[i], nicView.Rules[j]
+			if a.Direction != b.Direction {
+				return a.Direction == network.SecurityRuleDirectionInbound
+			}
+			return a.Priority < b.Priority
+		})
+		view = append(view, nicView)
+	}
+	return view
+}
+
+// ConnectivityHop is a hop of the path a connectivity check probed.
+type ConnectivityHop struct {
+	Type     string
+	Address  string
+	Resource string
+	Issues   []string
+}
+
+// ConnectivityReport is the outcome of a connectivity check.
+type ConnectivityReport struct {
+	Status       network.ConnectionStatus
+	ProbesSent   int32
+	ProbesFailed int32
+	MinLatencyMs int32
+	AvgLatencyMs int32
+	MaxLatencyMs int32
+	Hops         []ConnectivityHop
+}
+
+func (r ConnectivityReport) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s: %d of %d probes failed", r.Status, r.ProbesFailed, r.ProbesSent)
+	if r.ProbesFailed < r.ProbesSent {
+		fmt.Fprintf(&buf, ", latency min %d ms, avg %d ms, max %d ms", r.MinLatencyMs, r.AvgLatencyMs, r.MaxLatencyMs)
+	}
+	buf.WriteString("\n")
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOP\tTYPE\tADDRESS\tRESOURCE\tISSUES")
+	for i, hop := range r.Hops {
+		issues := strings.Join(hop.Issues, "; ")
+		if issues == "" {
+			issues = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, hop.Type, hop.Address, hop.Resource, issues)
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+// TroubleshootConnection checks TCP connectivity from a VM to a host name
+// or IP address and port, and reports each hop and the issues found on
+// it, such as a security rule or route dropping the traffic. The VM needs
+// the Network Watcher agent extension.
+func TroubleshootConnection(ctx context.Context, watcher NetworkWatcher, vmName, destination string, port int32) (report ConnectivityReport, err error) {
+	watchersClient := getWatchersClient()
+	future, err := watchersClient.CheckConnectivity(ctx, watcher.ResourceGroup, watcher.Name, network.ConnectivityParameters{
+		Source:      &network.ConnectivitySource{ResourceID: to.StringPtr(vmID(vmName))},
+		Destination: &network.ConnectivityDestination{Address: to.StringPtr(destination), Port: to.Int32Ptr(port)},
+		Protocol:    network.ProtocolTCP,
+	})
+	if err != nil {
+		return report, fmt.Errorf("cannot check connectivity: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, watchersClient.Client)
+	if err != nil {
+		return report, fmt.Errorf("cannot get the connectivity check future response: %v", err)
+	}
+
+	result, err := future.Result(watchersClient)
+	if err != nil {
+		return report, err
+	}
+	return connectivityReport(result), nil
+}
+
+func connectivityReport(result network.ConnectivityInformation) ConnectivityReport {
+	report := ConnectivityReport{
+		Status:       result.ConnectionStatus,
+		ProbesSent:   to.Int32(result.ProbesSent),
+		ProbesFailed: to.Int32(result.ProbesFailed),
+		MinLatencyMs: to.Int32(result.MinLatencyInMs),
+		AvgLatencyMs: to.Int32(result.AvgLatencyInMs),
+		MaxLatencyMs: to.Int32(result.MaxLatencyInMs),
+	}
+	if result.Hops == nil {
+		return report
+	}
+	for _, h := range *result.Hops {
+		hop := ConnectivityHop{Type: to.String(h.Type), Address: to.String(h.Address), Resource: "-"}
+		if id := to.String(h.ResourceID); id != "" {
+			hop.Resource = path.Base(id)
+		}
+		if h.Issues != nil {
+			for _, issue := range *h.Issues {
+				hop.Issues = append(hop.Issues, fmt.Sprintf("%s %s (%s)", issue.Severity, issue.Type, issue.Origin))
+			}
+		}
+		report.Hops = append(report.Hops, hop)
+	}
+	return report
+}
+
+// FlowLogStatus is the flow log configuration of a network security group.
+type FlowLogStatus struct {
+	NSG            string
+	Enabled        bool
+	StorageAccount string
+	Version        int32
+	RetentionDays  int32
+}
+
+func (s FlowLogStatus) String() string {
+	if !s.Enabled {
+		return fmt.Sprintf("flow log of %s: disabled", s.NSG)
+	}
+	retention := "kept forever"
+	if s.RetentionDays > 0 {
+		retention = fmt.Sprintf("kept %d days", s.RetentionDays)
+	}
+	return fmt.Sprintf("flow log of %s: version %d to storage account %s, %s", s.NSG, s.Version, s.StorageAccount, retention)
+}
+
+// SetNSGFlowLog enables or disables version 2 flow logs of a network
+// security group, written to a storage account in the same region.
+// retentionDays 0 keeps the logs forever.
+func SetNSGFlowLog(ctx context.Context, watcher NetworkWatcher, nsgName, storageAccountID string, enabled bool, retentionDays int32) (status FlowLogStatus, err error) {
+	nsg, err := GetNetworkSecurityGroup(ctx, nsgName)
+	if err != nil {
+		return status, fmt.Errorf("cannot get nsg: %v", err)
+	}
+
+	watchersClient := getWatchersClient()
+	future, err := watchersClient.SetFlowLogConfiguration(ctx, watcher.ResourceGroup, watcher.Name, network.FlowLogInformation{
+		TargetResourceID: nsg.ID,
+		FlowLogProperties: &network.FlowLogProperties{
+			StorageID: to.StringPtr(storageAccountID),
+			Enabled:   to.BoolPtr(enabled),
+			RetentionPolicy: &network.RetentionPolicyParameters{
+				Days:    to.Int32Ptr(retentionDays),
+				Enabled: to.BoolPtr(retentionDays > 0),
+			},
+			Format: &network.FlowLogFormatParameters{Type: network.JSON, Version: to.Int32Ptr(2)},
+		},
+	})
+	if err != nil {
+		return status, fmt.Errorf("cannot set flow log configuration: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, watchersClient.Client)
+	if err != nil {
+		return status, fmt.Errorf("cannot get the flow log configuration future response: %v", err)
+	}
+
+	result, err := future.Result(watchersClient)
+	if err != nil {
+		return status, err
+	}
+	return flowLogStatus(result), nil
+}
+
+// GetNSGFlowLogStatus returns the flow log configuration of a network
+// security group.
+func GetNSGFlowLogStatus(ctx context.Context, watcher NetworkWatcher, nsgName string) (status FlowLogStatus, err error) {
+	nsg, err := GetNetworkSecurityGroup(ctx, nsgName)
+	if err != nil {
+		return status, fmt.Errorf("cannot get nsg: %v", err)
+	}
+
+	watchersClient := getWatchersClient()
+	future, err := watchersClient.GetFlowLogStatus(ctx, watcher.ResourceGroup, watcher.Name, network.FlowLogStatusParameters{
+		TargetResourceID: nsg.ID,
+	})
+	if err != nil {
+		return status, fmt.Errorf("cannot get flow log status: %v", err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, watchersClient.Client)
+	if err != nil {
+		return status, fmt.Errorf("cannot get the flow log status future response: %v", err)
+	}
+
+	result, err := future.Result(watchersClient)
+	if err != nil {
+		return status, err
+	}
+	return flowLogStatus(result), nil
+}
+
+func flowLogStatus(info network.FlowLogInformation) FlowLogStatus {
+	status := FlowLogStatus{NSG: path.Base(to.String(info.TargetResourceID))}
+	if info.FlowLogProperties == nil {
+		return status
+	}
+	status.Enabled = to.Bool(info.Enabled)
+	status.StorageAccount = path.Base(to.String(info.StorageID))
+	status.Version = 1
+	if info.Format != nil && info.Format.Version != nil && *info.Format.Version != 0 {
+		status.Version = *info.Format.Version
+	}
+	if info.RetentionPolicy != nil && to.Bool(info.RetentionPolicy.Enabled) {
+		status.RetentionDays = to.Int32(info.RetentionPolicy.Days)
+	}
+	return status
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package network
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-11-01/network"
+)
+
+// readRecorded decodes a Network Watcher response recorded in
+// testdata/watcher.
+func readRecorded(t *testing.T, name string, v interface{}) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "watcher", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("cannot decode %s: %v", name, err)
+	}
+}
+
+func checkReport(t *testing.T, name, got, want string) {
+	if got != want {
+		t.Errorf("%s report:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestIPFlowVerdictReport(t *testing.T) {
+	var result network.VerificationIPFlowResult
+	readRecorded(t, "ipflowverify.json", &result)
+	flow := IPFlow{VMName: "vm1", Direction: network.Inbound, Protocol: network.IPFlowProtocolTCP,
+		LocalIP: "10.0.0.4", LocalPort: "3389", RemoteIP: "203.0.113.7", RemotePort: "60000"}
+	checkReport(t, "ip flow", ipFlowVerdict(flow, result).String(),
+		"Inbound TCP 10.0.0.4:3389 from 203.0.113.7:60000: Deny by securityRules/deny_rdp")
+
+	flow.Direction = network.Outbound
+	checkReport(t, "outbound ip flow", ipFlowVerdict(flow, result).String(),
+		"Outbound TCP 10.0.0.4:3389 to 203.0.113.7:60000: Deny by securityRules/deny_rdp")
+}
+
+func TestNextHopReport(t *testing.T) {
+	var result network.NextHopResult
+	readRecorded(t, "nexthop.json", &result)
+	checkReport(t, "next hop", nextHopReport("10.0.0.4", "8.8.8.8", result).String(),
+		"10.0.0.4 to 8.8.8.8: next hop VirtualAppliance 10.0.100.4 (route table egress)")
+
+	result = network.NextHopResult{}
+	readRecorded(t, "nexthop_system.json", &result)
+	checkReport(t, "system next hop", nextHopReport("10.0.0.4", "8.8.8.8", result).String(),
+		"10.0.0.4 to 8.8.8.8: next hop Internet (system route)")
+}
+
+func TestSecurityGroupViewReport(t *testing.T) {
+	var result network.SecurityGroupViewResult
+	readRecorded(t, "securitygroupview.json", &result)
+	checkReport(t, "security group view", securityGroupView(result).String(), `nic vm1-nic
+DIRECTION  PRIORITY  ACCESS  PROTOCOL  SOURCE                                  DESTINATION        RULE
+Inbound    100       Allow   Tcp       10.0.0.0/16:0-65535                     0.0.0.0/0:22-22    securityRules/allow_ssh
+Inbound    200       Allow   Tcp       203.0.113.0/24,198.51.100.0/24:0-65535  0.0.0.0/0:443-443  securityRules/allow_https
+Inbound    65500     Deny    All       0.0.0.0/0:0-65535                       0.0.0.0/0:0-65535  defaultSecurityRules/DenyAllInBound
+Outbound   65001     Allow   All       0.0.0.0/0:0-65535                       Internet:0-65535   defaultSecurityRules/AllowInternetOutBound
+`)
+	if view := securityGroupView(network.SecurityGroupViewResult{}); len(view) != 0 || view.String() != "" {
+		t.Errorf("expected an empty view, got %q", view.String())
+	}
+}
+
+func TestConnectivityReport(t *testing.T) {
+	var result network.ConnectivityInformation
+	readRecorded(t, "connectivity.json", &result)
+	checkReport(t, "connectivity", connectivityReport(result).String(), `Disconnected: 30 of 30 probes failed
+HOP  TYPE              ADDRESS        RESOURCE  ISSUES
+1    Source            10.0.0.4       vm1       -
+2    VirtualAppliance  10.0.100.4     fw-nic    Error NetworkSecurityRule (Outbound)
+3    Internet          13.107.21.200  Internet  -
+`)
+
+	connected := ConnectivityReport{Status: network.ConnectionStatusConnected, ProbesSent: 10, ProbesFailed: 0, MinLatencyMs: 1, AvgLatencyMs: 2, MaxLatencyMs: 4}
+	checkReport(t, "connected", connected.String(), `Connected: 0 of 10 probes failed, latency min 1 ms, avg 2 ms, max 4 ms
+HOP  TYPE  ADDRESS  RESOURCE  ISSUES
+`)
+}
+
+func TestFlowLogStatusReport(t *testing.T) {
+	var info network.FlowLogInformation
+	readRecorded(t, "flowlog.json", &info)
+	status := flowLogStatus(info)
+	checkReport(t, "flow log", status.String(), "flow log of nsg1: version 2 to storage account flowlogs, kept 7 days")
+
+	status.RetentionDays = 0
+	checkReport(t, "flow log kept forever", status.String(), "flow log of nsg1: version 2 to storage account flowlogs, kept forever")
+	status.Enabled = false
+	checkReport(t, "disabled flow log", status.String(), "flow log of nsg1: disabled")
+}
+
+func TestResourceGroupName(t *testing.T) {
+	id := "/subscriptions/sub/resourceGroups/NetworkWatcherRG/providers/Microsoft.Network/networkWatchers/NetworkWatcher_westus2"
+	if got := resourceGroupName(id); got != "NetworkWatcherRG" {
+		t.Errorf("got resource group %q, want NetworkWatcherRG", got)
+	}
+	if got := resourceGroupName("/subscriptions/sub"); got != "" {
+		t.Errorf("expected no resource group, got %q", got)
+	}
+}
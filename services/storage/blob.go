@@ -5,7 +5,10 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
+	"net/url"
+	"time"
 
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
 	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
@@ -48,3 +51,44 @@ func GetBlob(ctx context.Context, accountName, accountGroupName, containerName,
 	body, err := ioutil.ReadAll(resp.Body(azblob.RetryReaderOptions{}))
 	return string(body), err
 }
+
+// CopyBlobFromURL copies the blob at sourceURL, which must be readable by
+// the storage service, for example with a SAS, and waits for the copy to
+// finish, checking every interval.
+func CopyBlobFromURL(ctx context.Context, accountName, accountGroupName, containerName, blobName string, sourceURL url.URL, interval time.Duration) (azblob.BlobURL, error) {
+	b := getBlobURL(ctx, accountName, accountGroupName, containerName, blobName)
+
+	copied, err := b.StartCopyFromURL(ctx, sourceURL, azblob.Metadata{}, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{})
+	if err != nil {
+		return b, fmt.Errorf("cannot start blob copy: %v", err)
+	}
+	status := copied.CopyStatus()
+	for status == azblob.CopyStatusPending {
+		select {
+		case <-ctx.Done():
+			_, _ = b.AbortCopyFromURL(context.Background(), copied.CopyID(), azblob.LeaseAccessConditions{})
+			return b, ctx.Err()
+		case <-time.After(interval):
+		}
+		props, err := b.GetProperties(ctx, azblob.BlobAccessConditions{})
+		if err != nil {
+			return b, fmt.Errorf("cannot get blob copy status: %v", err)
+		}
+		status = props.CopyStatus()
+		if status != azblob.CopyStatusPending && status != azblob.CopyStatusSuccess {
+			return b, fmt.Errorf("blob copy %s: %s", status, props.CopyStatusDescription())
+		}
+	}
+	if status != azblob.CopyStatusSuccess {
+		return b, fmt.Errorf("blob copy %s", status)
+	}
+	return b, nil
+}
+
+// DeleteBlob deletes a blob and its snapshots.
+func DeleteBlob(ctx context.Context, accountName, accountGroupName, containerName, blobName string) error {
+	b := getBlobURL(ctx, accountName, accountGroupName, containerName, blobName)
+
+	_, err := b.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return err
+}
@@ -36,6 +36,16 @@ func CreateBlockBlob(ctx context.Context, accountName, accountGroupName, contain
 	return b, err
 }
 
+// UploadBlockBlob uploads data as a block blob, replacing any existing blob
+// with the same name.
+func UploadBlockBlob(ctx context.Context, accountName, accountGroupName, containerName, blobName string, data []byte, contentType string) (azblob.BlockBlobURL, error) {
+	b := getBlockBlobURL(ctx, accountName, accountGroupName, containerName, blobName)
+	_, err := azblob.UploadBufferToBlockBlob(ctx, data, b, azblob.UploadToBlockBlobOptions{
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: contentType},
+	})
+	return b, err
+}
+
 // PutBlockOnBlob adds a block to a block blob. It does not commit the block.
 func PutBlockOnBlob(ctx context.Context, accountName, accountGroupName, containerName, blobName, message string, blockNum int) error {
 	b := getBlockBlobURL(ctx, accountName, accountGroupName, containerName, blobName)
@@ -0,0 +1,276 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+// Package file demonstrates uploading and downloading directory trees to
+// and from Azure file shares, writing and reading files in ranges.
+//
+// The local storage emulator has no file service, so these helpers need a
+// real storage account.
+package file
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/storage"
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+)
+
+// MaxRangeSize is the most bytes a single range write can hold.
+const MaxRangeSize = 4 * 1024 * 1024
+
+// GetFileServiceClient returns a client for the file service of a storage
+// account, authorized with the account's primary key.
+func GetFileServiceClient(ctx context.Context, accountName, accountGroupName string) (azstorage.FileServiceClient, error) {
+	keys, err := storage.GetAccountKeys(ctx, accountName, accountGroupName)
+	if err != nil {
+		return azstorage.FileServiceClient{}, err
+	}
+	client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, *(*keys.Keys)[0].Value, *config.Environment())
+	if err != nil {
+		return azstorage.FileServiceClient{}, err
+	}
+	_ = client.AddToUserAgent(config.UserAgent())
+	return client.GetFileService(), nil
+}
+
+// CreateShare creates the named file share if it does not exist yet.
+func CreateShare(fs azstorage.FileServiceClient, shareName string) (*azstorage.Share, error) {
+	share := fs.GetShareReference(shareName)
+	_, err := share.CreateIfNotExists(nil)
+	return share, err
+}
+
+// DeleteShare deletes the named file share and everything in it.
+func DeleteShare(fs azstorage.FileServiceClient, shareName string) error {
+	_, err := fs.GetShareReference(shareName).DeleteIfExists(nil)
+	return err
+}
+
+// splitPath splits a slash separated path within a share into its
+// directory names. The path is cleaned as if rooted at the share, so ".."
+// cannot leave it.
+func splitPath(p string) []string {
+	p = strings.Trim(path.Clean("/"+strings.Replace(p, "\\", "/", -1)), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// GetDirectory returns a reference to a directory of a share by its path,
+// such as "logs/2020", optionally creating any missing directories.
+func GetDirectory(share *azstorage.Share, dirPath string, create bool) (*azstorage.Directory, error) {
+	dir := share.GetRootDirectoryReference()
+	for _, name := range splitPath(dirPath) {
+		dir = dir.GetDirectoryReference(name)
+		if create {
+			if _, err := dir.CreateIfNotExists(nil); err != nil {
+				return nil, fmt.Errorf("failed to create directory %s: %v", name, err)
+			}
+		}
+	}
+	return dir, nil
+}
+
+// SplitRanges splits size bytes into consecutive ranges of at most
+// rangeSize bytes. Range ends are inclusive, as the service expects.
+func SplitRanges(size, rangeSize uint64) []azstorage.FileRange {
+	if rangeSize == 0 {
+		rangeSize = MaxRangeSize
+	}
+	var ranges []azstorage.FileRange
+	for start := uint64(0); start < size; start += rangeSize {
+		end := start + rangeSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		ranges = append(ranges, azstorage.FileRange{Start: start, End: end})
+	}
+	return ranges
+}
+
+// isZero reports whether b holds only zero bytes. Such ranges need not be
+// written, since new files read as zeros.
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// UploadFile creates a file in dir with the contents of a local file,
+// writing it in ranges of up to 4 MiB. Ranges of zeros are skipped, so
+// sparse files stay sparse in the share.
+func UploadFile(dir *azstorage.Directory, localPath, name string) (*azstorage.File, error) {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer local.Close()
+	info, err := local.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	f := dir.GetFileReference(name)
+	size := uint64(info.Size())
+	if err := f.Create(size, nil); err != nil {
+		return nil, fmt.Errorf("failed to create file %s: %v", name, err)
+	}
+	buf := make([]byte, MaxRangeSize)
+	for _, r := range SplitRanges(size, MaxRangeSize) {
+		chunk := buf[:r.End-r.Start+1]
+		if _, err := io.ReadFull(local, chunk); err != nil {
+			return f, err
+		}
+		if isZero(chunk) {
+			continue
+		}
+		if err := f.WriteRange(bytes.NewReader(chunk), r, nil); err != nil {
+			return f, fmt.Errorf("failed to write range %s of %s: %v", r, name, err)
+		}
+	}
+	return f, nil
+}
+
+// DownloadFile writes a file of a share to a local path. Only the ranges
+// that were written to the file are downloaded; the rest of the local file
+// reads as zeros.
+func DownloadFile(f *azstorage.File, localPath string) error {
+	ranges, err := f.ListRanges(nil)
+	if err != nil {
+		return err
+	}
+	local, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	if err := local.Truncate(int64(ranges.ContentLength)); err != nil {
+		return err
+	}
+
+	for _, written := range ranges.FileRanges {
+		for _, r := range SplitRanges(written.End-written.Start+1, MaxRangeSize) {
+			r.Start += written.Start
+			r.End += written.Start
+			if err := downloadRange(f, r, local); err != nil {
+				return fmt.Errorf("failed to read range %s of %s: %v", r, f.Name, err)
+			}
+		}
+	}
+	return local.Close()
+}
+
+func downloadRange(f *azstorage.File, r azstorage.FileRange, local *os.File) error {
+	stream, err := f.DownloadRangeToStream(r, nil)
+	if err != nil {
+		return err
+	}
+	defer stream.Body.Close()
+	data, err := ioutil.ReadAll(stream.Body)
+	if err != nil {
+		return err
+	}
+	if uint64(len(data)) != r.End-r.Start+1 {
+		return fmt.Errorf("got %d bytes", len(data))
+	}
+	_, err = local.WriteAt(data, int64(r.Start))
+	return err
+}
+
+// UploadDirectory copies a local directory tree into a directory of a
+// share, creating directories as needed. It returns the number of files
+// uploaded.
+func UploadDirectory(share *azstorage.Share, localDir, remoteDir string) (int, error) {
+	root, err := GetDirectory(share, remoteDir, true)
+	if err != nil {
+		return 0, err
+	}
+	dirs := map[string]*azstorage.Directory{".": root}
+	uploaded := 0
+	err = filepath.Walk(localDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		parent := dirs[filepath.Dir(rel)]
+		if info.IsDir() {
+			dir := parent.GetDirectoryReference(info.Name())
+			if _, err := dir.CreateIfNotExists(nil); err != nil {
+				return fmt.Errorf("failed to create directory %s: %v", rel, err)
+			}
+			dirs[rel] = dir
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if _, err := UploadFile(parent, p, info.Name()); err != nil {
+			return err
+		}
+		uploaded++
+		return nil
+	})
+	return uploaded, err
+}
+
+// DownloadDirectory copies a directory tree of a share into a local
+// directory, creating it if needed. It returns the number of files
+// downloaded.
+func DownloadDirectory(share *azstorage.Share, remoteDir, localDir string) (int, error) {
+	dir, err := GetDirectory(share, remoteDir, false)
+	if err != nil {
+		return 0, err
+	}
+	return downloadDirectory(dir, localDir)
+}
+
+func downloadDirectory(dir *azstorage.Directory, localDir string) (int, error) {
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return 0, err
+	}
+	downloaded := 0
+	params := azstorage.ListDirsAndFilesParameters{}
+	for {
+		list, err := dir.ListDirsAndFiles(params)
+		if err != nil {
+			return downloaded, err
+		}
+		// listed entries have no client, so reference them through dir
+		for _, f := range list.Files {
+			if err := DownloadFile(dir.GetFileReference(f.Name), filepath.Join(localDir, f.Name)); err != nil {
+				return downloaded, err
+			}
+			downloaded++
+		}
+		for _, d := range list.Directories {
+			n, err := downloadDirectory(dir.GetDirectoryReference(d.Name), filepath.Join(localDir, d.Name))
+			downloaded += n
+			if err != nil {
+				return downloaded, err
+			}
+		}
+		if list.NextMarker == "" {
+			return downloaded, nil
+		}
+		params.Marker = list.NextMarker
+	}
+}
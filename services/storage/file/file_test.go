@@ -0,0 +1,238 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package file
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marstr/randname"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/util"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/resources"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/storage"
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+)
+
+var (
+	testAccountName      string
+	testAccountGroupName string
+	// createdGroup is set once an example created the resource group,
+	// which teardown then deletes.
+	createdGroup bool
+)
+
+func setup() error {
+	err := config.ParseEnvironment()
+	if err != nil {
+		return fmt.Errorf("failed to add top-level env: %+v", err)
+	}
+	testAccountName = os.Getenv("AZURE_STORAGE_ACCOUNT_NAME")
+	testAccountGroupName = os.Getenv("AZURE_STORAGE_ACCOUNT_GROUP_NAME")
+
+	err = config.AddFlags()
+	if err != nil {
+		return fmt.Errorf("failed to add top-level flags: %+v", err)
+	}
+	flag.StringVar(
+		&testAccountName, "storageAccountName", testAccountName,
+		"Name for test storage account.")
+	flag.StringVar(
+		&testAccountGroupName, "storageAccountGroupName", testAccountGroupName,
+		"Name for the storage account group.")
+	flag.Parse()
+
+	if len(testAccountName) == 0 {
+		testAccountName = strings.ToLower(randname.GenerateWithPrefix("gosdkfiletest", 5))
+	}
+	if len(testAccountGroupName) == 0 {
+		testAccountGroupName = config.GenerateGroupName("storagefile")
+	}
+	return nil
+}
+
+func teardown() error {
+	if createdGroup && config.KeepResources() == false {
+		// does not wait
+		_, err := resources.DeleteGroup(context.Background(), testAccountGroupName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestMain sets up the environment and initiates tests.
+func TestMain(m *testing.M) {
+	err := setup()
+	if err != nil {
+		log.Fatalf("could not set up environment: %+v", err)
+	}
+
+	code := m.Run()
+
+	err = teardown()
+	if err != nil {
+		log.Fatalf(
+			"could not tear down environment: %v\n; original exit code: %v\n",
+			err, code)
+	}
+
+	os.Exit(code)
+}
+
+// writeTree creates a log file and a sparse file bigger than a range under
+// dir.
+func writeTree(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "logs", "2020"), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "logs", "2020", "app.log"), []byte("started\nstopped\n"), 0644); err != nil {
+		return err
+	}
+	sparse := make([]byte, MaxRangeSize+512)
+	copy(sparse[MaxRangeSize:], "end of disk")
+	return ioutil.WriteFile(filepath.Join(dir, "disk.img"), sparse, 0644)
+}
+
+// sameTree reports whether two local directories hold the same files.
+func sameTree(a, b string) (bool, error) {
+	same := true
+	err := filepath.Walk(a, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(a, p)
+		want, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		got, err := ioutil.ReadFile(filepath.Join(b, rel))
+		if err != nil {
+			return err
+		}
+		same = same && bytes.Equal(got, want)
+		return nil
+	})
+	return same, err
+}
+
+func Example_fileShareDirectories() {
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Second)
+	defer cancel()
+
+	_, err := resources.CreateGroup(ctx, testAccountGroupName)
+	if err != nil {
+		util.LogAndPanic(err)
+	}
+	createdGroup = true
+	_, err = storage.CreateStorageAccount(ctx, testAccountName, testAccountGroupName)
+	if err != nil {
+		util.LogAndPanic(err)
+	}
+	fs, err := GetFileServiceClient(ctx, testAccountName, testAccountGroupName)
+	if err != nil {
+		util.LogAndPanic(err)
+	}
+
+	shareName := strings.ToLower(randname.GenerateWithPrefix("gosdkshare", 5))
+	share, err := CreateShare(fs, shareName)
+	if err != nil {
+		util.LogAndPanic(err)
+	}
+	defer DeleteShare(fs, shareName)
+	util.PrintAndLog("created file share")
+
+	local, err := ioutil.TempDir("", "gosdkfile")
+	if err != nil {
+		util.LogAndPanic(err)
+	}
+	defer os.RemoveAll(local)
+	src, dst := filepath.Join(local, "src"), filepath.Join(local, "dst")
+	if err := writeTree(src); err != nil {
+		util.LogAndPanic(err)
+	}
+
+	uploaded, err := UploadDirectory(share, src, "backup/2020")
+	if err != nil {
+		util.LogAndPanic(err)
+	}
+	util.PrintAndLog(fmt.Sprintf("uploaded %d files", uploaded))
+
+	downloaded, err := DownloadDirectory(share, "/backup/2020/", dst)
+	if err != nil {
+		util.LogAndPanic(err)
+	}
+	util.PrintAndLog(fmt.Sprintf("downloaded %d files", downloaded))
+
+	same, err := sameTree(src, dst)
+	if err != nil {
+		util.LogAndPanic(err)
+	}
+	if same {
+		util.PrintAndLog("downloaded files match")
+	}
+
+	// Output:
+	// created file share
+	// uploaded 2 files
+	// downloaded 2 files
+	// downloaded files match
+}
+
+func TestSplitRanges(t *testing.T) {
+	cases := []struct {
+		size, rangeSize uint64
+		want            []azstorage.FileRange
+	}{
+		{0, 4, nil},
+		{1, 4, []azstorage.FileRange{{Start: 0, End: 0}}},
+		{8, 4, []azstorage.FileRange{{Start: 0, End: 3}, {Start: 4, End: 7}}},
+		{9, 4, []azstorage.FileRange{{Start: 0, End: 3}, {Start: 4, End: 7}, {Start: 8, End: 8}}},
+		{MaxRangeSize + 1, 0, []azstorage.FileRange{{Start: 0, End: MaxRangeSize - 1}, {Start: MaxRangeSize, End: MaxRangeSize}}},
+	}
+	for _, c := range cases {
+		if got := SplitRanges(c.size, c.rangeSize); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("SplitRanges(%d, %d) = %v, want %v", c.size, c.rangeSize, got, c.want)
+		}
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	cases := map[string][]string{
+		"":              nil,
+		"/":             nil,
+		"logs":          {"logs"},
+		"/logs/2020/":   {"logs", "2020"},
+		`logs\2020`:     {"logs", "2020"},
+		"logs/./2020":   {"logs", "2020"},
+		"logs/../data":  {"data"},
+		"/../../etc/x/": {"etc", "x"},
+	}
+	for p, want := range cases {
+		if got := splitPath(p); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitPath(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	if !isZero(make([]byte, 16)) || !isZero(nil) {
+		t.Errorf("zero bytes not detected")
+	}
+	if isZero([]byte{0, 0, 1}) {
+		t.Errorf("non-zero bytes reported as zero")
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/keyvault"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
+)
+
+// KeyRotationStep is the last completed step of a key rotation.
+type KeyRotationStep string
+
+const (
+	// KeyRotationStarted means no keys have been changed yet.
+	KeyRotationStarted KeyRotationStep = "started"
+	// KeyRotationStandbyRegenerated means the key not used by consumers has
+	// been regenerated.
+	KeyRotationStandbyRegenerated KeyRotationStep = "standby-key-regenerated"
+	// KeyRotationSecretUpdated means a connection string with the
+	// regenerated key has been stored in Key Vault.
+	KeyRotationSecretUpdated KeyRotationStep = "secret-updated"
+	// KeyRotationConsumersConfirmed means consumers have switched to the
+	// regenerated key.
+	KeyRotationConsumersConfirmed KeyRotationStep = "consumers-confirmed"
+	// KeyRotationCompleted means the key consumers used to hold has been
+	// regenerated too.
+	KeyRotationCompleted KeyRotationStep = "completed"
+)
+
+// KeyRotationState records the progress of a key rotation so that an
+// interrupted run can be resumed.
+type KeyRotationState struct {
+	AccountName      string          `json:"accountName"`
+	AccountGroupName string          `json:"accountGroupName"`
+	ActiveKey        int             `json:"activeKey"` // index of the key in use when the rotation started
+	Step             KeyRotationStep `json:"step"`
+	SecretID         string          `json:"secretId,omitempty"` // Key Vault secret version with the new connection string
+	StartedAt        time.Time       `json:"startedAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
+}
+
+// KeyRotationOptions configures RotateAccountKeys.
+type KeyRotationOptions struct {
+	// ActiveKey is the index (0 or 1) of the key consumers use now. It is
+	// ignored when StatePath holds a rotation of the same account.
+	ActiveKey int
+	// VaultName and SecretName identify the Key Vault secret consumers read
+	// the storage connection string from.
+	VaultName  string
+	SecretName string
+	// ConfirmConsumers is called once the new connection string is in Key
+	// Vault. It should block until every consumer has picked up the secret
+	// version `secretID` and return an error if they have not.
+	ConfirmConsumers func(ctx context.Context, secretID string) error
+	// StatePath is a file in which progress is recorded after each step. If
+	// it holds an unfinished rotation of the same account, that rotation is
+	// resumed. Leave empty to not record progress.
+	StatePath string
+	// StartNew starts another rotation when StatePath holds a completed
+	// rotation of the same account, taking the key that rotation made
+	// active as the active key. Without it the completed rotation is
+	// returned as is, so rerunning a finished rotation changes nothing.
+	StartNew bool
+	// DryRun logs the steps that would be taken without changing keys,
+	// secrets or the state file.
+	DryRun bool
+}
+
+// keyRotator runs the rotation steps; the operations are fields so that the
+// workflow can be exercised without Azure.
+type keyRotator struct {
+	regenerateKey func(ctx context.Context, accountName, accountGroupName string, key int) (storage.AccountListKeysResult, error)
+	listKeys      func(ctx context.Context, accountName, accountGroupName string) (storage.AccountListKeysResult, error)
+	setSecret     func(ctx context.Context, vaultName, secretName, value string) (string, error)
+	now           func() time.Time
+}
+
+// RotateAccountKeys rotates both keys of a storage account without
+// interrupting consumers that read a connection string from Key Vault:
+//
+//  1. regenerate the standby key, which consumers do not use
+//  2. store a connection string with the standby key in Key Vault
+//  3. wait for opts.ConfirmConsumers to report consumers have switched
+//  4. regenerate the previously active key
+//
+// After a successful rotation the standby key is the active one. With a
+// state file the next rotation is started with opts.StartNew, which picks
+// the active key from the file; without one it should pass
+// `1 - state.ActiveKey` as opts.ActiveKey.
+func RotateAccountKeys(ctx context.Context, accountName, accountGroupName string, opts KeyRotationOptions) (KeyRotationState, error) {
+	r := keyRotator{
+		regenerateKey: RegenerateAccountKey,
+		listKeys:      GetAccountKeys,
+		setSecret: func(ctx context.Context, vaultName, secretName, value string) (string, error) {
+			secret, err := keyvault.SetSecret(ctx, vaultName, secretName, value, "storage-connection-string")
+			if err != nil {
+				return "", err
+			}
+			return *secret.ID, nil
+		},
+		now: time.Now,
+	}
+	return r.rotate(ctx, accountName, accountGroupName, opts)
+}
+
+func (r keyRotator) rotate(ctx context.Context, accountName, accountGroupName string, opts KeyRotationOptions) (KeyRotationState, error) {
+	if opts.ConfirmConsumers == nil {
+		return KeyRotationState{}, fmt.Errorf("a consumer confirmation hook is required")
+	}
+	if opts.VaultName == "" || opts.SecretName == "" {
+		return KeyRotationState{}, fmt.Errorf("a vault and secret name are required")
+	}
+
+	state, err := loadKeyRotationState(opts.StatePath)
+	if err != nil {
+		return KeyRotationState{}, err
+	}
+	sameAccount := state != nil && state.AccountName == accountName && state.AccountGroupName == accountGroupName
+	switch {
+	case state != nil && state.Step != KeyRotationCompleted:
+		if !sameAccount {
+			return *state, fmt.Errorf(
+				"state file %s holds an unfinished rotation of account %s in group %s",
+				opts.StatePath, state.AccountName, state.AccountGroupName)
+		}
+		log.Printf("resuming rotation of %s after step %s", accountName, state.Step)
+	case sameAccount && !opts.StartNew:
+		log.Printf("rotation of %s already completed at %s", accountName, state.UpdatedAt)
+		return *state, nil
+	default:
+		activeKey := opts.ActiveKey
+		if sameAccount {
+			activeKey = 1 - state.ActiveKey
+		}
+		if activeKey != 0 && activeKey != 1 {
+			return KeyRotationState{}, fmt.Errorf("active key must be 0 or 1, got %d", activeKey)
+		}
+		state = &KeyRotationState{
+			AccountName:      accountName,
+			AccountGroupName: accountGroupName,
+			ActiveKey:        activeKey,
+			Step:             KeyRotationStarted,
+			StartedAt:        r.now(),
+		}
+	}
+
+	active, standby := state.ActiveKey, 1-state.ActiveKey
+	logf := log.Printf
+	if opts.DryRun {
+		logf = func(format string, v ...interface{}) {
+			log.Printf("dry run: would "+format, v...)
+		}
+	}
+
+	for state.Step != KeyRotationCompleted {
+		var next KeyRotationStep
+		switch state.Step {
+		case KeyRotationStarted:
+			logf("regenerate standby key%d of %s", standby+1, accountName)
+			if !opts.DryRun {
+				if _, err := r.regenerateKey(ctx, accountName, accountGroupName, standby); err != nil {
+					return *state, fmt.Errorf("failed to regenerate standby key: %v", err)
+				}
+			}
+			next = KeyRotationStandbyRegenerated
+
+		case KeyRotationStandbyRegenerated:
+			logf("store connection string for key%d in secret %s of vault %s", standby+1, opts.SecretName, opts.VaultName)
+			if !opts.DryRun {
+				keys, err := r.listKeys(ctx, accountName, accountGroupName)
+				if err != nil {
+					return *state, fmt.Errorf("failed to list keys: %v", err)
+				}
+				if keys.Keys == nil || len(*keys.Keys) < 2 {
+					return *state, fmt.Errorf("expected two keys for account %s", accountName)
+				}
+				secretID, err := r.setSecret(ctx, opts.VaultName, opts.SecretName,
+					connectionString(accountName, *(*keys.Keys)[standby].Value))
+				if err != nil {
+					return *state, fmt.Errorf("failed to store connection string: %v", err)
+				}
+				state.SecretID = secretID
+			}
+			next = KeyRotationSecretUpdated
+
+		case KeyRotationSecretUpdated:
+			logf("wait for consumers to confirm secret %s", state.SecretID)
+			if !opts.DryRun {
+				if err := opts.ConfirmConsumers(ctx, state.SecretID); err != nil {
+					return *state, fmt.Errorf("consumers did not confirm the new connection string: %v", err)
+				}
+			}
+			next = KeyRotationConsumersConfirmed
+
+		case KeyRotationConsumersConfirmed:
+			logf("regenerate previously active key%d of %s", active+1, accountName)
+			if !opts.DryRun {
+				if _, err := r.regenerateKey(ctx, accountName, accountGroupName, active); err != nil {
+					return *state, fmt.Errorf("failed to regenerate previously active key: %v", err)
+				}
+			}
+			next = KeyRotationCompleted
+
+		default:
+			return *state, fmt.Errorf("unknown rotation step %q", state.Step)
+		}
+
+		state.Step = next
+		state.UpdatedAt = r.now()
+		if !opts.DryRun {
+			if err := saveKeyRotationState(opts.StatePath, state); err != nil {
+				return *state, err
+			}
+		}
+	}
+	return *state, nil
+}
+
+// connectionString returns a storage connection string for the account in
+// the configured cloud.
+func connectionString(accountName, accountKey string) string {
+	return fmt.Sprintf(
+		"DefaultEndpointsProtocol=https;AccountName=%s;AccountKey=%s;EndpointSuffix=%s",
+		accountName, accountKey, config.Environment().StorageEndpointSuffix)
+}
+
+func loadKeyRotationState(path string) (*KeyRotationState, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rotation state: %v", err)
+	}
+	var state KeyRotationState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse rotation state %s: %v", path, err)
+	}
+	return &state, nil
+}
+
+// saveKeyRotationState writes the state to a temporary file and renames it
+// into place so that a crash never leaves a partial file behind.
+func saveKeyRotationState(path string, state *KeyRotationState) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write rotation state: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write rotation state: %v", err)
+	}
+	return nil
+}
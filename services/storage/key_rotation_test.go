@@ -0,0 +1,215 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// fakeKeyRotator records the operations a rotation performs.
+type fakeKeyRotator struct {
+	calls []string
+	keys  [2]int // generation of each key
+}
+
+func (f *fakeKeyRotator) rotator() keyRotator {
+	return keyRotator{
+		regenerateKey: func(ctx context.Context, accountName, accountGroupName string, key int) (storage.AccountListKeysResult, error) {
+			f.keys[key]++
+			f.calls = append(f.calls, fmt.Sprintf("regenerate key%d", key+1))
+			return storage.AccountListKeysResult{}, nil
+		},
+		listKeys: func(ctx context.Context, accountName, accountGroupName string) (storage.AccountListKeysResult, error) {
+			return storage.AccountListKeysResult{Keys: &[]storage.AccountKey{
+				{KeyName: to.StringPtr("key1"), Value: to.StringPtr(fmt.Sprintf("key1-v%d", f.keys[0]))},
+				{KeyName: to.StringPtr("key2"), Value: to.StringPtr(fmt.Sprintf("key2-v%d", f.keys[1]))},
+			}}, nil
+		},
+		setSecret: func(ctx context.Context, vaultName, secretName, value string) (string, error) {
+			f.calls = append(f.calls, "set secret "+value)
+			return "https://vault/secrets/" + secretName + "/1", nil
+		},
+		now: func() time.Time { return time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC) },
+	}
+}
+
+func testRotationOptions(dir string, f *fakeKeyRotator, confirmErr error) KeyRotationOptions {
+	return KeyRotationOptions{
+		ActiveKey:  0,
+		VaultName:  "vault",
+		SecretName: "conn",
+		StatePath:  filepath.Join(dir, "state.json"),
+		ConfirmConsumers: func(ctx context.Context, secretID string) error {
+			f.calls = append(f.calls, "confirm "+secretID)
+			return confirmErr
+		},
+	}
+}
+
+func TestRotateAccountKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyrotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &fakeKeyRotator{}
+	opts := testRotationOptions(dir, f, nil)
+
+	state, err := f.rotator().rotate(context.Background(), "acct", "group", opts)
+	if err != nil {
+		t.Fatalf("rotation failed: %v", err)
+	}
+	want := []string{
+		"regenerate key2",
+		"set secret DefaultEndpointsProtocol=https;AccountName=acct;AccountKey=key2-v1;EndpointSuffix=core.windows.net",
+		"confirm https://vault/secrets/conn/1",
+		"regenerate key1",
+	}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %q, want %q", f.calls, want)
+	}
+	if state.Step != KeyRotationCompleted {
+		t.Errorf("step = %s, want %s", state.Step, KeyRotationCompleted)
+	}
+
+	saved, err := loadKeyRotationState(opts.StatePath)
+	if err != nil || saved == nil || saved.Step != KeyRotationCompleted {
+		t.Errorf("state file not completed: %+v, %v", saved, err)
+	}
+}
+
+func TestRotateAccountKeysRerun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyrotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &fakeKeyRotator{}
+	opts := testRotationOptions(dir, f, nil)
+	first, err := f.rotator().rotate(context.Background(), "acct", "group", opts)
+	if err != nil {
+		t.Fatalf("rotation failed: %v", err)
+	}
+
+	// rerunning the same command must not regenerate key2, which consumers
+	// now use
+	f.calls = nil
+	state, err := f.rotator().rotate(context.Background(), "acct", "group", opts)
+	if err != nil {
+		t.Fatalf("rerun failed: %v", err)
+	}
+	if len(f.calls) != 0 || !reflect.DeepEqual(state, first) {
+		t.Errorf("rerun of a completed rotation changed keys: calls %q, state %+v", f.calls, state)
+	}
+
+	// the next rotation starts from the key the completed one made active,
+	// whatever opts.ActiveKey says
+	opts.StartNew = true
+	state, err = f.rotator().rotate(context.Background(), "acct", "group", opts)
+	if err != nil {
+		t.Fatalf("next rotation failed: %v", err)
+	}
+	want := []string{
+		"regenerate key1",
+		"set secret DefaultEndpointsProtocol=https;AccountName=acct;AccountKey=key1-v2;EndpointSuffix=core.windows.net",
+		"confirm https://vault/secrets/conn/1",
+		"regenerate key2",
+	}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %q, want %q", f.calls, want)
+	}
+	if state.ActiveKey != 1 || state.Step != KeyRotationCompleted {
+		t.Errorf("unexpected state of the next rotation: %+v", state)
+	}
+}
+
+func TestRotateAccountKeysResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyrotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &fakeKeyRotator{}
+	opts := testRotationOptions(dir, f, fmt.Errorf("consumer still on old key"))
+
+	state, err := f.rotator().rotate(context.Background(), "acct", "group", opts)
+	if err == nil {
+		t.Fatalf("expected rotation to stop when consumers do not confirm")
+	}
+	if state.Step != KeyRotationSecretUpdated {
+		t.Fatalf("step = %s, want %s", state.Step, KeyRotationSecretUpdated)
+	}
+
+	// the rerun must not touch the standby key or secret again, and must
+	// keep the active key from the first run
+	f.calls = nil
+	opts.ActiveKey = 1
+	opts.ConfirmConsumers = func(ctx context.Context, secretID string) error {
+		f.calls = append(f.calls, "confirm "+secretID)
+		return nil
+	}
+	if _, err := f.rotator().rotate(context.Background(), "acct", "group", opts); err != nil {
+		t.Fatalf("resumed rotation failed: %v", err)
+	}
+	want := []string{"confirm https://vault/secrets/conn/1", "regenerate key1"}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls = %q, want %q", f.calls, want)
+	}
+
+	// a completed rotation does not block rotating another account
+	if _, err := f.rotator().rotate(context.Background(), "other", "group", opts); err != nil {
+		t.Errorf("rotation of another account failed: %v", err)
+	}
+}
+
+func TestRotateAccountKeysRejectsOtherAccountState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyrotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &fakeKeyRotator{}
+	opts := testRotationOptions(dir, f, fmt.Errorf("not yet"))
+	if _, err := f.rotator().rotate(context.Background(), "acct", "group", opts); err == nil {
+		t.Fatalf("expected rotation to stop")
+	}
+	if _, err := f.rotator().rotate(context.Background(), "other", "group", opts); err == nil {
+		t.Errorf("expected unfinished rotation of another account to be rejected")
+	}
+}
+
+func TestRotateAccountKeysDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyrotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &fakeKeyRotator{}
+	opts := testRotationOptions(dir, f, nil)
+	opts.DryRun = true
+
+	state, err := f.rotator().rotate(context.Background(), "acct", "group", opts)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("dry run performed operations: %q", f.calls)
+	}
+	if state.Step != KeyRotationCompleted {
+		t.Errorf("step = %s, want %s", state.Step, KeyRotationCompleted)
+	}
+	if _, err := os.Stat(opts.StatePath); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote state file: %v", err)
+	}
+}
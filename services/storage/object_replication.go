@@ -0,0 +1,289 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	// objectReplicationServiceVersion is the first blob service version
+	// that returns object replication headers with blob properties.
+	objectReplicationServiceVersion = "2019-12-12"
+
+	// defaultObjectReplicationPolicyID is used when creating a policy on
+	// the destination account, which then assigns the real policy ID.
+	defaultObjectReplicationPolicyID = "default"
+)
+
+// ReplicateAllExistingBlobs can be used as an ObjectReplicationRule's
+// MinCreationTime to also replicate blobs that existed before the policy.
+var ReplicateAllExistingBlobs = time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ObjectReplicationRule replicates blobs from a source container to a
+// destination container.
+type ObjectReplicationRule struct {
+	// RuleID is assigned by the destination account; leave it empty when
+	// creating a policy.
+	RuleID               string
+	SourceContainer      string
+	DestinationContainer string
+	// PrefixMatch limits replication to blobs whose names start with one of
+	// the prefixes.
+	PrefixMatch []string
+	// MinCreationTime limits replication to blobs created after it. When
+	// zero only blobs created after the policy are replicated.
+	MinCreationTime time.Time
+}
+
+func objectReplicationPolicy(sourceAccountName, destinationAccountName string, rules []ObjectReplicationRule) storage.ObjectReplicationPolicy {
+	var policyRules []storage.ObjectReplicationPolicyRule
+	for _, r := range rules {
+		rule := storage.ObjectReplicationPolicyRule{
+			SourceContainer:      to.StringPtr(r.SourceContainer),
+			DestinationContainer: to.StringPtr(r.DestinationContainer),
+		}
+		if r.RuleID != "" {
+			rule.RuleID = to.StringPtr(r.RuleID)
+		}
+		if len(r.PrefixMatch) > 0 || !r.MinCreationTime.IsZero() {
+			rule.Filters = &storage.ObjectReplicationPolicyFilter{}
+			if len(r.PrefixMatch) > 0 {
+				prefixes := append([]string{}, r.PrefixMatch...)
+				rule.Filters.PrefixMatch = &prefixes
+			}
+			if !r.MinCreationTime.IsZero() {
+				rule.Filters.MinCreationTime = to.StringPtr(r.MinCreationTime.UTC().Format(sasTimeFormat))
+			}
+		}
+		policyRules = append(policyRules, rule)
+	}
+	return storage.ObjectReplicationPolicy{
+		ObjectReplicationPolicyProperties: &storage.ObjectReplicationPolicyProperties{
+			SourceAccount:      to.StringPtr(sourceAccountName),
+			DestinationAccount: to.StringPtr(destinationAccountName),
+			Rules:              &policyRules,
+		},
+	}
+}
+
+// CreateObjectReplicationPolicy creates an object replication policy between
+// two accounts. Both need blob versioning enabled, and the source needs the
+// change feed enabled too. The policy is created on the destination account
+// first, which assigns the policy and rule IDs, and then on the source
+// account with those IDs. The source account's policy is returned.
+func CreateObjectReplicationPolicy(ctx context.Context, sourceAccountName, sourceGroupName, destinationAccountName, destinationGroupName string, rules []ObjectReplicationRule) (storage.ObjectReplicationPolicy, error) {
+	if len(rules) == 0 {
+		return storage.ObjectReplicationPolicy{}, fmt.Errorf("object replication policy requires at least one rule")
+	}
+	objRepClient := getObjRepClient()
+
+	destination, err := objRepClient.CreateOrUpdate(
+		ctx,
+		destinationGroupName,
+		destinationAccountName,
+		defaultObjectReplicationPolicyID,
+		objectReplicationPolicy(sourceAccountName, destinationAccountName, rules))
+	if err != nil {
+		return destination, fmt.Errorf("failed to create policy on destination account: %v", err)
+	}
+
+	// the source policy must carry the IDs the destination assigned
+	if destination.ObjectReplicationPolicyProperties == nil || destination.PolicyID == nil {
+		return destination, fmt.Errorf("cannot create policy on source account: destination account returned no policy ID")
+	}
+	source := destination
+	source.ID, source.Name, source.Type = nil, nil, nil
+	return objRepClient.CreateOrUpdate(
+		ctx,
+		sourceGroupName,
+		sourceAccountName,
+		*destination.PolicyID,
+		source)
+}
+
+// GetObjectReplicationPolicy gets an object replication policy of an account.
+func GetObjectReplicationPolicy(ctx context.Context, accountName, accountGroupName, policyID string) (storage.ObjectReplicationPolicy, error) {
+	objRepClient := getObjRepClient()
+	return objRepClient.Get(ctx, accountGroupName, accountName, policyID)
+}
+
+// ListObjectReplicationPolicies lists the object replication policies of an
+// account, whether it is their source or destination.
+func ListObjectReplicationPolicies(ctx context.Context, accountName, accountGroupName string) (storage.ObjectReplicationPolicies, error) {
+	objRepClient := getObjRepClient()
+	return objRepClient.List(ctx, accountGroupName, accountName)
+}
+
+// DeleteObjectReplicationPolicy deletes an object replication policy from
+// the source account and then from the destination account.
+func DeleteObjectReplicationPolicy(ctx context.Context, sourceAccountName, sourceGroupName, destinationAccountName, destinationGroupName, policyID string) error {
+	objRepClient := getObjRepClient()
+	_, err := objRepClient.Delete(ctx, sourceGroupName, sourceAccountName, policyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete policy from source account: %v", err)
+	}
+	_, err = objRepClient.Delete(ctx, destinationGroupName, destinationAccountName, policyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete policy from destination account: %v", err)
+	}
+	return nil
+}
+
+// ObjectReplicationStatus is the replication status of a source blob for one
+// rule.
+type ObjectReplicationStatus string
+
+const (
+	// ObjectReplicationComplete means the blob was copied to the destination.
+	ObjectReplicationComplete ObjectReplicationStatus = "complete"
+	// ObjectReplicationFailed means copying the blob failed.
+	ObjectReplicationFailed ObjectReplicationStatus = "failed"
+)
+
+// RuleReplicationStatus is the status of a blob under one policy rule.
+type RuleReplicationStatus struct {
+	PolicyID string
+	RuleID   string
+	Status   ObjectReplicationStatus
+}
+
+// BlobReplicationStatus reports replication of a blob. Source blobs carry
+// a status per rule once replication has been attempted; blobs that match a
+// rule but have no status yet are still pending. Destination blobs carry
+// the ID of the policy that replicated them instead.
+type BlobReplicationStatus struct {
+	BlobName            string
+	Rules               []RuleReplicationStatus
+	DestinationPolicyID string
+}
+
+// Failed reports whether replication failed under any rule.
+func (s BlobReplicationStatus) Failed() bool {
+	for _, r := range s.Rules {
+		if r.Status == ObjectReplicationFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// parseReplicationHeaders reads replication status from blob property
+// headers: `x-ms-or-policy-id` on destination blobs and
+// `x-ms-or-{policyId}_{ruleId}` on source blobs.
+func parseReplicationHeaders(blobName string, h http.Header) BlobReplicationStatus {
+	status := BlobReplicationStatus{BlobName: blobName}
+	for key, values := range h {
+		key = strings.ToLower(key)
+		if !strings.HasPrefix(key, "x-ms-or-") || len(values) == 0 {
+			continue
+		}
+		if key == "x-ms-or-policy-id" {
+			status.DestinationPolicyID = values[0]
+			continue
+		}
+		ids := strings.SplitN(strings.TrimPrefix(key, "x-ms-or-"), "_", 2)
+		if len(ids) != 2 {
+			continue
+		}
+		status.Rules = append(status.Rules, RuleReplicationStatus{
+			PolicyID: ids[0],
+			RuleID:   ids[1],
+			Status:   ObjectReplicationStatus(strings.ToLower(values[0])),
+		})
+	}
+	sort.Slice(status.Rules, func(i, j int) bool {
+		if status.Rules[i].PolicyID != status.Rules[j].PolicyID {
+			return status.Rules[i].PolicyID < status.Rules[j].PolicyID
+		}
+		return status.Rules[i].RuleID < status.Rules[j].RuleID
+	})
+	return status
+}
+
+// serviceVersionCredential signs requests with a shared key after replacing
+// the service version the azblob package sends.
+type serviceVersionCredential struct {
+	*azblob.SharedKeyCredential
+	version string
+}
+
+func (c serviceVersionCredential) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	sign := c.SharedKeyCredential.New(next, po)
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		request.Header.Set("x-ms-version", c.version)
+		return sign.Do(ctx, request)
+	})
+}
+
+// getReplicationContainerURL is like getContainerURL but requests a service
+// version that returns object replication headers.
+func getReplicationContainerURL(ctx context.Context, accountName, accountGroupName, containerName string) (azblob.ContainerURL, error) {
+	keys, err := GetAccountKeys(ctx, accountName, accountGroupName)
+	if err != nil {
+		return azblob.ContainerURL{}, err
+	}
+	c, err := azblob.NewSharedKeyCredential(accountName, *(*keys.Keys)[0].Value)
+	if err != nil {
+		return azblob.ContainerURL{}, err
+	}
+	p := azblob.NewPipeline(
+		serviceVersionCredential{SharedKeyCredential: c, version: objectReplicationServiceVersion},
+		azblob.PipelineOptions{
+			Telemetry: azblob.TelemetryOptions{Value: config.UserAgent()},
+		})
+	u, _ := url.Parse(fmt.Sprintf(blobFormatString, accountName))
+	return azblob.NewServiceURL(*u, p).NewContainerURL(containerName), nil
+}
+
+// GetBlobReplicationStatus reads the object replication status of a blob
+// from its properties.
+func GetBlobReplicationStatus(ctx context.Context, accountName, accountGroupName, containerName, blobName string) (BlobReplicationStatus, error) {
+	c, err := getReplicationContainerURL(ctx, accountName, accountGroupName, containerName)
+	if err != nil {
+		return BlobReplicationStatus{}, err
+	}
+	props, err := c.NewBlobURL(blobName).GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return BlobReplicationStatus{}, err
+	}
+	return parseReplicationHeaders(blobName, props.Response().Header), nil
+}
+
+// ListContainerReplicationStatus reads the object replication status of
+// every blob in a container whose name starts with prefix.
+func ListContainerReplicationStatus(ctx context.Context, accountName, accountGroupName, containerName, prefix string) ([]BlobReplicationStatus, error) {
+	c, err := getReplicationContainerURL(ctx, accountName, accountGroupName, containerName)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []BlobReplicationStatus
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		list, err := c.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return statuses, err
+		}
+		for _, blob := range list.Segment.BlobItems {
+			props, err := c.NewBlobURL(blob.Name).GetProperties(ctx, azblob.BlobAccessConditions{})
+			if err != nil {
+				return statuses, fmt.Errorf("failed to get properties of %s: %v", blob.Name, err)
+			}
+			statuses = append(statuses, parseReplicationHeaders(blob.Name, props.Response().Header))
+		}
+		marker = list.NextMarker
+	}
+	return statuses, nil
+}
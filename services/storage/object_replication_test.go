@@ -0,0 +1,66 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package storage
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestObjectReplicationPolicy(t *testing.T) {
+	policy := objectReplicationPolicy("source", "destination", []ObjectReplicationRule{
+		{SourceContainer: "logs", DestinationContainer: "logs-replica", PrefixMatch: []string{"2021/"}},
+		{SourceContainer: "images", DestinationContainer: "images-replica", MinCreationTime: ReplicateAllExistingBlobs},
+		{RuleID: "rule-3", SourceContainer: "a", DestinationContainer: "b"},
+	})
+
+	if *policy.SourceAccount != "source" || *policy.DestinationAccount != "destination" {
+		t.Errorf("unexpected accounts: %s -> %s", *policy.SourceAccount, *policy.DestinationAccount)
+	}
+	rules := *policy.Rules
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rules, got %d", len(rules))
+	}
+	if rules[0].RuleID != nil || !reflect.DeepEqual(*rules[0].Filters.PrefixMatch, []string{"2021/"}) || rules[0].Filters.MinCreationTime != nil {
+		t.Errorf("unexpected first rule: %+v", rules[0].Filters)
+	}
+	if *rules[1].Filters.MinCreationTime != "1601-01-01T00:00:00Z" || rules[1].Filters.PrefixMatch != nil {
+		t.Errorf("unexpected second rule: %+v", rules[1].Filters)
+	}
+	if *rules[2].RuleID != "rule-3" || rules[2].Filters != nil {
+		t.Errorf("unexpected third rule: %+v", rules[2])
+	}
+}
+
+func TestParseReplicationHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Content-Length", "10")
+	h.Set("x-ms-or-8b2c1e7a-0000-4000-8000-000000000001_4e3f0a1b-0000-4000-8000-000000000002", "complete")
+	h.Set("x-ms-or-8b2c1e7a-0000-4000-8000-000000000001_4e3f0a1b-0000-4000-8000-000000000001", "Failed")
+	h.Set("Last-Modified", time.Now().Format(http.TimeFormat))
+
+	status := parseReplicationHeaders("blob.txt", h)
+	want := BlobReplicationStatus{
+		BlobName: "blob.txt",
+		Rules: []RuleReplicationStatus{
+			{PolicyID: "8b2c1e7a-0000-4000-8000-000000000001", RuleID: "4e3f0a1b-0000-4000-8000-000000000001", Status: ObjectReplicationFailed},
+			{PolicyID: "8b2c1e7a-0000-4000-8000-000000000001", RuleID: "4e3f0a1b-0000-4000-8000-000000000002", Status: ObjectReplicationComplete},
+		},
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("status = %+v, want %+v", status, want)
+	}
+	if !status.Failed() {
+		t.Errorf("expected failed rule to be reported")
+	}
+
+	h = http.Header{}
+	h.Set("x-ms-or-policy-id", "8b2c1e7a-0000-4000-8000-000000000001")
+	status = parseReplicationHeaders("replica.txt", h)
+	if status.DestinationPolicyID != "8b2c1e7a-0000-4000-8000-000000000001" || len(status.Rules) != 0 || status.Failed() {
+		t.Errorf("unexpected destination status: %+v", status)
+	}
+}
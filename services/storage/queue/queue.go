@@ -0,0 +1,216 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+// Package queue demonstrates sending, receiving and deleting Azure Storage
+// queue messages, including visibility timeouts and poison messages.
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/storage"
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+)
+
+const (
+	// DefaultMaxDequeueCount is the number of times a message can be
+	// received before ProcessMessages treats it as a poison message.
+	DefaultMaxDequeueCount = 5
+
+	// maxMessagesPerReceive is the most messages the service returns from a
+	// single receive or peek.
+	maxMessagesPerReceive = 32
+)
+
+// GetQueueServiceClient returns a client for the queue service of a storage
+// account, authorized with the account's primary key.
+func GetQueueServiceClient(ctx context.Context, accountName, accountGroupName string) (azstorage.QueueServiceClient, error) {
+	keys, err := storage.GetAccountKeys(ctx, accountName, accountGroupName)
+	if err != nil {
+		return azstorage.QueueServiceClient{}, err
+	}
+	client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, *(*keys.Keys)[0].Value, *config.Environment())
+	if err != nil {
+		return azstorage.QueueServiceClient{}, err
+	}
+	_ = client.AddToUserAgent(config.UserAgent())
+	return client.GetQueueService(), nil
+}
+
+// GetEmulatorQueueServiceClient returns a client for the queue service of a
+// local storage emulator such as Azurite, listening on 127.0.0.1:10001.
+func GetEmulatorQueueServiceClient() (azstorage.QueueServiceClient, error) {
+	client, err := azstorage.NewEmulatorClient()
+	if err != nil {
+		return azstorage.QueueServiceClient{}, err
+	}
+	_ = client.AddToUserAgent(config.UserAgent())
+	return client.GetQueueService(), nil
+}
+
+// CreateQueue creates the named queue if it does not exist yet.
+func CreateQueue(qs azstorage.QueueServiceClient, queueName string) (*azstorage.Queue, error) {
+	q := qs.GetQueueReference(queueName)
+	exists, err := q.Exists()
+	if err != nil {
+		return q, err
+	}
+	if exists {
+		return q, nil
+	}
+	return q, q.Create(nil)
+}
+
+// DeleteQueue deletes the named queue and any messages in it.
+func DeleteQueue(qs azstorage.QueueServiceClient, queueName string) error {
+	return qs.GetQueueReference(queueName).Delete(nil)
+}
+
+// SendMessage adds a message to the back of the queue. The message stays
+// invisible to receivers for `delay`, and expires after `ttl`; zero values
+// use the service defaults of no delay and seven days.
+func SendMessage(q *azstorage.Queue, text string, delay, ttl time.Duration) (*azstorage.Message, error) {
+	msg := q.GetMessageReference(text)
+	err := msg.Put(&azstorage.PutMessageOptions{
+		VisibilityTimeout: int(delay / time.Second),
+		MessageTTL:        int(ttl / time.Second),
+	})
+	return msg, err
+}
+
+// ReceiveMessages receives up to max messages from the front of the queue
+// and hides them from other receivers for visibilityTimeout. Delete each
+// message once it is processed, or it reappears when the timeout expires.
+func ReceiveMessages(q *azstorage.Queue, max int, visibilityTimeout time.Duration) ([]azstorage.Message, error) {
+	if max <= 0 || max > maxMessagesPerReceive {
+		return nil, fmt.Errorf("can receive between 1 and %d messages, not %d", maxMessagesPerReceive, max)
+	}
+	messages, err := q.GetMessages(&azstorage.GetMessagesOptions{
+		NumOfMessages:     max,
+		VisibilityTimeout: int(visibilityTimeout / time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Queue = q
+	}
+	return messages, nil
+}
+
+// PeekMessages returns up to max messages from the front of the queue
+// without hiding them from receivers. Peeked messages cannot be deleted.
+func PeekMessages(q *azstorage.Queue, max int) ([]azstorage.Message, error) {
+	if max <= 0 || max > maxMessagesPerReceive {
+		return nil, fmt.Errorf("can peek between 1 and %d messages, not %d", maxMessagesPerReceive, max)
+	}
+	return q.PeekMessages(&azstorage.PeekMessagesOptions{NumOfMessages: max})
+}
+
+// DeleteMessage deletes a received message from its queue.
+func DeleteMessage(msg *azstorage.Message) error {
+	return msg.Delete(nil)
+}
+
+// ExtendVisibility hides a received message from other receivers for a
+// further `timeout`, for processing that takes longer than expected.
+func ExtendVisibility(msg *azstorage.Message, timeout time.Duration) error {
+	return msg.Update(&azstorage.UpdateMessageOptions{
+		VisibilityTimeout: int(timeout / time.Second),
+	})
+}
+
+// ProcessOptions configures ProcessMessages.
+type ProcessOptions struct {
+	// MaxMessages is the number of messages to receive, at most 32.
+	MaxMessages int
+	// VisibilityTimeout is how long received messages are hidden from other
+	// receivers while the handler runs.
+	VisibilityTimeout time.Duration
+	// MaxDequeueCount is how many times a message may be received before it
+	// is moved to the poison queue. Defaults to DefaultMaxDequeueCount.
+	MaxDequeueCount int
+	// PoisonQueue receives messages that exceeded MaxDequeueCount. If nil,
+	// they are moved to a queue named after the source queue with a
+	// `-poison` suffix, which is created if needed.
+	PoisonQueue *azstorage.Queue
+}
+
+// ProcessResult counts what happened to received messages.
+type ProcessResult struct {
+	Processed int // handled and deleted
+	Failed    int // left to reappear after the visibility timeout
+	Poisoned  int // moved to the poison queue
+}
+
+// IsPoison reports whether a message has been received more than
+// maxDequeueCount times and so keeps failing.
+func IsPoison(msg azstorage.Message, maxDequeueCount int) bool {
+	if maxDequeueCount <= 0 {
+		maxDequeueCount = DefaultMaxDequeueCount
+	}
+	return msg.DequeueCount > maxDequeueCount
+}
+
+// PoisonQueueName returns the default poison queue name for a queue.
+func PoisonQueueName(queueName string) string {
+	return queueName + "-poison"
+}
+
+// ProcessMessages receives a batch of messages from the named queue and
+// calls handle for each. Messages are deleted when handle returns nil, and
+// left to reappear after the visibility timeout when it fails. Messages
+// received too many times are moved to the poison queue without calling
+// handle.
+func ProcessMessages(ctx context.Context, qs azstorage.QueueServiceClient, queueName string, opts ProcessOptions, handle func(context.Context, azstorage.Message) error) (ProcessResult, error) {
+	var result ProcessResult
+	q := qs.GetQueueReference(queueName)
+	if opts.MaxMessages == 0 {
+		opts.MaxMessages = maxMessagesPerReceive
+	}
+	messages, err := ReceiveMessages(q, opts.MaxMessages, opts.VisibilityTimeout)
+	if err != nil {
+		return result, err
+	}
+
+	for i := range messages {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		msg := &messages[i]
+		if IsPoison(*msg, opts.MaxDequeueCount) {
+			if opts.PoisonQueue == nil {
+				if opts.PoisonQueue, err = CreateQueue(qs, PoisonQueueName(queueName)); err != nil {
+					return result, fmt.Errorf("failed to create poison queue: %v", err)
+				}
+			}
+			if err := movePoisonMessage(msg, opts.PoisonQueue); err != nil {
+				return result, err
+			}
+			result.Poisoned++
+			continue
+		}
+		if err := handle(ctx, *msg); err != nil {
+			result.Failed++
+			continue
+		}
+		if err := DeleteMessage(msg); err != nil {
+			return result, fmt.Errorf("failed to delete processed message %s: %v", msg.ID, err)
+		}
+		result.Processed++
+	}
+	return result, nil
+}
+
+func movePoisonMessage(msg *azstorage.Message, poison *azstorage.Queue) error {
+	if _, err := SendMessage(poison, msg.Text, 0, 0); err != nil {
+		return fmt.Errorf("failed to move message %s to poison queue: %v", msg.ID, err)
+	}
+	if err := DeleteMessage(msg); err != nil {
+		return fmt.Errorf("failed to delete poison message %s: %v", msg.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/marstr/randname"
+)
+
+// emulatorQueueService returns a queue client for a local emulator, or skips
+// the test unless AZURE_STORAGE_EMULATOR is set to true.
+func emulatorQueueService(t *testing.T) azstorage.QueueServiceClient {
+	if os.Getenv("AZURE_STORAGE_EMULATOR") != "true" {
+		t.Skip("set AZURE_STORAGE_EMULATOR=true to run against a local storage emulator")
+	}
+	qs, err := GetEmulatorQueueServiceClient()
+	if err != nil {
+		t.Fatalf("failed to create emulator client: %v", err)
+	}
+	return qs
+}
+
+func TestIsPoison(t *testing.T) {
+	cases := []struct {
+		dequeueCount, max int
+		want              bool
+	}{
+		{1, 0, false},
+		{DefaultMaxDequeueCount, 0, false},
+		{DefaultMaxDequeueCount + 1, 0, true},
+		{2, 1, true},
+		{2, 2, false},
+	}
+	for _, c := range cases {
+		msg := azstorage.Message{DequeueCount: c.dequeueCount}
+		if got := IsPoison(msg, c.max); got != c.want {
+			t.Errorf("IsPoison(dequeued %d, max %d) = %v, want %v", c.dequeueCount, c.max, got, c.want)
+		}
+	}
+	if got := PoisonQueueName("orders"); got != "orders-poison" {
+		t.Errorf("PoisonQueueName() = %q", got)
+	}
+}
+
+func TestReceiveMessagesLimits(t *testing.T) {
+	q := &azstorage.Queue{Name: "unused"}
+	for _, max := range []int{0, -1, maxMessagesPerReceive + 1} {
+		if _, err := ReceiveMessages(q, max, time.Second); err == nil {
+			t.Errorf("ReceiveMessages(%d) should fail", max)
+		}
+		if _, err := PeekMessages(q, max); err == nil {
+			t.Errorf("PeekMessages(%d) should fail", max)
+		}
+	}
+}
+
+func TestQueueMessagesEmulator(t *testing.T) {
+	qs := emulatorQueueService(t)
+	queueName := strings.ToLower(randname.GenerateWithPrefix("gosdkqueue", 6))
+	q, err := CreateQueue(qs, queueName)
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	defer DeleteQueue(qs, queueName)
+	defer DeleteQueue(qs, PoisonQueueName(queueName))
+
+	for i := 0; i < 3; i++ {
+		if _, err := SendMessage(q, fmt.Sprintf("message %d", i), 0, time.Hour); err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+	}
+	if _, err := SendMessage(q, "delayed", time.Hour, 0); err != nil {
+		t.Fatalf("failed to send delayed message: %v", err)
+	}
+
+	peeked, err := PeekMessages(q, 10)
+	if err != nil {
+		t.Fatalf("failed to peek: %v", err)
+	}
+	if len(peeked) != 3 {
+		t.Errorf("peeked %d messages, want 3 visible", len(peeked))
+	}
+
+	// fail "message 1" every time so that it is poisoned on the third receive
+	opts := ProcessOptions{VisibilityTimeout: time.Second, MaxDequeueCount: 2}
+	handle := func(ctx context.Context, msg azstorage.Message) error {
+		if msg.Text == "message 1" {
+			return fmt.Errorf("cannot handle %s", msg.Text)
+		}
+		return nil
+	}
+	var total ProcessResult
+	for i := 0; i < 3; i++ {
+		result, err := ProcessMessages(context.Background(), qs, queueName, opts, handle)
+		if err != nil {
+			t.Fatalf("failed to process messages: %v", err)
+		}
+		total.Processed += result.Processed
+		total.Failed += result.Failed
+		total.Poisoned += result.Poisoned
+		time.Sleep(2 * time.Second)
+	}
+	if total != (ProcessResult{Processed: 2, Failed: 2, Poisoned: 1}) {
+		t.Errorf("processed %+v", total)
+	}
+
+	poisoned, err := PeekMessages(qs.GetQueueReference(PoisonQueueName(queueName)), 10)
+	if err != nil {
+		t.Fatalf("failed to peek poison queue: %v", err)
+	}
+	if len(poisoned) != 1 || poisoned[0].Text != "message 1" {
+		t.Errorf("unexpected poison messages: %+v", poisoned)
+	}
+}
@@ -0,0 +1,784 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/iam"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+const (
+	// sasVersion is the storage service version used to sign SAS tokens. It
+	// is the first version that supports user delegation SAS.
+	sasVersion = "2018-11-09"
+
+	sasTimeFormat = "2006-01-02T15:04:05Z"
+	sasDateFormat = "2006-01-02"
+)
+
+// SASProtocol restricts the protocols permitted for requests made with a SAS.
+type SASProtocol string
+
+const (
+	// SASProtocolHTTPS permits HTTPS requests only.
+	SASProtocolHTTPS SASProtocol = "https"
+	// SASProtocolHTTPSandHTTP permits both HTTPS and HTTP requests.
+	SASProtocolHTTPSandHTTP SASProtocol = "https,http"
+)
+
+// SASResource identifies the kind of resource a service SAS is scoped to.
+type SASResource int
+
+const (
+	// SASResourceBlob scopes a SAS to a single blob.
+	SASResourceBlob SASResource = iota
+	// SASResourceContainer scopes a SAS to a container and its blobs.
+	SASResourceContainer
+	// SASResourceQueue scopes a SAS to a queue.
+	SASResourceQueue
+	// SASResourceTable scopes a SAS to a table.
+	SASResourceTable
+)
+
+func (r SASResource) String() string {
+	switch r {
+	case SASResourceBlob:
+		return "blob"
+	case SASResourceContainer:
+		return "container"
+	case SASResourceQueue:
+		return "queue"
+	case SASResourceTable:
+		return "table"
+	}
+	return fmt.Sprintf("SASResource(%d)", int(r))
+}
+
+// sasPermissionOrder lists the permissions valid for each kind of SAS in the
+// order the service requires them to appear in the `sp` parameter.
+var sasPermissionOrder = map[SASResource]string{
+	SASResourceBlob:      "racwd",
+	SASResourceContainer: "racwdl",
+	SASResourceQueue:     "raup",
+	SASResourceTable:     "raud",
+}
+
+const accountSASPermissionOrder = "rwdlacup"
+
+// canonicalPermissions checks that every letter in perms is listed in order
+// and returns perms rearranged into that order.
+func canonicalPermissions(perms, order string) (string, error) {
+	for _, p := range perms {
+		if !strings.ContainsRune(order, p) {
+			return "", fmt.Errorf("invalid SAS permission %q, expected one of %q", p, order)
+		}
+	}
+	var b strings.Builder
+	for _, p := range order {
+		if strings.ContainsRune(perms, p) {
+			b.WriteRune(p)
+		}
+	}
+	return b.String(), nil
+}
+
+// SASIPRange restricts a SAS to a single IP address, or to an inclusive range
+// of addresses when End is set.
+type SASIPRange struct {
+	Start net.IP
+	End   net.IP
+}
+
+// String returns the range in the form used by the `sip` parameter.
+func (r SASIPRange) String() string {
+	if len(r.Start) == 0 {
+		return ""
+	}
+	if len(r.End) == 0 || r.Start.Equal(r.End) {
+		return r.Start.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
+
+// Contains reports whether ip falls within the range. An empty range
+// contains every address.
+func (r SASIPRange) Contains(ip net.IP) bool {
+	if len(r.Start) == 0 {
+		return true
+	}
+	if len(r.End) == 0 {
+		return r.Start.Equal(ip)
+	}
+	start, end, ip4 := r.Start.To4(), r.End.To4(), ip.To4()
+	if start == nil || end == nil || ip4 == nil {
+		return false
+	}
+	return compareIP(start, ip4) <= 0 && compareIP(ip4, end) <= 0
+}
+
+func compareIP(a, b net.IP) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseSASIPRange(s string) (SASIPRange, error) {
+	var r SASIPRange
+	if s == "" {
+		return r, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	r.Start = net.ParseIP(parts[0])
+	if r.Start == nil {
+		return r, fmt.Errorf("invalid SAS IP address %q", parts[0])
+	}
+	if len(parts) == 2 {
+		r.End = net.ParseIP(parts[1])
+		if r.End == nil {
+			return r, fmt.Errorf("invalid SAS IP address %q", parts[1])
+		}
+		if compareIP(r.Start.To16(), r.End.To16()) > 0 {
+			return r, fmt.Errorf("SAS IP range %q starts after it ends", s)
+		}
+	}
+	return r, nil
+}
+
+func formatSASTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(sasTimeFormat)
+}
+
+func parseSASTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(sasTimeFormat, s)
+	if err != nil {
+		t, err = time.Parse(sasDateFormat, s)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SAS time %q", s)
+	}
+	return t, nil
+}
+
+func signSAS(key []byte, stringToSign string) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// ServiceSASValues describes a service SAS for a single container, blob,
+// queue or table. Sign it with an account key, or with a user delegation key
+// for containers and blobs.
+type ServiceSASValues struct {
+	Version     string // defaults to sasVersion
+	Protocol    SASProtocol
+	StartTime   time.Time // optional
+	ExpiryTime  time.Time // required unless Identifier names a stored access policy
+	Permissions string    // required unless Identifier names a stored access policy
+	IPRange     SASIPRange
+	Identifier  string // stored access policy
+
+	Resource      SASResource
+	ContainerName string // SASResourceContainer and SASResourceBlob
+	BlobName      string // SASResourceBlob
+	QueueName     string // SASResourceQueue
+	TableName     string // SASResourceTable
+}
+
+func (v *ServiceSASValues) prepare() error {
+	if v.Version == "" {
+		v.Version = sasVersion
+	}
+	order, ok := sasPermissionOrder[v.Resource]
+	if !ok {
+		return fmt.Errorf("unknown SAS resource %v", v.Resource)
+	}
+	if v.Identifier == "" && (v.ExpiryTime.IsZero() || v.Permissions == "") {
+		return fmt.Errorf("%v SAS requires an expiry time and permissions or a stored access policy", v.Resource)
+	}
+	perms, err := canonicalPermissions(v.Permissions, order)
+	if err != nil {
+		return err
+	}
+	v.Permissions = perms
+
+	switch v.Resource {
+	case SASResourceBlob:
+		if v.ContainerName == "" || v.BlobName == "" {
+			return fmt.Errorf("blob SAS requires a container and blob name")
+		}
+	case SASResourceContainer:
+		if v.ContainerName == "" {
+			return fmt.Errorf("container SAS requires a container name")
+		}
+	case SASResourceQueue:
+		if v.QueueName == "" {
+			return fmt.Errorf("queue SAS requires a queue name")
+		}
+	case SASResourceTable:
+		if v.TableName == "" {
+			return fmt.Errorf("table SAS requires a table name")
+		}
+	}
+	return nil
+}
+
+// canonicalResource returns the resource name included in the string to
+// sign, such as `/blob/account/container/blob`.
+func (v ServiceSASValues) canonicalResource(accountName string) string {
+	switch v.Resource {
+	case SASResourceBlob:
+		return "/blob/" + accountName + "/" + v.ContainerName + "/" + strings.Replace(v.BlobName, "\\", "/", -1)
+	case SASResourceContainer:
+		return "/blob/" + accountName + "/" + v.ContainerName
+	case SASResourceQueue:
+		return "/queue/" + accountName + "/" + v.QueueName
+	default:
+		return "/table/" + accountName + "/" + strings.ToLower(v.TableName)
+	}
+}
+
+// signedResource returns the `sr` parameter, which only blob SAS carry.
+func (v ServiceSASValues) signedResource() string {
+	switch v.Resource {
+	case SASResourceBlob:
+		return "b"
+	case SASResourceContainer:
+		return "c"
+	}
+	return ""
+}
+
+func (v ServiceSASValues) queryParameters(signature string) SASQueryParameters {
+	p := SASQueryParameters{
+		Version:     v.Version,
+		Protocol:    v.Protocol,
+		StartTime:   v.StartTime,
+		ExpiryTime:  v.ExpiryTime,
+		Permissions: v.Permissions,
+		IPRange:     v.IPRange,
+		Identifier:  v.Identifier,
+		Resource:    v.signedResource(),
+		Signature:   signature,
+	}
+	if v.Resource == SASResourceTable {
+		p.TableName = v.TableName
+	}
+	return p
+}
+
+// StringToSign returns the string that is signed with the account key.
+func (v ServiceSASValues) StringToSign(accountName string) string {
+	fields := []string{
+		v.Permissions,
+		formatSASTime(v.StartTime),
+		formatSASTime(v.ExpiryTime),
+		v.canonicalResource(accountName),
+		v.Identifier,
+		v.IPRange.String(),
+		string(v.Protocol),
+		v.Version,
+	}
+	switch v.Resource {
+	case SASResourceBlob, SASResourceContainer:
+		// signed resource and snapshot time, then the five response header
+		// overrides (rscc, rscd, rsce, rscl, rsct) which are not supported here
+		fields = append(fields, v.signedResource(), "", "", "", "", "", "")
+	case SASResourceTable:
+		// partition and row key ranges are not supported here
+		fields = append(fields, "", "", "", "")
+	}
+	return strings.Join(fields, "\n")
+}
+
+// Sign signs the SAS with a base64-encoded storage account key.
+func (v ServiceSASValues) Sign(accountName, accountKey string) (SASQueryParameters, error) {
+	if err := v.prepare(); err != nil {
+		return SASQueryParameters{}, err
+	}
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return SASQueryParameters{}, fmt.Errorf("account key is not valid base64: %v", err)
+	}
+	return v.queryParameters(signSAS(key, v.StringToSign(accountName))), nil
+}
+
+// UserDelegationKey is a key issued to an AAD identity by the blob service
+// for signing user delegation SAS. See GetUserDelegationKey.
+type UserDelegationKey struct {
+	SignedOID     string `xml:"SignedOid"`
+	SignedTID     string `xml:"SignedTid"`
+	SignedStart   string `xml:"SignedStart"`
+	SignedExpiry  string `xml:"SignedExpiry"`
+	SignedService string `xml:"SignedService"`
+	SignedVersion string `xml:"SignedVersion"`
+	Value         string `xml:"Value"`
+}
+
+// UserDelegationStringToSign returns the string that is signed with a user
+// delegation key.
+func (v ServiceSASValues) UserDelegationStringToSign(accountName string, key UserDelegationKey) string {
+	return strings.Join([]string{
+		v.Permissions,
+		formatSASTime(v.StartTime),
+		formatSASTime(v.ExpiryTime),
+		v.canonicalResource(accountName),
+		key.SignedOID,
+		key.SignedTID,
+		key.SignedStart,
+		key.SignedExpiry,
+		key.SignedService,
+		key.SignedVersion,
+		v.IPRange.String(),
+		string(v.Protocol),
+		v.Version,
+		v.signedResource(),
+		"",                 // snapshot time
+		"", "", "", "", "", // response header overrides
+	}, "\n")
+}
+
+// SignWithUserDelegationKey signs a container or blob SAS with a user
+// delegation key, so that no account key is needed.
+func (v ServiceSASValues) SignWithUserDelegationKey(accountName string, key UserDelegationKey) (SASQueryParameters, error) {
+	if v.Resource != SASResourceBlob && v.Resource != SASResourceContainer {
+		return SASQueryParameters{}, fmt.Errorf("user delegation SAS is not supported for %v resources", v.Resource)
+	}
+	if v.Identifier != "" {
+		return SASQueryParameters{}, fmt.Errorf("user delegation SAS cannot use a stored access policy")
+	}
+	if err := v.prepare(); err != nil {
+		return SASQueryParameters{}, err
+	}
+	k, err := base64.StdEncoding.DecodeString(key.Value)
+	if err != nil {
+		return SASQueryParameters{}, fmt.Errorf("user delegation key is not valid base64: %v", err)
+	}
+	p := v.queryParameters(signSAS(k, v.UserDelegationStringToSign(accountName, key)))
+	p.SignedOID = key.SignedOID
+	p.SignedTID = key.SignedTID
+	p.SignedKeyStart = key.SignedStart
+	p.SignedKeyExpiry = key.SignedExpiry
+	p.SignedKeyService = key.SignedService
+	p.SignedKeyVersion = key.SignedVersion
+	return p, nil
+}
+
+// AccountSASValues describes an account SAS, which can grant access to
+// several services and to service-level operations.
+type AccountSASValues struct {
+	Version       string // defaults to sasVersion
+	Protocol      SASProtocol
+	StartTime     time.Time // optional
+	ExpiryTime    time.Time
+	Permissions   string // any of "rwdlacup"
+	IPRange       SASIPRange
+	Services      string // any of "bqtf"
+	ResourceTypes string // any of "sco"
+}
+
+// StringToSign returns the string that is signed with the account key.
+func (v AccountSASValues) StringToSign(accountName string) string {
+	return strings.Join([]string{
+		accountName,
+		v.Permissions,
+		v.Services,
+		v.ResourceTypes,
+		formatSASTime(v.StartTime),
+		formatSASTime(v.ExpiryTime),
+		v.IPRange.String(),
+		string(v.Protocol),
+		v.Version,
+		"",
+	}, "\n")
+}
+
+// Sign signs the SAS with a base64-encoded storage account key.
+func (v AccountSASValues) Sign(accountName, accountKey string) (SASQueryParameters, error) {
+	if v.ExpiryTime.IsZero() || v.Permissions == "" || v.Services == "" || v.ResourceTypes == "" {
+		return SASQueryParameters{}, fmt.Errorf("account SAS requires an expiry time, permissions, services and resource types")
+	}
+	if v.Version == "" {
+		v.Version = sasVersion
+	}
+	var err error
+	if v.Permissions, err = canonicalPermissions(v.Permissions, accountSASPermissionOrder); err != nil {
+		return SASQueryParameters{}, err
+	}
+	if v.Services, err = canonicalPermissions(v.Services, "bfqt"); err != nil {
+		return SASQueryParameters{}, err
+	}
+	if v.ResourceTypes, err = canonicalPermissions(v.ResourceTypes, "sco"); err != nil {
+		return SASQueryParameters{}, err
+	}
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return SASQueryParameters{}, fmt.Errorf("account key is not valid base64: %v", err)
+	}
+	return SASQueryParameters{
+		Version:       v.Version,
+		Services:      v.Services,
+		ResourceTypes: v.ResourceTypes,
+		Protocol:      v.Protocol,
+		StartTime:     v.StartTime,
+		ExpiryTime:    v.ExpiryTime,
+		Permissions:   v.Permissions,
+		IPRange:       v.IPRange,
+		Signature:     signSAS(key, v.StringToSign(accountName)),
+	}, nil
+}
+
+// SASQueryParameters holds the query parameters of a signed SAS. Produce
+// one by signing ServiceSASValues or AccountSASValues, or by parsing an
+// existing token with ParseSAS.
+type SASQueryParameters struct {
+	Version       string // sv
+	Services      string // ss, account SAS only
+	ResourceTypes string // srt, account SAS only
+	Protocol      SASProtocol
+	StartTime     time.Time
+	ExpiryTime    time.Time
+	Permissions   string // sp
+	IPRange       SASIPRange
+	Identifier    string // si
+	Resource      string // sr, blob SAS only
+	TableName     string // tn, table SAS only
+
+	// user delegation key fields
+	SignedOID        string // skoid
+	SignedTID        string // sktid
+	SignedKeyStart   string // skt
+	SignedKeyExpiry  string // ske
+	SignedKeyService string // sks
+	SignedKeyVersion string // skv
+
+	Signature string // sig
+}
+
+// IsAccountSAS reports whether the parameters describe an account SAS.
+func (p SASQueryParameters) IsAccountSAS() bool {
+	return p.Services != "" || p.ResourceTypes != ""
+}
+
+// IsUserDelegationSAS reports whether the parameters were signed with a
+// user delegation key.
+func (p SASQueryParameters) IsUserDelegationSAS() bool {
+	return p.SignedOID != ""
+}
+
+// Values returns the parameters as url.Values, omitting empty ones.
+func (p SASQueryParameters) Values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("sv", p.Version)
+	set("ss", p.Services)
+	set("srt", p.ResourceTypes)
+	set("spr", string(p.Protocol))
+	set("st", formatSASTime(p.StartTime))
+	set("se", formatSASTime(p.ExpiryTime))
+	set("sp", p.Permissions)
+	set("sip", p.IPRange.String())
+	set("si", p.Identifier)
+	set("sr", p.Resource)
+	set("tn", p.TableName)
+	set("skoid", p.SignedOID)
+	set("sktid", p.SignedTID)
+	set("skt", p.SignedKeyStart)
+	set("ske", p.SignedKeyExpiry)
+	set("sks", p.SignedKeyService)
+	set("skv", p.SignedKeyVersion)
+	set("sig", p.Signature)
+	return v
+}
+
+// Encode returns the SAS as a URL query string without a leading `?`.
+func (p SASQueryParameters) Encode() string {
+	return p.Values().Encode()
+}
+
+// ParseSAS parses a SAS token. It accepts a bare query string, with or
+// without a leading `?`, or a full URL carrying the token.
+func ParseSAS(sas string) (SASQueryParameters, error) {
+	var p SASQueryParameters
+	if i := strings.Index(sas, "?"); i >= 0 {
+		sas = sas[i+1:]
+	}
+	q, err := url.ParseQuery(sas)
+	if err != nil {
+		return p, fmt.Errorf("failed to parse SAS: %v", err)
+	}
+
+	p.Version = q.Get("sv")
+	p.Services = q.Get("ss")
+	p.ResourceTypes = q.Get("srt")
+	p.Protocol = SASProtocol(q.Get("spr"))
+	p.Permissions = q.Get("sp")
+	p.Identifier = q.Get("si")
+	p.Resource = q.Get("sr")
+	p.TableName = q.Get("tn")
+	p.SignedOID = q.Get("skoid")
+	p.SignedTID = q.Get("sktid")
+	p.SignedKeyStart = q.Get("skt")
+	p.SignedKeyExpiry = q.Get("ske")
+	p.SignedKeyService = q.Get("sks")
+	p.SignedKeyVersion = q.Get("skv")
+	p.Signature = q.Get("sig")
+
+	if p.StartTime, err = parseSASTime(q.Get("st")); err != nil {
+		return p, err
+	}
+	if p.ExpiryTime, err = parseSASTime(q.Get("se")); err != nil {
+		return p, err
+	}
+	if p.IPRange, err = parseSASIPRange(q.Get("sip")); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// Validate checks that the SAS is well formed and usable at time `at`. It
+// cannot check the signature itself, which requires the signing key.
+func (p SASQueryParameters) Validate(at time.Time) error {
+	if p.Version == "" {
+		return fmt.Errorf("SAS is missing the signed version (sv)")
+	}
+	if p.Signature == "" {
+		return fmt.Errorf("SAS is missing the signature (sig)")
+	}
+	if _, err := base64.StdEncoding.DecodeString(p.Signature); err != nil {
+		return fmt.Errorf("SAS signature is not valid base64: %v", err)
+	}
+	switch p.Protocol {
+	case "", SASProtocolHTTPS, SASProtocolHTTPSandHTTP:
+	default:
+		return fmt.Errorf("SAS has unknown protocol %q", p.Protocol)
+	}
+
+	if p.IsAccountSAS() {
+		if p.Services == "" || p.ResourceTypes == "" {
+			return fmt.Errorf("account SAS requires both services (ss) and resource types (srt)")
+		}
+		if _, err := canonicalPermissions(p.Services, "bfqt"); err != nil {
+			return err
+		}
+		if _, err := canonicalPermissions(p.ResourceTypes, "sco"); err != nil {
+			return err
+		}
+		if _, err := canonicalPermissions(p.Permissions, accountSASPermissionOrder); err != nil {
+			return err
+		}
+	} else if p.Identifier == "" || p.Permissions != "" {
+		order := "racwdlup" // queue SAS carry no resource marker
+		switch {
+		case p.Resource == "b":
+			order = sasPermissionOrder[SASResourceBlob]
+		case p.Resource == "c":
+			order = sasPermissionOrder[SASResourceContainer]
+		case p.Resource != "":
+			return fmt.Errorf("SAS has unknown signed resource %q", p.Resource)
+		case p.TableName != "":
+			order = sasPermissionOrder[SASResourceTable]
+		}
+		if _, err := canonicalPermissions(p.Permissions, order); err != nil {
+			return err
+		}
+	}
+
+	if p.IsUserDelegationSAS() {
+		if p.SignedTID == "" || p.SignedKeyStart == "" || p.SignedKeyExpiry == "" ||
+			p.SignedKeyService == "" || p.SignedKeyVersion == "" {
+			return fmt.Errorf("user delegation SAS is missing signed key fields")
+		}
+		keyExpiry, err := parseSASTime(p.SignedKeyExpiry)
+		if err != nil {
+			return err
+		}
+		if !at.Before(keyExpiry) {
+			return fmt.Errorf("user delegation key expired at %s", p.SignedKeyExpiry)
+		}
+	}
+
+	// without a stored access policy the SAS itself must carry the
+	// permissions and expiry
+	if p.Identifier == "" && (p.Permissions == "" || p.ExpiryTime.IsZero()) {
+		return fmt.Errorf("SAS without a stored access policy requires permissions (sp) and expiry (se)")
+	}
+	if !p.StartTime.IsZero() && at.Before(p.StartTime) {
+		return fmt.Errorf("SAS is not valid until %s", formatSASTime(p.StartTime))
+	}
+	if !p.ExpiryTime.IsZero() && !at.Before(p.ExpiryTime) {
+		return fmt.Errorf("SAS expired at %s", formatSASTime(p.ExpiryTime))
+	}
+	if !p.StartTime.IsZero() && !p.ExpiryTime.IsZero() && !p.StartTime.Before(p.ExpiryTime) {
+		return fmt.Errorf("SAS start time is not before its expiry time")
+	}
+	return nil
+}
+
+// userDelegationKeyRequest is the body of a Get User Delegation Key request.
+type userDelegationKeyRequest struct {
+	XMLName xml.Name `xml:"KeyInfo"`
+	Start   string   `xml:"Start"`
+	Expiry  string   `xml:"Expiry"`
+}
+
+// GetUserDelegationKey requests a key from the blob service of the account
+// for signing user delegation SAS. The request is authorized with the
+// configured AAD identity, which needs a role granting the
+// `generateUserDelegationKey` action, such as Storage Blob Delegator.
+func GetUserDelegationKey(ctx context.Context, accountName string, start, expiry time.Time) (UserDelegationKey, error) {
+	var key UserDelegationKey
+	auth, err := iam.GetStorageAuthorizer()
+	if err != nil {
+		return key, err
+	}
+	client := autorest.NewClientWithUserAgent(config.UserAgent())
+	client.Authorizer = auth
+
+	req, err := autorest.Prepare((&http.Request{}).WithContext(ctx),
+		autorest.AsPost(),
+		autorest.WithBaseURL(fmt.Sprintf(blobFormatString, accountName)),
+		autorest.WithPath("/"),
+		autorest.WithQueryParameters(map[string]interface{}{
+			"restype": "service",
+			"comp":    "userdelegationkey",
+		}),
+		autorest.WithHeader("x-ms-version", sasVersion),
+		autorest.WithXML(userDelegationKeyRequest{
+			Start:  formatSASTime(start),
+			Expiry: formatSASTime(expiry),
+		}),
+		client.WithAuthorization())
+	if err != nil {
+		return key, fmt.Errorf("failed to prepare user delegation key request: %v", err)
+	}
+
+	resp, err := autorest.SendWithSender(client, req)
+	if err != nil {
+		return key, fmt.Errorf("failed to request user delegation key: %v", err)
+	}
+	err = autorest.Respond(
+		resp,
+		azure.WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByUnmarshallingXML(&key),
+		autorest.ByClosing())
+	if err != nil {
+		return key, fmt.Errorf("failed to get user delegation key: %v", err)
+	}
+	return key, nil
+}
+
+func getBlobServiceURL(accountName string, sas SASQueryParameters, path ...string) url.URL {
+	u, _ := url.Parse(fmt.Sprintf(blobFormatString, accountName))
+	u.Path = "/" + strings.Join(path, "/")
+	u.RawQuery = sas.Encode()
+	return *u
+}
+
+// GetContainerSASURL returns a URL for the container carrying a service SAS
+// signed with the account's primary key.
+func GetContainerSASURL(ctx context.Context, accountName, accountGroupName, containerName, permissions string, expiry time.Time) (url.URL, error) {
+	keys, err := GetAccountKeys(ctx, accountName, accountGroupName)
+	if err != nil {
+		return url.URL{}, err
+	}
+	sas, err := ServiceSASValues{
+		Protocol:      SASProtocolHTTPS,
+		ExpiryTime:    expiry,
+		Permissions:   permissions,
+		Resource:      SASResourceContainer,
+		ContainerName: containerName,
+	}.Sign(accountName, *(*keys.Keys)[0].Value)
+	if err != nil {
+		return url.URL{}, err
+	}
+	return getBlobServiceURL(accountName, sas, containerName), nil
+}
+
+// GetBlobSASURL returns a URL for the blob carrying a service SAS signed
+// with the account's primary key.
+func GetBlobSASURL(ctx context.Context, accountName, accountGroupName, containerName, blobName, permissions string, expiry time.Time) (url.URL, error) {
+	keys, err := GetAccountKeys(ctx, accountName, accountGroupName)
+	if err != nil {
+		return url.URL{}, err
+	}
+	sas, err := ServiceSASValues{
+		Protocol:      SASProtocolHTTPS,
+		ExpiryTime:    expiry,
+		Permissions:   permissions,
+		Resource:      SASResourceBlob,
+		ContainerName: containerName,
+		BlobName:      blobName,
+	}.Sign(accountName, *(*keys.Keys)[0].Value)
+	if err != nil {
+		return url.URL{}, err
+	}
+	return getBlobServiceURL(accountName, sas, containerName, blobName), nil
+}
+
+// GetAccountSAS returns an account SAS signed with the account's primary key.
+func GetAccountSAS(ctx context.Context, accountName, accountGroupName, services, resourceTypes, permissions string, expiry time.Time) (SASQueryParameters, error) {
+	keys, err := GetAccountKeys(ctx, accountName, accountGroupName)
+	if err != nil {
+		return SASQueryParameters{}, err
+	}
+	return AccountSASValues{
+		Protocol:      SASProtocolHTTPS,
+		ExpiryTime:    expiry,
+		Permissions:   permissions,
+		Services:      services,
+		ResourceTypes: resourceTypes,
+	}.Sign(accountName, *(*keys.Keys)[0].Value)
+}
+
+// GetUserDelegationBlobSASURL returns a URL for the blob carrying a user
+// delegation SAS. The delegation key is requested with the configured AAD
+// identity and expires with the SAS, so no account key is involved.
+func GetUserDelegationBlobSASURL(ctx context.Context, accountName, containerName, blobName, permissions string, expiry time.Time) (url.URL, error) {
+	key, err := GetUserDelegationKey(ctx, accountName, time.Now().Add(-5*time.Minute), expiry)
+	if err != nil {
+		return url.URL{}, err
+	}
+	sas, err := ServiceSASValues{
+		Protocol:      SASProtocolHTTPS,
+		ExpiryTime:    expiry,
+		Permissions:   permissions,
+		Resource:      SASResourceBlob,
+		ContainerName: containerName,
+		BlobName:      blobName,
+	}.SignWithUserDelegationKey(accountName, key)
+	if err != nil {
+		return url.URL{}, err
+	}
+	return getBlobServiceURL(accountName, sas, containerName, blobName), nil
+}
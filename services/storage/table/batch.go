@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package table
+
+import (
+	"fmt"
+
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+)
+
+// maxBatchSize is the most operations an entity group transaction can hold.
+const maxBatchSize = 100
+
+// BatchOperation is the kind of change a BatchChange makes.
+type BatchOperation string
+
+const (
+	// BatchInsert inserts an entity, failing if it exists.
+	BatchInsert BatchOperation = "insert"
+	// BatchUpsert inserts an entity or replaces the existing one.
+	BatchUpsert BatchOperation = "upsert"
+	// BatchMerge merges properties into an existing entity.
+	BatchMerge BatchOperation = "merge"
+	// BatchDelete deletes an existing entity whatever its ETag.
+	BatchDelete BatchOperation = "delete"
+)
+
+// BatchChange is one change to apply with ExecuteBatches.
+type BatchChange struct {
+	Operation    BatchOperation
+	PartitionKey string
+	RowKey       string
+	Properties   map[string]interface{}
+}
+
+// planBatches splits changes into groups that can each run as one entity
+// group transaction: all changes of a group share a partition key, a group
+// holds at most 100 changes, and no entity appears twice in a group. The
+// order of changes to a partition is kept.
+func planBatches(changes []BatchChange) ([][]BatchChange, error) {
+	var batches [][]BatchChange
+	open := map[string]int{}          // partition key to index of its last batch
+	rows := map[int]map[string]bool{} // row keys in each batch
+	for _, c := range changes {
+		switch c.Operation {
+		case BatchInsert, BatchUpsert, BatchMerge, BatchDelete:
+		default:
+			return nil, fmt.Errorf("unknown batch operation %q for %s/%s", c.Operation, c.PartitionKey, c.RowKey)
+		}
+		i, ok := open[c.PartitionKey]
+		if !ok || len(batches[i]) == maxBatchSize || rows[i][c.RowKey] {
+			i = len(batches)
+			batches = append(batches, nil)
+			rows[i] = map[string]bool{}
+			open[c.PartitionKey] = i
+		}
+		batches[i] = append(batches[i], c)
+		rows[i][c.RowKey] = true
+	}
+	return batches, nil
+}
+
+// ExecuteBatches applies changes to a table in as few entity group
+// transactions as possible. Each transaction is atomic, but changes that
+// span partitions or more than 100 entities run in several transactions,
+// so an error can leave earlier transactions applied. It returns the number
+// of changes applied.
+func ExecuteBatches(t *azstorage.Table, changes []BatchChange) (int, error) {
+	batches, err := planBatches(changes)
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for _, batch := range batches {
+		tb := t.NewBatch()
+		for _, c := range batch {
+			e := t.GetEntityReference(c.PartitionKey, c.RowKey)
+			e.Properties = c.Properties
+			switch c.Operation {
+			case BatchInsert:
+				tb.InsertEntity(e)
+			case BatchUpsert:
+				tb.InsertOrReplaceEntityByForce(e)
+			case BatchMerge:
+				tb.MergeEntity(e)
+			case BatchDelete:
+				tb.DeleteEntity(e, true)
+			}
+		}
+		if err := tb.ExecuteBatch(); err != nil {
+			return applied, fmt.Errorf("failed to execute batch for partition %s: %v", batch[0].PartitionKey, err)
+		}
+		applied += len(batch)
+	}
+	return applied, nil
+}
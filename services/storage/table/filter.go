@@ -0,0 +1,187 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package table
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter is an OData filter expression for table queries. Build filters
+// with the comparison functions and combine them with And, Or and Not; they
+// quote literals so that values cannot change the meaning of the query.
+// The zero Filter matches every entity.
+type Filter struct {
+	expr string
+	err  error
+}
+
+// Build returns the filter expression, or the first error found while
+// building the filter.
+func (f Filter) Build() (string, error) {
+	return f.expr, f.err
+}
+
+// String returns the filter expression, or a description of its error.
+func (f Filter) String() string {
+	if f.err != nil {
+		return fmt.Sprintf("invalid filter: %v", f.err)
+	}
+	return f.expr
+}
+
+// Equal matches entities whose property equals value.
+func Equal(property string, value interface{}) Filter {
+	return compare(property, "eq", value)
+}
+
+// NotEqual matches entities whose property does not equal value.
+func NotEqual(property string, value interface{}) Filter {
+	return compare(property, "ne", value)
+}
+
+// GreaterThan matches entities whose property is greater than value.
+func GreaterThan(property string, value interface{}) Filter {
+	return compare(property, "gt", value)
+}
+
+// GreaterThanOrEqual matches entities whose property is at least value.
+func GreaterThanOrEqual(property string, value interface{}) Filter {
+	return compare(property, "ge", value)
+}
+
+// LessThan matches entities whose property is less than value.
+func LessThan(property string, value interface{}) Filter {
+	return compare(property, "lt", value)
+}
+
+// LessThanOrEqual matches entities whose property is at most value.
+func LessThanOrEqual(property string, value interface{}) Filter {
+	return compare(property, "le", value)
+}
+
+// PartitionKeyEqual matches the entities of one partition.
+func PartitionKeyEqual(partitionKey string) Filter {
+	return Equal("PartitionKey", partitionKey)
+}
+
+// RowKeyPrefix matches entities whose row key starts with prefix, using a
+// range on the row key since OData for tables has no startswith.
+func RowKeyPrefix(prefix string) Filter {
+	if prefix == "" {
+		return Filter{}
+	}
+	// the first string after every string with the prefix increments its
+	// last character
+	end := []rune(prefix)
+	end[len(end)-1]++
+	return And(
+		GreaterThanOrEqual("RowKey", prefix),
+		LessThan("RowKey", string(end)))
+}
+
+// And matches entities matched by all filters. Zero filters are ignored.
+func And(filters ...Filter) Filter {
+	return combine("and", filters)
+}
+
+// Or matches entities matched by any of the filters. Zero filters are
+// ignored.
+func Or(filters ...Filter) Filter {
+	return combine("or", filters)
+}
+
+// Not matches entities not matched by f.
+func Not(f Filter) Filter {
+	if f.err != nil {
+		return f
+	}
+	if f.expr == "" {
+		return Filter{err: fmt.Errorf("cannot negate an empty filter")}
+	}
+	return Filter{expr: fmt.Sprintf("not (%s)", f.expr)}
+}
+
+func combine(op string, filters []Filter) Filter {
+	var exprs []string
+	for _, f := range filters {
+		if f.err != nil {
+			return f
+		}
+		if f.expr != "" {
+			exprs = append(exprs, f.expr)
+		}
+	}
+	switch len(exprs) {
+	case 0:
+		return Filter{}
+	case 1:
+		return Filter{expr: exprs[0]}
+	}
+	return Filter{expr: "(" + strings.Join(exprs, ") "+op+" (") + ")"}
+}
+
+func compare(property, op string, value interface{}) Filter {
+	if err := validatePropertyName(property); err != nil {
+		return Filter{err: err}
+	}
+	lit, err := literal(value)
+	if err != nil {
+		return Filter{err: fmt.Errorf("property %s: %v", property, err)}
+	}
+	return Filter{expr: fmt.Sprintf("%s %s %s", property, op, lit)}
+}
+
+// validatePropertyName rejects names that are not C# identifiers, which
+// table property names must be.
+func validatePropertyName(name string) error {
+	if name == "" {
+		return fmt.Errorf("property name is empty")
+	}
+	for i, r := range name {
+		letter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		digit := r >= '0' && r <= '9'
+		if !letter && !(digit && i > 0) {
+			return fmt.Errorf("invalid property name %q", name)
+		}
+	}
+	return nil
+}
+
+// literal formats a value as an OData literal of the matching EDM type.
+func literal(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		if int64(v) != int64(int32(v)) {
+			return strconv.FormatInt(int64(v), 10) + "L", nil
+		}
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10) + "L", nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", fmt.Errorf("cannot compare with %v", v)
+		}
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s, nil
+	case time.Time:
+		return "datetime'" + v.UTC().Format(time.RFC3339Nano) + "'", nil
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'", nil
+	}
+	return "", fmt.Errorf("unsupported filter value type %T", value)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+// Package table demonstrates working with Azure Storage table entities:
+// CRUD, batch transactions and OData query filters.
+package table
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/internal/config"
+	"github.com/Azure-Samples/azure-sdk-for-go-samples/services/storage"
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+)
+
+// timeout is the server side timeout in seconds for table operations.
+const timeout = 30
+
+// GetTableServiceClient returns a client for the table service of a storage
+// account, authorized with the account's primary key.
+func GetTableServiceClient(ctx context.Context, accountName, accountGroupName string) (azstorage.TableServiceClient, error) {
+	keys, err := storage.GetAccountKeys(ctx, accountName, accountGroupName)
+	if err != nil {
+		return azstorage.TableServiceClient{}, err
+	}
+	client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, *(*keys.Keys)[0].Value, *config.Environment())
+	if err != nil {
+		return azstorage.TableServiceClient{}, err
+	}
+	_ = client.AddToUserAgent(config.UserAgent())
+	return client.GetTableService(), nil
+}
+
+// GetEmulatorTableServiceClient returns a client for the table service of a
+// local storage emulator such as Azurite, listening on 127.0.0.1:10002.
+func GetEmulatorTableServiceClient() (azstorage.TableServiceClient, error) {
+	client, err := azstorage.NewEmulatorClient()
+	if err != nil {
+		return azstorage.TableServiceClient{}, err
+	}
+	_ = client.AddToUserAgent(config.UserAgent())
+	return client.GetTableService(), nil
+}
+
+// isStatus reports whether err is an unexpected status code error from the
+// storage service with the given status code.
+func isStatus(err error, statusCode int) bool {
+	e, ok := err.(azstorage.UnexpectedStatusCodeError)
+	return ok && e.Got() == statusCode
+}
+
+// CreateTable creates the named table if it does not exist yet.
+func CreateTable(ts azstorage.TableServiceClient, tableName string) (*azstorage.Table, error) {
+	t := ts.GetTableReference(tableName)
+	err := t.Create(timeout, azstorage.EmptyPayload, nil)
+	if err != nil && !isStatus(err, http.StatusConflict) {
+		return t, err
+	}
+	return t, nil
+}
+
+// DeleteTable deletes the named table and all its entities.
+func DeleteTable(ts azstorage.TableServiceClient, tableName string) error {
+	return ts.GetTableReference(tableName).Delete(timeout, nil)
+}
+
+// InsertEntity adds an entity to the table. It fails if an entity with the
+// same partition and row key exists.
+func InsertEntity(t *azstorage.Table, partitionKey, rowKey string, properties map[string]interface{}) (*azstorage.Entity, error) {
+	e := t.GetEntityReference(partitionKey, rowKey)
+	e.Properties = properties
+	return e, e.Insert(azstorage.EmptyPayload, nil)
+}
+
+// UpsertEntity adds an entity to the table, or replaces all properties of
+// the entity with the same partition and row key.
+func UpsertEntity(t *azstorage.Table, partitionKey, rowKey string, properties map[string]interface{}) (*azstorage.Entity, error) {
+	e := t.GetEntityReference(partitionKey, rowKey)
+	e.Properties = properties
+	return e, e.InsertOrReplace(nil)
+}
+
+// GetEntity gets an entity by its partition and row key. It returns nil
+// without an error if the entity does not exist.
+func GetEntity(t *azstorage.Table, partitionKey, rowKey string) (*azstorage.Entity, error) {
+	e := t.GetEntityReference(partitionKey, rowKey)
+	err := e.Get(timeout, azstorage.FullMetadata, nil)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// MergeEntity updates the properties set on e and leaves its other
+// properties unchanged. Unless force is set, the update fails if the entity
+// changed since it was read, based on its ETag.
+func MergeEntity(e *azstorage.Entity, force bool) error {
+	return e.Merge(force, nil)
+}
+
+// ReplaceEntity replaces all properties of the entity with those set on e.
+// Unless force is set, the update fails if the entity changed since it was
+// read, based on its ETag.
+func ReplaceEntity(e *azstorage.Entity, force bool) error {
+	return e.Update(force, nil)
+}
+
+// DeleteEntity deletes an entity by its partition and row key, whatever its
+// ETag. Deleting an entity that does not exist succeeds.
+func DeleteEntity(t *azstorage.Table, partitionKey, rowKey string) error {
+	err := t.GetEntityReference(partitionKey, rowKey).Delete(true, nil)
+	if isStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+// QueryEntities returns all entities matching filter, following continuation
+// tokens until the results are exhausted. An empty filter matches every
+// entity, and an empty selection returns every property.
+func QueryEntities(t *azstorage.Table, filter Filter, selection ...string) ([]*azstorage.Entity, error) {
+	expr, err := filter.Build()
+	if err != nil {
+		return nil, err
+	}
+	result, err := t.QueryEntities(timeout, azstorage.FullMetadata, &azstorage.QueryOptions{
+		Filter: expr,
+		Select: selection,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entities := result.Entities
+	for result.NextLink != nil {
+		result, err = result.NextResults(nil)
+		if err != nil {
+			return entities, err
+		}
+		entities = append(entities, result.Entities...)
+	}
+	return entities, nil
+}
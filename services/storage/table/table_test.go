@@ -0,0 +1,169 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package table
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/marstr/randname"
+)
+
+// emulatorTableService returns a table client for a local emulator, or skips
+// the test unless AZURE_STORAGE_EMULATOR is set to true.
+func emulatorTableService(t *testing.T) azstorage.TableServiceClient {
+	if os.Getenv("AZURE_STORAGE_EMULATOR") != "true" {
+		t.Skip("set AZURE_STORAGE_EMULATOR=true to run against a local storage emulator")
+	}
+	ts, err := GetEmulatorTableServiceClient()
+	if err != nil {
+		t.Fatalf("failed to create emulator client: %v", err)
+	}
+	return ts
+}
+
+func TestFilter(t *testing.T) {
+	when := time.Date(2020, 7, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	cases := []struct {
+		filter Filter
+		want   string
+	}{
+		{Filter{}, ""},
+		{Equal("Name", "O'Brien"), "Name eq 'O''Brien'"},
+		{NotEqual("Active", false), "Active ne false"},
+		{GreaterThan("Count", 3), "Count gt 3"},
+		{GreaterThan("Count", int32(3)), "Count gt 3"},
+		{GreaterThanOrEqual("Size", int64(3)), "Size ge 3L"},
+		{GreaterThanOrEqual("Size", 1<<40), "Size ge 1099511627776L"},
+		{LessThan("Price", 2.0), "Price lt 2.0"},
+		{LessThan("Price", 0.25), "Price lt 0.25"},
+		{LessThanOrEqual("Created", when), "Created le datetime'2020-07-01T10:30:00Z'"},
+		{Equal("Hash", []byte{0xde, 0xad}), "Hash eq X'dead'"},
+		{And(PartitionKeyEqual("p1"), Filter{}), "PartitionKey eq 'p1'"},
+		{
+			And(PartitionKeyEqual("p1"), Or(Equal("A", 1), Not(Equal("B", 2)))),
+			"(PartitionKey eq 'p1') and ((A eq 1) or (not (B eq 2)))",
+		},
+		{RowKeyPrefix("log-"), "(RowKey ge 'log-') and (RowKey lt 'log.')"},
+	}
+	for _, c := range cases {
+		got, err := c.filter.Build()
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", c.want, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+
+	invalid := []Filter{
+		Equal("Name eq 'x' or Name", "y"),
+		Equal("1st", "y"),
+		Equal("", "y"),
+		Equal("Name", struct{}{}),
+		Equal("Ratio", math.NaN()),
+		Not(Filter{}),
+		And(PartitionKeyEqual("p1"), Equal("bad name", 1)),
+	}
+	for _, f := range invalid {
+		if _, err := f.Build(); err == nil {
+			t.Errorf("expected an error for %s", f)
+		}
+	}
+}
+
+func TestPlanBatches(t *testing.T) {
+	var changes []BatchChange
+	for i := 0; i < 150; i++ {
+		changes = append(changes, BatchChange{Operation: BatchUpsert, PartitionKey: "a", RowKey: fmt.Sprint(i)})
+	}
+	changes = append(changes,
+		BatchChange{Operation: BatchInsert, PartitionKey: "b", RowKey: "1"},
+		BatchChange{Operation: BatchMerge, PartitionKey: "b", RowKey: "1"},
+		BatchChange{Operation: BatchDelete, PartitionKey: "a", RowKey: "x"},
+	)
+
+	batches, err := planBatches(changes)
+	if err != nil {
+		t.Fatalf("failed to plan batches: %v", err)
+	}
+	var sizes []string
+	for _, b := range batches {
+		sizes = append(sizes, fmt.Sprintf("%s:%d", b[0].PartitionKey, len(b)))
+		for _, c := range b {
+			if c.PartitionKey != b[0].PartitionKey {
+				t.Errorf("batch mixes partitions %s and %s", b[0].PartitionKey, c.PartitionKey)
+			}
+		}
+	}
+	want := fmt.Sprint([]string{"a:100", "a:51", "b:1", "b:1"})
+	if fmt.Sprint(sizes) != want {
+		t.Errorf("batch sizes = %v, want %v", sizes, want)
+	}
+	if last := batches[1][50]; last.RowKey != "x" {
+		t.Errorf("delete was not appended to the open batch: %+v", last)
+	}
+
+	if _, err := planBatches([]BatchChange{{Operation: "replace"}}); err == nil {
+		t.Errorf("expected unknown operation to be rejected")
+	}
+}
+
+func TestTableEntitiesEmulator(t *testing.T) {
+	ts := emulatorTableService(t)
+	tableName := randname.GenerateWithPrefix("gosdktable", 6)
+	table, err := CreateTable(ts, tableName)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	defer DeleteTable(ts, tableName)
+	if _, err := CreateTable(ts, tableName); err != nil {
+		t.Errorf("creating an existing table should succeed: %v", err)
+	}
+
+	if _, err := InsertEntity(table, "fruit", "apple", map[string]interface{}{"Count": 3}); err != nil {
+		t.Fatalf("failed to insert entity: %v", err)
+	}
+	e, err := GetEntity(table, "fruit", "apple")
+	if err != nil || e == nil {
+		t.Fatalf("failed to get entity: %v", err)
+	}
+	e.Properties = map[string]interface{}{"Color": "red"}
+	if err := MergeEntity(e, false); err != nil {
+		t.Fatalf("failed to merge entity: %v", err)
+	}
+
+	var changes []BatchChange
+	for i := 0; i < 120; i++ {
+		changes = append(changes, BatchChange{
+			Operation:    BatchUpsert,
+			PartitionKey: "numbers",
+			RowKey:       fmt.Sprintf("n%03d", i),
+			Properties:   map[string]interface{}{"Value": i},
+		})
+	}
+	if n, err := ExecuteBatches(table, changes); err != nil || n != len(changes) {
+		t.Fatalf("applied %d changes: %v", n, err)
+	}
+
+	found, err := QueryEntities(table, And(PartitionKeyEqual("numbers"), RowKeyPrefix("n01")))
+	if err != nil {
+		t.Fatalf("failed to query entities: %v", err)
+	}
+	if len(found) != 10 {
+		t.Errorf("query found %d entities, want 10", len(found))
+	}
+
+	if err := DeleteEntity(table, "fruit", "apple"); err != nil {
+		t.Fatalf("failed to delete entity: %v", err)
+	}
+	if e, err := GetEntity(table, "fruit", "apple"); err != nil || e != nil {
+		t.Errorf("deleted entity still found: %+v, %v", e, err)
+	}
+}